@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -14,11 +15,17 @@ import (
 	"golang.org/x/term"
 
 	"github.com/spf13/cobra"
+	"trueword_node/pkg/accounting"
 	"trueword_node/pkg/config"
+	"trueword_node/pkg/drift"
 	"trueword_node/pkg/failover"
+	"trueword_node/pkg/firewall"
+	"trueword_node/pkg/forward"
+	"trueword_node/pkg/gc"
 	"trueword_node/pkg/ipsec"
 	"trueword_node/pkg/network"
 	"trueword_node/pkg/routing"
+	"trueword_node/pkg/scoring"
 	"trueword_node/pkg/system"
 	"trueword_node/pkg/wireguard"
 )
@@ -487,6 +494,136 @@ func interactiveCreateWireGuardWithMode(parentInterface string) error {
 	return nil
 }
 
+// subnetsDisplay 网段列表显示
+func subnetsDisplay(subnets []string) string {
+	if len(subnets) == 0 {
+		return "(无)"
+	}
+	return strings.Join(subnets, ", ")
+}
+
+// printPeerSubnets 输出对端需要执行的站点网段命令（两端网段互换）
+func printPeerSubnets(tunnelConfig *network.TunnelConfig) {
+	if len(tunnelConfig.LocalSubnets) == 0 && len(tunnelConfig.RemoteSubnets) == 0 {
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("【站点网段】(隧道创建后在对端执行)")
+	fmt.Println()
+	fmt.Printf("twnode line set-subnets %s", tunnelConfig.Name)
+	if len(tunnelConfig.LocalSubnets) > 0 {
+		fmt.Printf(" --remote %s", strings.Join(tunnelConfig.LocalSubnets, ","))
+	}
+	if len(tunnelConfig.RemoteSubnets) > 0 {
+		fmt.Printf(" --local %s", strings.Join(tunnelConfig.RemoteSubnets, ","))
+	}
+	if tunnelConfig.WGStrictAllowed {
+		fmt.Printf(" --wg-strict-allowed")
+	}
+	fmt.Println()
+}
+
+// parseShapingFlags 根据命令行参数更新流量整形配置（--off 清除）
+func parseShapingFlags(cmd *cobra.Command, current *network.Shaping) (*network.Shaping, error) {
+	if off, _ := cmd.Flags().GetBool("off"); off {
+		return nil, nil
+	}
+
+	shaping := &network.Shaping{}
+	if current != nil {
+		*shaping = *current
+	}
+
+	if cmd.Flags().Changed("egress") {
+		value, _ := cmd.Flags().GetString("egress")
+		kbit, err := network.ParseRate(value)
+		if err != nil {
+			return nil, err
+		}
+		shaping.EgressKbit = kbit
+	}
+
+	if cmd.Flags().Changed("ingress") {
+		value, _ := cmd.Flags().GetString("ingress")
+		kbit, err := network.ParseRate(value)
+		if err != nil {
+			return nil, err
+		}
+		shaping.IngressKbit = kbit
+	}
+
+	if cmd.Flags().Changed("qdisc") {
+		value, _ := cmd.Flags().GetString("qdisc")
+		qdisc, err := network.ParseQdisc(value)
+		if err != nil {
+			return nil, err
+		}
+		shaping.Qdisc = qdisc
+	}
+
+	return shaping, nil
+}
+
+// addShapingFlags 添加流量整形参数
+func addShapingFlags(cmd *cobra.Command) {
+	cmd.Flags().String("egress", "", "出向限速（如 50mbit、500kbit，0 表示不限速）")
+	cmd.Flags().String("ingress", "", "入向限速，经 ifb 设备整形（0 表示不限速）")
+	cmd.Flags().String("qdisc", "", "队列算法: fq_codel(默认) / cake")
+	cmd.Flags().Bool("off", false, "删除流量整形配置")
+}
+
+// parseQuotaArgs 解析流量配额参数（off 表示删除配额）
+func parseQuotaArgs(cmd *cobra.Command, limit string) (*network.Quota, error) {
+	if limit == "off" {
+		return nil, nil
+	}
+
+	resetDay, _ := cmd.Flags().GetInt("reset-day")
+	softPercent, _ := cmd.Flags().GetInt("soft")
+	count, _ := cmd.Flags().GetString("count")
+	return network.NewQuota(limit, resetDay, softPercent, count)
+}
+
+// addQuotaFlags 添加流量配额参数
+func addQuotaFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("reset-day", 1, "每月计费周期重置日 (1-28)")
+	cmd.Flags().Int("soft", network.DefaultQuotaSoftPercent, "有效成本开始上升的用量百分比")
+	cmd.Flags().String("count", network.QuotaCountTotal, "计量方向: total / tx / rx")
+}
+
+// printPeerNetmap 输出对端需要执行的网段映射命令（两端映射互为镜像）
+func printPeerNetmap(tunnelConfig *network.TunnelConfig) {
+	if len(tunnelConfig.NetmapLocal) == 0 && len(tunnelConfig.NetmapRemote) == 0 {
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("【网段映射】(隧道创建后在对端执行)")
+	fmt.Println()
+	fmt.Printf("twnode line set-netmap %s", tunnelConfig.Name)
+	if len(tunnelConfig.NetmapRemote) > 0 {
+		fmt.Printf(" --local %s", network.NetmapString(tunnelConfig.NetmapRemote))
+	}
+	if len(tunnelConfig.NetmapLocal) > 0 {
+		fmt.Printf(" --remote %s", network.NetmapString(tunnelConfig.NetmapLocal))
+	}
+	fmt.Println()
+	for _, rule := range tunnelConfig.NetmapLocal {
+		fmt.Printf("  ℹ 本端 %s 在对端显示为 %s\n", rule.Real, rule.Mapped)
+	}
+	for _, rule := range tunnelConfig.NetmapRemote {
+		fmt.Printf("  ℹ 对端 %s 在本端显示为 %s\n", rule.Real, rule.Mapped)
+	}
+}
+
+// syncFirewall 隧道/接口配置变化后同步 twnode 防火墙表（仅在已启用时）
+func syncFirewall(cmd *cobra.Command, args []string) {
+	if err := firewall.Sync(); err != nil {
+		fmt.Printf("⚠ 同步防火墙规则失败: %v\n", err)
+	}
+}
+
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
@@ -543,6 +680,7 @@ func main() {
 	interfaceScanCmd := &cobra.Command{
 		Use:   "scan",
 		Short: "重新扫描物理接口",
+		Long:  "重新扫描物理接口，更新已配置接口的IP和网关\n多出口（2个及以上有网关的接口）时同步源地址路由：每个接口一张路由表(1000-1099)，\n并添加 from <接口IP> lookup <表> 规则(pref 8)，保证回程流量从进入的接口发出",
 		Run: func(cmd *cobra.Command, args []string) {
 			interfaces, err := network.ScanPhysicalInterfaces()
 			if err != nil {
@@ -565,6 +703,43 @@ func main() {
 					fmt.Printf("    网关: (未检测到)\n")
 				}
 			}
+
+			// 更新已配置接口的IP和网关（保留成本和启用状态）
+			ifaceConfig, err := network.LoadInterfaceConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载接口配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			changed := false
+			for _, iface := range interfaces {
+				configured := ifaceConfig.GetInterfaceByName(iface.Name)
+				if configured == nil {
+					continue
+				}
+				if configured.IP != iface.IP || configured.Gateway != iface.Gateway {
+					fmt.Printf("\n  ⚠ %s 已变化: %s (网关 %s) → %s (网关 %s)\n",
+						iface.Name, configured.IP, configured.Gateway, iface.IP, iface.Gateway)
+					configured.IP = iface.IP
+					configured.Gateway = iface.Gateway
+					changed = true
+				}
+			}
+
+			if changed {
+				if err := network.SaveInterfaceConfig(ifaceConfig); err != nil {
+					fmt.Fprintf(os.Stderr, "保存接口配置失败: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Println("  ✓ 接口配置已更新")
+			}
+
+			// 同步多出口源地址路由
+			fmt.Println()
+			if err := routing.SyncSourceRouting(); err != nil {
+				fmt.Fprintf(os.Stderr, "同步源地址路由失败: %v\n", err)
+				os.Exit(1)
+			}
 		},
 	}
 
@@ -614,13 +789,176 @@ func main() {
 		},
 	}
 
-	interfaceCmd.AddCommand(interfaceListCmd, interfaceScanCmd, interfaceSetCostCmd)
+	// 设置接口出口NAT
+	interfaceSetNATCmd := &cobra.Command{
+		Use:   "set-nat <interface_name> <masquerade|snat:<address>|none>",
+		Short: "设置物理接口的出口NAT方式",
+		Long:  "设置从该物理接口转发出去的流量的NAT方式(nftables 表 inet twnode):\n  masquerade       使用接口地址伪装（默认）\n  snat:<address>   SNAT 到指定地址\n  none             不做NAT",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			interfaceName := args[0]
+
+			mode, _, err := firewall.ParseNATMode(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+
+			ifaceConfig, err := network.LoadInterfaceConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载接口配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			iface := ifaceConfig.GetInterfaceByName(interfaceName)
+			if iface == nil {
+				fmt.Fprintf(os.Stderr, "错误: 接口 %s 不存在\n", interfaceName)
+				os.Exit(1)
+			}
+
+			iface.NATMode = args[1]
+			if mode == firewall.NATMasquerade {
+				iface.NATMode = ""
+			}
+
+			if err := network.SaveInterfaceConfig(ifaceConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 接口 %s 的出口NAT已设置为 %s\n", interfaceName, args[1])
+		},
+		PostRun: syncFirewall,
+	}
+
+	// 设置接口流量整形
+	interfaceSetShapingCmd := &cobra.Command{
+		Use:     "set-shaping <interface_name>",
+		Short:   "设置物理接口的流量整形（tc）",
+		Long:    "设置物理接口的出向限速、入向限速（经 ifb 设备）和队列算法，立即应用，line start-all 时重新应用\n未指定的参数保持不变，--off 删除整形配置",
+		Example: "  twnode interface set-shaping eth0 --egress 100mbit --ingress 200mbit --qdisc cake",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			interfaceName := args[0]
+
+			ifaceConfig, err := network.LoadInterfaceConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载接口配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			iface := ifaceConfig.GetInterfaceByName(interfaceName)
+			if iface == nil {
+				fmt.Fprintf(os.Stderr, "错误: 接口 %s 不存在\n", interfaceName)
+				os.Exit(1)
+			}
+
+			shaping, err := parseShapingFlags(cmd, iface.Shaping)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+			iface.Shaping = shaping
+
+			if err := network.SaveInterfaceConfig(ifaceConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := network.ApplyShaping(interfaceName, "", shaping); err != nil {
+				fmt.Fprintf(os.Stderr, "应用流量整形失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 接口 %s 的流量整形已设置: %s\n", interfaceName, shaping.Summary())
+		},
+	}
+	addShapingFlags(interfaceSetShapingCmd)
+
+	// 查看接口流量整形
+	interfaceShapingCmd := &cobra.Command{
+		Use:   "shaping [interface_name]",
+		Short: "查看物理接口的流量整形和实时计数器",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ifaceConfig, err := network.LoadInterfaceConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载接口配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			shown := 0
+			for _, iface := range ifaceConfig.Interfaces {
+				if len(args) == 1 && iface.Name != args[0] {
+					continue
+				}
+				if len(args) == 0 && iface.Shaping == nil {
+					continue
+				}
+				if shown > 0 {
+					fmt.Println()
+				}
+				network.PrintShaping(iface.Name, "", iface.Shaping)
+				shown++
+			}
+
+			if shown == 0 {
+				if len(args) == 1 {
+					fmt.Fprintf(os.Stderr, "错误: 接口 %s 不存在\n", args[0])
+					os.Exit(1)
+				}
+				fmt.Println("没有配置流量整形的物理接口")
+			}
+		},
+	}
+
+	// 设置接口流量配额
+	interfaceSetQuotaCmd := &cobra.Command{
+		Use:     "set-quota <interface_name> <limit|off>",
+		Short:   "设置物理接口的流量配额（按量计费线路）",
+		Long:    "设置每个计费周期允许的流量，用量超过软阈值后故障转移评分使用的有效成本线性升至 100，\n配额耗尽后仅在没有其他可用出口时使用该接口。用量来自流量统计采集",
+		Example: "  twnode interface set-quota wwan0 50GB --reset-day 5\n  twnode interface set-quota wwan0 off",
+		Args:    cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			interfaceName := args[0]
+
+			quota, err := parseQuotaArgs(cmd, args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+
+			ifaceConfig, err := network.LoadInterfaceConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载接口配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			iface := ifaceConfig.GetInterfaceByName(interfaceName)
+			if iface == nil {
+				fmt.Fprintf(os.Stderr, "错误: 接口 %s 不存在\n", interfaceName)
+				os.Exit(1)
+			}
+
+			iface.Quota = quota
+
+			if err := network.SaveInterfaceConfig(ifaceConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 接口 %s 的流量配额: %s\n", interfaceName, quota.Summary())
+		},
+	}
+	addQuotaFlags(interfaceSetQuotaCmd)
+
+	interfaceCmd.AddCommand(interfaceListCmd, interfaceScanCmd, interfaceSetCostCmd, interfaceSetNATCmd, interfaceSetShapingCmd, interfaceShapingCmd, interfaceSetQuotaCmd)
 
 	// 初始化命令
 	initCmd := &cobra.Command{
 		Use:   "init",
 		Short: "初始化系统环境",
-		Long:  "检查并配置系统环境，包括IP转发、iptables等",
+		Long:  "检查并配置系统环境，包括IP转发、nftables出口NAT等",
 		Run: func(cmd *cobra.Command, args []string) {
 			if err := system.Initialize(); err != nil {
 				fmt.Fprintf(os.Stderr, "初始化失败: %v\n", err)
@@ -641,6 +979,95 @@ func main() {
 		},
 	}
 
+	// 漂移检测
+	driftCmd := &cobra.Command{
+		Use:   "drift",
+		Short: "检测配置与内核状态的漂移",
+		Long:  "比较配置中的隧道、表80 VIP路由、保护规则、已应用的策略组和默认路由与内核实际状态\n报告缺失/多余/不一致的项目\n使用 --fix 自动修复（策略组和默认路由保留内核中当前的出口）",
+		Run: func(cmd *cobra.Command, args []string) {
+			fix, _ := cmd.Flags().GetBool("fix")
+
+			report, err := drift.Check()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "漂移检测失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			report.Print()
+
+			if !fix || !report.HasDrift() {
+				if report.HasDrift() {
+					fmt.Println()
+					fmt.Println("使用 'twnode drift --fix' 自动修复")
+					os.Exit(2)
+				}
+				return
+			}
+
+			fmt.Println()
+			fmt.Println("开始修复...")
+			fixed, errs := report.Fix(nil)
+			for _, err := range errs {
+				fmt.Printf("  ✗ %v\n", err)
+			}
+
+			fmt.Println()
+			fmt.Printf("✓ 已修复 %d/%d 项\n", fixed, len(report.Items))
+			if len(errs) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	driftCmd.Flags().Bool("fix", false, "自动修复漂移")
+
+	// 垃圾回收
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "清理残留的路由/xfrm/接口状态",
+		Long:  "查找 twnode 创建但已无对应配置的内核状态和文件并删除:\n  - 撤销文件、xfrm 状态/策略\n  - 检测临时规则(pref 5)、保护规则(pref 10)、隧道策略路由(表50)、VIP路由(表80)\n  - 无对应策略组的规则和路由表(100-898)、未设置默认出口时的表900\n  - 无配置的 GRE/WireGuard 接口（可能不是 twnode 创建，需 --force）\n  - 无对应隧道的对端配置文件",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			force, _ := cmd.Flags().GetBool("force")
+			yes, _ := cmd.Flags().GetBool("yes")
+
+			report, err := gc.Collect(nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "收集残留失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Println("【残留状态】")
+			report.Print()
+
+			count := report.Count(force)
+			if dryRun || count == 0 {
+				return
+			}
+
+			if !yes {
+				confirm := readInput(fmt.Sprintf("\n确认删除以上 %d 项? (yes/no): ", count))
+				if confirm != "yes" {
+					fmt.Println("已取消")
+					return
+				}
+			}
+
+			removed, errs := report.Remove(force)
+			for _, err := range errs {
+				fmt.Printf("  ✗ %v\n", err)
+			}
+
+			fmt.Println()
+			fmt.Printf("✓ 已删除 %d/%d 项\n", removed, count)
+			if len(errs) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	gcCmd.Flags().Bool("dry-run", false, "仅列出，不删除")
+	gcCmd.Flags().Bool("force", false, "同时删除不确定由 twnode 创建的项")
+	gcCmd.Flags().BoolP("yes", "y", false, "不询问直接删除")
+
 	// 线路管理命令组
 	lineCmd := &cobra.Command{
 		Use:   "line",
@@ -649,10 +1076,11 @@ func main() {
 
 	// 创建线路
 	lineCreateCmd := &cobra.Command{
-		Use:   "create [parent_interface] [remote_ip] [remote_vip] [local_vip] [tunnel_name]",
-		Short: "创建隧道(自动创建IPsec连接和GRE隧道)",
-		Long:  "不带参数时进入交互模式\n带参数格式: twnode line create <parent_interface> <remote_ip> <remote_vip> <local_vip> [tunnel_name] --auth-key <key> --enc-key <key>",
-		Args:  cobra.RangeArgs(0, 5),
+		Use:     "create [parent_interface] [remote_ip] [remote_vip] [local_vip] [tunnel_name]",
+		Short:   "创建隧道(自动创建IPsec连接和GRE隧道)",
+		Long:    "不带参数时进入交互模式\n带参数格式: twnode line create <parent_interface> <remote_ip> <remote_vip> <local_vip> [tunnel_name] --auth-key <key> --enc-key <key>",
+		Args:    cobra.RangeArgs(0, 5),
+		PostRun: syncFirewall,
 		Run: func(cmd *cobra.Command, args []string) {
 			// 如果没有参数，进入交互模式
 			if len(args) == 0 {
@@ -829,6 +1257,10 @@ func main() {
 				}
 			}
 
+			// 隔离上下文（VRF 或网络命名空间）
+			tunnelConfig.VRF, _ = cmd.Flags().GetString("vrf")
+			tunnelConfig.Netns, _ = cmd.Flags().GetString("netns")
+
 			// 使用TunnelManager创建
 			tm := ipsec.NewTunnelManager(tunnelConfig)
 			if err := tm.Create(); err != nil {
@@ -864,6 +1296,8 @@ func main() {
 
 	// 通用参数
 	lineCreateCmd.Flags().Int("cost", 0, "成本值(0-100,默认0)")
+	lineCreateCmd.Flags().String("vrf", "", "隧道接口加入的VRF（VIP和对端网段路由写入VRF路由表）")
+	lineCreateCmd.Flags().String("netns", "", "隧道接口移入的网络命名空间（与 --vrf 二选一）")
 
 	// 删除隧道
 	lineRemoveCmd := &cobra.Command{
@@ -876,6 +1310,7 @@ func main() {
 				os.Exit(1)
 			}
 		},
+		PostRun: syncFirewall,
 	}
 
 	// 启动单个隧道
@@ -959,7 +1394,7 @@ func main() {
 				fmt.Printf("隧道 %s 已经是启用状态\n", tunnelName)
 
 				// 检查是否已启动，未启动则启动
-				if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s", tunnelName)); os.IsNotExist(err) {
+				if !network.LinkExistsIn(tunnelName, tunnelConfig.Netns) {
 					fmt.Printf("隧道未启动，正在启动...\n")
 					tm := ipsec.NewTunnelManager(tunnelConfig)
 					if err := tm.Start(); err != nil {
@@ -1012,7 +1447,7 @@ func main() {
 				fmt.Printf("隧道 %s 已经是禁用状态\n", tunnelName)
 
 				// 检查是否在运行，运行中则停止
-				if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s", tunnelName)); err == nil {
+				if network.LinkExistsIn(tunnelName, tunnelConfig.Netns) {
 					fmt.Printf("隧道正在运行，正在停止...\n")
 					tm := ipsec.NewTunnelManager(tunnelConfig)
 					if err := tm.Stop(); err != nil {
@@ -1148,42 +1583,550 @@ func main() {
 		},
 	}
 
-	// 显示对端配置命令
-	lineShowPeerCmd := &cobra.Command{
-		Use:   "show-peer <tunnel_name>",
-		Short: "显示对端创建命令",
-		Long:  "显示已创建隧道的对端配置命令，支持 IPsec 和 WireGuard",
-		Args:  cobra.ExactArgs(1),
+	// 设置隧道出口NAT
+	lineSetNATCmd := &cobra.Command{
+		Use:   "set-nat <tunnel_name> <masquerade|snat:<address>|none>",
+		Short: "设置隧道的出口NAT方式",
+		Long:  "设置从该隧道转发出去的流量的NAT方式(nftables 表 inet twnode):\n  masquerade       使用隧道本地VIP伪装（默认）\n  snat:<address>   SNAT 到指定地址\n  none             不做NAT（站点互联，两端子网直接互通）",
+		Args:  cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			tunnelName := args[0]
 
-			// 加载隧道配置
+			mode, _, err := firewall.ParseNATMode(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+
 			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
 				os.Exit(1)
 			}
 
-			// 根据隧道类型生成对端配置
-			if tunnelConfig.TunnelType == "wireguard" {
-				// WireGuard 隧道
-				// 检查是否有保存的对端配置
-				peerConfigPath := fmt.Sprintf("/var/lib/trueword_node/peer_configs/%s.txt", tunnelName)
-				if data, err := os.ReadFile(peerConfigPath); err == nil {
-					fmt.Println(string(data))
-					return
-				}
+			tunnelConfig.NATMode = args[1]
+			if mode == firewall.NATMasquerade {
+				tunnelConfig.NATMode = ""
+			}
 
-				// 如果没有保存的配置，尝试重新生成（需要对端密钥）
-				fmt.Printf("⚠️  未找到保存的对端配置文件\n\n")
-				fmt.Printf("WireGuard 隧道的对端配置需要对端私钥，该私钥在创建时生成。\n")
-				fmt.Printf("如果您丢失了对端配置，建议重新创建隧道。\n\n")
-				fmt.Printf("您可以查看当前隧道配置:\n")
-				fmt.Printf("  隧道名: %s\n", tunnelConfig.Name)
-				fmt.Printf("  模式: %s\n", tunnelConfig.WGMode)
-				fmt.Printf("  本地IP: %s\n", tunnelConfig.LocalIP)
-				fmt.Printf("  本地VIP: %s\n", tunnelConfig.LocalVIP)
-				fmt.Printf("  远程VIP: %s\n", tunnelConfig.RemoteVIP)
+			if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 隧道 %s 的出口NAT已设置为 %s\n", tunnelName, args[1])
+		},
+		PostRun: syncFirewall,
+	}
+
+	// 设置隧道 MSS 钳制
+	lineSetMSSCmd := &cobra.Command{
+		Use:   "set-mss <tunnel_name> <auto|off|size>",
+		Short: "设置隧道的TCP MSS钳制",
+		Long:  "设置经过该隧道转发的TCP连接的MSS钳制(nftables 表 inet twnode):\n  auto   按路径MTU自动钳制（默认）\n  off    不钳制\n  size   固定MSS值(536-9000)",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			tunnelName := args[0]
+
+			size, err := firewall.ParseMSSClamp(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+
+			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			tunnelConfig.MSSClamp = size
+
+			if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 隧道 %s 的MSS钳制已设置为 %s\n", tunnelName, args[1])
+		},
+		PostRun: syncFirewall,
+	}
+
+	lineSetSubnetsCmd := &cobra.Command{
+		Use:   "set-subnets <tunnel_name>",
+		Short: "设置隧道两端的站点网段",
+		Long: "设置站点互联的内网网段（逗号分隔，传空字符串清空）:\n" +
+			"  --remote  对端站点网段，隧道启动时与对端VIP一起路由到表80\n" +
+			"  --local   本端站点网段，通过 show-peer 告知对端\n" +
+			"  --wg-strict-allowed  WireGuard allowed-ips 仅包含对端VIP和对端网段（多对端场景）",
+		Example: "  twnode line set-subnets tun01 --remote 192.168.20.0/24,192.168.21.0/24 --local 192.168.10.0/24",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tunnelName := args[0]
+
+			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+				os.Exit(1)
+			}
+			oldSubnets := tunnelConfig.RoutedSubnets()
+
+			if cmd.Flags().Changed("remote") {
+				value, _ := cmd.Flags().GetString("remote")
+				subnets, err := network.ParseSubnets(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+					os.Exit(1)
+				}
+				tunnelConfig.RemoteSubnets = subnets
+			}
+
+			if cmd.Flags().Changed("local") {
+				value, _ := cmd.Flags().GetString("local")
+				subnets, err := network.ParseSubnets(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+					os.Exit(1)
+				}
+				tunnelConfig.LocalSubnets = subnets
+			}
+
+			if cmd.Flags().Changed("wg-strict-allowed") {
+				if tunnelConfig.TunnelType != "wireguard" {
+					fmt.Fprintf(os.Stderr, "错误: --wg-strict-allowed 仅适用于 WireGuard 隧道\n")
+					os.Exit(1)
+				}
+				tunnelConfig.WGStrictAllowed, _ = cmd.Flags().GetBool("wg-strict-allowed")
+			}
+
+			if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := ipsec.NewTunnelManager(tunnelConfig).SyncSubnets(oldSubnets); err != nil {
+				fmt.Fprintf(os.Stderr, "同步站点网段路由失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 隧道 %s 的站点网段已更新\n", tunnelName)
+			fmt.Printf("  对端网段: %s\n", subnetsDisplay(tunnelConfig.RemoteSubnets))
+			fmt.Printf("  本端网段: %s\n", subnetsDisplay(tunnelConfig.LocalSubnets))
+		},
+	}
+	lineSetSubnetsCmd.Flags().String("remote", "", "对端站点网段（逗号分隔）")
+	lineSetSubnetsCmd.Flags().String("local", "", "本端站点网段（逗号分隔）")
+	lineSetSubnetsCmd.Flags().Bool("wg-strict-allowed", false, "WireGuard allowed-ips 仅包含对端VIP和对端网段")
+
+	lineSetNetmapCmd := &cobra.Command{
+		Use:   "set-netmap <tunnel_name>",
+		Short: "设置隧道的1:1网段映射（两端站点网段重叠时）",
+		Long: "两端站点使用相同网段时，每端把自己的真实网段映射为唯一网段（逗号分隔的 真实网段=映射网段，传空字符串清空）:\n" +
+			"  --local   本端网段映射，出隧道时 SNAT 真实网段→映射网段，入隧道时 DNAT 映射网段→真实网段\n" +
+			"  --remote  对端网段映射（由对端执行），本端将映射后的网段路由到隧道\n" +
+			"两端配置互为镜像，show-peer 输出对端需要执行的命令",
+		Example: "  twnode line set-netmap tun01 --local 192.168.1.0/24=10.101.1.0/24 --remote 192.168.1.0/24=10.102.1.0/24",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tunnelName := args[0]
+
+			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+				os.Exit(1)
+			}
+			oldSubnets := tunnelConfig.RoutedSubnets()
+
+			if cmd.Flags().Changed("local") {
+				value, _ := cmd.Flags().GetString("local")
+				rules, err := network.ParseNetmap(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+					os.Exit(1)
+				}
+				tunnelConfig.NetmapLocal = rules
+			}
+
+			if cmd.Flags().Changed("remote") {
+				value, _ := cmd.Flags().GetString("remote")
+				rules, err := network.ParseNetmap(value)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+					os.Exit(1)
+				}
+				tunnelConfig.NetmapRemote = rules
+			}
+
+			if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			tm := ipsec.NewTunnelManager(tunnelConfig)
+			if err := tm.SyncSubnets(oldSubnets); err != nil {
+				fmt.Fprintf(os.Stderr, "同步对端映射网段路由失败: %v\n", err)
+				os.Exit(1)
+			}
+			if err := tm.SyncNetmap(); err != nil {
+				fmt.Fprintf(os.Stderr, "同步网段映射失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 隧道 %s 的网段映射已更新\n", tunnelName)
+			fmt.Printf("  本端映射: %s\n", network.NetmapDisplay(tunnelConfig.NetmapLocal))
+			fmt.Printf("  对端映射: %s\n", network.NetmapDisplay(tunnelConfig.NetmapRemote))
+		},
+	}
+	lineSetNetmapCmd.Flags().String("local", "", "本端网段映射（真实网段=映射网段，逗号分隔）")
+	lineSetNetmapCmd.Flags().String("remote", "", "对端网段映射（对端真实网段=对端映射网段，逗号分隔）")
+
+	// 设置隧道流量整形
+	lineSetShapingCmd := &cobra.Command{
+		Use:     "set-shaping <tunnel_name>",
+		Short:   "设置隧道的流量整形（tc）",
+		Long:    "设置隧道的出向限速、入向限速（经 ifb 设备）和队列算法，隧道启动时应用，停止时撤销\n未指定的参数保持不变，--off 删除整形配置",
+		Example: "  twnode line set-shaping tun01 --egress 20mbit --qdisc cake",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tunnelName := args[0]
+
+			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			shaping, err := parseShapingFlags(cmd, tunnelConfig.Shaping)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+			tunnelConfig.Shaping = shaping
+
+			if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := ipsec.NewTunnelManager(tunnelConfig).SyncShaping(); err != nil {
+				fmt.Fprintf(os.Stderr, "应用流量整形失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 隧道 %s 的流量整形已设置: %s\n", tunnelName, shaping.Summary())
+			if !network.LinkExistsIn(tunnelConfig.Name, tunnelConfig.Netns) {
+				fmt.Println("  ℹ 隧道未运行，将在启动时应用")
+			}
+		},
+	}
+	addShapingFlags(lineSetShapingCmd)
+
+	// 查看隧道流量整形
+	lineShapingCmd := &cobra.Command{
+		Use:   "shaping [tunnel_name]",
+		Short: "查看隧道的流量整形和实时计数器",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var tunnels []*network.TunnelConfig
+			if len(args) == 1 {
+				tunnelConfig, err := network.LoadTunnelConfig(args[0])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+					os.Exit(1)
+				}
+				tunnels = append(tunnels, tunnelConfig)
+			} else {
+				all, err := network.ListTunnelConfigs()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+					os.Exit(1)
+				}
+				for _, tunnelConfig := range all {
+					if tunnelConfig.Shaping != nil {
+						tunnels = append(tunnels, tunnelConfig)
+					}
+				}
+			}
+
+			if len(tunnels) == 0 {
+				fmt.Println("没有配置流量整形的隧道")
+				return
+			}
+
+			for i, tunnelConfig := range tunnels {
+				if i > 0 {
+					fmt.Println()
+				}
+				network.PrintShaping(tunnelConfig.Name, tunnelConfig.Netns, tunnelConfig.Shaping)
+			}
+		},
+	}
+
+	// 隧道防火墙命令组
+	lineFirewallCmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "管理隧道防火墙（限制对端经隧道访问的服务）",
+		Long: "隧道防火墙限制对端经隧道进入的流量（访问本机和经本机转发），规则按顺序匹配，未匹配时执行默认策略\n" +
+			"规则保存在隧道配置中，隧道启动时应用到独立的 nftables 表 inet twnode_tunnel_<隧道名>，停止时撤销",
+	}
+
+	lineFirewallShowCmd := &cobra.Command{
+		Use:   "show [tunnel_name]",
+		Short: "显示隧道防火墙规则",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var tunnels []*network.TunnelConfig
+			if len(args) == 1 {
+				tunnelConfig, err := network.LoadTunnelConfig(args[0])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+					os.Exit(1)
+				}
+				tunnels = append(tunnels, tunnelConfig)
+			} else {
+				all, err := network.ListTunnelConfigs()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+					os.Exit(1)
+				}
+				tunnels = all
+			}
+
+			if len(tunnels) == 0 {
+				fmt.Println("没有配置隧道")
+				return
+			}
+			network.PrintTunnelFirewalls(tunnels)
+		},
+	}
+
+	// updateTunnelFirewall 修改隧道防火墙配置，保存并同步到运行中的隧道
+	updateTunnelFirewall := func(tunnelName string, update func(tunnelConfig *network.TunnelConfig) error) {
+		tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := update(tunnelConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := ipsec.NewTunnelManager(tunnelConfig).SyncFirewall(); err != nil {
+			fmt.Fprintf(os.Stderr, "同步隧道防火墙失败: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("✓ 隧道 %s 的防火墙已更新: %s\n", tunnelName, network.TunnelFirewallSummary(tunnelConfig.Firewall))
+		if !network.LinkExistsIn(tunnelConfig.Name, tunnelConfig.Netns) {
+			fmt.Println("  ℹ 隧道未运行，将在启动时应用")
+		}
+	}
+
+	lineFirewallPolicyCmd := &cobra.Command{
+		Use:   "policy <tunnel_name> <accept|drop|off>",
+		Short: "设置隧道防火墙默认策略（off 关闭隧道防火墙并清空规则）",
+		Long:  "设置对端流量未匹配任何规则时的处理方式\n  accept: 默认放行（配合 deny 规则使用）\n  drop:   默认拒绝（配合 allow 规则使用，仍放行对端到本端VIP的 ICMP 探测）\n  off:    关闭隧道防火墙并清空规则",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			updateTunnelFirewall(args[0], func(tunnelConfig *network.TunnelConfig) error {
+				if args[1] == "off" {
+					tunnelConfig.Firewall = nil
+					return nil
+				}
+				policy, err := network.ParseTunnelFirewallPolicy(args[1])
+				if err != nil {
+					return err
+				}
+				if tunnelConfig.Firewall == nil {
+					tunnelConfig.Firewall = &network.TunnelFirewall{}
+				}
+				tunnelConfig.Firewall.Policy = policy
+				return nil
+			})
+		},
+	}
+
+	// newTunnelFirewallRuleCmd 添加规则命令（allow / deny）
+	newTunnelFirewallRuleCmd := func(use, action, short string) *cobra.Command {
+		ruleCmd := &cobra.Command{
+			Use:     use + " <tunnel_name>",
+			Short:   short,
+			Example: fmt.Sprintf("  twnode line firewall %s tun01 --dest 192.168.1.0/24 --proto tcp --port 22,443", use),
+			Args:    cobra.ExactArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				dest, _ := cmd.Flags().GetString("dest")
+				proto, _ := cmd.Flags().GetString("proto")
+				ports, _ := cmd.Flags().GetString("port")
+
+				rule, err := network.NewTunnelFirewallRule(action, dest, proto, ports)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+					os.Exit(1)
+				}
+
+				updateTunnelFirewall(args[0], func(tunnelConfig *network.TunnelConfig) error {
+					if tunnelConfig.Firewall == nil {
+						// 首次添加放行规则时默认拒绝，添加拒绝规则时默认放行
+						policy := network.TunnelFirewallDrop
+						if action == network.TunnelFirewallDrop {
+							policy = network.TunnelFirewallAccept
+						}
+						tunnelConfig.Firewall = &network.TunnelFirewall{Policy: policy}
+						fmt.Printf("ℹ 启用隧道防火墙，默认策略: %s\n", policy)
+					}
+					tunnelConfig.Firewall.Rules = append(tunnelConfig.Firewall.Rules, rule)
+					return nil
+				})
+			},
+		}
+		ruleCmd.Flags().String("dest", "", "目的网段（逗号分隔，默认任意）")
+		ruleCmd.Flags().String("proto", "", "协议: tcp/udp/icmp/any（默认任意）")
+		ruleCmd.Flags().String("port", "", "目的端口: 22,443,8000-8100（仅 tcp/udp）")
+		return ruleCmd
+	}
+	lineFirewallAllowCmd := newTunnelFirewallRuleCmd("allow", network.TunnelFirewallAccept, "添加放行规则")
+	lineFirewallDenyCmd := newTunnelFirewallRuleCmd("deny", network.TunnelFirewallDrop, "添加拒绝规则")
+
+	lineFirewallRemoveCmd := &cobra.Command{
+		Use:   "remove <tunnel_name> <序号>",
+		Short: "删除隧道防火墙规则（序号见 line firewall show）",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			index, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "无效的序号: %s\n", args[1])
+				os.Exit(1)
+			}
+
+			updateTunnelFirewall(args[0], func(tunnelConfig *network.TunnelConfig) error {
+				if tunnelConfig.Firewall == nil || index < 1 || index > len(tunnelConfig.Firewall.Rules) {
+					return fmt.Errorf("规则 %d 不存在", index)
+				}
+				rules := tunnelConfig.Firewall.Rules
+				tunnelConfig.Firewall.Rules = append(rules[:index-1], rules[index:]...)
+				return nil
+			})
+		},
+	}
+
+	lineFirewallCmd.AddCommand(lineFirewallShowCmd, lineFirewallPolicyCmd, lineFirewallAllowCmd, lineFirewallDenyCmd, lineFirewallRemoveCmd)
+
+	lineSetIsolationCmd := &cobra.Command{
+		Use:   "set-isolation <tunnel_name>",
+		Short: "设置隧道的隔离上下文（VRF 或网络命名空间）",
+		Long: "将隧道接口放入 VRF 或网络命名空间（二选一，均不指定表示恢复默认上下文）:\n" +
+			"  --vrf    隧道接口加入VRF，对端VIP和对端网段路由写入VRF的路由表\n" +
+			"  --netns  隧道接口移入网络命名空间，地址和路由在命名空间内配置（underlay 仍在默认命名空间）\n" +
+			"运行中的隧道会重新启动",
+		Example: "  twnode line set-isolation tun01 --vrf vrf-blue\n" +
+			"  twnode line set-isolation tun02 --netns tenant-a\n" +
+			"  twnode line set-isolation tun01",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tunnelName := args[0]
+			vrf, _ := cmd.Flags().GetString("vrf")
+			netns, _ := cmd.Flags().GetString("netns")
+
+			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := network.ValidateIsolation(vrf, netns); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+			if netns != "" {
+				// 其他隧道以本隧道为父接口时，underlay 必须留在默认命名空间
+				tunnels, _ := network.ListTunnelConfigs()
+				for _, other := range tunnels {
+					if other.ParentInterface == tunnelName {
+						fmt.Fprintf(os.Stderr, "错误: 隧道 %s 是隧道 %s 的父接口，不能移入网络命名空间\n", tunnelName, other.Name)
+						os.Exit(1)
+					}
+				}
+			}
+
+			// 先按旧的上下文停止运行中的隧道
+			running := network.LinkExistsIn(tunnelName, tunnelConfig.Netns)
+			if running {
+				if err := ipsec.NewTunnelManager(tunnelConfig).Stop(); err != nil {
+					fmt.Fprintf(os.Stderr, "停止隧道失败: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			tunnelConfig.VRF = vrf
+			tunnelConfig.Netns = netns
+			if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if running {
+				if err := ipsec.NewTunnelManager(tunnelConfig).Start(); err != nil {
+					fmt.Fprintf(os.Stderr, "启动隧道失败: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if err := routing.SyncProtection(); err != nil {
+				fmt.Printf("⚠ 警告: 同步保护路由失败: %v\n", err)
+			}
+
+			fmt.Printf("✓ 隧道 %s 的隔离上下文已设置为: %s\n", tunnelName, network.IsolationLabel(vrf, netns))
+		},
+		PostRun: syncFirewall,
+	}
+	lineSetIsolationCmd.Flags().String("vrf", "", "VRF 设备名")
+	lineSetIsolationCmd.Flags().String("netns", "", "网络命名空间名")
+
+	// 显示对端配置命令
+	lineShowPeerCmd := &cobra.Command{
+		Use:   "show-peer <tunnel_name>",
+		Short: "显示对端创建命令",
+		Long:  "显示已创建隧道的对端配置命令，支持 IPsec 和 WireGuard",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tunnelName := args[0]
+
+			// 加载隧道配置
+			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			// 根据隧道类型生成对端配置
+			if tunnelConfig.TunnelType == "wireguard" {
+				// WireGuard 隧道
+				// 检查是否有保存的对端配置
+				peerConfigPath := fmt.Sprintf("/var/lib/trueword_node/peer_configs/%s.txt", tunnelName)
+				if data, err := os.ReadFile(peerConfigPath); err == nil {
+					fmt.Println(string(data))
+					printPeerSubnets(tunnelConfig)
+					printPeerNetmap(tunnelConfig)
+					return
+				}
+
+				// 如果没有保存的配置，尝试重新生成（需要对端密钥）
+				fmt.Printf("⚠️  未找到保存的对端配置文件\n\n")
+				fmt.Printf("WireGuard 隧道的对端配置需要对端私钥，该私钥在创建时生成。\n")
+				fmt.Printf("如果您丢失了对端配置，建议重新创建隧道。\n\n")
+				fmt.Printf("您可以查看当前隧道配置:\n")
+				fmt.Printf("  隧道名: %s\n", tunnelConfig.Name)
+				fmt.Printf("  模式: %s\n", tunnelConfig.WGMode)
+				fmt.Printf("  本地IP: %s\n", tunnelConfig.LocalIP)
+				fmt.Printf("  本地VIP: %s\n", tunnelConfig.LocalVIP)
+				fmt.Printf("  远程VIP: %s\n", tunnelConfig.RemoteVIP)
 				fmt.Printf("  本地公钥: %s\n", tunnelConfig.PublicKey)
 				fmt.Printf("  监听端口: %d\n", tunnelConfig.ListenPort)
 
@@ -1223,12 +2166,48 @@ func main() {
 					fmt.Println("- 认证和加密密钥与本地相同")
 				}
 				fmt.Println("\n注意: 命令中已包含所有必需参数，替换 <父接口> 后可直接执行")
+				printPeerSubnets(tunnelConfig)
+				printPeerNetmap(tunnelConfig)
+			}
+		},
+	}
+
+	// 设置隧道流量配额
+	lineSetQuotaCmd := &cobra.Command{
+		Use:     "set-quota <tunnel_name> <limit|off>",
+		Short:   "设置隧道的流量配额（按量计费中转）",
+		Long:    "设置每个计费周期允许的流量，用量超过软阈值后故障转移评分使用的有效成本线性升至 100，\n配额耗尽后仅在没有其他可用出口时使用该隧道。用量来自流量统计采集",
+		Example: "  twnode line set-quota tun-hk 1TB --reset-day 1 --count tx\n  twnode line set-quota tun-hk off",
+		Args:    cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			tunnelName := args[0]
+
+			quota, err := parseQuotaArgs(cmd, args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+
+			tunnelConfig, err := network.LoadTunnelConfig(tunnelName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载隧道配置失败: %v\n", err)
+				os.Exit(1)
 			}
+
+			tunnelConfig.Quota = quota
+
+			if err := network.SaveTunnelConfig(tunnelConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 隧道 %s 的流量配额: %s\n", tunnelName, quota.Summary())
 		},
 	}
+	addQuotaFlags(lineSetQuotaCmd)
 
 	lineCmd.AddCommand(lineCreateCmd, lineRemoveCmd, lineStartCmd, lineStopCmd,
-		lineEnableCmd, lineDisableCmd, lineCheckCmd, lineStartAllCmd, lineStopAllCmd, lineSetCostCmd, lineShowPeerCmd)
+		lineEnableCmd, lineDisableCmd, lineCheckCmd, lineStartAllCmd, lineStopAllCmd, lineSetCostCmd, lineSetNATCmd, lineSetMSSCmd, lineSetSubnetsCmd, lineSetNetmapCmd, lineSetIsolationCmd, lineShowPeerCmd, lineFirewallCmd, lineSetShapingCmd, lineShapingCmd, lineSetQuotaCmd)
 
 	// 策略路由命令组
 	policyCmd := &cobra.Command{
@@ -1240,7 +2219,7 @@ func main() {
 	policyCreateCmd := &cobra.Command{
 		Use:   "create <group_name> <exit_interface>",
 		Short: "创建策略组",
-		Long:  "创建策略组，优先级自动分配或手动指定。出口可以是物理接口、隧道或第三方接口(OpenVPN/WireGuard等)\n可选参数 --from 指定源地址限制（接口名/CIDR/IP，默认all）\n可选参数 --priority 手动指定优先级（100-899，默认自动分配）",
+		Long:  "创建策略组，优先级自动分配或手动指定。出口可以是物理接口、隧道或第三方接口(OpenVPN/WireGuard等)\n可选参数 --from 指定源地址限制（接口名/CIDR/IP，默认all）\n可选参数 --priority 手动指定优先级（100-898，默认自动分配）\n可选参数 --on-exit-down 出口不可用时的处理方式（默认leak，流量回落到主路由表）",
 		Args:  cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			pm = routing.NewPolicyManager()
@@ -1270,10 +2249,11 @@ func main() {
 				}
 			}
 
+			ns := config.RoutingNamespace()
 			if priorityInput > 0 {
 				// 用户指定了优先级，检查是否合法且不冲突
-				if priorityInput < routing.PrioUserPolicyBase || priorityInput >= routing.PrioDefault {
-					fmt.Fprintf(os.Stderr, "错误: 优先级必须在 %d-%d 之间\n", routing.PrioUserPolicyBase, routing.PrioDefault-1)
+				if !ns.IsGroupPref(priorityInput) {
+					fmt.Fprintf(os.Stderr, "错误: 优先级必须在 %d-%d 之间\n", ns.GroupPrefMin, ns.GroupPrefMax())
 					os.Exit(1)
 				}
 
@@ -1287,7 +2267,7 @@ func main() {
 				fmt.Printf("使用指定优先级: %d\n", newPrio)
 			} else {
 				// 自动分配优先级，找到最大优先级
-				maxPrio := routing.PrioUserPolicyBase - 1
+				maxPrio := ns.GroupPrefMin - 1
 				for prio := range existingGroups {
 					if prio > maxPrio {
 						maxPrio = prio
@@ -1295,18 +2275,27 @@ func main() {
 				}
 
 				newPrio = maxPrio + 1
-				if newPrio >= routing.PrioDefault {
+				if newPrio > ns.GroupPrefMax() {
 					fmt.Fprintf(os.Stderr, "错误: 策略组数量已达上限\n")
 					os.Exit(1)
 				}
 				fmt.Printf("自动分配优先级: %d\n", newPrio)
 			}
 
-			if err := pm.CreateGroup(args[0], args[1], newPrio, fromInput); err != nil {
+			vrf, _ := cmd.Flags().GetString("vrf")
+			netns, _ := cmd.Flags().GetString("netns")
+			if err := pm.CreateGroup(args[0], args[1], newPrio, fromInput, vrf, netns); err != nil {
 				fmt.Fprintf(os.Stderr, "创建策略组失败: %v\n", err)
 				os.Exit(1)
 			}
 
+			if onExitDown, _ := cmd.Flags().GetString("on-exit-down"); onExitDown != "" {
+				if err := pm.SetGroupOnExitDown(args[0], onExitDown); err != nil {
+					fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
 			if err := pm.Save(); err != nil {
 				fmt.Fprintf(os.Stderr, "保存策略组失败: %v\n", err)
 				os.Exit(1)
@@ -1318,24 +2307,37 @@ func main() {
 
 	// 添加 --from 和 --priority 标志
 	policyCreateCmd.Flags().String("from", "all", "源地址/源地址段/源接口名（默认all表示所有源）")
-	policyCreateCmd.Flags().Int("priority", 0, "手动指定优先级（100-899，默认0表示自动分配）")
+	policyCreateCmd.Flags().Int("priority", 0, "手动指定优先级（100-898，默认0表示自动分配）")
+	policyCreateCmd.Flags().String("on-exit-down", "", "出口不可用时的处理方式: leak/blackhole/unreachable/fallback:<exit>（默认leak）")
+	policyCreateCmd.Flags().String("vrf", "", "只匹配从该VRF进入的流量")
+	policyCreateCmd.Flags().String("netns", "", "在网络命名空间内维护策略组（与 --vrf 二选一）")
 
 	// 添加CIDR
 	policyAddCmd := &cobra.Command{
 		Use:   "add <group_name> <cidr>",
 		Short: "向策略组添加CIDR",
+		Long:  "向策略组添加CIDR\n可选参数 --exclude 将CIDR添加为排除项（即使落在组内CIDR范围内也不走本组出口）",
 		Args:  cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			pm = routing.NewPolicyManager()
 
+			exclude, _ := cmd.Flags().GetBool("exclude")
+
 			if err := pm.LoadGroup(args[0]); err != nil {
 				fmt.Fprintf(os.Stderr, "加载策略组失败: %v\n", err)
 				os.Exit(1)
 			}
 
-			if err := pm.AddCIDR(args[0], args[1]); err != nil {
-				fmt.Fprintf(os.Stderr, "添加CIDR失败: %v\n", err)
-				os.Exit(1)
+			if exclude {
+				if err := pm.AddExclude(args[0], args[1]); err != nil {
+					fmt.Fprintf(os.Stderr, "添加排除CIDR失败: %v\n", err)
+					os.Exit(1)
+				}
+			} else {
+				if err := pm.AddCIDR(args[0], args[1]); err != nil {
+					fmt.Fprintf(os.Stderr, "添加CIDR失败: %v\n", err)
+					os.Exit(1)
+				}
 			}
 
 			if err := pm.Save(); err != nil {
@@ -1343,34 +2345,117 @@ func main() {
 				os.Exit(1)
 			}
 
+			if exclude {
+				fmt.Printf("✓ 已添加排除 %s 到策略组 %s\n", args[1], args[0])
+				return
+			}
+
 			fmt.Printf("✓ 已添加 %s 到策略组 %s\n", args[1], args[0])
+
+			routing.WarnCIDROverlaps(args[0])
 		},
 	}
+	policyAddCmd.Flags().Bool("exclude", false, "添加为排除CIDR")
 
 	// 从文件导入
 	policyImportCmd := &cobra.Command{
 		Use:   "import <group_name> <file_path>",
 		Short: "从文件批量导入CIDR到策略组",
+		Long:  "从文件批量导入CIDR到策略组（每行一个CIDR，忽略空行和#注释）\n可选参数 --exclude 将文件中的CIDR导入为排除项",
 		Args:  cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			pm = routing.NewPolicyManager()
 
+			exclude, _ := cmd.Flags().GetBool("exclude")
+
 			if err := pm.LoadGroup(args[0]); err != nil {
 				fmt.Fprintf(os.Stderr, "加载策略组失败: %v\n", err)
 				os.Exit(1)
 			}
 
-			if err := pm.ImportCIDRsFromFile(args[0], args[1]); err != nil {
-				fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
-				os.Exit(1)
+			if exclude {
+				if err := pm.ImportExcludesFromFile(args[0], args[1]); err != nil {
+					fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+					os.Exit(1)
+				}
+			} else {
+				if err := pm.ImportCIDRsFromFile(args[0], args[1]); err != nil {
+					fmt.Fprintf(os.Stderr, "导入失败: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if err := pm.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "保存失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if !exclude {
+				routing.WarnCIDROverlaps(args[0])
+			}
+		},
+	}
+	policyImportCmd.Flags().Bool("exclude", false, "导入为排除CIDR")
+
+	// 分析策略组
+	policyAnalyzeCmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "分析策略组CIDR的重复、冗余和跨组重叠",
+		Long:  "分析所有策略组:\n  - 组内重复、未规范化、被包含、可聚合的条目\n  - 跨组重叠（按优先级判断哪个组生效、被遮蔽的条目及重叠部分的实际出口）\n使用 --fix 规范化并聚合所有策略组的CIDR（不影响已应用的路由，需重新 apply）",
+		Run: func(cmd *cobra.Command, args []string) {
+			pm = routing.NewPolicyManager()
+
+			if err := pm.LoadAllGroups(); err != nil {
+				fmt.Fprintf(os.Stderr, "加载策略组失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fix, _ := cmd.Flags().GetBool("fix")
+			if fix {
+				fmt.Println("规范化并聚合策略组...")
+				for _, r := range pm.AnalyzeRedundancy() {
+					before, after, err := pm.NormalizeGroup(r.Group)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "处理策略组 %s 失败: %v\n", r.Group, err)
+						os.Exit(1)
+					}
+					if before != after {
+						fmt.Printf("  ✓ %s: %d 条 -> %d 条\n", r.Group, before, after)
+					}
+				}
+
+				if err := pm.Save(); err != nil {
+					fmt.Fprintf(os.Stderr, "保存失败: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Println("✓ 已保存，使用 'twnode policy apply' 使变更生效")
 			}
 
-			if err := pm.Save(); err != nil {
-				fmt.Fprintf(os.Stderr, "保存失败: %v\n", err)
+			pm.Analyze()
+		},
+	}
+	policyAnalyzeCmd.Flags().Bool("fix", false, "规范化并聚合所有策略组的CIDR")
+
+	// 解释路由决策
+	policyExplainCmd := &cobra.Command{
+		Use:   "explain <dst_ip>",
+		Short: "解释目标地址的路由决策（命中哪条规则、走哪个出口）",
+		Long:  "按内核规则顺序推演目标地址的路由决策:\n  系统保护规则(优先级10) -> 隧道VIP(表80) -> 用户策略组(100-898) -> 默认路由(900) -> 主路由表\n报告命中的策略组/保护规则及原因、出口接口和网关，并与内核 'ip route get' 的结果比对\n可选参数 --from 指定源地址，--iif 指定入接口（模拟转发流量）",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString("from")
+			iif, _ := cmd.Flags().GetString("iif")
+			verbose, _ := cmd.Flags().GetBool("verbose")
+
+			if err := routing.Explain(args[0], from, iif, verbose); err != nil {
+				fmt.Fprintf(os.Stderr, "解释路由失败: %v\n", err)
 				os.Exit(1)
 			}
 		},
 	}
+	policyExplainCmd.Flags().String("from", "", "源地址")
+	policyExplainCmd.Flags().String("iif", "", "入接口（不指定表示本机发出的流量）")
+	policyExplainCmd.Flags().BoolP("verbose", "v", false, "显示所有规则（包括非 twnode 管理的规则）")
 
 	// 列出策略组
 	policyListCmd := &cobra.Command{
@@ -1429,6 +2514,7 @@ func main() {
 			// 只应用默认路由（不重新应用所有策略组）
 			pm = routing.NewPolicyManager()
 			pm.SetDefaultExit(cfg.Routing.DefaultExit)
+			pm.SetDefaultOnExitDown(cfg.Routing.DefaultOnExitDown)
 
 			if err := pm.ApplyDefaultRouteOnly(); err != nil {
 				fmt.Fprintf(os.Stderr, "应用默认路由失败: %v\n", err)
@@ -1506,21 +2592,34 @@ func main() {
 					os.Exit(1)
 				}
 
-				// 验证出口接口
-				if !network.IsInterfaceUp(group.Exit) {
-					fmt.Fprintf(os.Stderr, "出口接口 %s 不存在或未启动\n", group.Exit)
-					os.Exit(1)
-				}
-
-				info, err := network.GetInterfaceInfo(group.Exit)
+				// 验证出口接口（配置了 on_exit_down 时由 ApplyGroup 处理出口不可用）
+				action, _, err := routing.ParseOnExitDown(group.OnExitDown)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "无法获取接口 %s 信息: %v\n", group.Exit, err)
+					fmt.Fprintf(os.Stderr, "策略组 %s: %v\n", groupName, err)
 					os.Exit(1)
 				}
 
-				if info.Type == network.InterfaceTypeLoopback {
-					fmt.Fprintf(os.Stderr, "不能使用回环接口作为出口\n")
-					os.Exit(1)
+				if action == routing.OnExitDownLeak && group.Netns != "" {
+					if !network.LinkUpIn(group.Exit, group.Netns) {
+						fmt.Fprintf(os.Stderr, "出口接口 %s 在网络命名空间 %s 中不存在或未启动\n", group.Exit, group.Netns)
+						os.Exit(1)
+					}
+				} else if action == routing.OnExitDownLeak {
+					if !network.IsInterfaceUp(group.Exit) {
+						fmt.Fprintf(os.Stderr, "出口接口 %s 不存在或未启动\n", group.Exit)
+						os.Exit(1)
+					}
+
+					info, err := network.GetInterfaceInfo(group.Exit)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "无法获取接口 %s 信息: %v\n", group.Exit, err)
+						os.Exit(1)
+					}
+
+					if info.Type == network.InterfaceTypeLoopback {
+						fmt.Fprintf(os.Stderr, "不能使用回环接口作为出口\n")
+						os.Exit(1)
+					}
 				}
 
 				fmt.Printf("应用策略组: %s\n", groupName)
@@ -1566,6 +2665,7 @@ func main() {
 			// 设置默认路由
 			if cfg.Routing.DefaultExit != "" {
 				pm.SetDefaultExit(cfg.Routing.DefaultExit)
+				pm.SetDefaultOnExitDown(cfg.Routing.DefaultOnExitDown)
 			}
 
 			// 应用
@@ -1573,6 +2673,14 @@ func main() {
 				fmt.Fprintf(os.Stderr, "应用策略失败: %v\n", err)
 				os.Exit(1)
 			}
+
+			// 按隧道和策略配置重新生成 twnode 防火墙表
+			fmt.Println("\n应用防火墙规则...")
+			if err := firewall.Apply(); err != nil {
+				fmt.Fprintf(os.Stderr, "应用防火墙规则失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✓ 防火墙规则已应用")
 		},
 	}
 
@@ -1638,6 +2746,13 @@ func main() {
 				fmt.Fprintf(os.Stderr, "撤销策略失败: %v\n", err)
 				os.Exit(1)
 			}
+
+			// 删除 twnode 防火墙表（出口NAT/MSS钳制/转发过滤）
+			if err := firewall.Remove(); err != nil {
+				fmt.Fprintf(os.Stderr, "撤销防火墙规则失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✓ 防火墙规则已撤销")
 		},
 	}
 
@@ -1675,6 +2790,12 @@ func main() {
 				os.Exit(1)
 			}
 
+			// 使用管理该目标的监控任务的评分参数（与守护进程一致），没有时使用全局评分参数
+			scoringConfig := scoring.Default()
+			if failoverConfig, err := failover.LoadConfig(failover.DefaultConfigFile); err == nil {
+				scoringConfig = failoverConfig.ScoringForTarget(target)
+			}
+
 			pm := routing.NewPolicyManager()
 
 			if target == "default" {
@@ -1683,9 +2804,10 @@ func main() {
 				cfg, err := config.Load()
 				if err == nil && cfg.Routing.DefaultExit != "" {
 					pm.SetDefaultExit(cfg.Routing.DefaultExit)
+					pm.SetDefaultOnExitDown(cfg.Routing.DefaultOnExitDown)
 				}
 
-				if err := pm.FailoverDefault(candidates, checkIP); err != nil {
+				if err := pm.FailoverDefault(candidates, checkIP, scoringConfig); err != nil {
 					fmt.Fprintf(os.Stderr, "切换默认路由失败: %v\n", err)
 					os.Exit(1)
 				}
@@ -1701,7 +2823,7 @@ func main() {
 				}
 			} else {
 				// 切换策略组
-				if err := pm.FailoverGroup(target, candidates, checkIP); err != nil {
+				if err := pm.FailoverGroup(target, candidates, checkIP, scoringConfig); err != nil {
 					fmt.Fprintf(os.Stderr, "切换策略组失败: %v\n", err)
 					os.Exit(1)
 				}
@@ -1709,11 +2831,130 @@ func main() {
 		},
 	}
 
+	// 计算候选出口评分（不切换）
+	var scoreMonitor, scoreCheckIP string
+	var scoreExplain bool
+	policyScoreCmd := &cobra.Command{
+		Use:   "score [exit...]",
+		Short: "计算候选出口的评分（不切换路由）",
+		Long: "按故障转移的评分参数计算候选出口的评分，使用上次检查结果（line check）\n" +
+			"示例: twnode policy score tun01 tun02 --explain\n" +
+			"      twnode policy score --monitor monitor-cn-routes --check-ip 223.5.5.5 --explain\n\n" +
+			"--monitor 使用监控任务的候选出口和评分参数（daemon.scoring + monitor.scoring）\n" +
+			"--explain 显示丢包/延迟/抖动得分、成本惩罚和连续失败惩罚",
+		Run: func(cmd *cobra.Command, args []string) {
+			candidates := args
+			var global, override *scoring.Overrides
+
+			if failoverConfig, err := failover.LoadConfig(failover.DefaultConfigFile); err == nil {
+				global = failoverConfig.Daemon.Scoring
+				if scoreMonitor != "" {
+					monitor := failoverConfig.GetMonitor(scoreMonitor)
+					if monitor == nil {
+						fmt.Fprintf(os.Stderr, "监控任务 %s 不存在\n", scoreMonitor)
+						os.Exit(1)
+					}
+					override = monitor.Scoring
+					if len(candidates) == 0 {
+						// 定时任务覆盖的候选出口优先
+						candidates = monitor.CandidateExits
+						if exits, ok := failover.LoadScheduleState().CandidateOverrides[monitor.Name]; ok {
+							candidates = exits
+						}
+					}
+				}
+			} else if scoreMonitor != "" {
+				fmt.Fprintf(os.Stderr, "加载故障转移配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if len(candidates) == 0 {
+				fmt.Fprintln(os.Stderr, "请指定候选出口或 --monitor")
+				os.Exit(1)
+			}
+			if scoreCheckIP != "" && net.ParseIP(scoreCheckIP) == nil {
+				fmt.Fprintf(os.Stderr, "无效的 IP 地址: %s\n", scoreCheckIP)
+				os.Exit(1)
+			}
+
+			if err := routing.ScoreExits(candidates, scoreCheckIP, scoring.Merge(global, override), scoreExplain); err != nil {
+				fmt.Fprintf(os.Stderr, "计算评分失败: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	policyScoreCmd.Flags().StringVar(&scoreMonitor, "monitor", "", "使用监控任务的候选出口和评分参数")
+	policyScoreCmd.Flags().StringVar(&scoreCheckIP, "check-ip", "", "重新检查候选出口使用的IP（默认使用上次检查结果）")
+	policyScoreCmd.Flags().BoolVar(&scoreExplain, "explain", false, "显示各项得分明细")
+
+	// 设置出口不可用时的处理方式
+	policyOnExitDownCmd := &cobra.Command{
+		Use:   "on-exit-down <group_name|default> <leak|blackhole|unreachable|fallback:<exit>>",
+		Short: "设置出口不可用时的处理方式(kill switch)",
+		Long: "设置策略组或默认路由的出口不可用时的处理方式:\n" +
+			"  leak              跳过，流量回落到后续规则/主路由表（默认）\n" +
+			"  blackhole         在路由表中安装 blackhole 路由，静默丢弃\n" +
+			"  unreachable       在路由表中安装 unreachable 路由，返回 ICMP 不可达\n" +
+			"  fallback:<exit>   切换到备用出口（备用出口也不可用时按 blackhole 处理）\n" +
+			"policy apply 和 failover 守护进程（所有候选出口均不可用时）都会执行该设置\n" +
+			"示例: twnode policy on-exit-down vpn_group blackhole",
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			target := args[0]
+			value := args[1]
+
+			action, _, err := routing.ParseOnExitDown(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+			if action == routing.OnExitDownLeak {
+				value = ""
+			}
+
+			if target == "default" {
+				cfg, err := config.Load()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "加载配置失败: %v\n", err)
+					os.Exit(1)
+				}
+
+				cfg.Routing.DefaultOnExitDown = value
+				if err := cfg.Save(); err != nil {
+					fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+					os.Exit(1)
+				}
+
+				fmt.Printf("✓ 默认路由出口不可用时: %s\n", routing.OnExitDownDisplay(value))
+				return
+			}
+
+			pm := routing.NewPolicyManager()
+			if err := pm.LoadGroup(target); err != nil {
+				fmt.Fprintf(os.Stderr, "加载策略组 %s 失败: %v\n", target, err)
+				os.Exit(1)
+			}
+
+			if err := pm.SetGroupOnExitDown(target, value); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := pm.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "保存策略组失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("✓ 策略组 %s 出口不可用时: %s\n", target, routing.OnExitDownDisplay(value))
+			fmt.Println("  使用 'twnode policy apply' 使其立即生效")
+		},
+	}
+
 	// 调整策略组优先级命令
 	policySetPriorityCmd := &cobra.Command{
 		Use:   "set-priority <group_name> <priority>",
 		Short: "调整策略组的优先级",
-		Long: "调整策略组的优先级(100-899)，会检查优先级冲突，调整后自动重新应用策略组\n" +
+		Long: "调整策略组的优先级(100-898)，会检查优先级冲突，调整后自动重新应用策略组\n" +
 			"示例: twnode policy set-priority vpn_group 150",
 		Args: cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -1725,8 +2966,9 @@ func main() {
 			}
 
 			// 验证优先级范围
-			if newPriority < routing.PrioUserPolicyBase || newPriority >= routing.PrioDefault {
-				fmt.Fprintf(os.Stderr, "错误: 优先级必须在 %d-%d 之间\n", routing.PrioUserPolicyBase, routing.PrioDefault-1)
+			ns := config.RoutingNamespace()
+			if !ns.IsGroupPref(newPriority) {
+				fmt.Fprintf(os.Stderr, "错误: 优先级必须在 %d-%d 之间\n", ns.GroupPrefMin, ns.GroupPrefMax())
 				os.Exit(1)
 			}
 
@@ -1781,18 +3023,18 @@ func main() {
 			fmt.Printf("调整策略组 '%s' 优先级: %d -> %d\n", groupName, oldPriority, newPriority)
 
 			// 检查策略组是否已应用（通过检查内核中的规则）
-			checkCmd := fmt.Sprintf("ip rule show pref %d", oldPriority)
+			checkCmd := network.IPCommandLine(group.Netns, fmt.Sprintf("ip rule show pref %d", oldPriority))
 			output, err := exec.Command("sh", "-c", checkCmd).CombinedOutput()
 			isApplied := err == nil && len(output) > 0 && strings.Contains(string(output), fmt.Sprintf("%d:", oldPriority))
 
 			if isApplied {
 				fmt.Println("策略组已应用，先撤销旧配置...")
-				// 撤销旧优先级的规则
-				delCmd := fmt.Sprintf("ip rule del pref %d", oldPriority)
+				// 撤销旧优先级的规则（按路由表匹配，早期版本的策略组可能与VRF隔离规则共用优先级）
+				delCmd := network.IPCommandLine(group.Netns, fmt.Sprintf("ip rule del pref %d lookup %d", oldPriority, ns.GroupTable(oldPriority)))
 				exec.Command("sh", "-c", delCmd).Run()
 
 				// 清空旧路由表
-				flushCmd := fmt.Sprintf("ip route flush table %d", oldPriority)
+				flushCmd := network.IPCommandLine(group.Netns, fmt.Sprintf("ip route flush table %d", ns.GroupTable(oldPriority)))
 				exec.Command("sh", "-c", flushCmd).Run()
 			}
 
@@ -1818,12 +3060,83 @@ func main() {
 				exec.Command("ip", "route", "flush", "cache").Run()
 
 				fmt.Printf("✓ 策略组 '%s' 已重新应用 (优先级: %d)\n", groupName, newPriority)
+
+				// 从 vrf_pref 迁移后补充VRF隔离规则
+				if oldPriority == ns.VRFPref {
+					if err := routing.SyncVRFIsolation(); err != nil {
+						fmt.Printf("⚠ 警告: 同步VRF隔离规则失败: %v\n", err)
+					}
+				}
 			} else {
 				fmt.Printf("✓ 策略组 '%s' 优先级已调整 (优先级: %d)，运行 'twnode policy apply' 以应用\n", groupName, newPriority)
 			}
 		},
 	}
 
+	// 设置策略组隔离上下文命令
+	policySetIsolationCmd := &cobra.Command{
+		Use:   "set-isolation <group_name>",
+		Short: "设置策略组的隔离上下文（VRF 或网络命名空间）",
+		Long: "设置策略组所属的 VRF 或网络命名空间（二选一，均不指定表示恢复默认上下文）:\n" +
+			"  --vrf    策略规则只匹配从该VRF进入的流量（iif <vrf>）\n" +
+			"  --netns  路由表和策略规则在网络命名空间内维护，出口为命名空间内的接口\n" +
+			"已应用的策略组会在新的上下文中重新应用",
+		Example: "  twnode policy set-isolation tenant_a --vrf vrf-blue",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			groupName := args[0]
+			vrf, _ := cmd.Flags().GetString("vrf")
+			netns, _ := cmd.Flags().GetString("netns")
+
+			pm := routing.NewPolicyManager()
+			if err := pm.LoadGroup(groupName); err != nil {
+				fmt.Fprintf(os.Stderr, "加载策略组失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			applied, _ := routing.LoadAppliedGroups()
+			isApplied := false
+			for _, name := range applied {
+				if name == groupName {
+					isApplied = true
+				}
+			}
+
+			// 先在旧的上下文中撤销
+			if isApplied {
+				if err := pm.RevokeGroup(groupName); err != nil {
+					fmt.Fprintf(os.Stderr, "撤销策略组失败: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if err := pm.SetGroupIsolation(groupName, vrf, netns); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+			if err := pm.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "保存配置失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := routing.SyncVRFIsolation(); err != nil {
+				fmt.Printf("⚠ 警告: 同步VRF隔离规则失败: %v\n", err)
+			}
+
+			if isApplied {
+				if err := pm.ApplyGroup(pm.GetGroup(groupName)); err != nil {
+					fmt.Fprintf(os.Stderr, "应用策略组失败: %v\n", err)
+					os.Exit(1)
+				}
+				exec.Command("ip", "route", "flush", "cache").Run()
+			}
+
+			fmt.Printf("✓ 策略组 %s 的隔离上下文已设置为: %s\n", groupName, network.IsolationLabel(vrf, netns))
+		},
+	}
+	policySetIsolationCmd.Flags().String("vrf", "", "VRF 设备名")
+	policySetIsolationCmd.Flags().String("netns", "", "网络命名空间名")
+
 	// 删除策略组命令
 	policyDeleteCmd := &cobra.Command{
 		Use:   "delete <group_name>",
@@ -1986,6 +3299,9 @@ func main() {
 	var addMonitorCheckMode, addMonitorDNSServersStr, addMonitorDNSQueryDomain string
 	var addMonitorInterval, addMonitorFailThreshold, addMonitorRecvThreshold, addMonitorSwitchConfirmCount int
 	var addMonitorScoreThreshold float64
+	var addMonitorSelectionPolicy, addMonitorCheckPolicy string
+	var addMonitorSLALoss, addMonitorSLALatency, addMonitorSLAJitter float64
+	var addMonitorSLAHold, addMonitorMaxSwitches, addMonitorMinDwell int
 	policyFailoverAddMonitorCmd := &cobra.Command{
 		Use:   "add-monitor [name]",
 		Short: "添加监控任务",
@@ -2013,7 +3329,16 @@ func main() {
     --dns-query-domain google.com \\
     --exits tun_hk,tun_us \\
     --interval 2000 \\
-    --score-threshold 5.0`,
+    --score-threshold 5.0
+
+命令行模式（SLA 策略，候选出口顺序即优先级）:
+  twnode policy failover add-monitor office \\
+    --type policy_group \\
+    --target office_routes \\
+    --check-targets 223.5.5.5 \\
+    --exits tun_mpls,tun_backup,eth_lte \\
+    --selection-policy sla \\
+    --sla-max-loss 2 --sla-max-latency 150 --sla-preempt-hold 300`,
 		Args: cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			// 如果没有提供参数，使用交互式模式
@@ -2084,6 +3409,18 @@ func main() {
 				RecoveryThreshold:       addMonitorRecvThreshold,
 				ScoreThreshold:          addMonitorScoreThreshold,
 				SwitchConfirmationCount: addMonitorSwitchConfirmCount,
+				SelectionPolicy:         addMonitorSelectionPolicy,
+				MaxSwitchesPerHour:      addMonitorMaxSwitches,
+				MinDwellSec:             addMonitorMinDwell,
+				CheckPolicy:             addMonitorCheckPolicy,
+			}
+			if addMonitorSelectionPolicy == failover.SelectionSLA {
+				monitor.SLA = &failover.SLAConfig{
+					MaxLossPercent: addMonitorSLALoss,
+					MaxLatencyMs:   addMonitorSLALatency,
+					MaxJitterMs:    addMonitorSLAJitter,
+					PreemptHoldSec: addMonitorSLAHold,
+				}
 			}
 
 			if err := failover.AddMonitor(monitor); err != nil {
@@ -2095,7 +3432,7 @@ func main() {
 	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorType, "type", "", "类型 (policy_group 或 default_route)")
 	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorTarget, "target", "", "目标策略组名称或 default")
 	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorCheckMode, "check-mode", "", "检测模式 (ping 或 dns，默认 ping)")
-	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorTargetsStr, "check-targets", "", "检测目标IP列表（逗号分隔，最多3个，ping模式使用）")
+	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorTargetsStr, "check-targets", "", "检测目标IP列表（逗号分隔，最多16个，ping模式使用）")
 	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorDNSServersStr, "dns-servers", "", "DNS服务器列表（逗号分隔，不限数量，dns模式使用）")
 	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorDNSQueryDomain, "dns-query-domain", "", "DNS查询域名（可选，默认 google.com，dns模式使用）")
 	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorExitsStr, "exits", "", "候选出口列表（逗号分隔）")
@@ -2104,6 +3441,14 @@ func main() {
 	policyFailoverAddMonitorCmd.Flags().IntVar(&addMonitorRecvThreshold, "recovery-threshold", 0, "恢复阈值（可选）[已废弃]")
 	policyFailoverAddMonitorCmd.Flags().Float64Var(&addMonitorScoreThreshold, "score-threshold", 0, "评分差值阈值（0-100，可选）")
 	policyFailoverAddMonitorCmd.Flags().IntVar(&addMonitorSwitchConfirmCount, "switch-confirmation-count", 0, "切换确认次数（1-10，可选）")
+	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorCheckPolicy, "check-policy", "", "多目标检测策略 (any/all/quorum:N/weighted，可选)")
+	policyFailoverAddMonitorCmd.Flags().StringVar(&addMonitorSelectionPolicy, "selection-policy", "", "出口选择策略 (score 或 sla，默认 score)")
+	policyFailoverAddMonitorCmd.Flags().Float64Var(&addMonitorSLALoss, "sla-max-loss", 0, "SLA: 丢包率需低于此值（%，sla 策略使用）")
+	policyFailoverAddMonitorCmd.Flags().Float64Var(&addMonitorSLALatency, "sla-max-latency", 0, "SLA: 延迟需低于此值（ms，sla 策略使用）")
+	policyFailoverAddMonitorCmd.Flags().Float64Var(&addMonitorSLAJitter, "sla-max-jitter", 0, "SLA: 抖动需低于此值（ms，sla 策略使用）")
+	policyFailoverAddMonitorCmd.Flags().IntVar(&addMonitorSLAHold, "sla-preempt-hold", 0, "SLA: 更高优先级出口持续满足多久后抢占（秒，默认60）")
+	policyFailoverAddMonitorCmd.Flags().IntVar(&addMonitorMaxSwitches, "max-switches-per-hour", 0, "每小时最多切换次数（可选，0 使用全局配置）")
+	policyFailoverAddMonitorCmd.Flags().IntVar(&addMonitorMinDwell, "min-dwell", 0, "切换后的最短停留时间（秒，可选，0 使用全局配置）")
 
 	// failover remove-monitor：删除监控任务
 	var removeMonitorForce bool
@@ -2165,20 +3510,277 @@ func main() {
 		},
 	}
 
+	// failover schedule：管理定时任务的覆盖
+	policyFailoverScheduleCmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "管理定时任务设置的覆盖",
+	}
+
+	// failover schedule clear：清除定时任务设置的覆盖
+	policyFailoverScheduleClearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "清除定时任务设置的候选出口覆盖和成本覆盖",
+		Long: `清除定时任务（set_candidates / set_cost）设置的所有覆盖，恢复配置中的候选出口和成本
+
+守护进程运行时会通知其重新加载；之后定时任务再次执行时会重新设置覆盖`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := failover.ClearScheduleOverrides(); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	policyFailoverScheduleCmd.AddCommand(policyFailoverScheduleClearCmd)
+
 	// 将守护进程子命令添加到 failover 命令
 	policyFailoverCmd.AddCommand(
 		policyFailoverDaemonCmd, policyFailoverInitConfigCmd, policyFailoverValidateConfigCmd,
 		policyFailoverShowConfigCmd, policyFailoverSetConfigCmd,
 		policyFailoverListMonitorsCmd, policyFailoverAddMonitorCmd,
 		policyFailoverRemoveMonitorCmd, policyFailoverShowMonitorCmd,
-		policyFailoverReloadCmd, policyFailoverStatusCmd)
+		policyFailoverReloadCmd, policyFailoverStatusCmd, policyFailoverScheduleCmd)
 
 	// 将所有命令添加到 policyCmd
 	policyCmd.AddCommand(policyCreateCmd, policyAddCmd, policyImportCmd,
-		policyListCmd, policyDefaultCmd, policyUnsetDefaultCmd,
-		policyApplyCmd, policyRevokeCmd, policyFailoverCmd, policySetPriorityCmd,
+		policyAnalyzeCmd, policyExplainCmd, policyListCmd, policyDefaultCmd, policyUnsetDefaultCmd, policyOnExitDownCmd,
+		policyApplyCmd, policyRevokeCmd, policyFailoverCmd, policyScoreCmd, policySetPriorityCmd, policySetIsolationCmd,
 		policyDeleteCmd, policySyncProtectionCmd)
 
+	// 防火墙命令组
+	firewallCmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "管理 twnode 防火墙(nftables)",
+		Long: "twnode 独占 nftables 表 inet twnode，由隧道、物理接口和策略配置生成:\n" +
+			"  - 出口NAT: 每个出口 masquerade / SNAT / 不做NAT（line set-nat、interface set-nat）\n" +
+			"  - TCP MSS钳制: 每个隧道（line set-mss）\n" +
+			"  - 转发过滤: config.yaml 中 firewall.forward_policy / forward_allow",
+	}
+
+	firewallApplyCmd := &cobra.Command{
+		Use:   "apply",
+		Short: "生成并应用防火墙规则",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := firewall.Apply(); err != nil {
+				fmt.Fprintf(os.Stderr, "应用防火墙规则失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✓ 防火墙规则已应用")
+		},
+	}
+
+	firewallRevokeCmd := &cobra.Command{
+		Use:   "revoke",
+		Short: "删除 twnode 防火墙表",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := firewall.Remove(); err != nil {
+				fmt.Fprintf(os.Stderr, "撤销防火墙规则失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✓ 防火墙规则已撤销")
+		},
+	}
+
+	firewallShowCmd := &cobra.Command{
+		Use:   "show",
+		Short: "显示防火墙规则",
+		Run: func(cmd *cobra.Command, args []string) {
+			rs, err := firewall.Build()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "生成防火墙规则失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if raw, _ := cmd.Flags().GetBool("raw"); raw {
+				fmt.Print(rs.Render())
+				return
+			}
+			rs.Print()
+		},
+	}
+	firewallShowCmd.Flags().Bool("raw", false, "输出生成的 nft 脚本")
+
+	firewallCmd.AddCommand(firewallApplyCmd, firewallRevokeCmd, firewallShowCmd)
+
+	// 端口转发命令
+	forwardCmd := &cobra.Command{
+		Use:   "forward",
+		Short: "管理端口转发(DNAT)",
+		Long: "将入口（隧道或物理接口）上的端口转发到内部主机:\n" +
+			"  - DNAT 规则写入 twnode 防火墙表\n" +
+			"  - 转发连接打上入口标记，回包经 fwmark 规则(pref 9)和回程路由表(1100-1199)从原入口返回",
+	}
+
+	forwardAddCmd := &cobra.Command{
+		Use:   "add <tcp|udp> <入口端口> <隧道|接口> <内部IP:端口>",
+		Short: "添加端口转发",
+		Example: "  twnode forward add tcp 8080 tun01 192.168.1.10:80\n" +
+			"  twnode forward add udp 51820 eth0 10.0.0.2:51820",
+		Args: cobra.ExactArgs(4),
+		Run: func(cmd *cobra.Command, args []string) {
+			port, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "无效的端口: %s\n", args[1])
+				os.Exit(1)
+			}
+
+			f, err := forward.New(args[0], port, args[2], args[3])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "添加端口转发失败: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := forward.Save(f); err != nil {
+				fmt.Fprintf(os.Stderr, "保存端口转发失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("✓ 端口转发 %s 已保存: %s %s:%d → %s\n", f.Name, f.Proto, f.Via, f.PublicPort, f.Target())
+
+			if err := firewall.Apply(); err != nil {
+				fmt.Fprintf(os.Stderr, "应用防火墙规则失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✓ 端口转发已生效")
+		},
+	}
+
+	forwardRemoveCmd := &cobra.Command{
+		Use:   "remove <名称>",
+		Short: "删除端口转发",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := forward.Delete(args[0]); err != nil {
+				fmt.Fprintf(os.Stderr, "删除端口转发失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("✓ 端口转发 %s 已删除\n", args[0])
+
+			if err := firewall.Sync(); err != nil {
+				fmt.Fprintf(os.Stderr, "同步防火墙规则失败: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	forwardListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "列出端口转发",
+		Run: func(cmd *cobra.Command, args []string) {
+			forwards, err := forward.List()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "加载端口转发失败: %v\n", err)
+				os.Exit(1)
+			}
+			forward.Print(forwards)
+		},
+	}
+
+	forwardCmd.AddCommand(forwardAddCmd, forwardRemoveCmd, forwardListCmd)
+
+	// 流量统计
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "查看出口和策略组的流量统计",
+		Long: "显示各出口（隧道/物理接口）和各策略组经各出口的流量，按小时/天/月汇总\n" +
+			"统计由守护进程按 stats_interval_sec 定期采集，也可以手动执行 twnode stats collect",
+		Example: "  twnode stats\n  twnode stats --period day --last 7 --group hk_sites\n  twnode stats --period hour --last 24 --exit tun-hk",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			periodInput, _ := cmd.Flags().GetString("period")
+			last, _ := cmd.Flags().GetInt("last")
+			group, _ := cmd.Flags().GetString("group")
+			exit, _ := cmd.Flags().GetString("exit")
+
+			period, err := accounting.ParsePeriod(periodInput)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err := accounting.Print(period, last, group, exit); err != nil {
+				fmt.Fprintf(os.Stderr, "读取流量统计失败: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	statsCmd.Flags().String("period", accounting.PeriodMonth, "统计粒度: hour/day/month")
+	statsCmd.Flags().Int("last", 1, "显示最近的时段数（0 表示全部保留的时段）")
+	statsCmd.Flags().String("group", "", "只显示指定策略组")
+	statsCmd.Flags().String("exit", "", "只显示指定出口")
+
+	statsCollectCmd := &cobra.Command{
+		Use:   "collect",
+		Short: "立即采集一次流量计数",
+		Long:  "读取出口链路计数器和策略组 nftables 计数器，累加到汇总中\n首次采集只记录基准值，策略组或出口变化时重建计数表 inet twnode_accounting",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := accounting.Collect(); err != nil {
+				fmt.Fprintf(os.Stderr, "采集失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✓ 流量统计已采集")
+		},
+	}
+
+	statsResetCmd := &cobra.Command{
+		Use:   "reset",
+		Short: "清空流量统计并删除计数表",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := accounting.Reset(); err != nil {
+				fmt.Fprintf(os.Stderr, "清空失败: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✓ 流量统计已清空")
+		},
+	}
+
+	statsQuotaCmd := &cobra.Command{
+		Use:   "quota",
+		Short: "查看出口流量配额的用量和有效成本",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			network.PrintQuotas()
+		},
+	}
+
+	statsCmd.AddCommand(statsCollectCmd, statsResetCmd, statsQuotaCmd)
+
+	// 路由命名空间
+	namespaceCmd := &cobra.Command{
+		Use:   "namespace",
+		Short: "路由命名空间（路由表号和规则优先级）",
+		Long: "twnode 使用的路由表号和 ip rule 优先级在 config.yaml 的 namespace 中配置\n" +
+			"与 Docker、Tailscale、FRR 等软件冲突时调整 table_offset 和各优先级，再执行 migrate",
+	}
+
+	namespaceShowCmd := &cobra.Command{
+		Use:   "show",
+		Short: "显示路由命名空间布局和冲突",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := system.ShowNamespace(); err != nil {
+				fmt.Fprintf(os.Stderr, "显示路由命名空间失败: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	var namespaceForce bool
+	namespaceMigrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "将现有部署迁移到配置的路由命名空间",
+		Long: "按旧的路由命名空间清理规则和路由表，再按新的命名空间重建隧道路由、策略路由和端口转发\n" +
+			"存在冲突时默认中止，使用 --force 强制迁移",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := system.MigrateNamespace(namespaceForce); err != nil {
+				fmt.Fprintf(os.Stderr, "迁移失败: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	namespaceMigrateCmd.Flags().BoolVar(&namespaceForce, "force", false, "存在冲突时仍然迁移")
+
+	namespaceCmd.AddCommand(namespaceShowCmd, namespaceMigrateCmd)
+
 	// 版本命令
 	versionCmd := &cobra.Command{
 		Use:   "version",
@@ -2190,7 +3792,7 @@ func main() {
 	}
 
 	// 添加所有命令
-	rootCmd.AddCommand(initCmd, statusCmd, interfaceCmd, lineCmd, policyCmd, versionCmd)
+	rootCmd.AddCommand(initCmd, statusCmd, driftCmd, gcCmd, interfaceCmd, lineCmd, policyCmd, firewallCmd, forwardCmd, statsCmd, namespaceCmd, versionCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
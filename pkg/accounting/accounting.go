@@ -0,0 +1,410 @@
+package accounting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"trueword_node/pkg/network"
+)
+
+// 流量统计
+// 每次采集读取出口（隧道/物理接口）的链路计数器和策略组的 nftables 计数器，
+// 与上次采集的原始值相减得到增量，累加到小时/天/月三级汇总中（计数器重置时以当前值作为增量）
+// 采集由守护进程按 stats_interval_sec 定期执行，也可以手动执行 twnode stats collect
+
+const (
+	StatsDir  = "/var/lib/trueword_node/stats"
+	StatsFile = StatsDir + "/accounting.json"
+
+	// 汇总粒度
+	PeriodHour  = "hour"
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+
+	// 各粒度保留的时段数
+	hourRetention  = 7 * 24
+	dayRetention   = 92
+	monthRetention = 36
+)
+
+// 统计对象键: exit:<出口> / group:<策略组>@<出口>
+const (
+	exitKeyPrefix  = "exit:"
+	groupKeyPrefix = "group:"
+)
+
+// periodLayouts 各粒度的时段格式
+var periodLayouts = map[string]string{
+	PeriodHour:  "2006-01-02 15:00",
+	PeriodDay:   "2006-01-02",
+	PeriodMonth: "2006-01",
+}
+
+// ParsePeriod 解析汇总粒度
+func ParsePeriod(value string) (string, error) {
+	if _, ok := periodLayouts[value]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("无效的统计粒度 '%s' (可选: hour/day/month)", value)
+}
+
+// Counter 流量计数（发送为经出口发出的方向，接收为经出口进入的方向）
+type Counter struct {
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+}
+
+// add 累加计数
+func (c *Counter) add(other Counter) {
+	c.TxBytes += other.TxBytes
+	c.TxPackets += other.TxPackets
+	c.RxBytes += other.RxBytes
+	c.RxPackets += other.RxPackets
+}
+
+// delta 相对上次原始值的增量（计数器回绕或重置时以当前值作为增量）
+func delta(current, last uint64) uint64 {
+	if current < last {
+		return current
+	}
+	return current - last
+}
+
+// Store 流量统计持久化数据
+type Store struct {
+	UpdatedAt   time.Time                                 `json:"updated_at"`
+	Last        map[string]Counter                        `json:"last"`         // 上次采集的原始计数
+	CounterKeys map[string]string                         `json:"counter_keys"` // nftables 计数器名 -> 统计对象键
+	Ruleset     string                                    `json:"ruleset"`      // 当前 nftables 计数规则（变化时重建）
+	Periods     map[string]map[string]map[string]*Counter `json:"periods"`      // 粒度 -> 时段 -> 统计对象键 -> 计数
+}
+
+// LoadStore 加载流量统计数据（不存在时返回空数据）
+func LoadStore() (*Store, error) {
+	store := &Store{}
+
+	data, err := os.ReadFile(StatsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取流量统计失败: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, store); err != nil {
+			return nil, fmt.Errorf("解析流量统计失败: %w", err)
+		}
+	}
+
+	if store.Last == nil {
+		store.Last = make(map[string]Counter)
+	}
+	if store.CounterKeys == nil {
+		store.CounterKeys = make(map[string]string)
+	}
+	if store.Periods == nil {
+		store.Periods = make(map[string]map[string]map[string]*Counter)
+	}
+	for period := range periodLayouts {
+		if store.Periods[period] == nil {
+			store.Periods[period] = make(map[string]map[string]*Counter)
+		}
+	}
+	return store, nil
+}
+
+// Save 保存流量统计数据（先写临时文件再替换，避免中断时损坏）
+func (s *Store) Save() error {
+	if err := os.MkdirAll(StatsDir, 0755); err != nil {
+		return fmt.Errorf("创建统计目录失败: %w", err)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("序列化流量统计失败: %w", err)
+	}
+
+	tmp := StatsFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入流量统计失败: %w", err)
+	}
+	return os.Rename(tmp, StatsFile)
+}
+
+// record 将增量累加到各粒度的当前时段
+func (s *Store) record(now time.Time, key string, value Counter) {
+	for period, layout := range periodLayouts {
+		bucket := now.Format(layout)
+		if s.Periods[period][bucket] == nil {
+			s.Periods[period][bucket] = make(map[string]*Counter)
+		}
+		if s.Periods[period][bucket][key] == nil {
+			s.Periods[period][bucket][key] = &Counter{}
+		}
+		s.Periods[period][bucket][key].add(value)
+	}
+}
+
+// prune 清理超出保留期的时段
+func (s *Store) prune() {
+	retention := map[string]int{
+		PeriodHour:  hourRetention,
+		PeriodDay:   dayRetention,
+		PeriodMonth: monthRetention,
+	}
+	for period, keep := range retention {
+		buckets := sortedBuckets(s.Periods[period])
+		for len(buckets) > keep {
+			delete(s.Periods[period], buckets[0])
+			buckets = buckets[1:]
+		}
+	}
+}
+
+// sortedBuckets 按时间排序的时段（时段格式可按字符串排序）
+func sortedBuckets(buckets map[string]map[string]*Counter) []string {
+	names := make([]string, 0, len(buckets))
+	for name := range buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collect 采集一次流量计数并更新汇总
+// 首次出现的统计对象只记录基准值；计数规则随策略组/出口变化重建
+func Collect() error {
+	store, err := LoadStore()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	samples := readExitCounters()
+	groupSamples, err := readGroupCounters(store.CounterKeys)
+	if err != nil {
+		return err
+	}
+	for key, value := range groupSamples {
+		samples[key] = value
+	}
+
+	for key, current := range samples {
+		last, ok := store.Last[key]
+		if !ok {
+			continue
+		}
+		store.record(now, key, Counter{
+			TxBytes:   delta(current.TxBytes, last.TxBytes),
+			TxPackets: delta(current.TxPackets, last.TxPackets),
+			RxBytes:   delta(current.RxBytes, last.RxBytes),
+			RxPackets: delta(current.RxPackets, last.RxPackets),
+		})
+	}
+	store.Last = samples
+
+	// 计数规则变化时重建（新表的计数从0开始，基准值置0，下次采集的增量即为完整计数）
+	ruleset, counterKeys, err := buildRuleset()
+	if err != nil {
+		return err
+	}
+	if ruleset.Render() != store.Ruleset || !TableExists() {
+		if err := ruleset.Apply(); err != nil {
+			return err
+		}
+		store.Ruleset = ruleset.Render()
+		store.CounterKeys = counterKeys
+		for key := range store.Last {
+			if strings.HasPrefix(key, groupKeyPrefix) {
+				delete(store.Last, key)
+			}
+		}
+		for _, key := range counterKeys {
+			store.Last[key] = Counter{}
+		}
+	}
+
+	store.UpdatedAt = now
+	store.prune()
+	if err := store.Save(); err != nil {
+		return err
+	}
+	return store.updateQuotaUsage(now)
+}
+
+// updateQuotaUsage 汇总配置了流量配额的出口在当前计费周期的用量（按天汇总累加）
+func (s *Store) updateQuotaUsage(now time.Time) error {
+	quotas := network.ExitQuotas()
+	if len(quotas) == 0 {
+		return nil
+	}
+
+	usage := make(map[string]*network.QuotaUsage)
+	for exit, quota := range quotas {
+		start := quota.PeriodStart(now)
+		first := start.Format(periodLayouts[PeriodDay])
+
+		var used uint64
+		for bucket, counters := range s.Periods[PeriodDay] {
+			counter, ok := counters[exitKeyPrefix+exit]
+			if !ok || bucket < first {
+				continue
+			}
+			switch quota.CountMode() {
+			case network.QuotaCountTx:
+				used += counter.TxBytes
+			case network.QuotaCountRx:
+				used += counter.RxBytes
+			default:
+				used += counter.TxBytes + counter.RxBytes
+			}
+		}
+
+		usage[exit] = &network.QuotaUsage{
+			UsedBytes:   used,
+			LimitBytes:  quota.LimitBytes,
+			PeriodStart: start,
+			UpdatedAt:   now,
+		}
+	}
+
+	return network.SaveQuotaUsage(usage)
+}
+
+// Entry 查询结果中的一行
+type Entry struct {
+	Bucket string
+	Group  string // 出口统计为空
+	Exit   string
+	Counter
+}
+
+// Query 查询最近 last 个时段的统计（按时段、策略组、出口排序）
+// group/exit 非空时只返回匹配的统计对象
+func (s *Store) Query(period string, last int, group, exit string) (exits []Entry, groups []Entry) {
+	buckets := sortedBuckets(s.Periods[period])
+	if last > 0 && len(buckets) > last {
+		buckets = buckets[len(buckets)-last:]
+	}
+
+	for _, bucket := range buckets {
+		for key, counter := range s.Periods[period][bucket] {
+			entry := Entry{Bucket: bucket, Counter: *counter}
+			switch {
+			case strings.HasPrefix(key, exitKeyPrefix):
+				entry.Exit = strings.TrimPrefix(key, exitKeyPrefix)
+			case strings.HasPrefix(key, groupKeyPrefix):
+				parts := strings.SplitN(strings.TrimPrefix(key, groupKeyPrefix), "@", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				entry.Group, entry.Exit = parts[0], parts[1]
+			default:
+				continue
+			}
+
+			if exit != "" && entry.Exit != exit {
+				continue
+			}
+			if entry.Group == "" {
+				if group == "" {
+					exits = append(exits, entry)
+				}
+				continue
+			}
+			if group != "" && entry.Group != group {
+				continue
+			}
+			groups = append(groups, entry)
+		}
+	}
+
+	less := func(entries []Entry) func(i, j int) bool {
+		return func(i, j int) bool {
+			a, b := entries[i], entries[j]
+			if a.Bucket != b.Bucket {
+				return a.Bucket < b.Bucket
+			}
+			if a.Group != b.Group {
+				return a.Group < b.Group
+			}
+			return a.Exit < b.Exit
+		}
+	}
+	sort.Slice(exits, less(exits))
+	sort.Slice(groups, less(groups))
+	return exits, groups
+}
+
+// Reset 删除所有流量统计数据和计数规则
+func Reset() error {
+	RemoveTable()
+	if err := os.Remove(StatsFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除流量统计失败: %w", err)
+	}
+	if err := os.Remove(network.QuotaUsageFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除配额用量失败: %w", err)
+	}
+	return nil
+}
+
+// Print 打印最近 last 个时段的出口和策略组流量
+func Print(period string, last int, group, exit string) error {
+	store, err := LoadStore()
+	if err != nil {
+		return err
+	}
+
+	if store.UpdatedAt.IsZero() {
+		fmt.Println("尚未采集流量统计，请执行 'twnode stats collect' 或在守护进程配置中设置 stats_interval_sec")
+		return nil
+	}
+
+	exits, groups := store.Query(period, last, group, exit)
+	periodNames := map[string]string{PeriodHour: "按小时", PeriodDay: "按天", PeriodMonth: "按月"}
+
+	if group == "" {
+		fmt.Printf("【出口流量】(%s)\n", periodNames[period])
+		printEntries(exits, false)
+		fmt.Println()
+	}
+
+	fmt.Printf("【策略组流量】(%s)\n", periodNames[period])
+	printEntries(groups, true)
+
+	fmt.Println()
+	fmt.Printf("最后采集: %s\n", store.UpdatedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// printEntries 打印统计表格
+func printEntries(entries []Entry, withGroup bool) {
+	if len(entries) == 0 {
+		fmt.Println("  (无)")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	if withGroup {
+		table.Header("时段", "策略组", "出口", "发送", "接收", "发送包", "接收包")
+	} else {
+		table.Header("时段", "出口", "发送", "接收", "发送包", "接收包")
+	}
+
+	for _, entry := range entries {
+		row := []string{entry.Bucket}
+		if withGroup {
+			row = append(row, entry.Group)
+		}
+		row = append(row, entry.Exit,
+			network.FormatBytes(entry.TxBytes), network.FormatBytes(entry.RxBytes),
+			strconv.FormatUint(entry.TxPackets, 10), strconv.FormatUint(entry.RxPackets, 10))
+		table.Append(row)
+	}
+	table.Render()
+}
@@ -0,0 +1,298 @@
+package accounting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
+)
+
+// 策略组计数: nftables 表 inet twnode_accounting
+// 每个策略组的目标网段放入一个集合，在各出口上按 策略组优先级 依次匹配（首个匹配的策略组计数后结束），
+// 与策略路由的匹配顺序一致。发送方向在 postrouting 按目的地址匹配，接收方向在 prerouting 按源地址匹配
+// IPv4 和 IPv6 网段分别放入 ipv4_addr / ipv6_addr 集合（ip / ip6 匹配），两者计入同一计数器
+// 说明: 计数按目的网段和出口归属，不区分 --from 源地址；网络命名空间内的策略组不统计
+
+const (
+	TableFamily = "inet"
+	TableName   = "twnode_accounting"
+)
+
+// groupRule 策略组计数规则
+type groupRule struct {
+	Set       string // IPv4 网段集合（可为空）
+	Excludes  string // IPv4 排除网段集合（可为空）
+	Set6      string // IPv6 网段集合（可为空）
+	Excludes6 string // IPv6 排除网段集合（可为空）
+	Counter   string // 计数器名前缀（_tx / _rx）
+	Exit      string
+}
+
+// addrSet nftables 地址集合
+type addrSet struct {
+	Type     string // ipv4_addr / ipv6_addr
+	Elements []string
+}
+
+// Ruleset 策略组计数规则集
+type Ruleset struct {
+	Sets  map[string]addrSet // 集合名 -> 网段
+	Rules []groupRule
+}
+
+// splitFamilies 按地址族拆分网段
+func splitFamilies(cidrs []string) ([]string, []string) {
+	v4, v6 := make([]string, 0), make([]string, 0)
+	for _, cidr := range cidrs {
+		if strings.Contains(cidr, ":") {
+			v6 = append(v6, cidr)
+		} else {
+			v4 = append(v4, cidr)
+		}
+	}
+	return v4, v6
+}
+
+// addSet 添加集合（网段为空时不添加，返回空集合名）
+func (rs *Ruleset) addSet(name, setType string, elements []string) string {
+	if len(elements) == 0 {
+		return ""
+	}
+	rs.Sets[name] = addrSet{Type: setType, Elements: elements}
+	return name
+}
+
+// buildRuleset 根据策略组和出口生成计数规则集
+// 返回: 规则集, nftables 计数器名 -> 统计对象键
+func buildRuleset() (*Ruleset, map[string]string, error) {
+	rs := &Ruleset{Sets: make(map[string]addrSet)}
+	counterKeys := make(map[string]string)
+
+	pm := routing.NewPolicyManager()
+	if err := pm.LoadAllGroups(); err != nil {
+		return nil, nil, err
+	}
+	exits := exitNames(false)
+	for i, group := range pm.Groups() {
+		if group.Netns != "" || len(group.CIDRs) == 0 {
+			continue
+		}
+
+		cidrs, cidrs6 := splitFamilies(group.CIDRs)
+		excludes, excludes6 := splitFamilies(group.Excludes)
+		rule := groupRule{
+			Set:       rs.addSet(fmt.Sprintf("g%d", i), "ipv4_addr", cidrs),
+			Excludes:  rs.addSet(fmt.Sprintf("x%d", i), "ipv4_addr", excludes),
+			Set6:      rs.addSet(fmt.Sprintf("g%d_6", i), "ipv6_addr", cidrs6),
+			Excludes6: rs.addSet(fmt.Sprintf("x%d_6", i), "ipv6_addr", excludes6),
+		}
+
+		for j, exit := range exits {
+			counter := fmt.Sprintf("c%d_%d", i, j)
+			rule.Counter, rule.Exit = counter, exit
+			rs.Rules = append(rs.Rules, rule)
+			counterKeys[counter] = groupKeyPrefix + group.Name + "@" + exit
+		}
+	}
+
+	return rs, counterKeys, nil
+}
+
+// Render 生成 nft 脚本（先删除旧表再创建，nft -f 原子执行）
+func (rs *Ruleset) Render() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "table %s %s\n", TableFamily, TableName)
+	fmt.Fprintf(&b, "delete table %s %s\n", TableFamily, TableName)
+	fmt.Fprintf(&b, "table %s %s {\n", TableFamily, TableName)
+
+	setNames := make([]string, 0, len(rs.Sets))
+	for name := range rs.Sets {
+		setNames = append(setNames, name)
+	}
+	sort.Strings(setNames)
+	for _, name := range setNames {
+		fmt.Fprintf(&b, "\tset %s {\n", name)
+		fmt.Fprintf(&b, "\t\ttype %s; flags interval; auto-merge;\n", rs.Sets[name].Type)
+		fmt.Fprintf(&b, "\t\telements = { %s }\n", strings.Join(rs.Sets[name].Elements, ", "))
+		b.WriteString("\t}\n")
+	}
+
+	for _, rule := range rs.Rules {
+		fmt.Fprintf(&b, "\tcounter %s_tx {\n\t\tpackets 0 bytes 0\n\t}\n", rule.Counter)
+		fmt.Fprintf(&b, "\tcounter %s_rx {\n\t\tpackets 0 bytes 0\n\t}\n", rule.Counter)
+	}
+
+	writeChain := func(name, hook, iface, addr, suffix string) {
+		fmt.Fprintf(&b, "\tchain %s {\n", name)
+		fmt.Fprintf(&b, "\t\ttype filter hook %s priority filter; policy accept;\n", hook)
+		writeRule := func(rule groupRule, family, set, excludes string) {
+			if set == "" {
+				return
+			}
+			exclude := ""
+			if excludes != "" {
+				exclude = fmt.Sprintf(" %s %s != @%s", family, addr, excludes)
+			}
+			fmt.Fprintf(&b, "\t\t%s %q %s %s @%s%s counter name %q return\n",
+				iface, rule.Exit, family, addr, set, exclude, rule.Counter+suffix)
+		}
+		for _, rule := range rs.Rules {
+			writeRule(rule, "ip", rule.Set, rule.Excludes)
+			writeRule(rule, "ip6", rule.Set6, rule.Excludes6)
+		}
+		b.WriteString("\t}\n")
+	}
+	writeChain("postrouting", "postrouting", "oifname", "daddr", "_tx")
+	writeChain("prerouting", "prerouting", "iifname", "saddr", "_rx")
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Apply 应用计数规则集（原子替换计数表，计数从0开始）
+func (rs *Ruleset) Apply() error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(rs.Render())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("应用流量计数规则失败: %v, 输出: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// TableExists 检查计数表是否存在
+func TableExists() bool {
+	return exec.Command("nft", "list", "table", TableFamily, TableName).Run() == nil
+}
+
+// RemoveTable 删除计数表
+func RemoveTable() {
+	exec.Command("nft", "delete", "table", TableFamily, TableName).Run()
+}
+
+// exitNames 所有已配置的出口（隧道和启用的物理接口），withNetns 为 false 时排除网络命名空间内的隧道
+func exitNames(withNetns bool) []string {
+	names := make([]string, 0)
+
+	if ifaceConfig, err := network.LoadInterfaceConfig(); err == nil {
+		for _, iface := range ifaceConfig.Interfaces {
+			if iface.Enabled {
+				names = append(names, iface.Name)
+			}
+		}
+	}
+
+	if tunnels, err := network.ListTunnelConfigs(); err == nil {
+		for _, tunnel := range tunnels {
+			if tunnel.Netns == "" || withNetns {
+				names = append(names, tunnel.Name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// linkStats ip -s -j link show 输出中的计数器
+type linkStats struct {
+	Stats64 struct {
+		Rx struct {
+			Bytes   uint64 `json:"bytes"`
+			Packets uint64 `json:"packets"`
+		} `json:"rx"`
+		Tx struct {
+			Bytes   uint64 `json:"bytes"`
+			Packets uint64 `json:"packets"`
+		} `json:"tx"`
+	} `json:"stats64"`
+}
+
+// readExitCounters 读取各出口的链路计数器（未运行的隧道跳过）
+func readExitCounters() map[string]Counter {
+	samples := make(map[string]Counter)
+
+	for _, exit := range exitNames(true) {
+		output, err := network.IPCommand(network.TunnelNetns(exit), "-s", "-j", "link", "show", "dev", exit).Output()
+		if err != nil {
+			continue
+		}
+		var links []linkStats
+		if err := json.Unmarshal(output, &links); err != nil || len(links) == 0 {
+			continue
+		}
+		stats := links[0].Stats64
+		samples[exitKeyPrefix+exit] = Counter{
+			TxBytes:   stats.Tx.Bytes,
+			TxPackets: stats.Tx.Packets,
+			RxBytes:   stats.Rx.Bytes,
+			RxPackets: stats.Rx.Packets,
+		}
+	}
+
+	return samples
+}
+
+// nft list counters 输出中的计数器名和计数值
+var (
+	nftCounterName  = regexp.MustCompile(`^counter (\S+) \{`)
+	nftCounterValue = regexp.MustCompile(`packets (\d+) bytes (\d+)`)
+)
+
+// readGroupCounters 读取策略组计数器（计数表不存在时返回空）
+func readGroupCounters(counterKeys map[string]string) (map[string]Counter, error) {
+	samples := make(map[string]Counter)
+	if len(counterKeys) == 0 || !TableExists() {
+		return samples, nil
+	}
+
+	output, err := exec.Command("nft", "list", "counters", "table", TableFamily, TableName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("读取流量计数器失败: %w", err)
+	}
+
+	name := ""
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if match := nftCounterName.FindStringSubmatch(line); match != nil {
+			name = match[1]
+			continue
+		}
+		match := nftCounterValue.FindStringSubmatch(line)
+		if match == nil || name == "" {
+			continue
+		}
+
+		packets, _ := strconv.ParseUint(match[1], 10, 64)
+		bytes, _ := strconv.ParseUint(match[2], 10, 64)
+
+		counter, direction := name, ""
+		if idx := strings.LastIndex(name, "_"); idx > 0 {
+			counter, direction = name[:idx], name[idx+1:]
+		}
+		key, ok := counterKeys[counter]
+		if !ok {
+			name = ""
+			continue
+		}
+
+		sample := samples[key]
+		switch direction {
+		case "tx":
+			sample.TxBytes, sample.TxPackets = bytes, packets
+		case "rx":
+			sample.RxBytes, sample.RxPackets = bytes, packets
+		}
+		samples[key] = sample
+		name = ""
+	}
+
+	return samples, nil
+}
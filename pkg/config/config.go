@@ -18,11 +18,29 @@ const (
 type Config struct {
 	// 路由配置
 	Routing RoutingConfig `yaml:"routing"`
+
+	// 防火墙配置（nftables 表 inet twnode）
+	Firewall FirewallConfig `yaml:"firewall,omitempty"`
+
+	// 路由命名空间（路由表号和规则优先级），修改后需执行 twnode namespace migrate
+	Namespace Namespace `yaml:"namespace,omitempty"`
 }
 
 type RoutingConfig struct {
 	// 默认路由出口 (可以是隧道名或物理接口名)
 	DefaultExit string `yaml:"default_exit"`
+
+	// 默认出口不可用时的处理方式: leak/blackhole/unreachable/fallback:<exit>（空表示 leak）
+	DefaultOnExitDown string `yaml:"default_on_exit_down,omitempty"`
+}
+
+type FirewallConfig struct {
+	// 转发默认策略: accept(默认) / drop
+	// drop 时只放行已建立连接、策略组源地址和 forward_allow 中的源地址到已配置出口的流量
+	ForwardPolicy string `yaml:"forward_policy,omitempty"`
+
+	// 额外允许转发的源地址段（仅 forward_policy 为 drop 时生效）
+	ForwardAllow []string `yaml:"forward_allow,omitempty"`
 }
 
 // 生成IPsec密钥(从字符串生成)
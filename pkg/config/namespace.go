@@ -0,0 +1,284 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// 当前内核状态所使用的路由命名空间（迁移时用于清理旧的规则和路由表）
+	AppliedNamespaceFile = "/var/lib/trueword_node/namespace.yaml"
+
+	// 路由表相对 table_offset 的布局
+	testTableBase    = 5
+	tunnelTableBase  = 50
+	vipTableBase     = 80
+	groupTableBase   = 100 // 策略组: 100 + (优先级 - group_pref_min)
+	defaultTableBase = 900
+	sourceTableBase  = 1000
+	forwardTableBase = 1100
+	tableSpan        = 1200 // 每个命名空间占用的路由表数量
+
+	// 每类动态路由表的数量（源地址路由、端口转发）
+	dynamicTableCount = 100
+)
+
+// Namespace twnode 使用的路由表号和规则优先级
+// 默认值与早期版本的固定值一致；与 Docker、Tailscale、FRR 等冲突时可在 config.yaml 中调整
+type Namespace struct {
+	// 路由表号偏移，twnode 使用 [offset+5, offset+1199] 范围内的路由表
+	TableOffset int `yaml:"table_offset"`
+
+	// 规则优先级（必须严格递增）
+	TestPref     int `yaml:"test_pref"`      // 检测探测规则（只匹配带探测标记的检测报文）
+	SourcePref   int `yaml:"source_pref"`    // 多出口源地址路由（source_pref-1 用于源地址的本地/VIP路由规则）
+	ForwardPref  int `yaml:"forward_pref"`   // 端口转发回程路由
+	ProtectPref  int `yaml:"protect_pref"`   // 隧道对端IP保护规则
+	TunnelPref   int `yaml:"tunnel_pref"`    // 隧道对端策略路由
+	VIPPref      int `yaml:"vip_pref"`       // 隧道VIP路由
+	GroupPrefMin int `yaml:"group_pref_min"` // 策略组优先级下限
+	VRFPref      int `yaml:"vrf_pref"`       // VRF 隔离规则（策略组优先级上限为 vrf_pref-1）
+	DefaultPref  int `yaml:"default_pref"`   // 默认路由
+}
+
+// DefaultNamespace 返回默认路由命名空间
+func DefaultNamespace() Namespace {
+	return Namespace{
+		TableOffset:  0,
+		TestPref:     5,
+		SourcePref:   8,
+		ForwardPref:  9,
+		ProtectPref:  10,
+		TunnelPref:   50,
+		VIPPref:      80,
+		GroupPrefMin: 100,
+		VRFPref:      899,
+		DefaultPref:  900,
+	}
+}
+
+// withDefaults 未设置（为0）的优先级使用默认值
+func (ns Namespace) withDefaults() Namespace {
+	def := DefaultNamespace()
+	fields := []struct{ value, def *int }{
+		{&ns.TestPref, &def.TestPref},
+		{&ns.SourcePref, &def.SourcePref},
+		{&ns.ForwardPref, &def.ForwardPref},
+		{&ns.ProtectPref, &def.ProtectPref},
+		{&ns.TunnelPref, &def.TunnelPref},
+		{&ns.VIPPref, &def.VIPPref},
+		{&ns.GroupPrefMin, &def.GroupPrefMin},
+		{&ns.VRFPref, &def.VRFPref},
+		{&ns.DefaultPref, &def.DefaultPref},
+	}
+	for _, field := range fields {
+		if *field.value == 0 {
+			*field.value = *field.def
+		}
+	}
+	return ns
+}
+
+// Validate 校验路由命名空间
+func (ns Namespace) Validate() error {
+	if ns.TableOffset < 0 || ns.TableOffset > 1<<30 {
+		return fmt.Errorf("table_offset 超出范围: %d", ns.TableOffset)
+	}
+
+	// 表号不能与内核保留表 local(255)/main(254)/default(253) 重叠（偏移为0时保持旧布局）
+	if ns.TableOffset > 0 && ns.TableOffset+testTableBase <= 255 && ns.TableOffset+tableSpan > 253 {
+		return fmt.Errorf("table_offset %d 使路由表范围覆盖内核保留表 253-255", ns.TableOffset)
+	}
+
+	prefs := []struct {
+		name  string
+		value int
+	}{
+		{"test_pref", ns.TestPref},
+		{"source_pref", ns.SourcePref},
+		{"forward_pref", ns.ForwardPref},
+		{"protect_pref", ns.ProtectPref},
+		{"tunnel_pref", ns.TunnelPref},
+		{"vip_pref", ns.VIPPref},
+		{"group_pref_min", ns.GroupPrefMin},
+		{"vrf_pref", ns.VRFPref},
+		{"default_pref", ns.DefaultPref},
+	}
+	for i, pref := range prefs {
+		if pref.value <= 0 || pref.value >= 32766 {
+			return fmt.Errorf("%s 必须在 1-32765 之间: %d", pref.name, pref.value)
+		}
+		if i > 0 && pref.value <= prefs[i-1].value {
+			return fmt.Errorf("%s(%d) 必须大于 %s(%d)", pref.name, pref.value, prefs[i-1].name, prefs[i-1].value)
+		}
+	}
+
+	if ns.SourceLocalPref() <= ns.TestPref {
+		return fmt.Errorf("source_pref(%d) 与 test_pref(%d) 之间至少需要留出一个优先级（source_pref-1 用于源地址的本地路由规则）", ns.SourcePref, ns.TestPref)
+	}
+
+	if ns.VRFPref-ns.GroupPrefMin > defaultTableBase-groupTableBase {
+		return fmt.Errorf("策略组优先级范围 %d-%d 超过 %d 个", ns.GroupPrefMin, ns.GroupPrefMax(), defaultTableBase-groupTableBase)
+	}
+
+	return nil
+}
+
+// GroupPrefMax 策略组优先级上限
+func (ns Namespace) GroupPrefMax() int {
+	return ns.VRFPref - 1
+}
+
+// SourceLocalPref 源地址路由之前的本地路由规则优先级
+// 以上行IP为源、目标为直连网段或隧道VIP的流量先查主路由表/VIP路由表（忽略默认路由），不进入源地址路由表
+func (ns Namespace) SourceLocalPref() int {
+	return ns.SourcePref - 1
+}
+
+// IsGroupPref 判断优先级是否属于策略组范围
+func (ns Namespace) IsGroupPref(pref int) bool {
+	return pref >= ns.GroupPrefMin && pref < ns.VRFPref
+}
+
+// TestTable 检测探测路由表（表号同时作为探测标记 fwmark）
+func (ns Namespace) TestTable() int {
+	return ns.TableOffset + testTableBase
+}
+
+// TunnelTable 隧道对端策略路由表
+func (ns Namespace) TunnelTable() int {
+	return ns.TableOffset + tunnelTableBase
+}
+
+// VIPTable 隧道VIP路由表
+func (ns Namespace) VIPTable() int {
+	return ns.TableOffset + vipTableBase
+}
+
+// GroupTable 策略组路由表
+func (ns Namespace) GroupTable(pref int) int {
+	return ns.TableOffset + groupTableBase + pref - ns.GroupPrefMin
+}
+
+// GroupPrefOfTable 路由表对应的策略组优先级
+func (ns Namespace) GroupPrefOfTable(table int) (int, bool) {
+	pref := table - ns.TableOffset - groupTableBase + ns.GroupPrefMin
+	return pref, ns.IsGroupPref(pref)
+}
+
+// DefaultTable 默认路由表
+func (ns Namespace) DefaultTable() int {
+	return ns.TableOffset + defaultTableBase
+}
+
+// SourceTables 源地址路由表范围
+func (ns Namespace) SourceTables() (int, int) {
+	base := ns.TableOffset + sourceTableBase
+	return base, base + dynamicTableCount - 1
+}
+
+// ForwardTables 端口转发回程路由表范围
+func (ns Namespace) ForwardTables() (int, int) {
+	base := ns.TableOffset + forwardTableBase
+	return base, base + dynamicTableCount - 1
+}
+
+// TableRange twnode 使用的路由表范围
+func (ns Namespace) TableRange() (int, int) {
+	return ns.TableOffset + testTableBase, ns.TableOffset + tableSpan - 1
+}
+
+// OwnsTable 判断路由表是否属于 twnode
+func (ns Namespace) OwnsTable(table int) bool {
+	if table == ns.TestTable() || table == ns.TunnelTable() || table == ns.VIPTable() || table == ns.DefaultTable() {
+		return true
+	}
+	if _, ok := ns.GroupPrefOfTable(table); ok {
+		return true
+	}
+	sourceMin, _ := ns.SourceTables()
+	_, forwardMax := ns.ForwardTables()
+	return table >= sourceMin && table <= forwardMax
+}
+
+// OwnsPref 判断规则优先级是否属于 twnode
+func (ns Namespace) OwnsPref(pref int) bool {
+	switch pref {
+	case ns.TestPref, ns.SourceLocalPref(), ns.SourcePref, ns.ForwardPref, ns.ProtectPref, ns.TunnelPref, ns.VIPPref, ns.VRFPref, ns.DefaultPref:
+		return true
+	}
+	return ns.IsGroupPref(pref)
+}
+
+var (
+	namespaceOnce sync.Once
+	namespace     Namespace
+)
+
+// RoutingNamespace 返回当前配置的路由命名空间（进程内缓存）
+// 配置文件不存在或配置无效时使用默认值
+func RoutingNamespace() Namespace {
+	namespaceOnce.Do(func() {
+		namespace = DefaultNamespace()
+		cfg, err := Load()
+		if err != nil {
+			return
+		}
+		ns := cfg.Namespace.withDefaults()
+		if err := ns.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠ 路由命名空间配置无效，使用默认值: %v\n", err)
+			return
+		}
+		namespace = ns
+	})
+	return namespace
+}
+
+// LoadNamespace 加载并校验配置的路由命名空间（不使用缓存，配置无效时返回错误）
+func LoadNamespace() (Namespace, error) {
+	cfg, err := Load()
+	if err != nil {
+		return Namespace{}, err
+	}
+	ns := cfg.Namespace.withDefaults()
+	if err := ns.Validate(); err != nil {
+		return Namespace{}, fmt.Errorf("路由命名空间配置无效: %w", err)
+	}
+	return ns, nil
+}
+
+// LoadAppliedNamespace 加载内核状态当前使用的路由命名空间
+// 没有记录时（早期版本部署）返回默认值
+func LoadAppliedNamespace() Namespace {
+	data, err := os.ReadFile(AppliedNamespaceFile)
+	if err != nil {
+		return DefaultNamespace()
+	}
+
+	var ns Namespace
+	if err := yaml.Unmarshal(data, &ns); err != nil {
+		return DefaultNamespace()
+	}
+	return ns.withDefaults()
+}
+
+// SaveAppliedNamespace 记录内核状态使用的路由命名空间
+func SaveAppliedNamespace(ns Namespace) error {
+	if err := os.MkdirAll(filepath.Dir(AppliedNamespaceFile), 0755); err != nil {
+		return fmt.Errorf("创建状态目录失败: %w", err)
+	}
+
+	data, err := yaml.Marshal(ns)
+	if err != nil {
+		return fmt.Errorf("序列化路由命名空间失败: %w", err)
+	}
+
+	if err := os.WriteFile(AppliedNamespaceFile, data, 0644); err != nil {
+		return fmt.Errorf("写入路由命名空间记录失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,676 @@
+package drift
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/vishvananda/netlink"
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/ipsec"
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
+)
+
+const (
+	KindMissing  = "missing"  // 配置中有，内核中没有
+	KindExtra    = "extra"    // 内核中有，配置中没有
+	KindMismatch = "mismatch" // 两边都有但不一致
+)
+
+// FixOptions 修复选项
+type FixOptions struct {
+	// ExitOverrides 出口覆盖（策略组名或 "default" -> 出口）
+	// 守护进程传入故障转移后的当前出口，避免修复时把出口改回配置值
+	ExitOverrides map[string]string
+
+	// SkipRepair 跳过修复的策略组名或 "default"
+	// 守护进程传入已执行 on_exit_down 的监控任务，避免修复时用不可用的出口覆盖阻断路由
+	SkipRepair map[string]bool
+}
+
+// Item 漂移项
+type Item struct {
+	Category string // 隧道/VIP路由/保护规则/策略组/默认路由
+	Name     string // 对象名称
+	Kind     string // missing/extra/mismatch
+	Detail   string
+	fixKey   string // 相同 fixKey 的修复只执行一次
+	fix      func(opts *FixOptions) error
+}
+
+// Fixable 是否可自动修复
+func (i *Item) Fixable() bool {
+	return i.fix != nil
+}
+
+// Report 漂移检测报告
+type Report struct {
+	Items []*Item
+	Notes []string // 非漂移的提示信息（如出口已被故障转移切换）
+}
+
+// HasDrift 是否存在漂移
+func (r *Report) HasDrift() bool {
+	return len(r.Items) > 0
+}
+
+// add 添加漂移项，默认每项单独修复；需要合并修复的调用方可修改返回项的 fixKey
+func (r *Report) add(category, name, kind, detail string, fix func(opts *FixOptions) error) *Item {
+	item := &Item{
+		Category: category,
+		Name:     name,
+		Kind:     kind,
+		Detail:   detail,
+		fixKey:   category + "/" + name + "/" + detail,
+		fix:      fix,
+	}
+	r.Items = append(r.Items, item)
+	return item
+}
+
+func (r *Report) note(format string, args ...interface{}) {
+	r.Notes = append(r.Notes, fmt.Sprintf(format, args...))
+}
+
+// Check 比较配置与内核状态
+func Check() (*Report, error) {
+	report := &Report{}
+
+	rules, err := routing.ListIPRules()
+	if err != nil {
+		return nil, err
+	}
+
+	tunnels, err := network.ListTunnelConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("加载隧道配置失败: %w", err)
+	}
+
+	checkTunnels(report, tunnels, rules)
+	checkProtection(report, tunnels, rules)
+
+	if err := checkPolicyGroups(report, rules); err != nil {
+		return nil, err
+	}
+
+	checkDefaultRoute(report, rules)
+
+	return report, nil
+}
+
+// Fix 修复报告中的漂移项，返回成功修复的数量和失败的错误
+func (r *Report) Fix(opts *FixOptions) (int, []error) {
+	if opts == nil {
+		opts = &FixOptions{}
+	}
+
+	fixed := 0
+	errs := make([]error, 0)
+	done := make(map[string]bool)
+
+	for _, item := range r.Items {
+		if item.fix == nil {
+			continue
+		}
+
+		// 同一对象的多个漂移项只需修复一次
+		if done[item.fixKey] {
+			fixed++
+			continue
+		}
+		done[item.fixKey] = true
+
+		if err := item.fix(opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %v", item.Category, item.Name, err))
+			continue
+		}
+		fixed++
+	}
+
+	exec.Command("ip", "route", "flush", "cache").Run()
+	return fixed, errs
+}
+
+// Print 打印报告
+func (r *Report) Print() {
+	fmt.Println()
+	fmt.Println("【漂移检测】")
+	fmt.Println()
+
+	if !r.HasDrift() {
+		fmt.Println("  ✓ 内核状态与配置一致")
+	} else {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header("类别", "对象", "状态", "详情", "可修复")
+
+		for _, item := range r.Items {
+			fixable := "否"
+			if item.Fixable() {
+				fixable = "是"
+			}
+			table.Append(item.Category, item.Name, kindDisplay(item.Kind), item.Detail, fixable)
+		}
+
+		table.Render()
+
+		fmt.Println()
+		fmt.Printf("共 %d 项漂移\n", len(r.Items))
+	}
+
+	if len(r.Notes) > 0 {
+		fmt.Println()
+		fmt.Println("提示:")
+		for _, note := range r.Notes {
+			fmt.Printf("  ℹ %s\n", note)
+		}
+	}
+}
+
+// Summary 返回单行摘要（用于守护进程日志）
+func (r *Report) Summary() string {
+	if !r.HasDrift() {
+		return "无漂移"
+	}
+
+	parts := make([]string, 0, len(r.Items))
+	for _, item := range r.Items {
+		parts = append(parts, fmt.Sprintf("%s %s %s", item.Category, item.Name, kindDisplay(item.Kind)))
+	}
+	return fmt.Sprintf("%d 项漂移: %s", len(r.Items), strings.Join(parts, "; "))
+}
+
+func kindDisplay(kind string) string {
+	switch kind {
+	case KindMissing:
+		return "缺失"
+	case KindExtra:
+		return "多余"
+	case KindMismatch:
+		return "不一致"
+	}
+	return kind
+}
+
+// tunnelInstalled 判断 twnode 是否认为隧道已启动（撤销文件存在）
+func tunnelInstalled(name string) bool {
+	_, err := os.Stat(filepath.Join(ipsec.RevDir, name+".rev"))
+	return err == nil
+}
+
+// hasRule 检查是否存在指定优先级和路由表的规则
+func hasRule(rules []*routing.IPRule, pref int, table string) bool {
+	for _, rule := range rules {
+		if rule.Pref == pref && rule.Action == "lookup" && rule.Table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTunnels 检查隧道接口及表80中的VIP路由
+func checkTunnels(report *Report, tunnels []*network.TunnelConfig, rules []*routing.IPRule) {
+	ns := config.RoutingNamespace()
+	vipTable := strconv.Itoa(ns.VIPTable())
+	vipRoutes, _ := routing.ListIPRoutes(vipTable)
+
+	installed := make(map[string]*network.TunnelConfig)
+	for _, cfg := range tunnels {
+		if !tunnelInstalled(cfg.Name) {
+			continue
+		}
+		// 网络命名空间内的隧道接口和路由不在默认命名空间中，不做检测
+		if cfg.Netns != "" {
+			continue
+		}
+		installed[cfg.Name] = cfg
+		tunnel := cfg
+
+		link, err := netlink.LinkByName(cfg.Name)
+		if err != nil {
+			report.add("隧道", cfg.Name, KindMissing, "接口不存在（已启动但被外部删除）", func(opts *FixOptions) error {
+				return ipsec.NewTunnelManager(tunnel).Start()
+			})
+			continue
+		}
+
+		if link.Attrs().Flags&net.FlagUp == 0 {
+			report.add("隧道", cfg.Name, KindMismatch, "接口处于 DOWN 状态", func(opts *FixOptions) error {
+				return exec.Command("ip", "link", "set", "dev", tunnel.Name, "up").Run()
+			})
+		}
+
+		if cfg.LocalVIP != "" && !linkHasAddr(link, cfg.LocalVIP) {
+			report.add("隧道", cfg.Name, KindMissing, fmt.Sprintf("本地VIP %s 未配置", cfg.LocalVIP), func(opts *FixOptions) error {
+				return exec.Command("ip", "addr", "add", tunnel.LocalVIP+"/32", "dev", tunnel.Name).Run()
+			})
+		}
+
+		if cfg.TunnelType != "wireguard" && cfg.UseEncryption && !xfrmStateExists(cfg.LocalIP, cfg.RemoteIP) {
+			report.add("隧道", cfg.Name, KindMissing, "IPsec xfrm 状态缺失", func(opts *FixOptions) error {
+				tm := ipsec.NewTunnelManager(tunnel)
+				if err := tm.Stop(); err != nil {
+					return err
+				}
+				return tm.Start()
+			})
+		}
+
+		// VRF 内的隧道: 对端路由在VRF的路由表中
+		peerTable, peerRoutes := vipTable, vipRoutes
+		if cfg.VRF != "" {
+			table, err := network.PeerRouteTable(cfg.VRF, "")
+			if err != nil {
+				report.add("隧道", cfg.Name, KindMismatch, err.Error(), nil)
+				continue
+			}
+			peerTable = table
+			peerRoutes, _ = routing.ListIPRoutes(table)
+		}
+
+		for _, prefix := range cfg.PeerRoutes() {
+			if hasVIPRoute(peerRoutes, prefix, cfg.Name) {
+				continue
+			}
+			p := prefix
+			report.add("VIP路由", cfg.Name, KindMissing, fmt.Sprintf("表%s 中缺少 %s dev %s", peerTable, prefix, cfg.Name), func(opts *FixOptions) error {
+				return exec.Command("ip", "route", "replace", p, "dev", tunnel.Name, "table", peerTable).Run()
+			})
+		}
+	}
+
+	// 表80规则
+	if len(installed) > 0 && !hasRule(rules, ns.VIPPref, vipTable) {
+		report.add("VIP路由", fmt.Sprintf("pref %d", ns.VIPPref), KindMissing, fmt.Sprintf("缺少规则 from all lookup %s", vipTable), func(opts *FixOptions) error {
+			return exec.Command("ip", "rule", "add", "from", "all", "lookup", vipTable, "pref", strconv.Itoa(ns.VIPPref)).Run()
+		})
+	}
+
+	// 表80中无对应隧道的路由
+	for _, route := range vipRoutes {
+		if route.Dev == "" {
+			continue
+		}
+		cfg, ok := installed[route.Dev]
+		if ok && containsString(cfg.PeerRoutes(), route.Prefix.String()) {
+			continue
+		}
+		r := route
+		report.add("VIP路由", route.Prefix.String(), KindExtra, fmt.Sprintf("表%s: %s", vipTable, route.Raw), func(opts *FixOptions) error {
+			return exec.Command("ip", "route", "del", r.Prefix.String(), "dev", r.Dev, "table", vipTable).Run()
+		})
+	}
+}
+
+// linkHasAddr 检查接口是否配置了指定IPv4地址
+func linkHasAddr(link netlink.Link, ip string) bool {
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.String() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVIPRoute 检查表80中是否存在到对端VIP（或对端网段）的路由
+func hasVIPRoute(routes []*routing.IPRoute, prefix, dev string) bool {
+	for _, route := range routes {
+		if route.Prefix.String() == prefix && route.Dev == dev {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString 判断切片中是否包含指定字符串
+func containsString(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// xfrmStateExists 检查两端之间是否存在 xfrm 状态
+func xfrmStateExists(localIP, remoteIP string) bool {
+	output, err := exec.Command("ip", "xfrm", "state", "list", "src", localIP, "dst", remoteIP).Output()
+	return err == nil && strings.TrimSpace(string(output)) != ""
+}
+
+// checkProtection 检查保护规则（protect_pref，默认 pref 10）
+func checkProtection(report *Report, tunnels []*network.TunnelConfig, rules []*routing.IPRule) {
+	protectPref := config.RoutingNamespace().ProtectPref
+	fix := func(opts *FixOptions) error {
+		return routing.SyncProtection()
+	}
+
+	expected := make(map[string]string) // IP -> 隧道名
+	for _, cfg := range tunnels {
+		ip := cfg.RemoteIP
+		if ip == "" || ip == "0.0.0.0" {
+			ip = cfg.ProtectedIP
+		}
+		if ip == "" || ip == "0.0.0.0" {
+			continue
+		}
+		expected[ip] = cfg.Name
+	}
+
+	actual := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Pref != protectPref || rule.To == "" {
+			continue
+		}
+		ip := strings.TrimSuffix(rule.To, "/32")
+		actual[ip] = true
+		if _, ok := expected[ip]; !ok {
+			// 保护规则统一由 SyncProtection 处理，只需执行一次
+			report.add("保护规则", ip, KindExtra, "无对应隧道的保护规则", fix).fixKey = "保护规则"
+		}
+	}
+
+	ips := make([]string, 0, len(expected))
+	for ip := range expected {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		if !actual[ip] {
+			report.add("保护规则", ip, KindMissing, fmt.Sprintf("隧道 %s 的对端IP未受保护", expected[ip]), fix).fixKey = "保护规则"
+		}
+	}
+}
+
+// checkPolicyGroups 检查已应用策略组的规则与路由表
+func checkPolicyGroups(report *Report, rules []*routing.IPRule) error {
+	ns := config.RoutingNamespace()
+	pm := routing.NewPolicyManager()
+	if err := pm.LoadAllGroups(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 期望已应用的策略组
+	applied, recorded := routing.LoadAppliedGroups()
+	expected := make(map[string]bool)
+	if recorded {
+		for _, name := range applied {
+			expected[name] = true
+		}
+	} else if len(pm.Groups()) > 0 {
+		// 旧版本没有应用记录：以内核中存在规则的策略组为准
+		report.note("未找到策略组应用记录，以内核中存在规则的策略组为准（执行一次 'twnode policy apply' 后生成记录）")
+		for _, rule := range rules {
+			for _, group := range groupsByPriority(pm, rule.Pref) {
+				expected[group.Name] = true
+			}
+		}
+	}
+
+	groupPrios := make(map[int]bool)
+	for name := range expected {
+		group := pm.GetGroup(name)
+		if group == nil {
+			continue
+		}
+		// 网络命名空间内的策略组规则和路由表不在默认命名空间中
+		if group.Netns != "" {
+			report.note("策略组 %s 位于网络命名空间 %s，跳过检测", group.Name, group.Netns)
+			continue
+		}
+		groupPrios[group.Priority] = true
+		checkPolicyGroup(report, pm, group, rules)
+	}
+
+	// 用户策略组优先级范围内的多余规则
+	for _, rule := range rules {
+		if !ns.IsGroupPref(rule.Pref) || groupPrios[rule.Pref] {
+			continue
+		}
+
+		pref := rule.Pref
+		name := fmt.Sprintf("pref %d", pref)
+		detail := "无对应策略组的规则"
+		if groups := groupsByPriority(pm, pref); len(groups) > 0 {
+			name = groups[0].Name
+			detail = "策略组未应用（已撤销），但内核中仍存在规则"
+		}
+		report.add("策略组", name, KindExtra, detail, func(opts *FixOptions) error {
+			exec.Command("ip", "rule", "del", "pref", strconv.Itoa(pref)).Run()
+			return exec.Command("ip", "route", "flush", "table", strconv.Itoa(ns.GroupTable(pref))).Run()
+		})
+		groupPrios[pref] = true
+	}
+
+	return nil
+}
+
+// groupsByPriority 返回指定优先级的策略组
+func groupsByPriority(pm *routing.PolicyManager, prio int) []*routing.PolicyGroup {
+	result := make([]*routing.PolicyGroup, 0)
+	for _, group := range pm.Groups() {
+		if group.Priority == prio {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// checkPolicyGroup 检查单个策略组
+func checkPolicyGroup(report *Report, pm *routing.PolicyManager, group *routing.PolicyGroup, rules []*routing.IPRule) {
+	tableID := strconv.Itoa(config.RoutingNamespace().GroupTable(group.Priority))
+	groupName := group.Name
+	reapplyKey := "策略组/" + groupName // 重新应用一次即可修复该组的所有漂移项
+
+	// 重新应用策略组（保留当前实际出口）
+	reapply := func(opts *FixOptions) error {
+		if opts.SkipRepair[groupName] {
+			return fmt.Errorf("所有候选出口均不可用（已执行 on_exit_down），跳过修复")
+		}
+		g := pm.GetGroup(groupName)
+		exit := g.Exit
+		if override, ok := opts.ExitOverrides[groupName]; ok && override != "" {
+			exit = override
+		}
+		if !network.IsInterfaceUp(exit) {
+			return fmt.Errorf("出口接口 %s 不存在或未启动", exit)
+		}
+		applied := *g
+		applied.Exit = exit
+		return pm.ApplyGroup(&applied)
+	}
+
+	// 规则
+	var rule *routing.IPRule
+	for _, r := range rules {
+		if r.Pref == group.Priority {
+			rule = r
+			break
+		}
+	}
+
+	expectedFrom := group.From
+	if expectedFrom == "" {
+		expectedFrom = "all"
+	}
+
+	if rule == nil {
+		report.add("策略组", groupName, KindMissing, fmt.Sprintf("缺少规则 pref %d", group.Priority), reapply).fixKey = reapplyKey
+	} else if rule.Table != tableID || !sameFrom(rule.From, expectedFrom) || rule.Iif != group.VRF {
+		expectedRule := fmt.Sprintf("from %s lookup %s", expectedFrom, tableID)
+		if group.VRF != "" {
+			expectedRule = fmt.Sprintf("from %s iif %s lookup %s", expectedFrom, group.VRF, tableID)
+		}
+		report.add("策略组", groupName, KindMismatch,
+			fmt.Sprintf("规则不一致: 期望 %s，实际 %s", expectedRule, rule.Raw), reapply).fixKey = reapplyKey
+	}
+
+	// 路由表
+	routes, err := routing.ListIPRoutes(tableID)
+	if err != nil {
+		routes = nil
+	}
+
+	// 与 on_exit_down 一致的阻断路由视为合规（出口不可用时由守护进程安装）
+	exitDownType := routing.ExitDownRouteType(group.OnExitDown)
+	blocked := 0
+
+	actual := make(map[string]*routing.IPRoute)
+	throws := make(map[string]bool)
+	exits := make(map[string]bool)
+	for _, route := range routes {
+		if route.Type == "throw" {
+			throws[route.Prefix.String()] = true
+			continue
+		}
+		if route.Type == "blackhole" || route.Type == "unreachable" {
+			if route.Type != exitDownType {
+				report.add("策略组", groupName, KindMismatch,
+					fmt.Sprintf("路由表 %s 存在 %s 路由 %s，但 on_exit_down 为 %s", tableID, route.Type, route.Prefix, routing.OnExitDownDisplay(group.OnExitDown)),
+					reapply).fixKey = reapplyKey
+				continue
+			}
+			blocked++
+		}
+		actual[route.Prefix.String()] = route
+		if route.Dev != "" {
+			exits[route.Dev] = true
+		}
+	}
+
+	missing := 0
+	cidrs, expectedThrows := group.RouteSets()
+	for _, cidr := range cidrs {
+		if _, ok := actual[cidr]; !ok {
+			missing++
+		}
+		delete(actual, cidr)
+	}
+	for _, cidr := range expectedThrows {
+		if !throws[cidr] {
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		report.add("策略组", groupName, KindMissing, fmt.Sprintf("路由表 %s 缺少 %d 条路由", tableID, missing), reapply).fixKey = reapplyKey
+	}
+	if len(actual) > 0 {
+		report.add("策略组", groupName, KindExtra, fmt.Sprintf("路由表 %s 存在 %d 条配置外的路由", tableID, len(actual)), reapply).fixKey = reapplyKey
+	}
+
+	if blocked > 0 {
+		report.note("策略组 %s 已按 on_exit_down 阻断 %d 个CIDR (%s)", groupName, blocked, exitDownType)
+	}
+
+	for exit := range exits {
+		if exit != group.Exit {
+			report.note("策略组 %s 的内核出口为 %s，配置出口为 %s（可能正在进行故障转移）", groupName, exit, group.Exit)
+			break
+		}
+	}
+}
+
+// sameFrom 比较规则的源选择器
+func sameFrom(actual, expected string) bool {
+	if actual == "" {
+		actual = "all"
+	}
+	if actual == expected {
+		return true
+	}
+	a, errA := routing.NormalizeCIDR(actual)
+	e, errE := routing.NormalizeCIDR(expected)
+	return errA == nil && errE == nil && a == e
+}
+
+// checkDefaultRoute 检查默认路由（pref/table 900）
+func checkDefaultRoute(report *Report, rules []*routing.IPRule) {
+	cfg, err := config.Load()
+	defaultExit, onExitDown := "", ""
+	if err == nil {
+		defaultExit = cfg.Routing.DefaultExit
+		onExitDown = cfg.Routing.DefaultOnExitDown
+	}
+
+	ns := config.RoutingNamespace()
+	tableID := strconv.Itoa(ns.DefaultTable())
+	reapplyKey := "默认路由"
+	hasDefaultRule := hasRule(rules, ns.DefaultPref, tableID)
+
+	routes, _ := routing.ListIPRoutes(tableID)
+	var defaultRoute, blockRoute *routing.IPRoute
+	for _, route := range routes {
+		if route.Prefix.Bits() != 0 {
+			continue
+		}
+		switch route.Type {
+		case "unicast":
+			if defaultRoute == nil {
+				defaultRoute = route
+			}
+		case "blackhole", "unreachable":
+			if blockRoute == nil {
+				blockRoute = route
+			}
+		}
+	}
+
+	if defaultExit == "" {
+		if hasDefaultRule || defaultRoute != nil || blockRoute != nil {
+			report.add("默认路由", "0.0.0.0/0", KindExtra, "未设置默认出口，但内核中存在默认路由", func(opts *FixOptions) error {
+				return routing.NewPolicyManager().RevokeDefaultRouteOnly()
+			})
+		}
+		return
+	}
+
+	// 修复时优先保留内核当前出口（守护进程可能已切换）
+	currentExit := defaultExit
+	if defaultRoute != nil && defaultRoute.Dev != "" {
+		currentExit = defaultRoute.Dev
+	}
+	reapply := func(opts *FixOptions) error {
+		if opts.SkipRepair["default"] {
+			return fmt.Errorf("所有候选出口均不可用（已执行 on_exit_down），跳过修复")
+		}
+		exit := currentExit
+		if override, ok := opts.ExitOverrides["default"]; ok && override != "" {
+			exit = override
+		}
+		if !network.IsInterfaceUp(exit) {
+			return fmt.Errorf("出口接口 %s 不存在或未启动", exit)
+		}
+		pm := routing.NewPolicyManager()
+		pm.SetDefaultExit(exit)
+		return pm.ApplyDefaultRouteOnly()
+	}
+
+	if !hasDefaultRule {
+		report.add("默认路由", "0.0.0.0/0", KindMissing, fmt.Sprintf("缺少规则 pref %d", ns.DefaultPref), reapply).fixKey = reapplyKey
+	}
+	if defaultRoute == nil && blockRoute != nil {
+		// 与 on_exit_down 一致的阻断路由视为合规（出口不可用时由守护进程安装）
+		if blockRoute.Type == routing.ExitDownRouteType(onExitDown) {
+			report.note("默认路由已按 on_exit_down 阻断 (%s)", blockRoute.Type)
+		} else {
+			report.add("默认路由", "0.0.0.0/0", KindMismatch,
+				fmt.Sprintf("路由表 %s 中为 %s 默认路由，但 on_exit_down 为 %s", tableID, blockRoute.Type, routing.OnExitDownDisplay(onExitDown)),
+				reapply).fixKey = reapplyKey
+		}
+	} else if defaultRoute == nil {
+		report.add("默认路由", "0.0.0.0/0", KindMissing, fmt.Sprintf("路由表 %s 中缺少默认路由", tableID), reapply).fixKey = reapplyKey
+	} else if defaultRoute.Dev != defaultExit {
+		report.note("默认路由的内核出口为 %s，配置出口为 %s（可能正在进行故障转移）", defaultRoute.Dev, defaultExit)
+	}
+}
@@ -0,0 +1,182 @@
+package failover
+
+import (
+	"fmt"
+	"time"
+)
+
+// 自适应检测间隔
+// 每次共享检测后按订阅的监控任务的状态决定下一次检测的间隔（check_interval_ms 为基准间隔）:
+//   - 加速: 监控任务的当前出口丢包或延迟劣化（或不可用）时，以 fast_interval_ms 检测其所有候选出口
+//   - 放松: 监控任务的所有候选出口都健康时以 relaxed_interval_ms 检测（完全不可用的出口按退避处理，不影响放松）
+//   - 退避: 完全不可用且不是任何订阅监控任务当前出口的出口，间隔从基准间隔起每次翻倍，上限 down_max_interval_ms
+// 共享检测任务取所有订阅监控任务中最短的间隔；加速不会慢于基准间隔，放松和退避不会快于基准间隔
+// 每轮检测的报文数量和等待时间按当轮的实际间隔计算（加速时缩短，放松时延长）
+
+// 自适应检测间隔默认值
+const (
+	DefaultAdaptiveFastIntervalMs    = 500
+	DefaultAdaptiveRelaxedIntervalMs = 3000
+	DefaultAdaptiveDownMaxIntervalMs = 30000
+	DefaultAdaptiveDegradedLoss      = 5
+)
+
+// 自适应检测节奏
+const (
+	paceFast    = "加速"
+	paceRelaxed = "放松"
+	paceBackoff = "退避"
+)
+
+// AdaptiveConfig 自适应检测间隔配置（零值字段使用默认值）
+type AdaptiveConfig struct {
+	FastIntervalMs      int     `yaml:"fast_interval_ms,omitempty"`      // 当前出口劣化时的检测间隔（默认500）
+	RelaxedIntervalMs   int     `yaml:"relaxed_interval_ms,omitempty"`   // 所有出口健康时的检测间隔（默认3000）
+	DownMaxIntervalMs   int     `yaml:"down_max_interval_ms,omitempty"`  // 不可用出口退避的最长间隔（默认30000）
+	DegradedLossPercent float64 `yaml:"degraded_loss_percent,omitempty"` // 丢包率达到此值视为劣化（%，默认5）
+	DegradedLatencyMs   float64 `yaml:"degraded_latency_ms,omitempty"`   // 平均延迟达到此值视为劣化（ms，默认0 不检查）
+}
+
+// withDefaults 返回填充默认值后的配置
+func (c *AdaptiveConfig) withDefaults() AdaptiveConfig {
+	result := AdaptiveConfig{
+		FastIntervalMs:      DefaultAdaptiveFastIntervalMs,
+		RelaxedIntervalMs:   DefaultAdaptiveRelaxedIntervalMs,
+		DownMaxIntervalMs:   DefaultAdaptiveDownMaxIntervalMs,
+		DegradedLossPercent: DefaultAdaptiveDegradedLoss,
+		DegradedLatencyMs:   c.DegradedLatencyMs,
+	}
+	if c.FastIntervalMs > 0 {
+		result.FastIntervalMs = c.FastIntervalMs
+	}
+	if c.RelaxedIntervalMs > 0 {
+		result.RelaxedIntervalMs = c.RelaxedIntervalMs
+	}
+	if c.DownMaxIntervalMs > 0 {
+		result.DownMaxIntervalMs = c.DownMaxIntervalMs
+	}
+	if c.DegradedLossPercent > 0 {
+		result.DegradedLossPercent = c.DegradedLossPercent
+	}
+	return result
+}
+
+// Validate 校验自适应检测间隔配置（prefix 为配置路径，用于错误信息）
+func (c *AdaptiveConfig) Validate(prefix string) []string {
+	if c == nil {
+		return nil
+	}
+
+	errors := make([]string, 0)
+	if c.FastIntervalMs < 0 || c.RelaxedIntervalMs < 0 || c.DownMaxIntervalMs < 0 || c.DegradedLossPercent < 0 || c.DegradedLatencyMs < 0 {
+		errors = append(errors, prefix+" 的参数不能为负数")
+	}
+	merged := c.withDefaults()
+	// 检测报文数量和回复等待时间有下限（10 个包、200ms），间隔小于 400ms 时一轮检测会超过间隔
+	if merged.FastIntervalMs < 400 {
+		errors = append(errors, prefix+".fast_interval_ms 不能小于 400")
+	}
+	if merged.FastIntervalMs > merged.RelaxedIntervalMs {
+		errors = append(errors, prefix+".fast_interval_ms 不能大于 relaxed_interval_ms")
+	}
+	if merged.RelaxedIntervalMs > 60000 {
+		errors = append(errors, prefix+".relaxed_interval_ms 不能大于 60000")
+	}
+	if merged.DownMaxIntervalMs < merged.RelaxedIntervalMs || merged.DownMaxIntervalMs > 600000 {
+		errors = append(errors, prefix+".down_max_interval_ms 必须在 relaxed_interval_ms 到 600000 之间")
+	}
+	if merged.DegradedLossPercent >= 100 {
+		errors = append(errors, prefix+".degraded_loss_percent 必须小于 100")
+	}
+	return errors
+}
+
+// Summary 自适应检测间隔配置的简要描述
+func (c *AdaptiveConfig) Summary() string {
+	if c == nil {
+		return "未启用（固定间隔）"
+	}
+	merged := c.withDefaults()
+	degraded := fmt.Sprintf("丢包 ≥%.0f%%", merged.DegradedLossPercent)
+	if merged.DegradedLatencyMs > 0 {
+		degraded += fmt.Sprintf(" 或延迟 ≥%.0fms", merged.DegradedLatencyMs)
+	}
+	return fmt.Sprintf("加速 %dms (%s), 放松 %dms, 不可用出口退避至最长 %dms",
+		merged.FastIntervalMs, degraded, merged.RelaxedIntervalMs, merged.DownMaxIntervalMs)
+}
+
+// degraded 出口状态是否劣化（包括完全不可用）
+func (c AdaptiveConfig) degraded(state *InterfaceState) bool {
+	if state.PacketLoss >= 100.0 || state.PacketLoss >= c.DegradedLossPercent {
+		return true
+	}
+	return c.DegradedLatencyMs > 0 && state.Latency >= c.DegradedLatencyMs
+}
+
+// monitorPace 监控任务期望的检测间隔和节奏（基准节奏时为空）
+func (d *FailoverDaemon) monitorPace(monitor *MonitorConfig, adaptive AdaptiveConfig) (int, string) {
+	base := monitor.GetCheckInterval(d.config.Daemon.CheckIntervalMs)
+
+	if current, _ := d.cachedExit(monitor.Name); current != "" {
+		state := d.stateManager.GetState(current)
+		if state.InitialCheckDone && adaptive.degraded(state) {
+			return min(base, adaptive.FastIntervalMs), paceFast
+		}
+	}
+
+	for _, exit := range d.candidateExits(monitor) {
+		state := d.stateManager.GetState(exit)
+		if !state.InitialCheckDone {
+			return base, ""
+		}
+		if state.PacketLoss < 100.0 && adaptive.degraded(state) {
+			return base, ""
+		}
+	}
+	return max(base, adaptive.RelaxedIntervalMs), paceRelaxed
+}
+
+// nextProbeInterval 共享检测任务下一次检测的间隔（未启用自适应时为基准间隔）
+func (d *FailoverDaemon) nextProbeInterval(task *probeTask) (time.Duration, string) {
+	if d.config.Daemon.Adaptive == nil {
+		task.downStreak = 0
+		return time.Duration(task.intervalMs) * time.Millisecond, ""
+	}
+	adaptive := d.config.Daemon.Adaptive.withDefaults()
+
+	interval, pace := 0, ""
+	isCurrent := false
+	currentExits := d.currentExitsSnapshot()
+	for _, monitor := range task.monitors {
+		monitorInterval, monitorPace := d.monitorPace(monitor, adaptive)
+		if interval == 0 || monitorInterval < interval {
+			interval, pace = monitorInterval, monitorPace
+		}
+		if currentExits[monitor.Name] == task.exit {
+			isCurrent = true
+		}
+	}
+	if interval == 0 {
+		interval = task.intervalMs
+	}
+
+	// 完全不可用且不是当前出口：退避
+	state := d.stateManager.GetState(task.exit)
+	if !isCurrent && state.InitialCheckDone && state.PacketLoss >= 100.0 {
+		if task.downStreak < 30 {
+			task.downStreak++
+		}
+		backoff := task.intervalMs
+		for i := 0; i < task.downStreak && backoff < adaptive.DownMaxIntervalMs; i++ {
+			backoff *= 2
+		}
+		backoff = min(backoff, adaptive.DownMaxIntervalMs)
+		if backoff > interval {
+			interval, pace = backoff, paceBackoff
+		}
+	} else {
+		task.downStreak = 0
+	}
+
+	return time.Duration(interval) * time.Millisecond, pace
+}
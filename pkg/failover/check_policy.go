@@ -0,0 +1,213 @@
+package failover
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 多目标检测策略: 并行检测所有目标（ping 模式的 check_targets / dns 模式的 dns_servers），按策略汇总为出口的检测结果
+//   any       至少 1 个目标可达即可用，指标取最好的目标（默认）
+//   all       所有目标可达才可用，指标取最差的目标
+//   quorum:N  至少 N 个目标可达才可用，指标取第 N 好的目标（至少 N 个目标不差于该指标）
+//   weighted  按 target_weights 加权平均丢包（不可达目标计 100%）、延迟和抖动，有目标可达即可用
+
+const (
+	CheckPolicyAny      = "any"
+	CheckPolicyAll      = "all"
+	CheckPolicyQuorum   = "quorum"
+	CheckPolicyWeighted = "weighted"
+
+	// ping 模式最多的检测目标数（进程内探测一个套接字同时探测所有目标）
+	MaxCheckTargets = 16
+)
+
+// CheckPolicy 解析后的多目标检测策略
+type CheckPolicy struct {
+	Mode    string             // any / all / quorum / weighted
+	Quorum  int                // quorum 模式需要的可达目标数
+	Weights map[string]float64 // weighted 模式的目标权重（未配置的目标权重为 1）
+}
+
+// TargetResult 单个检测目标的结果
+type TargetResult struct {
+	Target     string  `json:"target"`
+	Success    bool    `json:"success"`
+	Latency    float64 `json:"latency"`
+	PacketLoss float64 `json:"packet_loss"`
+	Jitter     float64 `json:"jitter"`
+}
+
+// ParseCheckPolicy 解析检测策略: any / all / quorum:N / weighted（空字符串为 any）
+func ParseCheckPolicy(value string) (CheckPolicy, error) {
+	switch value {
+	case "", CheckPolicyAny:
+		return CheckPolicy{Mode: CheckPolicyAny}, nil
+	case CheckPolicyAll, CheckPolicyWeighted:
+		return CheckPolicy{Mode: value}, nil
+	}
+
+	if strings.HasPrefix(value, CheckPolicyQuorum+":") {
+		n, err := strconv.Atoi(strings.TrimPrefix(value, CheckPolicyQuorum+":"))
+		if err != nil || n < 1 {
+			return CheckPolicy{}, fmt.Errorf("无效的 quorum 数量: %s", value)
+		}
+		return CheckPolicy{Mode: CheckPolicyQuorum, Quorum: n}, nil
+	}
+
+	return CheckPolicy{}, fmt.Errorf("无效的检测策略 '%s' (可选: any / all / quorum:N / weighted)", value)
+}
+
+// String 检测策略的配置形式
+func (p CheckPolicy) String() string {
+	if p.Mode == CheckPolicyQuorum {
+		return fmt.Sprintf("%s:%d", CheckPolicyQuorum, p.Quorum)
+	}
+	return p.Mode
+}
+
+// weight 目标的权重
+func (p CheckPolicy) weight(target string) float64 {
+	if w, ok := p.Weights[target]; ok {
+		return w
+	}
+	return 1
+}
+
+// GetCheckPolicy 获取多目标检测策略（优先使用局部配置，配置已通过校验）
+func (m *MonitorConfig) GetCheckPolicy(globalPolicy string) CheckPolicy {
+	value := m.CheckPolicy
+	if value == "" {
+		value = globalPolicy
+	}
+	policy, err := ParseCheckPolicy(value)
+	if err != nil {
+		policy = CheckPolicy{Mode: CheckPolicyAny}
+	}
+	policy.Weights = m.TargetWeights
+	return policy
+}
+
+// validateCheckPolicy 校验监控任务的检测策略和目标权重
+func validateCheckPolicy(prefix string, monitor *MonitorConfig, globalPolicy string, targets []string) []string {
+	errors := make([]string, 0)
+
+	value := monitor.CheckPolicy
+	if value == "" {
+		value = globalPolicy
+	}
+	policy, err := ParseCheckPolicy(value)
+	if err != nil {
+		return append(errors, fmt.Sprintf("%s.check_policy: %v", prefix, err))
+	}
+	if policy.Mode == CheckPolicyQuorum && policy.Quorum > len(targets) {
+		errors = append(errors, fmt.Sprintf("%s.check_policy: quorum %d 超过检测目标数量 %d", prefix, policy.Quorum, len(targets)))
+	}
+
+	for target, weight := range monitor.TargetWeights {
+		found := false
+		for _, t := range targets {
+			if t == target {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errors = append(errors, fmt.Sprintf("%s.target_weights: %s 不是检测目标", prefix, target))
+		}
+		if weight <= 0 {
+			errors = append(errors, fmt.Sprintf("%s.target_weights: %s 的权重必须大于 0", prefix, target))
+		}
+	}
+	return errors
+}
+
+// aggregateResults 按检测策略汇总各目标的结果
+func aggregateResults(iface string, results []*CheckResult, policy CheckPolicy) *CheckResult {
+	aggregate := &CheckResult{
+		Interface:  iface,
+		Success:    false,
+		Latency:    0,
+		PacketLoss: 100.0,
+		Targets:    make([]TargetResult, 0, len(results)),
+	}
+
+	reachable := make([]*CheckResult, 0, len(results))
+	for _, result := range results {
+		aggregate.Targets = append(aggregate.Targets, TargetResult{
+			Target:     result.TargetIP,
+			Success:    result.Success,
+			Latency:    result.Latency,
+			PacketLoss: result.PacketLoss,
+			Jitter:     result.Jitter,
+		})
+		if result.Success {
+			reachable = append(reachable, result)
+		}
+	}
+	if len(reachable) == 0 {
+		return aggregate
+	}
+
+	// 按丢包、延迟从好到差排序
+	sort.SliceStable(reachable, func(i, j int) bool {
+		if reachable[i].PacketLoss != reachable[j].PacketLoss {
+			return reachable[i].PacketLoss < reachable[j].PacketLoss
+		}
+		return reachable[i].Latency < reachable[j].Latency
+	})
+
+	if policy.Mode == CheckPolicyWeighted {
+		var totalWeight, reachableWeight, loss, latency, jitter float64
+		for _, result := range results {
+			w := policy.weight(result.TargetIP)
+			totalWeight += w
+			loss += w * result.PacketLoss
+			if result.Success {
+				reachableWeight += w
+				latency += w * result.Latency
+				jitter += w * result.Jitter
+			}
+		}
+		aggregate.TargetIP = reachable[0].TargetIP
+		aggregate.PacketLoss = loss / totalWeight
+		aggregate.Latency = latency / reachableWeight
+		aggregate.Jitter = jitter / reachableWeight
+		aggregate.Success = aggregate.PacketLoss < 100.0
+		return aggregate
+	}
+
+	need := 1
+	switch policy.Mode {
+	case CheckPolicyAll:
+		need = len(results)
+	case CheckPolicyQuorum:
+		need = policy.Quorum
+		if need > len(results) {
+			need = len(results)
+		}
+	}
+	if len(reachable) < need {
+		return aggregate
+	}
+
+	pick := reachable[need-1]
+	aggregate.TargetIP = pick.TargetIP
+	aggregate.Success = true
+	aggregate.Latency = pick.Latency
+	aggregate.PacketLoss = pick.PacketLoss
+	aggregate.Jitter = pick.Jitter
+	return aggregate
+}
+
+// reachableCount 可达目标数量
+func reachableCount(targets []TargetResult) int {
+	count := 0
+	for _, target := range targets {
+		if target.Success {
+			count++
+		}
+	}
+	return count
+}
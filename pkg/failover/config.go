@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
+	"trueword_node/pkg/scoring"
 )
 
 const (
@@ -15,8 +18,9 @@ const (
 
 // FailoverConfig 守护进程配置
 type FailoverConfig struct {
-	Daemon   DaemonConfig     `yaml:"daemon"`
-	Monitors []MonitorConfig  `yaml:"monitors"`
+	Daemon    DaemonConfig     `yaml:"daemon"`
+	Monitors  []MonitorConfig  `yaml:"monitors"`
+	Schedules []ScheduleConfig `yaml:"schedules,omitempty"`
 }
 
 // DaemonConfig 全局配置
@@ -29,6 +33,15 @@ type DaemonConfig struct {
 	CheckMode                 string  `yaml:"check_mode"`                 // 全局默认检测模式：ping / dns
 	DNSQueryDomain            string  `yaml:"dns_query_domain"`           // DNS 查询的默认域名
 	LogFile                   string  `yaml:"log_file"`
+	DriftCheckIntervalSec     int     `yaml:"drift_check_interval_sec"`   // 漂移检测间隔（秒），0 表示禁用
+	DriftAutoFix              bool    `yaml:"drift_auto_fix"`             // 检测到漂移时自动修复
+	StatsIntervalSec          int     `yaml:"stats_interval_sec"`         // 流量统计采集间隔（秒），0 表示禁用
+	Scoring                   *scoring.Overrides `yaml:"scoring,omitempty"`  // 评分参数（可选，默认与原评分表一致）
+	Dampening                 *DampeningConfig `yaml:"dampening,omitempty"` // 出口振荡抑制（可选，未配置时禁用）
+	Adaptive                  *AdaptiveConfig `yaml:"adaptive,omitempty"`   // 自适应检测间隔（可选，未配置时使用固定间隔）
+	MaxSwitchesPerHour        int     `yaml:"max_switches_per_hour,omitempty"` // 每个监控任务每小时最多切换次数，0 表示不限制
+	MinDwellSec               int     `yaml:"min_dwell_sec,omitempty"`         // 切换后在新出口的最短停留时间（秒），0 表示不限制
+	CheckPolicy               string  `yaml:"check_policy,omitempty"`          // 多目标检测策略: any（默认）/ all / quorum:N / weighted
 }
 
 // MonitorConfig 监控任务配置
@@ -46,6 +59,34 @@ type MonitorConfig struct {
 	CheckMode               string   `yaml:"check_mode"`                 // 可选，覆盖全局检测模式：ping / dns
 	DNSServers              []string `yaml:"dns_servers"`                // dns 模式使用
 	DNSQueryDomain          string   `yaml:"dns_query_domain"`           // 可选，覆盖全局查询域名
+	Scoring                 *scoring.Overrides `yaml:"scoring,omitempty"` // 可选，覆盖全局评分参数（设置的字段）
+	SelectionPolicy         string   `yaml:"selection_policy,omitempty"` // 出口选择策略：score（默认）/ sla
+	SLA                     *SLAConfig `yaml:"sla,omitempty"`            // sla 策略的阈值和抢占配置
+	MaxSwitchesPerHour      int      `yaml:"max_switches_per_hour,omitempty"` // 可选，覆盖全局配置
+	MinDwellSec             int      `yaml:"min_dwell_sec,omitempty"`         // 可选，覆盖全局配置
+	CheckPolicy             string   `yaml:"check_policy,omitempty"`          // 可选，覆盖全局多目标检测策略
+	TargetWeights           map[string]float64 `yaml:"target_weights,omitempty"` // weighted 策略的目标权重（未配置的目标为 1）
+}
+
+// 定时任务动作
+const (
+	ScheduleEnableGroup   = "enable_group"   // 应用策略组
+	ScheduleDisableGroup  = "disable_group"  // 撤销策略组
+	ScheduleDefaultExit   = "default_exit"   // 切换默认路由出口
+	ScheduleSetCost       = "set_cost"       // 覆盖出口成本（value: 0-100 或 reset）
+	ScheduleSetCandidates = "set_candidates" // 覆盖监控任务的候选出口（value: 逗号分隔的出口或 reset）
+
+	// 恢复配置值
+	ScheduleValueReset = "reset"
+)
+
+// ScheduleConfig 定时任务配置
+type ScheduleConfig struct {
+	Name   string `yaml:"name"`
+	Cron   string `yaml:"cron"`            // 分 时 日 月 周（本地时区）
+	Action string `yaml:"action"`          // enable_group / disable_group / default_exit / set_cost / set_candidates
+	Target string `yaml:"target"`          // 策略组 / 出口 / 监控任务
+	Value  string `yaml:"value,omitempty"` // set_cost / set_candidates 的参数
 }
 
 // GetCheckInterval 获取检测间隔（优先使用局部配置）
@@ -113,6 +154,27 @@ func (m *MonitorConfig) GetDNSQueryDomain(globalDomain string) string {
 	return "google.com" // 默认值
 }
 
+// GetMaxSwitchesPerHour 获取每小时最多切换次数（0 表示不限制）
+func (m *MonitorConfig) GetMaxSwitchesPerHour(globalMax int) int {
+	if m.MaxSwitchesPerHour > 0 {
+		return m.MaxSwitchesPerHour
+	}
+	return globalMax
+}
+
+// GetMinDwellSec 获取切换后的最短停留时间（0 表示不限制）
+func (m *MonitorConfig) GetMinDwellSec(globalDwell int) int {
+	if m.MinDwellSec > 0 {
+		return m.MinDwellSec
+	}
+	return globalDwell
+}
+
+// GetScoring 获取评分参数（默认值 ← 全局配置 ← 监控任务配置）
+func (m *MonitorConfig) GetScoring(global *scoring.Overrides) scoring.Config {
+	return scoring.Merge(global, m.Scoring)
+}
+
 // Equals 比较两个MonitorConfig是否相等
 func (m *MonitorConfig) Equals(other *MonitorConfig) bool {
 	if m.Name != other.Name || m.Type != other.Type || m.Target != other.Target {
@@ -139,6 +201,18 @@ func (m *MonitorConfig) Equals(other *MonitorConfig) bool {
 	if m.SwitchConfirmationCount != other.SwitchConfirmationCount {
 		return false
 	}
+	if !reflect.DeepEqual(m.Scoring, other.Scoring) {
+		return false
+	}
+	if m.SelectionPolicy != other.SelectionPolicy || !reflect.DeepEqual(m.SLA, other.SLA) {
+		return false
+	}
+	if m.MaxSwitchesPerHour != other.MaxSwitchesPerHour || m.MinDwellSec != other.MinDwellSec {
+		return false
+	}
+	if m.CheckPolicy != other.CheckPolicy || !reflect.DeepEqual(m.TargetWeights, other.TargetWeights) {
+		return false
+	}
 	return true
 }
 
@@ -201,6 +275,23 @@ func (config *FailoverConfig) Validate() error {
 	} else if config.Daemon.SwitchConfirmationCount < 1 || config.Daemon.SwitchConfirmationCount > 10 {
 		errors = append(errors, "daemon.switch_confirmation_count 必须在 1-10 范围内")
 	}
+	if config.Daemon.DriftCheckIntervalSec != 0 {
+		if config.Daemon.DriftCheckIntervalSec < 10 || config.Daemon.DriftCheckIntervalSec > 86400 {
+			errors = append(errors, "daemon.drift_check_interval_sec 必须在 10-86400 范围内（0 表示禁用）")
+		}
+	}
+	errors = append(errors, config.Daemon.Scoring.Validate("daemon.scoring")...)
+	errors = append(errors, config.Daemon.Dampening.Validate("daemon.dampening")...)
+	errors = append(errors, config.Daemon.Adaptive.Validate("daemon.adaptive")...)
+	errors = append(errors, validateSwitchLimits("daemon", config.Daemon.MaxSwitchesPerHour, config.Daemon.MinDwellSec)...)
+	if _, err := ParseCheckPolicy(config.Daemon.CheckPolicy); err != nil {
+		errors = append(errors, fmt.Sprintf("daemon.check_policy: %v", err))
+	}
+	if config.Daemon.StatsIntervalSec != 0 {
+		if config.Daemon.StatsIntervalSec < 30 || config.Daemon.StatsIntervalSec > 3600 {
+			errors = append(errors, "daemon.stats_interval_sec 必须在 30-3600 范围内（0 表示禁用）")
+		}
+	}
 
 	// 验证每个monitor
 	monitorNames := make(map[string]bool)
@@ -245,13 +336,13 @@ func (config *FailoverConfig) Validate() error {
 				}
 			}
 		} else {
-			// ping 模式：必须有 check_targets（1-3个）
-			if len(monitor.CheckTargets) < 1 || len(monitor.CheckTargets) > 3 {
-				errors = append(errors, prefix+".check_targets 必须有 1-3 个IP（ping 模式）")
+			// ping 模式：必须有 check_targets（1-16个）
+			if len(monitor.CheckTargets) < 1 || len(monitor.CheckTargets) > MaxCheckTargets {
+				errors = append(errors, fmt.Sprintf("%s.check_targets 必须有 1-%d 个IP（ping 模式）", prefix, MaxCheckTargets))
 			}
 			for j, ip := range monitor.CheckTargets {
-				if net.ParseIP(ip) == nil {
-					errors = append(errors, fmt.Sprintf("%s.check_targets[%d] 不是有效的IP: %s", prefix, j, ip))
+				if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() == nil {
+					errors = append(errors, fmt.Sprintf("%s.check_targets[%d] 不是有效的IPv4地址: %s", prefix, j, ip))
 				}
 			}
 		}
@@ -288,6 +379,62 @@ func (config *FailoverConfig) Validate() error {
 				errors = append(errors, prefix+".switch_confirmation_count 必须在 1-10 范围内")
 			}
 		}
+		errors = append(errors, monitor.Scoring.Validate(prefix+".scoring", config.Daemon.Scoring)...)
+		errors = append(errors, validateSwitchLimits(prefix, monitor.MaxSwitchesPerHour, monitor.MinDwellSec)...)
+		if checkMode == "dns" {
+			errors = append(errors, validateCheckPolicy(prefix, &monitor, config.Daemon.CheckPolicy, monitor.DNSServers)...)
+		} else {
+			errors = append(errors, validateCheckPolicy(prefix, &monitor, config.Daemon.CheckPolicy, monitor.CheckTargets)...)
+		}
+
+		switch monitor.SelectionPolicy {
+		case "", SelectionScore:
+		case SelectionSLA:
+			errors = append(errors, monitor.SLA.Validate(prefix+".sla")...)
+		default:
+			errors = append(errors, fmt.Sprintf("%s.selection_policy 必须是 %s 或 %s", prefix, SelectionScore, SelectionSLA))
+		}
+	}
+
+	// 验证定时任务
+	scheduleNames := make(map[string]bool)
+	for i, schedule := range config.Schedules {
+		prefix := fmt.Sprintf("schedules[%d]", i)
+
+		if schedule.Name == "" {
+			errors = append(errors, prefix+".name 不能为空")
+		} else if scheduleNames[schedule.Name] {
+			errors = append(errors, fmt.Sprintf("%s.name: 定时任务名称 '%s' 重复", prefix, schedule.Name))
+		}
+		scheduleNames[schedule.Name] = true
+
+		if _, err := ParseCron(schedule.Cron); err != nil {
+			errors = append(errors, fmt.Sprintf("%s.cron: %v", prefix, err))
+		}
+		if schedule.Target == "" {
+			errors = append(errors, prefix+".target 不能为空")
+		}
+
+		switch schedule.Action {
+		case ScheduleEnableGroup, ScheduleDisableGroup, ScheduleDefaultExit:
+		case ScheduleSetCost:
+			if schedule.Value != ScheduleValueReset {
+				cost, err := strconv.Atoi(schedule.Value)
+				if err != nil || cost < 0 || cost > 100 {
+					errors = append(errors, prefix+".value 必须是 0-100 的成本值或 reset")
+				}
+			}
+		case ScheduleSetCandidates:
+			if config.GetMonitor(schedule.Target) == nil {
+				errors = append(errors, fmt.Sprintf("%s.target: 监控任务 '%s' 不存在", prefix, schedule.Target))
+			}
+			if schedule.Value != ScheduleValueReset && len(splitExits(schedule.Value)) == 0 {
+				errors = append(errors, prefix+".value 必须是逗号分隔的出口列表或 reset")
+			}
+		default:
+			errors = append(errors, fmt.Sprintf("%s.action 必须是 %s/%s/%s/%s/%s 之一", prefix,
+				ScheduleEnableGroup, ScheduleDisableGroup, ScheduleDefaultExit, ScheduleSetCost, ScheduleSetCandidates))
+		}
 	}
 
 	if len(errors) > 0 {
@@ -316,6 +463,19 @@ func (config *FailoverConfig) GetMonitor(name string) *MonitorConfig {
 	return nil
 }
 
+// ScoringForTarget 获取管理指定目标的监控任务的评分参数（target 为策略组名或 "default"）
+// 没有对应的监控任务时使用全局评分参数
+func (config *FailoverConfig) ScoringForTarget(target string) scoring.Config {
+	for i := range config.Monitors {
+		monitor := &config.Monitors[i]
+		if (target == "default" && monitor.Type == "default_route") ||
+			(monitor.Type == "policy_group" && monitor.Target == target) {
+			return monitor.GetScoring(config.Daemon.Scoring)
+		}
+	}
+	return scoring.Merge(config.Daemon.Scoring, nil)
+}
+
 // AddMonitor 添加监控任务
 func (config *FailoverConfig) AddMonitor(monitor MonitorConfig) error {
 	// 检查名称是否重复
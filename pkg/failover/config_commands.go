@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/olekukonko/tablewriter"
+	"trueword_node/pkg/scoring"
 )
 
 // InitConfig 初始化配置文件
@@ -65,6 +66,7 @@ daemon:
   # 检测间隔（毫秒）
   # 范围: 100-60000
   # 推荐: 500（毫秒级响应），1000（稳定优先）
+  # 说明: 多个监控任务检测同一出口且检测模式、目标和检测策略相同时共享一次检测，间隔取其中最短的
   check_interval_ms: 500
 
   # 评分差值阈值（避免频繁切换）
@@ -87,6 +89,15 @@ daemon:
   # 说明: 每个 monitor 可覆盖此设置
   # check_mode: ping
 
+  # 多目标检测策略（全局默认）
+  # 并行检测所有 check_targets / dns_servers，按策略汇总:
+  #   any       至少 1 个目标可达即可用，指标取最好的目标（默认）
+  #   all       所有目标可达才可用，指标取最差的目标
+  #   quorum:N  至少 N 个目标可达才可用，指标取第 N 好的目标
+  #   weighted  按 monitor 的 target_weights 加权平均（不可达目标丢包计 100%）
+  # 说明: 每个 monitor 可覆盖此设置
+  # check_policy: any
+
   # DNS 查询域名（DNS 模式使用）
   # 默认: google.com
   # 说明: 每个 monitor 可覆盖此设置
@@ -96,6 +107,65 @@ daemon:
   # log_file: /var/log/twnode-failover.log
   log_file: ""
 
+  # 漂移检测间隔（秒）
+  # 范围: 10-86400，0 表示禁用
+  # 说明: 定期比较配置与内核状态（隧道、VIP路由、保护规则、策略组、默认路由），
+  #       外部工具或重启网络服务删除的规则会被发现，等同于 'twnode drift'
+  # drift_check_interval_sec: 300
+
+  # 检测到漂移时自动修复（等同于 'twnode drift --fix'）
+  # 修复时保留故障转移后的当前出口
+  # drift_auto_fix: false
+
+  # 流量统计采集间隔（秒）
+  # 范围: 30-3600，0 表示禁用
+  # 说明: 定期采集出口和策略组的流量计数，按小时/天/月汇总，等同于 'twnode stats collect'
+  #       使用 'twnode stats' 查看
+  # stats_interval_sec: 300
+
+  # 评分参数（可选，省略的字段使用默认值，默认与原评分表一致）
+  # 基础评分 = 丢包得分 + 延迟得分 + 抖动得分，最终评分 = 基础评分 - 成本 × cost_factor - 连续失败惩罚
+  # 每个 monitor 可通过 scoring 覆盖（设置的字段生效，显式的 0 也生效，如 cost_factor: 0 表示不计成本）
+  # 使用 'twnode policy score --explain' 查看各项得分
+  # scoring:
+  #   loss_weight: 60            # 丢包满分
+  #   latency_weight: 40         # 延迟满分（三项权重之和不超过 100）
+  #   jitter_weight: 0           # 抖动满分（0 表示不参与评分）
+  #   curve: step                # step: 阶梯评分表 / linear: 在阈值之间线性插值
+  #   latency_good_ms: 50        # linear: 延迟满分阈值
+  #   latency_bad_ms: 300        # linear: 延迟 0 分阈值
+  #   loss_bad_percent: 20       # linear: 丢包 0 分阈值
+  #   jitter_good_ms: 5          # 抖动满分阈值
+  #   jitter_bad_ms: 50          # 抖动 0 分阈值
+  #   cost_factor: 0.5           # 每点成本的扣分
+  #   failure_penalty: 0         # 每次连续失败的扣分（恢复后逐次递减）
+  #   max_failure_penalty: 30    # 失败惩罚上限
+
+  # 出口振荡抑制（可选，类似 BGP route flap dampening，省略的字段使用默认值）
+  # 出口每次由可用变为不可用增加 flap_penalty，惩罚按半衰期衰减；
+  # 超过 suppress_threshold 时该出口不作为切换目标（除非没有其他可用出口），低于 reuse_threshold 时解除
+  # dampening:
+  #   half_life_sec: 900
+  #   flap_penalty: 1000
+  #   suppress_threshold: 2000
+  #   reuse_threshold: 750
+  #   max_suppress_sec: 3600
+
+  # 自适应检测间隔（可选，未配置时按 check_interval_ms 固定间隔检测，省略的字段使用默认值）
+  # 当前出口丢包或延迟劣化时加速检测其候选出口，所有出口健康时放松间隔，
+  # 完全不可用的非当前出口从 check_interval_ms 起按 2 倍退避
+  # adaptive:
+  #   fast_interval_ms: 500        # 当前出口劣化时的检测间隔（最小400）
+  #   relaxed_interval_ms: 3000    # 所有出口健康时的检测间隔
+  #   down_max_interval_ms: 30000  # 不可用出口退避的最长间隔
+  #   degraded_loss_percent: 5     # 丢包率达到此值视为劣化（%）
+  #   degraded_latency_ms: 0       # 平均延迟达到此值视为劣化（ms，0 表示不检查）
+
+  # 切换限速（每个 monitor 可覆盖，0 表示不限制）
+  # 当前出口完全不可用时不受限制
+  # max_switches_per_hour: 6     # 每个监控任务每小时最多切换次数
+  # min_dwell_sec: 300           # 切换后在新出口的最短停留时间
+
 # ============================================
 # 监控任务列表
 # ============================================
@@ -125,7 +195,7 @@ monitors: []
 #   type: "default_route"
 #   target: "default"
 #   check_mode: "dns"              # 使用 DNS 查询检测
-#   dns_servers:                   # DNS 服务器列表（按顺序尝试）
+#   dns_servers:                   # DNS 服务器列表（并行检测，按 check_policy 汇总）
 #     - "1.1.1.1"
 #     - "8.8.8.8"
 #   dns_query_domain: "google.com" # 可选，覆盖全局查询域名
@@ -143,11 +213,65 @@ monitors: []
 #   check_targets:
 #     - "8.8.8.8"
 #     - "1.1.1.1"                  # Cloudflare DNS
+#   check_policy: "weighted"       # 加权平均（可选）
+#   target_weights:
+#     "8.8.8.8": 2
+#     "1.1.1.1": 1
 #
 #   candidate_exits:
 #     - "tun_hk"
 #     - "tun_us"
 #     - "tun_sg"
+#
+# 示例 4: SLA 策略（按优先级停留在满足 SLA 的出口，而不是评分最高的出口）
+# - name: "monitor-office"
+#   type: "policy_group"
+#   target: "office_routes"
+#   check_targets:                 # 并行检测所有目标
+#     - "223.5.5.5"
+#     - "119.29.29.29"
+#     - "10.0.0.1"                 # 总部网关
+#   check_policy: "quorum:2"       # 至少 2 个目标可达（可选，默认 any）
+#   candidate_exits:               # 顺序即优先级
+#     - "tun_mpls"
+#     - "tun_backup"
+#     - "eth_lte"
+#   selection_policy: "sla"        # score（默认）/ sla
+#   sla:
+#     max_loss_percent: 2          # 丢包 < 2%
+#     max_latency_ms: 150          # 延迟 < 150ms
+#     # max_jitter_ms: 30          # 抖动 < 30ms（可选）
+#     preempt_hold_sec: 300        # 更高优先级出口持续满足 SLA 5 分钟后切回（默认60）
+#   switch_confirmation_count: 3   # 连续 3 次违反 SLA 才切换
+
+# ============================================
+# 定时任务（可选）
+# ============================================
+# cron: 分 时 日 月 周（本地时区），支持 * , - / 和 @hourly/@daily/@weekly/@monthly
+# action:
+#   enable_group    应用策略组（target: 策略组）
+#   disable_group   撤销策略组（target: 策略组）
+#   default_exit    切换默认路由出口（target: 出口）
+#   set_cost        覆盖出口成本（target: 出口, value: 0-100 或 reset）
+#   set_candidates  覆盖监控任务的候选出口（target: 监控任务, value: 逗号分隔的出口或 reset）
+# 使用 'twnode policy failover status' 查看下次执行时间
+# 删除定时任务后，其设置的覆盖在守护进程重载时清除；'twnode policy failover schedule clear' 立即清除所有覆盖
+#
+# schedules:
+#   - name: "night-cheap-transit"
+#     cron: "0 23 * * *"
+#     action: "set_cost"
+#     target: "tun_transit"
+#     value: "0"
+#   - name: "day-transit-cost"
+#     cron: "0 7 * * *"
+#     action: "set_cost"
+#     target: "tun_transit"
+#     value: "reset"
+#   - name: "office-hours"
+#     cron: "0 9 * * 1-5"
+#     action: "enable_group"
+#     target: "office_routes"
 `
 }
 
@@ -184,6 +308,15 @@ func ShowConfig() error {
 	fmt.Println()
 	fmt.Printf("检测间隔: %dms\n", config.Daemon.CheckIntervalMs)
 	fmt.Printf("评分阈值: %.1f\n", config.Daemon.ScoreThreshold)
+	fmt.Printf("评分参数: %s\n", scoring.Merge(config.Daemon.Scoring).Summary())
+	fmt.Printf("振荡抑制: %s\n", config.Daemon.Dampening.Summary())
+	fmt.Printf("自适应间隔: %s\n", config.Daemon.Adaptive.Summary())
+	if config.Daemon.MaxSwitchesPerHour > 0 || config.Daemon.MinDwellSec > 0 {
+		fmt.Printf("切换限速: 每小时最多 %d 次, 最短停留 %d 秒 (0 表示不限制)\n",
+			config.Daemon.MaxSwitchesPerHour, config.Daemon.MinDwellSec)
+	} else {
+		fmt.Println("切换限速: 未启用")
+	}
 
 	// 显示切换确认次数（获取实际值，包括默认值）
 	confirmationCount := config.Daemon.SwitchConfirmationCount
@@ -204,8 +337,20 @@ func ShowConfig() error {
 	} else {
 		fmt.Println("日志文件: 未配置（不保存日志）")
 	}
+	if config.Daemon.DriftCheckIntervalSec > 0 {
+		fmt.Printf("漂移检测: 每 %d 秒 (自动修复: %s)\n", config.Daemon.DriftCheckIntervalSec,
+			map[bool]string{true: "是", false: "否"}[config.Daemon.DriftAutoFix])
+	} else {
+		fmt.Println("漂移检测: 未启用")
+	}
+	if config.Daemon.StatsIntervalSec > 0 {
+		fmt.Printf("流量统计: 每 %d 秒采集\n", config.Daemon.StatsIntervalSec)
+	} else {
+		fmt.Println("流量统计: 未启用")
+	}
 	fmt.Printf("配置文件: %s\n", DefaultConfigFile)
 	fmt.Printf("监控任务数: %d\n", len(config.Monitors))
+	fmt.Printf("定时任务数: %d\n", len(config.Schedules))
 
 	return nil
 }
@@ -267,7 +412,7 @@ func ListMonitors() error {
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.Header("任务名称", "类型", "目标", "候选出口", "检测间隔")
+	table.Header("任务名称", "类型", "目标", "候选出口", "检测间隔", "选择策略")
 
 	for _, monitor := range config.Monitors {
 		monitorType := map[string]string{
@@ -284,6 +429,7 @@ func ListMonitors() error {
 			monitor.Target,
 			exits,
 			interval,
+			monitor.GetSelectionPolicy(),
 		)
 	}
 
@@ -344,7 +490,7 @@ func AddMonitorInteractive() error {
 	if modeChoice == "1" {
 		// Ping 模式
 		checkMode = "ping"
-		fmt.Print("输入检测目标IP（最多3个，逗号分隔）: ")
+		fmt.Print("输入检测目标IP（最多16个，逗号分隔）: ")
 		targetsStr, _ := reader.ReadString('\n')
 		targetsStr = strings.TrimSpace(targetsStr)
 		checkTargets = strings.Split(targetsStr, ",")
@@ -546,6 +692,12 @@ func ShowMonitor(name string) error {
 		fmt.Printf(" (全局配置)\n")
 	}
 
+	fmt.Printf("  多目标策略: %s", monitor.GetCheckPolicy(config.Daemon.CheckPolicy))
+	if monitor.CheckPolicy != "" {
+		fmt.Printf(" (自定义)\n")
+	} else {
+		fmt.Printf(" (全局配置)\n")
+	}
 	fmt.Printf("  检测间隔: %dms", monitor.GetCheckInterval(config.Daemon.CheckIntervalMs))
 	if monitor.CheckIntervalMs > 0 {
 		fmt.Printf(" (自定义)\n")
@@ -617,9 +769,28 @@ func ShowMonitor(name string) error {
 	}
 	fmt.Println()
 
+	fmt.Println("【出口选择】")
+	if monitor.GetSelectionPolicy() == SelectionSLA {
+		fmt.Println("  策略: SLA（按优先级选择满足 SLA 的出口）")
+		fmt.Printf("  SLA: %s\n", monitor.SLA.Summary())
+	} else {
+		fmt.Println("  策略: 评分（选择评分最高的出口）")
+		fmt.Printf("  评分参数: %s\n", monitor.GetScoring(config.Daemon.Scoring).Summary())
+	}
+	maxSwitches := monitor.GetMaxSwitchesPerHour(config.Daemon.MaxSwitchesPerHour)
+	minDwell := monitor.GetMinDwellSec(config.Daemon.MinDwellSec)
+	if maxSwitches > 0 || minDwell > 0 {
+		fmt.Printf("  切换限速: 每小时最多 %d 次, 最短停留 %d 秒 (0 表示不限制)\n", maxSwitches, minDwell)
+	}
+	fmt.Println()
+
 	fmt.Println("【候选出口】")
-	for _, exit := range monitor.CandidateExits {
-		fmt.Printf("  - %s\n", exit)
+	for i, exit := range monitor.CandidateExits {
+		if monitor.GetSelectionPolicy() == SelectionSLA {
+			fmt.Printf("  %d. %s\n", i+1, exit)
+		} else {
+			fmt.Printf("  - %s\n", exit)
+		}
 	}
 
 	return nil
@@ -693,12 +864,23 @@ func ShowStatus() error {
 					if exitState.PacketLoss >= 100.0 {
 						statusStr = "DOWN"
 					}
+					// SLA 策略显示是否满足 SLA
+					if monitor.GetSelectionPolicy() == SelectionSLA && monitor.SLA != nil {
+						if met, reason := monitor.SLA.Check(exitState); met {
+							statusStr += ", SLA ✓"
+						} else {
+							statusStr += ", SLA ✗ " + reason
+						}
+					}
+					// 按监控任务的评分参数计算
+					score := monitor.GetScoring(config.Daemon.Scoring).Score(exitState.Sample()).Final
 					fmt.Printf("  %s: %s (%s) [延迟: %.1fms, 丢包: %.0f%%, 评分: %.1f]\n",
 						monitor.Name, currentExit, statusStr,
-						exitState.Latency, exitState.PacketLoss, exitState.FinalScore)
+						exitState.Latency, exitState.PacketLoss, score)
 				} else {
 					fmt.Printf("  %s: %s (检测中...)\n", monitor.Name, currentExit)
 				}
+				printSwitchState(state.SwitchStates[monitor.Name], &monitor, config)
 			} else {
 				fmt.Printf("  %s: (未初始化)\n", monitor.Name)
 			}
@@ -706,6 +888,14 @@ func ShowStatus() error {
 		fmt.Println()
 	}
 
+	// 共享检测任务
+	printProbes(state.Probes)
+
+	// 定时任务
+	if config != nil {
+		printSchedules(config)
+	}
+
 	// 最近事件
 	if len(state.RecentEvents) > 0 {
 		fmt.Println("【最近事件】")
@@ -738,12 +928,59 @@ func ShowStatus() error {
 				statusStr = "UP"
 			}
 
-			// 显示详细信息：延迟、丢包、评分
-			fmt.Printf("  %s: %s [延迟: %.1fms, 丢包: %.0f%%, Cost: %d, 评分: %.1f]\n",
-				name, statusStr, ifaceState.Latency, ifaceState.PacketLoss,
+			if ifaceState.QuotaExhausted {
+				statusStr += " (配额耗尽)"
+			}
+
+			if ifaceState.Failures > 0 {
+				statusStr += fmt.Sprintf(" (连续失败 %d)", ifaceState.Failures)
+			}
+			if ifaceState.Suppressed {
+				statusStr += fmt.Sprintf(" (振荡抑制, 惩罚 %.0f)", ifaceState.FlapPenalty)
+			} else if ifaceState.FlapPenalty > 0 {
+				statusStr += fmt.Sprintf(" (振荡惩罚 %.0f)", ifaceState.FlapPenalty)
+			}
+
+			// 显示详细信息：延迟、丢包、抖动、评分（全局评分参数）
+			fmt.Printf("  %s: %s [延迟: %.1fms, 丢包: %.0f%%, 抖动: %.1fms, Cost: %d, 全局评分: %.1f]\n",
+				name, statusStr, ifaceState.Latency, ifaceState.PacketLoss, ifaceState.Jitter,
 				ifaceState.Cost, ifaceState.FinalScore)
+
+			// 设置了评分参数的监控任务按自身参数评分（与守护进程的选择一致）
+			if config != nil {
+				for i := range config.Monitors {
+					monitor := &config.Monitors[i]
+					if monitor.Scoring == nil || !containsExit(monitor.CandidateExits, name) {
+						continue
+					}
+					fmt.Printf("    %s 评分: %.1f\n", monitor.Name,
+						monitor.GetScoring(config.Daemon.Scoring).Score(ifaceState.Sample()).Final)
+				}
+			}
+
+			// 多目标检测：显示各目标的结果
+			if len(ifaceState.Targets) > 1 {
+				for _, target := range ifaceState.Targets {
+					if target.Success {
+						fmt.Printf("    ✓ %s [延迟: %.1fms, 丢包: %.0f%%, 抖动: %.1fms]\n",
+							target.Target, target.Latency, target.PacketLoss, target.Jitter)
+					} else {
+						fmt.Printf("    ✗ %s 不可达\n", target.Target)
+					}
+				}
+			}
 		}
 	}
 
 	return nil
 }
+
+// containsExit 出口列表中是否包含指定出口
+func containsExit(exits []string, exit string) bool {
+	for _, e := range exits {
+		if e == exit {
+			return true
+		}
+	}
+	return false
+}
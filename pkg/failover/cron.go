@@ -0,0 +1,158 @@
+package failover
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSpec 解析后的定时表达式（分 时 日 月 周，本地时区）
+type CronSpec struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	anyDay   bool // 日字段为 *
+	anyWeek  bool // 周字段为 *
+}
+
+// cronAliases 常用表达式的简写
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseCron 解析定时表达式
+// 格式: 分 时 日 月 周，每个字段支持 * / 数字 / 范围 a-b / 列表 a,b / 步长 */n 或 a-b/n
+// 周: 0-7（0 和 7 均为周日）；也支持 @hourly / @daily / @weekly / @monthly
+func ParseCron(expr string) (*CronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("定时表达式 '%s' 必须有 5 个字段: 分 时 日 月 周", expr)
+	}
+
+	spec := &CronSpec{anyDay: fields[2] == "*", anyWeek: fields[4] == "*"}
+	bounds := []struct {
+		name     string
+		min, max int
+		target   *map[int]bool
+	}{
+		{"分", 0, 59, &spec.minutes},
+		{"时", 0, 23, &spec.hours},
+		{"日", 1, 31, &spec.days},
+		{"月", 1, 12, &spec.months},
+		{"周", 0, 7, &spec.weekdays},
+	}
+	for i, b := range bounds {
+		values, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("定时表达式 '%s' 的%s字段: %w", expr, b.name, err)
+		}
+		*b.target = values
+	}
+	if spec.weekdays[7] {
+		spec.weekdays[0] = true
+	}
+
+	return spec, nil
+}
+
+// parseCronField 解析单个字段
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("无效的步长: %s", part)
+			}
+			step = n
+			part = part[:idx]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, errA := strconv.Atoi(bounds[0])
+			b, errB := strconv.Atoi(bounds[1])
+			if errA != nil || errB != nil || a > b {
+				return nil, fmt.Errorf("无效的范围: %s", part)
+			}
+			low, high = a, b
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("无效的值: %s", part)
+			}
+			low, high = n, n
+			if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max {
+			return nil, fmt.Errorf("%s 超出范围 %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// matchDay 日期是否匹配（日和周都有限制时满足其一即可，与 cron 一致）
+func (c *CronSpec) matchDay(t time.Time) bool {
+	dayMatch := c.days[t.Day()]
+	weekMatch := c.weekdays[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeek:
+		return true
+	case c.anyDay:
+		return weekMatch
+	case c.anyWeek:
+		return dayMatch
+	}
+	return dayMatch || weekMatch
+}
+
+// Next 返回 after 之后（不含）的下一次执行时间，5 年内没有匹配时返回零值
+func (c *CronSpec) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !c.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -5,12 +5,13 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"reflect"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"trueword_node/pkg/network"
+	"trueword_node/pkg/config"
 	"trueword_node/pkg/routing"
 )
 
@@ -23,9 +24,23 @@ type FailoverDaemon struct {
 	stateManager         *StateManager
 	failoverMutex        sync.Mutex
 	stopChan             chan struct{}
-	tickers              map[string]*time.Ticker
-	currentExits         map[string]string // monitor_name -> current_exit
-	confirmationCounters map[string]int    // monitor_name -> 当前确认次数
+	monitors             map[string]*monitorLoop         // monitor_name -> 评估循环
+	probes               map[string]*probeTask           // 检测键 -> 共享检测任务
+	probeMutex           sync.Mutex                      // 保护 monitors 和 probes（定时任务修改候选出口时重建检测任务）
+	currentExits         map[string]string               // monitor_name -> current_exit
+	exitsMutex           sync.RWMutex                    // 保护 currentExits（监控任务、共享检测任务和漂移检测并发访问）
+	confirmationCounters map[string]int                  // monitor_name -> 当前确认次数
+	driftTicker          *time.Ticker                    // 漂移检测定时器（未启用时为 nil）
+	driftStop            chan struct{}                   // 关闭时漂移检测循环退出
+	statsTicker          *time.Ticker                    // 流量统计采集定时器（未启用时为 nil）
+	statsStop            chan struct{}                   // 关闭时流量统计采集循环退出
+	scheduleTicker       *time.Ticker                    // 定时任务检查定时器（未配置时为 nil）
+	scheduleStop         chan struct{}                   // 关闭时定时任务循环退出
+	scheduleState        *ScheduleState                  // 定时任务执行记录和候选出口覆盖
+	overrideMutex        sync.RWMutex                    // 保护 scheduleState
+	exitDownActive       map[string]bool                 // monitor_name -> 是否已执行出口故障处理（阻断/备用出口）
+	slaCompliantSince    map[string]map[string]time.Time // monitor_name -> 出口 -> 持续满足 SLA 的起始时间
+	slaMutex             sync.Mutex                      // 保护 slaCompliantSince（各监控任务的评估循环并发访问）
 }
 
 // NewFailoverDaemon 创建守护进程
@@ -60,9 +75,13 @@ func NewFailoverDaemon(configFile string, debugMode bool) (*FailoverDaemon, erro
 		healthChecker:        healthChecker,
 		stateManager:         stateManager,
 		stopChan:             make(chan struct{}),
-		tickers:              make(map[string]*time.Ticker),
+		monitors:             make(map[string]*monitorLoop),
+		probes:               make(map[string]*probeTask),
 		currentExits:         make(map[string]string),
 		confirmationCounters: make(map[string]int),
+		exitDownActive:       make(map[string]bool),
+		slaCompliantSince:    make(map[string]map[string]time.Time),
+		scheduleState:        LoadScheduleState(),
 	}
 
 	return daemon, nil
@@ -74,6 +93,9 @@ func (d *FailoverDaemon) Run() error {
 	d.logger.Info("配置文件: %s", d.configFile)
 	d.logger.Info("监控任务: %d 个", len(d.config.Monitors))
 
+	// 清除失效的定时任务覆盖（定时任务可能在守护进程停止期间被删除）
+	d.pruneScheduleOverrides()
+
 	// 初始化当前出口
 	for i := range d.config.Monitors {
 		monitor := &d.config.Monitors[i]
@@ -81,16 +103,26 @@ func (d *FailoverDaemon) Run() error {
 		if err != nil {
 			d.logger.Warn("获取监控任务 %s 当前出口失败: %v", monitor.Name, err)
 		} else {
-			d.currentExits[monitor.Name] = currentExit
+			d.setCachedExit(monitor.Name, currentExit)
 			d.logger.Info("监控任务 %s 当前出口: %s", monitor.Name, currentExit)
 		}
 	}
 
-	// 为每个monitor启动独立的检测循环
+	// 为每个monitor启动独立的评估循环，检测由共享检测任务执行
 	for i := range d.config.Monitors {
 		monitor := &d.config.Monitors[i]
 		d.startMonitor(monitor)
 	}
+	d.startProbes()
+
+	// 启动漂移检测
+	d.startDriftReconcile()
+
+	// 启动流量统计采集
+	d.startStatsCollector()
+
+	// 启动定时任务
+	d.startScheduler()
 
 	// 注册信号处理
 	sigChan := make(chan os.Signal, 1)
@@ -124,27 +156,74 @@ func (d *FailoverDaemon) Run() error {
 	}
 }
 
-// startMonitor 启动监控任务
+// startMonitor 启动监控任务的评估循环（订阅关系在 startProbes 中建立）
+// 所有候选出口都有新的检测结果后评估一次
 func (d *FailoverDaemon) startMonitor(monitor *MonitorConfig) {
-	interval := monitor.GetCheckInterval(d.config.Daemon.CheckIntervalMs)
-	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
-	d.tickers[monitor.Name] = ticker
-
-	d.logger.Info("启动监控任务: %s (间隔: %dms)", monitor.Name, interval)
-
-	go func(m *MonitorConfig, t *time.Ticker) {
-		for range t.C {
-			d.checkMonitor(m)
+	loop := &monitorLoop{
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	d.probeMutex.Lock()
+	d.monitors[monitor.Name] = loop
+	d.probeMutex.Unlock()
+
+	d.logger.Info("启动监控任务: %s (间隔: %dms)", monitor.Name, monitor.GetCheckInterval(d.config.Daemon.CheckIntervalMs))
+
+	go func(m *MonitorConfig) {
+		var lastCheck time.Time
+		for {
+			select {
+			case <-loop.notify:
+				if !d.stateManager.CheckedSince(d.candidateExits(m), lastCheck) {
+					continue
+				}
+				lastCheck = time.Now()
+				d.checkMonitor(m)
+			case <-loop.stop:
+				return
+			}
 		}
-	}(monitor, ticker)
+	}(monitor)
 }
 
-// stopMonitor 停止监控任务
+// stopMonitor 停止监控任务的评估循环
 func (d *FailoverDaemon) stopMonitor(name string) {
-	if ticker, exists := d.tickers[name]; exists {
-		ticker.Stop()
-		delete(d.tickers, name)
+	d.probeMutex.Lock()
+	defer d.probeMutex.Unlock()
+
+	if loop, exists := d.monitors[name]; exists {
+		close(loop.stop)
+		delete(d.monitors, name)
+	}
+}
+
+// cachedExit 缓存的监控任务当前出口
+func (d *FailoverDaemon) cachedExit(name string) (string, bool) {
+	d.exitsMutex.RLock()
+	defer d.exitsMutex.RUnlock()
+
+	exit, exists := d.currentExits[name]
+	return exit, exists
+}
+
+// setCachedExit 更新缓存的监控任务当前出口
+func (d *FailoverDaemon) setCachedExit(name, exit string) {
+	d.exitsMutex.Lock()
+	defer d.exitsMutex.Unlock()
+
+	d.currentExits[name] = exit
+}
+
+// currentExitsSnapshot 当前出口缓存的副本
+func (d *FailoverDaemon) currentExitsSnapshot() map[string]string {
+	d.exitsMutex.RLock()
+	defer d.exitsMutex.RUnlock()
+
+	snapshot := make(map[string]string, len(d.currentExits))
+	for name, exit := range d.currentExits {
+		snapshot[name] = exit
 	}
+	return snapshot
 }
 
 // getCurrentExit 获取当前出口
@@ -155,8 +234,8 @@ func (d *FailoverDaemon) getCurrentExit(monitor *MonitorConfig) (string, error)
 		if err != nil {
 			d.logger.Warn("无法从系统读取默认路由: %v，使用候选列表第一个作为初始值", err)
 			// 降级：使用候选列表第一个
-			if len(monitor.CandidateExits) > 0 {
-				return monitor.CandidateExits[0], nil
+			if len(d.candidateExits(monitor)) > 0 {
+				return d.candidateExits(monitor)[0], nil
 			}
 			return "", fmt.Errorf("候选出口列表为空")
 		}
@@ -178,18 +257,19 @@ func (d *FailoverDaemon) getCurrentExit(monitor *MonitorConfig) (string, error)
 
 // getActualDefaultRoute 从系统读取实际的默认路由出口
 func (d *FailoverDaemon) getActualDefaultRoute(monitor *MonitorConfig) (string, error) {
-	// 读取优先级 900 的路由规则
-	cmd := exec.Command("sh", "-c", "ip rule show pref 900 | head -1")
+	// 读取默认路由规则（default_pref，默认 900）
+	ns := config.RoutingNamespace()
+	cmd := exec.Command("sh", "-c", fmt.Sprintf("ip rule show pref %d | head -1", ns.DefaultPref))
 	output, err := cmd.Output()
 	if err != nil || len(output) == 0 {
-		return "", fmt.Errorf("未找到默认路由规则（pref 900）")
+		return "", fmt.Errorf("未找到默认路由规则（pref %d）", ns.DefaultPref)
 	}
 
-	// 解析规则，提取路由表编号（应该是 900）
+	// 默认路由表（默认 900）
 	// 格式：900:	from all lookup 900
-	tableID := 900
+	tableID := ns.DefaultTable()
 
-	// 读取路由表 900 中的默认路由
+	// 读取默认路由表中的默认路由
 	cmd = exec.Command("sh", "-c", fmt.Sprintf("ip route show table %d | grep '^default'", tableID))
 	output, err = cmd.Output()
 	if err != nil || len(output) == 0 {
@@ -210,7 +290,7 @@ func (d *FailoverDaemon) getActualDefaultRoute(monitor *MonitorConfig) (string,
 			// 提取完整路由信息用于删除命令
 			routeParts := strings.Fields(routeLine)
 			if len(routeParts) >= 2 {
-				// 构建删除命令：ip route del <路由参数> table 900
+				// 构建删除命令：ip route del <路由参数> table <默认路由表>
 				delCmd := fmt.Sprintf("ip route del %s table %d", strings.Join(routeParts[1:], " "), tableID)
 				cmd := exec.Command("sh", "-c", delCmd)
 				if err := cmd.Run(); err != nil {
@@ -244,61 +324,26 @@ func (d *FailoverDaemon) getActualDefaultRoute(monitor *MonitorConfig) (string,
 	d.logger.Debug("解析得到出口接口: %s", exitIface)
 
 	// 验证出口是否在候选列表中
-	for _, candidate := range monitor.CandidateExits {
+	for _, candidate := range d.candidateExits(monitor) {
 		if candidate == exitIface {
 			return exitIface, nil
 		}
 	}
 
 	// 如果不在候选列表中，警告但仍返回
-	d.logger.Warn("当前默认路由出口 %s 不在候选列表中: %v", exitIface, monitor.CandidateExits)
+	d.logger.Warn("当前默认路由出口 %s 不在候选列表中: %v", exitIface, d.candidateExits(monitor))
 	return exitIface, nil
 }
 
-// checkMonitor 检查监控任务（基于评分机制）
+// checkMonitor 评估监控任务（基于评分机制，出口状态由共享检测任务更新）
 func (d *FailoverDaemon) checkMonitor(monitor *MonitorConfig) {
-	d.logger.Debug("【监控任务】%s 开始检查 (候选: %v)", monitor.Name, monitor.CandidateExits)
-
-	// 获取检测间隔（用于自适应包数量）
-	checkIntervalMs := monitor.GetCheckInterval(d.config.Daemon.CheckIntervalMs)
-
-	// 获取检测模式
-	checkMode := monitor.GetCheckMode(d.config.Daemon.CheckMode)
-
-	// 获取检测目标（ping 模式用 check_targets，dns 模式用 dns_servers）
-	var targets []string
-	var dnsDomain string
-	if checkMode == "dns" {
-		targets = monitor.DNSServers
-		dnsDomain = monitor.GetDNSQueryDomain(d.config.Daemon.DNSQueryDomain)
-	} else {
-		targets = monitor.CheckTargets
-		dnsDomain = "" // ping 模式不使用
-	}
-
-	// 检查所有候选出口
-	for _, exit := range monitor.CandidateExits {
-		// 执行健康检查（支持 ping 和 dns 模式）
-		checkResult := d.healthChecker.CheckInterface(exit, checkMode, targets, dnsDomain, checkIntervalMs)
-
-		// 获取成本
-		cost := d.getExitCost(exit)
-
-		// 更新状态（计算评分）
-		isFirstCheck := d.stateManager.UpdateState(exit, checkResult, cost)
-
-		if isFirstCheck {
-			state := d.stateManager.GetState(exit)
-			d.logger.Debug("  接口 %s: 初始检测完成 [延迟: %.1fms, 丢包: %.0f%%, Cost: %d, 评分: %.1f]",
-				exit, state.Latency, state.PacketLoss, state.Cost, state.FinalScore)
-		}
-	}
+	d.logger.Debug("【监控任务】%s 开始评估 (候选: %v)", monitor.Name, d.candidateExits(monitor))
 
 	// 等待所有接口完成初始检测
-	if !d.stateManager.AllInitialChecksDone(monitor.CandidateExits) {
+	if !d.stateManager.AllInitialChecksDone(d.candidateExits(monitor)) {
 		d.logger.Debug("监控任务 %s 还在初始检测阶段，不触发故障转移", monitor.Name)
 		// 保存状态
-		if err := d.stateManager.SaveState(d.currentExits); err != nil {
+		if err := d.stateManager.SaveState(d.currentExitsSnapshot()); err != nil {
 			d.logger.Error("保存状态失败: %v", err)
 		}
 		return
@@ -308,12 +353,12 @@ func (d *FailoverDaemon) checkMonitor(monitor *MonitorConfig) {
 	d.evaluateFailover(monitor)
 
 	// 保存状态
-	if err := d.stateManager.SaveState(d.currentExits); err != nil {
+	if err := d.stateManager.SaveState(d.currentExitsSnapshot()); err != nil {
 		d.logger.Error("保存状态失败: %v", err)
 	}
 }
 
-// evaluateFailover 评估是否需要故障转移（基于评分）
+// evaluateFailover 评估是否需要故障转移（基于评分，或按 SLA 策略）
 func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 	// 获取当前出口
 	var currentExit string
@@ -325,7 +370,7 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 		if err != nil {
 			d.logger.Warn("无法从系统读取默认路由: %v，使用缓存值", err)
 			// 降级：使用缓存值
-			if cachedExit, exists := d.currentExits[monitor.Name]; exists {
+			if cachedExit, exists := d.cachedExit(monitor.Name); exists {
 				currentExit = cachedExit
 			} else {
 				d.logger.Error("获取监控任务 %s 当前出口失败: 无缓存且系统读取失败", monitor.Name)
@@ -333,7 +378,7 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 			}
 		} else {
 			// 检查是否与缓存不一致
-			if cachedExit, exists := d.currentExits[monitor.Name]; exists {
+			if cachedExit, exists := d.cachedExit(monitor.Name); exists {
 				// 缓存存在时才检查
 				if cachedExit != currentExit {
 					d.logger.Warn("检测到默认路由已被外部修改: %s → %s", cachedExit, currentExit)
@@ -345,11 +390,11 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 				d.logger.Debug("首次读取默认路由: %s", currentExit)
 			}
 			// 更新缓存
-			d.currentExits[monitor.Name] = currentExit
+			d.setCachedExit(monitor.Name, currentExit)
 		}
 	} else {
 		// 策略组：使用缓存值（策略组配置不会被外部修改）
-		if cachedExit, exists := d.currentExits[monitor.Name]; exists {
+		if cachedExit, exists := d.cachedExit(monitor.Name); exists {
 			currentExit = cachedExit
 		} else {
 			// 第一次评估，从配置文件读取
@@ -358,18 +403,34 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 				d.logger.Error("获取监控任务 %s 当前出口失败: %v", monitor.Name, err)
 				return
 			}
-			d.currentExits[monitor.Name] = currentExit
+			d.setCachedExit(monitor.Name, currentExit)
 		}
 	}
 
+	// SLA 策略：按优先级选择满足 SLA 的出口
+	if monitor.GetSelectionPolicy() == SelectionSLA {
+		d.evaluateSLA(monitor, currentExit)
+		return
+	}
+
 	// 获取所有候选出口的状态
 	var bestExit string
 	var bestScore float64 = -1
 	var currentScore float64 = -1
 
+	// 按监控任务的评分参数计算（出口状态由各监控任务共享，评分参数可能不同）
+	scoringConfig := monitor.GetScoring(d.config.Daemon.Scoring)
+
+	// 流量配额已耗尽的出口只在没有其他可用出口时参与选择
+	avoidExhausted := d.hasQuotaAvailableExit(monitor)
+	currentExhausted := false
+
+	// 振荡抑制中的出口只在没有其他可用出口时作为切换目标
+	avoidSuppressed := d.hasUnsuppressedExit(monitor, avoidExhausted)
+
 	d.logger.Debug("【评分结果】监控任务: %s", monitor.Name)
 
-	for _, exit := range monitor.CandidateExits {
+	for _, exit := range d.candidateExits(monitor) {
 		state := d.stateManager.GetState(exit)
 
 		// 判断 UP/DOWN 状态
@@ -377,30 +438,60 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 		if state.PacketLoss >= 100.0 {
 			status = "DOWN"
 		}
+		if state.QuotaExhausted {
+			status += " 配额耗尽"
+		}
+		if state.Suppressed {
+			status += fmt.Sprintf(" 振荡抑制(%.0f)", state.FlapPenalty)
+		}
 
-		d.logger.Debug("  %s: %s [延迟=%.1fms 丢包=%.0f%% Cost=%d 基础分=%.1f 最终分=%.1f]",
-			exit, status, state.Latency, state.PacketLoss, state.Cost, state.BaseScore, state.FinalScore)
+		breakdown := scoringConfig.Score(state.Sample())
+
+		d.logger.Debug("  %s: %s [延迟=%.1fms 丢包=%.0f%% 抖动=%.1fms 失败=%d Cost=%d 基础分=%.1f 最终分=%.1f]",
+			exit, status, state.Latency, state.PacketLoss, state.Jitter, state.Failures, state.Cost,
+			breakdown.Base, breakdown.Final)
 
 		// 记录当前出口的评分
 		if exit == currentExit {
-			currentScore = state.FinalScore
+			currentScore = breakdown.Final
+			currentExhausted = avoidExhausted && state.QuotaExhausted
+		}
+
+		if avoidExhausted && state.QuotaExhausted {
+			continue
+		}
+		if avoidSuppressed && state.Suppressed && exit != currentExit {
+			continue
 		}
 
 		// 选择最佳出口
-		if state.FinalScore > bestScore {
-			bestScore = state.FinalScore
+		if breakdown.Final > bestScore {
+			bestScore = breakdown.Final
 			bestExit = exit
-		} else if state.FinalScore == bestScore && exit == currentExit {
+		} else if breakdown.Final == bestScore && exit == currentExit {
 			// 分数相同，优先保持当前出口（避免频繁切换）
 			bestExit = currentExit
 		}
 	}
 
+	// 所有候选出口均不可用：按 on_exit_down 处理（阻断/备用出口），避免流量泄漏
+	if d.allCandidatesDown(monitor) {
+		d.logger.Debug("监控任务 %s: 所有候选出口均不可用", monitor.Name)
+		d.enforceExitDown(monitor)
+		return
+	}
+
 	if bestExit == "" {
 		d.logger.Warn("监控任务 %s: 所有候选出口均不可用", monitor.Name)
 		return
 	}
 
+	// 之前已阻断：有候选出口恢复，直接恢复到最佳出口（无需评分差值和确认）
+	if d.isExitDownActive(monitor.Name) {
+		d.restoreFromExitDown(monitor, bestExit)
+		return
+	}
+
 	d.logger.Debug("【决策】当前出口: %s (评分: %.1f), 最佳出口: %s (评分: %.1f)",
 		currentExit, currentScore, bestExit, bestScore)
 
@@ -409,8 +500,10 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 		scoreDiff := bestScore - currentScore
 		scoreThreshold := monitor.GetScoreThreshold(d.config.Daemon.ScoreThreshold)
 
-		// 检查评分差值是否超过阈值
-		if scoreDiff < scoreThreshold {
+		// 检查评分差值是否超过阈值（当前出口配额耗尽时不受阈值限制）
+		if currentExhausted {
+			d.logger.Info("【配额耗尽】当前出口 %s 流量配额已耗尽，切换到 %s", currentExit, bestExit)
+		} else if scoreDiff < scoreThreshold {
 			// 评分差值不足，重置确认计数器
 			if d.confirmationCounters[monitor.Name] > 0 {
 				d.logger.Info("【确认取消】评分差值不足，重置确认计数器 (之前: %d/%d)",
@@ -421,6 +514,7 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 				d.logger.Debug("【保持不变】评分提升 %.1f 未超过阈值 %.1f，不切换",
 					scoreDiff, scoreThreshold)
 			}
+			d.stateManager.ClearSwitchLimit(monitor.Name)
 			return
 		}
 
@@ -434,6 +528,11 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 
 		// 检查是否达到确认次数
 		if currentConfirmations >= confirmationCount {
+			// 切换限速（保持确认状态，解除限制后再切换）
+			if !d.switchAllowed(monitor, currentExit) {
+				return
+			}
+
 			// 确认完成，执行切换
 			d.logger.Info("【确认完成】连续 %d 次确认通过，执行切换", currentConfirmations)
 			d.confirmationCounters[monitor.Name] = 0 // 重置计数器
@@ -445,6 +544,8 @@ func (d *FailoverDaemon) evaluateFailover(monitor *MonitorConfig) {
 				currentConfirmations, confirmationCount, remaining)
 		}
 	} else {
+		d.stateManager.ClearSwitchLimit(monitor.Name)
+
 		// 当前出口仍是最佳出口，重置确认计数器
 		if d.confirmationCounters[monitor.Name] > 0 {
 			d.logger.Info("【确认取消】当前出口恢复为最佳，重置确认计数器 (之前: %d/%d)",
@@ -467,142 +568,51 @@ func (d *FailoverDaemon) executeFailover(monitor *MonitorConfig, oldExit, newExi
 	message := fmt.Sprintf("故障转移: %s → %s (评分: %.1f → %.1f)", oldExit, newExit, oldScore, newScore)
 	d.logger.Info("【执行】%s", message)
 
-	// 构造模拟的检查结果（供 routing.SelectBestExit 使用）
-	checkResults := &network.AllCheckResults{
-		Results: make(map[string]*network.CheckResult),
-	}
-
-	for _, exit := range monitor.CandidateExits {
-		state := d.stateManager.GetState(exit)
-		checkResults.Results[exit] = &network.CheckResult{
-			TunnelName: exit,
-			Status:     map[bool]string{true: "UP", false: "DOWN"}[state.PacketLoss < 100.0],
-			Latency:    state.Latency,
-			PacketLoss: state.PacketLoss,
-			TargetIP:   state.LastTarget,
-		}
-	}
-
-	// 调用现有的Failover逻辑（静默模式）
-	var err error
-	if monitor.Type == "default_route" {
-		// 默认路由故障转移
-		err = d.failoverDefaultRoute(monitor, checkResults)
-	} else {
-		// 策略组故障转移
-		err = d.failoverPolicyGroup(monitor, checkResults)
-	}
-
+	// 直接应用评估选定的出口（SelectBestExit 不考虑振荡抑制，重新选择可能与评估结果不一致）
+	err := d.applyExit(monitor, newExit)
 	if err != nil {
 		message := fmt.Sprintf("故障转移失败: %v", err)
-		d.logger.Error(message)
+		d.logger.Error("%s", message)
 		d.stateManager.RecordEvent(monitor.Name, "failover", message)
 	} else {
 		d.logger.Info("【完成】故障转移成功")
-		d.currentExits[monitor.Name] = newExit
+		d.setCachedExit(monitor.Name, newExit)
+		d.stateManager.RecordSwitch(monitor.Name)
 		d.stateManager.RecordEvent(monitor.Name, "failover", message)
 	}
 }
 
-// getExitCost 获取出口成本
-func (d *FailoverDaemon) getExitCost(exitName string) int {
-	// 先尝试作为隧道加载
-	tunnelConfig, err := network.LoadTunnelConfig(exitName)
-	if err == nil && tunnelConfig != nil {
-		return tunnelConfig.Cost
-	}
-
-	// 再尝试作为物理接口加载
-	ifaceConfig, err := network.LoadInterfaceConfig()
-	if err == nil {
-		iface := ifaceConfig.GetInterfaceByName(exitName)
-		if iface != nil {
-			return iface.Cost
+// hasQuotaAvailableExit 是否存在流量配额未耗尽的可用候选出口
+func (d *FailoverDaemon) hasQuotaAvailableExit(monitor *MonitorConfig) bool {
+	for _, exit := range d.candidateExits(monitor) {
+		state := d.stateManager.GetState(exit)
+		if state.PacketLoss < 100.0 && !state.QuotaExhausted {
+			return true
 		}
 	}
-
-	// 默认成本为0
-	return 0
+	return false
 }
 
-// failoverDefaultRoute 执行默认路由故障转移（静默模式）
-func (d *FailoverDaemon) failoverDefaultRoute(monitor *MonitorConfig, checkResults *network.AllCheckResults) error {
-	// 选择最佳出口
-	d.logger.Debug("【Failover】选择最佳出口...")
-	bestExit, _, err := routing.SelectBestExit(monitor.CandidateExits, checkResults)
-	if err != nil {
-		d.logger.Error("选择最佳出口失败: %v", err)
-		return err
-	}
-
-	d.logger.Debug("【Failover】最佳出口: %s", bestExit.Name)
-
-	// 创建 PolicyManager 并设置默认出口
+// applyExit 将监控任务的目标（默认路由或策略组）切换到指定出口（调用方持有 failoverMutex）
+func (d *FailoverDaemon) applyExit(monitor *MonitorConfig, exit string) error {
 	pm := routing.NewPolicyManager()
-	pm.SetDefaultExit(bestExit.Name)
-
-	// 应用默认路由
-	d.logger.Debug("【Failover】应用默认路由到 %s...", bestExit.Name)
-	if err := pm.ApplyDefaultRouteOnly(); err != nil {
-		d.logger.Error("应用默认路由失败: %v", err)
-		return fmt.Errorf("应用默认路由失败: %v", err)
-	}
-
-	d.logger.Debug("【Failover】默认路由应用成功")
-	return nil
-}
-
-// failoverPolicyGroup 执行策略组故障转移（静默模式）
-func (d *FailoverDaemon) failoverPolicyGroup(monitor *MonitorConfig, checkResults *network.AllCheckResults) error {
-	// 选择最佳出口
-	d.logger.Debug("【Failover】选择最佳出口...")
-	bestExit, _, err := routing.SelectBestExit(monitor.CandidateExits, checkResults)
-	if err != nil {
-		d.logger.Error("选择最佳出口失败: %v", err)
-		return err
+	if monitor.Type == "default_route" {
+		pm.SetDefaultExit(exit)
+		return pm.ApplyDefaultRouteOnly()
 	}
 
-	d.logger.Debug("【Failover】最佳出口: %s", bestExit.Name)
-
-	// 加载策略组
-	pm := routing.NewPolicyManager()
 	if err := pm.LoadGroup(monitor.Target); err != nil {
-		d.logger.Error("策略组 %s 不存在: %v", monitor.Target, err)
 		return fmt.Errorf("策略组 %s 不存在", monitor.Target)
 	}
-
 	group := pm.GetGroup(monitor.Target)
 	if group == nil {
-		d.logger.Error("策略组 %s 不存在", monitor.Target)
 		return fmt.Errorf("策略组 %s 不存在", monitor.Target)
 	}
-
-	// 检查是否需要切换
-	if group.Exit == bestExit.Name {
-		d.logger.Debug("【Failover】策略组 %s 当前出口已是 %s，无需切换", monitor.Target, bestExit.Name)
-		return nil // 无需切换
-	}
-
-	// 更新出口
-	d.logger.Debug("【Failover】更新策略组 %s 出口: %s → %s", monitor.Target, group.Exit, bestExit.Name)
-	group.Exit = bestExit.Name
-
-	// 保存配置
-	d.logger.Debug("【Failover】保存策略组配置...")
+	group.Exit = exit
 	if err := pm.Save(); err != nil {
-		d.logger.Error("保存配置失败: %v", err)
 		return fmt.Errorf("保存配置失败: %v", err)
 	}
-
-	// 应用策略组
-	d.logger.Debug("【Failover】应用策略组 %s...", monitor.Target)
-	if err := pm.ApplyGroup(group); err != nil {
-		d.logger.Error("应用策略失败: %v", err)
-		return fmt.Errorf("应用策略失败: %v", err)
-	}
-
-	d.logger.Debug("【Failover】策略组 %s 应用成功", monitor.Target)
-	return nil
+	return pm.ApplyGroup(group)
 }
 
 // reloadConfig 重载配置
@@ -653,11 +663,41 @@ func (d *FailoverDaemon) reloadConfig() error {
 	}
 
 	// 更新全局配置
+	driftChanged := d.config.Daemon.DriftCheckIntervalSec != newConfig.Daemon.DriftCheckIntervalSec
+	statsChanged := d.config.Daemon.StatsIntervalSec != newConfig.Daemon.StatsIntervalSec
+	schedulesChanged := !reflect.DeepEqual(d.config.Schedules, newConfig.Schedules)
 	d.config = newConfig
 
-	// 重置所有状态（避免旧状态干扰）
+	// 重新读取定时任务状态（schedule clear 会修改状态文件），并清除失效的覆盖
+	d.overrideMutex.Lock()
+	d.scheduleState = LoadScheduleState()
+	d.overrideMutex.Unlock()
+	d.pruneScheduleOverrides()
+
+	// 漂移检测间隔变化时重启定时器
+	if driftChanged {
+		d.stopDriftReconcile()
+		d.startDriftReconcile()
+	}
+
+	// 流量统计间隔变化时重启定时器
+	if statsChanged {
+		d.stopStatsCollector()
+		d.startStatsCollector()
+	}
+
+	// 定时任务变化时重新计算执行时间
+	if schedulesChanged {
+		d.stopScheduler()
+		d.startScheduler()
+	}
+
+	// 重置检测状态（避免旧状态干扰，振荡抑制和切换记录保留）
 	d.stateManager.ResetAllStates()
 
+	// 按新配置重建共享检测任务
+	d.restartProbes()
+
 	return nil
 }
 
@@ -665,14 +705,18 @@ func (d *FailoverDaemon) reloadConfig() error {
 func (d *FailoverDaemon) shutdown() {
 	d.logger.Info("正在停止所有监控任务...")
 
-	// 停止所有ticker
-	for name, ticker := range d.tickers {
-		ticker.Stop()
+	// 停止共享检测任务和所有监控任务
+	d.stopProbes()
+	for _, name := range d.config.GetMonitorNames() {
+		d.stopMonitor(name)
 		d.logger.Debug("停止监控任务: %s", name)
 	}
+	d.stopDriftReconcile()
+	d.stopStatsCollector()
+	d.stopScheduler()
 
 	// 保存最终状态
-	if err := d.stateManager.SaveState(d.currentExits); err != nil {
+	if err := d.stateManager.SaveState(d.currentExitsSnapshot()); err != nil {
 		d.logger.Error("保存状态失败: %v", err)
 	}
 
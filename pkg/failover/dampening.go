@@ -0,0 +1,322 @@
+package failover
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// 振荡抑制和切换限速
+// 出口振荡抑制（类似 BGP route flap dampening）: 出口每次由可用变为不可用，振荡惩罚增加 flap_penalty，
+// 惩罚按半衰期指数衰减；惩罚超过 suppress_threshold 时出口被抑制（不作为切换目标，除非没有其他可用出口），
+// 衰减到 reuse_threshold 以下时解除抑制。
+// 切换限速（每个监控任务）: 每小时最多切换 max_switches_per_hour 次，切换后至少在新出口停留 min_dwell_sec 秒。
+// 当前出口完全不可用时不受切换限速限制（避免流量中断）。
+
+// 振荡抑制默认值
+const (
+	DefaultDampeningHalfLifeSec    = 900
+	DefaultDampeningFlapPenalty    = 1000
+	DefaultDampeningSuppress       = 2000
+	DefaultDampeningReuse          = 750
+	DefaultDampeningMaxSuppressSec = 3600
+)
+
+// DampeningConfig 出口振荡抑制配置（零值字段使用默认值）
+type DampeningConfig struct {
+	HalfLifeSec       int `yaml:"half_life_sec,omitempty"`      // 惩罚半衰期（秒，默认900）
+	FlapPenalty       int `yaml:"flap_penalty,omitempty"`       // 每次振荡增加的惩罚（默认1000）
+	SuppressThreshold int `yaml:"suppress_threshold,omitempty"` // 抑制阈值（默认2000）
+	ReuseThreshold    int `yaml:"reuse_threshold,omitempty"`    // 解除抑制阈值（默认750）
+	MaxSuppressSec    int `yaml:"max_suppress_sec,omitempty"`   // 最长抑制时间（秒，默认3600，决定惩罚上限）
+}
+
+// withDefaults 返回填充默认值后的配置
+func (c *DampeningConfig) withDefaults() DampeningConfig {
+	result := DampeningConfig{
+		HalfLifeSec:       DefaultDampeningHalfLifeSec,
+		FlapPenalty:       DefaultDampeningFlapPenalty,
+		SuppressThreshold: DefaultDampeningSuppress,
+		ReuseThreshold:    DefaultDampeningReuse,
+		MaxSuppressSec:    DefaultDampeningMaxSuppressSec,
+	}
+	if c.HalfLifeSec > 0 {
+		result.HalfLifeSec = c.HalfLifeSec
+	}
+	if c.FlapPenalty > 0 {
+		result.FlapPenalty = c.FlapPenalty
+	}
+	if c.SuppressThreshold > 0 {
+		result.SuppressThreshold = c.SuppressThreshold
+	}
+	if c.ReuseThreshold > 0 {
+		result.ReuseThreshold = c.ReuseThreshold
+	}
+	if c.MaxSuppressSec > 0 {
+		result.MaxSuppressSec = c.MaxSuppressSec
+	}
+	return result
+}
+
+// ceiling 惩罚上限（从上限衰减到 reuse_threshold 恰好需要 max_suppress_sec）
+func (c DampeningConfig) ceiling() float64 {
+	return float64(c.ReuseThreshold) * math.Pow(2, float64(c.MaxSuppressSec)/float64(c.HalfLifeSec))
+}
+
+// Validate 校验振荡抑制配置（prefix 为配置路径，用于错误信息）
+func (c *DampeningConfig) Validate(prefix string) []string {
+	if c == nil {
+		return nil
+	}
+
+	errors := make([]string, 0)
+	if c.HalfLifeSec < 0 || c.FlapPenalty < 0 || c.SuppressThreshold < 0 || c.ReuseThreshold < 0 || c.MaxSuppressSec < 0 {
+		errors = append(errors, prefix+" 的参数不能为负数")
+	}
+	merged := c.withDefaults()
+	if merged.ReuseThreshold >= merged.SuppressThreshold {
+		errors = append(errors, prefix+".reuse_threshold 必须小于 suppress_threshold")
+	}
+	if merged.ceiling() <= float64(merged.SuppressThreshold) {
+		errors = append(errors, prefix+".max_suppress_sec 过小，惩罚上限达不到 suppress_threshold")
+	}
+	return errors
+}
+
+// Summary 振荡抑制配置的简要描述
+func (c *DampeningConfig) Summary() string {
+	if c == nil {
+		return "未启用"
+	}
+	merged := c.withDefaults()
+	return fmt.Sprintf("半衰期 %ds, 每次振荡 +%d, 抑制 ≥%d, 解除 <%d, 最长抑制 %ds",
+		merged.HalfLifeSec, merged.FlapPenalty, merged.SuppressThreshold, merged.ReuseThreshold, merged.MaxSuppressSec)
+}
+
+// validateSwitchLimits 校验切换限速参数
+func validateSwitchLimits(prefix string, maxSwitches, minDwell int) []string {
+	errors := make([]string, 0)
+	if maxSwitches < 0 || maxSwitches > 3600 {
+		errors = append(errors, prefix+".max_switches_per_hour 必须在 0-3600 范围内")
+	}
+	if minDwell < 0 || minDwell > 86400 {
+		errors = append(errors, prefix+".min_dwell_sec 必须在 0-86400 范围内")
+	}
+	return errors
+}
+
+// UpdateDampening 更新出口的振荡惩罚（每次检测后调用，cfg 为 nil 时清除惩罚）
+// 返回: 抑制状态变化的描述（无变化时为空）
+func (sm *StateManager) UpdateDampening(iface string, cfg *DampeningConfig) string {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	state, exists := sm.states[iface]
+	if !exists || !state.InitialCheckDone {
+		return ""
+	}
+
+	up := state.PacketLoss < 100.0
+	wasUp := state.lastUp
+	state.lastUp = up
+
+	if cfg == nil {
+		state.FlapPenalty = 0
+		state.Suppressed = false
+		return ""
+	}
+
+	damp := cfg.withDefaults()
+	now := time.Now()
+
+	// 按半衰期衰减
+	if state.FlapPenalty > 0 {
+		elapsed := now.Sub(state.FlapUpdated).Seconds()
+		state.FlapPenalty *= math.Pow(0.5, elapsed/float64(damp.HalfLifeSec))
+		if state.FlapPenalty < 1 {
+			state.FlapPenalty = 0
+		}
+	}
+	state.FlapUpdated = now
+
+	// 可用 → 不可用 记为一次振荡
+	if wasUp && !up {
+		state.FlapPenalty = math.Min(state.FlapPenalty+float64(damp.FlapPenalty), damp.ceiling())
+	}
+
+	switch {
+	case !state.Suppressed && state.FlapPenalty >= float64(damp.SuppressThreshold):
+		state.Suppressed = true
+		return fmt.Sprintf("出口 %s 振荡抑制 (惩罚 %.0f ≥ %d)", iface, state.FlapPenalty, damp.SuppressThreshold)
+	case state.Suppressed && state.FlapPenalty < float64(damp.ReuseThreshold):
+		state.Suppressed = false
+		return fmt.Sprintf("出口 %s 解除振荡抑制 (惩罚 %.0f < %d)", iface, state.FlapPenalty, damp.ReuseThreshold)
+	}
+	return ""
+}
+
+// SwitchState 监控任务的切换记录和限制状态
+type SwitchState struct {
+	Switches   []time.Time `json:"switches"`          // 最近一小时的切换时间
+	LastSwitch time.Time   `json:"last_switch"`       // 最近一次切换时间
+	Limited    string      `json:"limited,omitempty"` // 当前切换受限的原因（未受限时为空）
+
+	limitKind string // 受限类型: dwell / rate（剩余时间每次都不同，按类型判断受限状态是否变化）
+}
+
+// recentSwitches 最近一小时的切换次数
+func (s *SwitchState) recentSwitches(now time.Time) int {
+	count := 0
+	for _, t := range s.Switches {
+		if now.Sub(t) < time.Hour {
+			count++
+		}
+	}
+	return count
+}
+
+// RecordSwitch 记录监控任务的一次切换
+func (sm *StateManager) RecordSwitch(monitorName string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now()
+	state := sm.switchState(monitorName)
+	switches := make([]time.Time, 0, len(state.Switches)+1)
+	for _, t := range state.Switches {
+		if now.Sub(t) < time.Hour {
+			switches = append(switches, t)
+		}
+	}
+	state.Switches = append(switches, now)
+	state.LastSwitch = now
+	state.Limited = ""
+	state.limitKind = ""
+}
+
+// switchState 获取监控任务的切换状态（不存在时创建，调用方持有锁）
+func (sm *StateManager) switchState(monitorName string) *SwitchState {
+	state, exists := sm.switches[monitorName]
+	if !exists {
+		state = &SwitchState{Switches: make([]time.Time, 0)}
+		sm.switches[monitorName] = state
+	}
+	return state
+}
+
+// CheckSwitchLimit 检查监控任务当前是否允许切换
+// 返回: 受限原因（允许切换时为空）, 受限状态是否变化
+func (sm *StateManager) CheckSwitchLimit(monitorName string, maxPerHour, minDwellSec int) (string, bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now()
+	state := sm.switchState(monitorName)
+
+	reason, kind := "", ""
+	if minDwellSec > 0 && !state.LastSwitch.IsZero() {
+		dwell := time.Duration(minDwellSec) * time.Second
+		if remaining := dwell - now.Sub(state.LastSwitch); remaining > 0 {
+			reason = fmt.Sprintf("停留时间未满 %ds (剩余 %s)", minDwellSec, remaining.Truncate(time.Second))
+			kind = "dwell"
+		}
+	}
+	if kind == "" && maxPerHour > 0 {
+		if count := state.recentSwitches(now); count >= maxPerHour {
+			reason = fmt.Sprintf("最近一小时已切换 %d 次 (上限 %d)", count, maxPerHour)
+			kind = "rate"
+		}
+	}
+
+	changed := kind != state.limitKind
+	state.Limited = reason
+	state.limitKind = kind
+	return reason, changed
+}
+
+// ClearSwitchLimit 清除监控任务的受限状态（不再需要切换时调用）
+func (sm *StateManager) ClearSwitchLimit(monitorName string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if state, exists := sm.switches[monitorName]; exists {
+		state.Limited = ""
+		state.limitKind = ""
+	}
+}
+
+// GetSwitchState 获取监控任务的切换状态（副本）
+func (sm *StateManager) GetSwitchState(monitorName string) SwitchState {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if state, exists := sm.switches[monitorName]; exists {
+		return *state
+	}
+	return SwitchState{}
+}
+
+// switchAllowed 检查监控任务是否允许执行切换（当前出口不可用时总是允许）
+// 受限状态变化时记录事件
+func (d *FailoverDaemon) switchAllowed(monitor *MonitorConfig, currentExit string) bool {
+	if d.stateManager.GetState(currentExit).PacketLoss >= 100.0 {
+		return true
+	}
+
+	reason, changed := d.stateManager.CheckSwitchLimit(monitor.Name,
+		monitor.GetMaxSwitchesPerHour(d.config.Daemon.MaxSwitchesPerHour),
+		monitor.GetMinDwellSec(d.config.Daemon.MinDwellSec))
+	if reason == "" {
+		return true
+	}
+
+	if changed {
+		message := fmt.Sprintf("切换受限: %s", reason)
+		d.logger.Warn("【切换受限】监控任务 %s: %s", monitor.Name, reason)
+		d.stateManager.RecordEvent(monitor.Name, "switch_limited", message)
+	} else {
+		d.logger.Debug("【切换受限】监控任务 %s: %s", monitor.Name, reason)
+	}
+	return false
+}
+
+// updateDampening 更新出口的振荡惩罚，抑制状态变化时记录事件
+func (d *FailoverDaemon) updateDampening(exit string) {
+	message := d.stateManager.UpdateDampening(exit, d.config.Daemon.Dampening)
+	if message == "" {
+		return
+	}
+	d.logger.Warn("【振荡抑制】%s", message)
+	d.stateManager.RecordEvent("dampening", "flap_dampening", message)
+}
+
+// hasUnsuppressedExit 是否存在未被振荡抑制的可用候选出口（avoidExhausted 时同时要求配额未耗尽）
+func (d *FailoverDaemon) hasUnsuppressedExit(monitor *MonitorConfig, avoidExhausted bool) bool {
+	for _, exit := range d.candidateExits(monitor) {
+		state := d.stateManager.GetState(exit)
+		if state.PacketLoss < 100.0 && !state.Suppressed && !(avoidExhausted && state.QuotaExhausted) {
+			return true
+		}
+	}
+	return false
+}
+
+// printSwitchState 打印监控任务的切换次数和受限状态（policy failover status）
+func printSwitchState(state *SwitchState, monitor *MonitorConfig, config *FailoverConfig) {
+	maxPerHour := monitor.GetMaxSwitchesPerHour(config.Daemon.MaxSwitchesPerHour)
+	if state == nil || (len(state.Switches) == 0 && state.Limited == "") {
+		return
+	}
+
+	line := fmt.Sprintf("    切换: 最近一小时 %d 次", state.recentSwitches(time.Now()))
+	if maxPerHour > 0 {
+		line += fmt.Sprintf(" (上限 %d)", maxPerHour)
+	}
+	if !state.LastSwitch.IsZero() {
+		line += fmt.Sprintf(", 上次 %s", state.LastSwitch.Format("15:04:05"))
+	}
+	fmt.Println(line)
+	if state.Limited != "" {
+		fmt.Printf("    ⚠ 切换受限: %s\n", state.Limited)
+	}
+}
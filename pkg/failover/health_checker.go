@@ -2,13 +2,10 @@ package failover
 
 import (
 	"context"
-	"fmt"
+	"math"
 	"net"
-	"os/exec"
-	"regexp"
-	"strconv"
-	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"trueword_node/pkg/network"
@@ -21,88 +18,91 @@ type CheckResult struct {
 	Success    bool    // 是否成功（至少1个包通）
 	Latency    float64 // 平均延迟（ms）
 	PacketLoss float64 // 丢包率（%）
+	Jitter     float64 // 抖动（ms，ping mdev / DNS 延迟标准差）
+	Targets    []TargetResult // 各检测目标的结果（多目标检测）
 }
 
 // HealthChecker 健康检查器
+// 检测报文通过绑定到出口设备的套接字发出（进程内 ICMP 探测 / DNS 查询），不使用临时策略路由，不同出口可并发检测
 type HealthChecker struct {
-	logger     *Logger
-	globalLock sync.Mutex // 全局锁，保证测试路由的原子性
+	logger *Logger
 }
 
 // NewHealthChecker 创建健康检查器
 func NewHealthChecker(logger *Logger) *HealthChecker {
 	return &HealthChecker{
-		logger:     logger,
-		globalLock: sync.Mutex{},
+		logger: logger,
 	}
 }
 
 // CheckInterface 检测接口健康状态
-// 返回: 检查结果（包含延迟、丢包率）
+// 返回: 检查结果（包含延迟、丢包率，以及每个目标的结果）
 // checkMode: 检测模式（ping / dns）
-// targets: ping 模式使用的目标 IP 列表 / dns 模式使用的 DNS 服务器列表
+// targets: ping 模式使用的目标 IP 列表 / dns 模式使用的 DNS 服务器列表（并行检测所有目标）
 // dnsDomain: dns 模式使用的查询域名
 // checkIntervalMs: 检测间隔（毫秒），用于自适应包数量
-func (hc *HealthChecker) CheckInterface(iface string, checkMode string, targets []string, dnsDomain string, checkIntervalMs int) *CheckResult {
+// policy: 多目标检测策略（any / all / quorum:N / weighted）
+func (hc *HealthChecker) CheckInterface(iface string, checkMode string, targets []string, dnsDomain string, checkIntervalMs int, policy CheckPolicy) *CheckResult {
 	count := hc.calculatePingCount(checkIntervalMs)
+	interval, timeout := hc.calculateProbeTiming(checkIntervalMs, count)
+	results := hc.probeTargets(iface, checkMode, targets, dnsDomain, count, interval, timeout)
 
+	unit := "丢包"
 	if checkMode == "dns" {
-		// DNS 检测：尝试所有 DNS 服务器
-		for i, dnsServer := range targets {
-			result := hc.checkDNS(iface, dnsServer, dnsDomain, count)
-
-			if result.Success {
-				if i > 0 {
-					// 使用了备选 DNS 服务器
-					hc.logger.Debug("检测 %s → DNS %s: 成功 (使用备选 #%d) [延迟: %.1fms, 失败率: %.0f%%]",
-						iface, dnsServer, i+1, result.Latency, result.PacketLoss)
-				} else {
-					hc.logger.Debug("检测 %s → DNS %s: 成功 [延迟: %.1fms, 失败率: %.0f%%]",
-						iface, dnsServer, result.Latency, result.PacketLoss)
-				}
-				return result
-			} else {
-				hc.logger.Debug("检测 %s → DNS %s: 失败", iface, dnsServer)
-			}
+		unit = "失败率"
+	}
+	for _, result := range results {
+		if result.Success {
+			hc.logger.Debug("检测 %s → %s: 成功 [延迟: %.1fms, %s: %.0f%%, 抖动: %.1fms]",
+				iface, result.TargetIP, result.Latency, unit, result.PacketLoss, result.Jitter)
+		} else {
+			hc.logger.Debug("检测 %s → %s: 失败", iface, result.TargetIP)
 		}
+	}
 
-		// 所有 DNS 服务器都失败
-		hc.logger.Debug("检测 %s: 失败 (所有 DNS 服务器不可达)", iface)
-		return &CheckResult{
-			Interface:  iface,
-			Success:    false,
-			Latency:    0,
-			PacketLoss: 100.0,
-		}
+	result := aggregateResults(iface, results, policy)
+	if result.Success {
+		hc.logger.Debug("检测 %s: 可用 (%s, %d/%d 个目标可达) [延迟: %.1fms, %s: %.0f%%]",
+			iface, policy, reachableCount(result.Targets), len(results), result.Latency, unit, result.PacketLoss)
 	} else {
-		// ping 检测：尝试所有目标 IP
-		for i, target := range targets {
-			result := hc.quickPing(iface, target, checkIntervalMs)
-
-			if result.Success {
-				if i > 0 {
-					// 使用了备选目标
-					hc.logger.Debug("检测 %s → %s: 成功 (使用备选目标 #%d) [延迟: %.1fms, 丢包: %.0f%%]",
-						iface, target, i+1, result.Latency, result.PacketLoss)
-				} else {
-					hc.logger.Debug("检测 %s → %s: 成功 [延迟: %.1fms, 丢包: %.0f%%]",
-						iface, target, result.Latency, result.PacketLoss)
-				}
-				return result
-			} else {
-				hc.logger.Debug("检测 %s → %s: 失败", iface, target)
-			}
-		}
+		hc.logger.Debug("检测 %s: 失败 (%s, %d/%d 个目标可达)", iface, policy, reachableCount(result.Targets), len(results))
+	}
+	return result
+}
 
-		// 所有目标都失败
-		hc.logger.Debug("检测 %s: 失败 (所有目标不可达)", iface)
-		return &CheckResult{
+// probeTargets 并行检测接口到所有目标的连通性
+// ping 模式一个套接字同时探测所有目标；dns 模式每个 DNS 服务器一个 goroutine
+func (hc *HealthChecker) probeTargets(iface, checkMode string, targets []string, dnsDomain string, count int, interval, timeout time.Duration) []*CheckResult {
+	results := make([]*CheckResult, len(targets))
+	for i, target := range targets {
+		results[i] = &CheckResult{
 			Interface:  iface,
+			TargetIP:   target,
 			Success:    false,
 			Latency:    0,
 			PacketLoss: 100.0,
 		}
 	}
+
+	// 网络命名空间内的隧道在命名空间内探测（VRF 内的隧道绑定设备即可）
+	netns := interfaceNetns(iface)
+
+	if checkMode != "dns" {
+		hc.ping(results, netns, count, interval, timeout)
+		return results
+	}
+
+	var wg sync.WaitGroup
+	for _, result := range results {
+		wg.Add(1)
+		go func(result *CheckResult) {
+			defer wg.Done()
+			hc.checkDNS(result, netns, dnsDomain, count, interval, timeout)
+		}(result)
+	}
+	wg.Wait()
+
+	return results
 }
 
 // calculatePingCount 根据检测间隔自动计算ping包数量
@@ -121,193 +121,74 @@ func (hc *HealthChecker) calculatePingCount(checkIntervalMs int) int {
 	return count
 }
 
-// quickPing 自适应ping检测
-// 返回: 检查结果（延迟、丢包率）
-// checkIntervalMs: 检测间隔，用于计算最优包数量
-func (hc *HealthChecker) quickPing(iface, target string, checkIntervalMs int) *CheckResult {
-	result := &CheckResult{
-		Interface:  iface,
-		TargetIP:   target,
-		Success:    false,
-		Latency:    0,
-		PacketLoss: 100.0,
+// calculateProbeTiming 根据检测间隔计算包间隔和回复等待时间（一轮检测尽量在检测间隔内完成）
+// 包间隔: 检测间隔 / (2 × 包数量)，限制在 10-40ms；等待时间: 检测间隔的一半，限制在 200ms-1s
+// 示例：500ms间隔 → 10包 × 25ms，等待250ms；2000ms+间隔 → 20包 × 40ms，等待1s
+func (hc *HealthChecker) calculateProbeTiming(checkIntervalMs, count int) (time.Duration, time.Duration) {
+	interval := time.Duration(checkIntervalMs) * time.Millisecond / time.Duration(2*count)
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
 	}
-
-	// 全局锁：因为 pref 5 必须全局唯一，所有检查必须串行化
-	hc.globalLock.Lock()
-	defer hc.globalLock.Unlock()
-
-	// 先清理所有 pref 5 的残留规则（防止之前崩溃留下的）
-	hc.cleanupPref5Rules()
-
-	// 添加临时路由规则和路由
-	table := hc.getRouteTable(iface)
-	if err := hc.addTestRoute(target, iface, table); err != nil {
-		hc.logger.Debug("添加临时路由失败: %v", err)
-		return result
+	if interval > 40*time.Millisecond {
+		interval = 40 * time.Millisecond
 	}
 
-	// 确保删除（即使 panic 也要删除）
-	defer hc.removeTestRoute(target, iface, table)
-
-	// 自适应计算ping包数量
-	count := hc.calculatePingCount(checkIntervalMs)
-
-	// 执行自适应ping
-	// 包间隔：0.04秒（40ms）
-	// 总测试时间：count × 0.04秒
-	// 示例：500ms间隔 → 10包 × 0.04s = 0.4s（10%精度）
-	//      2000ms间隔 → 20包 × 0.04s = 0.8s（5%精度）
-	cmd := exec.Command("ping",
-		"-c", strconv.Itoa(count), // 自适应包数量
-		"-i", "0.04",               // 间隔40ms（提速）
-		"-W", "1",                  // 超时1秒
-		target,
-	)
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// ping命令返回非0，可能部分失败或全部失败
-		// 继续解析输出，看是否有包成功
+	timeout := time.Duration(checkIntervalMs) * time.Millisecond / 2
+	if timeout < 200*time.Millisecond {
+		timeout = 200 * time.Millisecond
 	}
-
-	// 解析结果：提取延迟和丢包率
-	hc.parsePingResult(output, result)
-
-	return result
-}
-
-// cleanupPref5Rules 清理所有 pref 5 的规则（防止残留）
-func (hc *HealthChecker) cleanupPref5Rules() {
-	// 循环删除所有 pref 5 的规则，最多尝试10次
-	for i := 0; i < 10; i++ {
-		// 不指定具体的 to，删除任何 pref 5 的规则
-		cmd := exec.Command("ip", "rule", "del", "pref", "5")
-		err := cmd.Run()
-		if err != nil {
-			// 删除失败，说明已经没有 pref 5 的规则了
-			break
-		}
-		hc.logger.Debug("清理残留规则: pref 5 (第%d次)", i+1)
+	if timeout > time.Second {
+		timeout = time.Second
 	}
+	return interval, timeout
 }
 
-// addTestRoute 添加临时测试路由规则和路由
-func (hc *HealthChecker) addTestRoute(target, iface, table string) error {
-	// 步骤1: 添加路由规则 - ip rule add to <target> lookup <table> pref 5
-	cmdRule := exec.Command("ip", "rule", "add", "to", target, "lookup", table, "pref", "5")
-	if output, err := cmdRule.CombinedOutput(); err != nil {
-		return fmt.Errorf("添加路由规则失败: %v, output: %s", err, output)
+// ping 通过出口设备同时ping所有目标（进程内 ICMP 探测），结果写入 results
+// count: 根据检测间隔计算的包数量
+func (hc *HealthChecker) ping(results []*CheckResult, netns string, count int, interval, timeout time.Duration) {
+	if len(results) == 0 {
+		return
 	}
+	iface := results[0].Interface
 
-	// 步骤2: 在指定路由表中添加到目标的路由
-	// 检查是否是物理接口（通过查找配置）
-	ifaceConfig, err := network.LoadInterfaceConfig()
-	var gateway string
-	isPhysical := false
-
-	if err == nil {
-		for _, physIface := range ifaceConfig.Interfaces {
-			if physIface.Name == iface {
-				isPhysical = true
-				gateway = physIface.Gateway
-				break
-			}
-		}
+	targets := make([]string, len(results))
+	for i, result := range results {
+		targets[i] = result.TargetIP
 	}
 
-	// 先删除可能存在的残留路由（忽略错误）
-	exec.Command("ip", "route", "del", target, "table", table).Run()
-
-	// 添加路由（支持 onlink 容错）
-	var cmdRoute *exec.Cmd
-	var output []byte
-	if isPhysical && gateway != "" {
-		// 物理接口：通过网关路由
-		// 第一次尝试：正常路由
-		cmdRoute = exec.Command("ip", "route", "add", target, "via", gateway, "dev", iface, "table", table)
-		output, err = cmdRoute.CombinedOutput()
-		if err != nil {
-			// 第二次尝试：添加 onlink 标志（支持 VPS/云服务器跨子网网关）
-			cmdRoute = exec.Command("ip", "route", "add", target, "via", gateway, "dev", iface, "table", table, "onlink")
-			output, err = cmdRoute.CombinedOutput()
-			if err != nil {
-				// 如果路由添加失败，需要清理已添加的规则
-				exec.Command("ip", "rule", "del", "to", target, "pref", "5").Run()
-				return fmt.Errorf("添加路由失败: %v, output: %s", err, output)
-			}
-		}
-	} else {
-		// 隧道或无网关的P2P连接：直接通过设备路由
-		cmdRoute = exec.Command("ip", "route", "add", target, "dev", iface, "table", table)
-		output, err = cmdRoute.CombinedOutput()
-		if err != nil {
-			// 如果路由添加失败，需要清理已添加的规则
-			exec.Command("ip", "rule", "del", "to", target, "pref", "5").Run()
-			return fmt.Errorf("添加路由失败: %v, output: %s", err, output)
-		}
+	probes, err := network.ProbeExit(iface, targets, network.ProbeOptions{
+		Count:    count,
+		Interval: interval,
+		Timeout:  timeout,
+		Netns:    netns,
+	})
+	if err != nil {
+		hc.logger.Debug("检测 %s 失败: %v", iface, err)
+		return
 	}
 
-	return nil
-}
-
-// removeTestRoute 删除临时测试路由规则和路由
-func (hc *HealthChecker) removeTestRoute(target, iface, table string) {
-	// 步骤1: 删除路由 - ip route del <target> table <table>
-	cmdRoute := exec.Command("ip", "route", "del", target, "table", table)
-	cmdRoute.Run() // 忽略错误
-
-	// 步骤2: 删除路由规则 - ip rule del to <target> pref 5
-	cmdRule := exec.Command("ip", "rule", "del", "to", target, "pref", "5")
-	cmdRule.Run() // 忽略错误
-}
-
-// getRouteTable 获取接口对应的路由表
-func (hc *HealthChecker) getRouteTable(iface string) string {
-	// 检查是否是隧道接口
-	if strings.HasPrefix(iface, "tun") || strings.HasPrefix(iface, "wg") {
-		return "80" // 虚拟IP路由表
+	for i, probe := range probes {
+		results[i].PacketLoss = probe.PacketLoss
+		results[i].Latency = probe.Latency
+		results[i].Jitter = probe.Jitter
+		// 成功：丢包率 < 100%（至少1个包通）
+		results[i].Success = probe.Received > 0
 	}
-	return "main" // 物理接口使用主路由表
 }
 
-// parsePingResult 解析ping输出，提取延迟和丢包率
-func (hc *HealthChecker) parsePingResult(output []byte, result *CheckResult) {
-	outputStr := string(output)
-
-	// 示例输出：
-	// 3 packets transmitted, 2 received, 33% packet loss, time 200ms
-	// rtt min/avg/max/mdev = 10.5/15.3/20.1/4.8 ms
-
-	// 1. 提取丢包率
-	// 匹配 "33% packet loss" 或 "33.3% packet loss"
-	lossRe := regexp.MustCompile(`(\d+(?:\.\d+)?)% packet loss`)
-	lossMatches := lossRe.FindStringSubmatch(outputStr)
-	if len(lossMatches) >= 2 {
-		if loss, err := strconv.ParseFloat(lossMatches[1], 64); err == nil {
-			result.PacketLoss = loss
-		}
-	}
-
-	// 2. 提取平均延迟
-	// 匹配 "rtt min/avg/max/mdev = 10.5/15.3/20.1/4.8 ms"
-	latencyRe := regexp.MustCompile(`rtt min/avg/max/mdev = [\d.]+/([\d.]+)/[\d.]+/[\d.]+ ms`)
-	latencyMatches := latencyRe.FindStringSubmatch(outputStr)
-	if len(latencyMatches) >= 2 {
-		if latency, err := strconv.ParseFloat(latencyMatches[1], 64); err == nil {
-			result.Latency = latency
-		}
-	}
-
-	// 3. 判断成功：丢包率 < 100%（至少1个包通）
-	if result.PacketLoss < 100.0 {
-		result.Success = true
+// interfaceNetns 返回隧道接口所在的网络命名空间，非隧道或默认命名空间返回空
+func interfaceNetns(iface string) string {
+	cfg, err := network.LoadTunnelConfig(iface)
+	if err != nil {
+		return ""
 	}
+	return cfg.Netns
 }
 
 // singleDNSQuery 执行单次 DNS 查询
+// 套接字绑定到出口接口，mark 非0时打上探测标记（有网关的物理接口，经探测规则路由）
 // 返回: 延迟（毫秒）、是否成功
-func (hc *HealthChecker) singleDNSQuery(dnsServer, domain string) (latency float64, success bool) {
+func (hc *HealthChecker) singleDNSQuery(dnsServer, domain, iface string, mark int) (latency float64, success bool) {
 	// 创建自定义 Resolver，强制使用指定的 DNS 服务器
 	resolver := &net.Resolver{
 		PreferGo: true,
@@ -315,6 +196,18 @@ func (hc *HealthChecker) singleDNSQuery(dnsServer, domain string) (latency float
 			// 超时设置为 1 秒
 			dialer := &net.Dialer{
 				Timeout: 1 * time.Second,
+				Control: func(_, _ string, c syscall.RawConn) error {
+					var bindErr error
+					if err := c.Control(func(fd uintptr) {
+						bindErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+						if bindErr == nil && mark != 0 {
+							bindErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+						}
+					}); err != nil {
+						return err
+					}
+					return bindErr
+				},
 			}
 			// 强制连接到指定的 DNS 服务器的 53 端口
 			return dialer.Dial("udp", dnsServer+":53")
@@ -350,44 +243,35 @@ func (hc *HealthChecker) singleDNSQuery(dnsServer, domain string) (latency float
 	return 0, false
 }
 
-// checkDNS DNS 检测方式（多次查询，计算平均延迟和失败率）
-// 返回: 检查结果（延迟、失败率）
-// count: 查询次数（自适应）
-func (hc *HealthChecker) checkDNS(iface, dnsServer, domain string, count int) *CheckResult {
-	result := &CheckResult{
-		Interface:  iface,
-		TargetIP:   dnsServer,
-		Success:    false,
-		Latency:    0,
-		PacketLoss: 100.0,
-	}
-
-	// 全局锁：因为 pref 5 必须全局唯一，所有检查必须串行化
-	hc.globalLock.Lock()
-	defer hc.globalLock.Unlock()
+// checkDNS DNS 检测方式（多次查询，计算平均延迟和失败率），结果写入 result
+// count: 查询次数（自适应）；interval/timeout 仅用于网络命名空间内改为 ping 的情况
+func (hc *HealthChecker) checkDNS(result *CheckResult, netns, domain string, count int, interval, timeout time.Duration) {
+	iface, dnsServer := result.Interface, result.TargetIP
 
-	// 先清理所有 pref 5 的残留规则（防止之前崩溃留下的）
-	hc.cleanupPref5Rules()
-
-	// 添加临时路由规则和路由
-	table := hc.getRouteTable(iface)
-	if err := hc.addTestRoute(dnsServer, iface, table); err != nil {
-		hc.logger.Debug("添加临时路由失败: %v", err)
-		return result
+	// 网络命名空间内无法在进程内查询，改为在命名空间内ping DNS服务器
+	if netns != "" {
+		hc.logger.Debug("接口 %s 位于网络命名空间 %s，DNS 检测改为 ping %s", iface, netns, dnsServer)
+		hc.ping([]*CheckResult{result}, netns, count, interval, timeout)
+		return
 	}
 
-	// 确保删除（即使 panic 也要删除）
-	defer hc.removeTestRoute(dnsServer, iface, table)
+	mark, err := network.ProbeMark(iface)
+	if err != nil {
+		hc.logger.Debug("检测 %s 失败: %v", iface, err)
+		return
+	}
 
 	// 执行多次 DNS 查询
 	var totalLatency float64
 	var successCount int
+	latencies := make([]float64, 0, count)
 
 	for i := 0; i < count; i++ {
-		latency, success := hc.singleDNSQuery(dnsServer, domain)
+		latency, success := hc.singleDNSQuery(dnsServer, domain, iface, mark)
 		if success {
 			totalLatency += latency
 			successCount++
+			latencies = append(latencies, latency)
 		}
 
 		// 查询间隔：50ms
@@ -401,7 +285,12 @@ func (hc *HealthChecker) checkDNS(iface, dnsServer, domain string, count int) *C
 		result.Success = true
 		result.Latency = totalLatency / float64(successCount)
 		result.PacketLoss = float64(count-successCount) / float64(count) * 100
-	}
 
-	return result
+		// 抖动: 延迟的标准差（与 ping mdev 含义一致）
+		var variance float64
+		for _, latency := range latencies {
+			variance += (latency - result.Latency) * (latency - result.Latency)
+		}
+		result.Jitter = math.Sqrt(variance / float64(successCount))
+	}
 }
@@ -0,0 +1,93 @@
+package failover
+
+import (
+	"fmt"
+
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/routing"
+)
+
+// allCandidatesDown 判断监控任务的所有候选出口是否均不可用
+func (d *FailoverDaemon) allCandidatesDown(monitor *MonitorConfig) bool {
+	for _, exit := range d.candidateExits(monitor) {
+		if d.stateManager.GetState(exit).PacketLoss < 100.0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isExitDownActive 监控任务是否已执行出口故障处理（exitDownActive 由 failoverMutex 保护）
+func (d *FailoverDaemon) isExitDownActive(name string) bool {
+	d.failoverMutex.Lock()
+	defer d.failoverMutex.Unlock()
+
+	return d.exitDownActive[name]
+}
+
+// enforceExitDown 所有候选出口均不可用时执行 on_exit_down（每次进入故障状态只执行一次）
+func (d *FailoverDaemon) enforceExitDown(monitor *MonitorConfig) {
+	d.failoverMutex.Lock()
+	defer d.failoverMutex.Unlock()
+
+	if d.exitDownActive[monitor.Name] {
+		return
+	}
+
+	pm := routing.NewPolicyManager()
+	groupName := ""
+	if monitor.Type == "default_route" {
+		cfg, err := config.Load()
+		if err != nil {
+			d.logger.Error("加载配置失败: %v", err)
+			return
+		}
+		pm.SetDefaultExit(cfg.Routing.DefaultExit)
+		pm.SetDefaultOnExitDown(cfg.Routing.DefaultOnExitDown)
+	} else {
+		if err := pm.LoadGroup(monitor.Target); err != nil {
+			d.logger.Error("策略组 %s 不存在: %v", monitor.Target, err)
+			return
+		}
+		groupName = monitor.Target
+	}
+
+	handled, err := pm.ApplyExitDown(groupName, "所有候选出口均不可用")
+	if err != nil {
+		message := fmt.Sprintf("出口故障处理失败: %v", err)
+		d.logger.Error("%s", message)
+		d.stateManager.RecordEvent(monitor.Name, "exit_down", message)
+		return
+	}
+
+	// leak：保持原有行为，不修改路由
+	if !handled {
+		return
+	}
+
+	d.exitDownActive[monitor.Name] = true
+	message := "所有候选出口均不可用，已执行出口故障处理"
+	d.logger.Warn("【阻断】监控任务 %s: %s", monitor.Name, message)
+	d.stateManager.RecordEvent(monitor.Name, "exit_down", message)
+}
+
+// restoreFromExitDown 有候选出口恢复后，撤销 on_exit_down 并应用到最佳出口
+func (d *FailoverDaemon) restoreFromExitDown(monitor *MonitorConfig, bestExit string) {
+	d.failoverMutex.Lock()
+	defer d.failoverMutex.Unlock()
+
+	if err := d.applyExit(monitor, bestExit); err != nil {
+		message := fmt.Sprintf("恢复到出口 %s 失败: %v", bestExit, err)
+		d.logger.Error("%s", message)
+		d.stateManager.RecordEvent(monitor.Name, "exit_down", message)
+		return
+	}
+
+	delete(d.exitDownActive, monitor.Name)
+	d.setCachedExit(monitor.Name, bestExit)
+	d.stateManager.RecordSwitch(monitor.Name)
+
+	message := fmt.Sprintf("候选出口已恢复，解除阻断并切换到 %s", bestExit)
+	d.logger.Info("【恢复】监控任务 %s: %s", monitor.Name, message)
+	d.stateManager.RecordEvent(monitor.Name, "exit_down", message)
+}
@@ -0,0 +1,310 @@
+package failover
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/scoring"
+)
+
+// 共享检测调度
+// 检测按 (出口, 检测模式, 目标集合) 去重: 多个监控任务监控同一出口且检测配置相同时只检测一次，
+// 间隔取订阅的监控任务中最短的检测间隔。检测结果汇总后写入 StateManager 的出口状态，再通知所有订阅的监控任务；
+// 监控任务在所有候选出口都有新结果后评估一次（切换确认按评估次数累计）
+// 出口状态按出口共享，因此检测策略（any/all/quorum/weighted 及权重）也是检测键的一部分；
+// 同一出口被多组不同的检测配置监控时各自检测，出口状态以最近一次检测为准
+// 启用 daemon.adaptive 时每次检测后按出口状态重新计算下一次检测的间隔（见 adaptive.go）
+
+// probeTask 共享检测任务
+type probeTask struct {
+	key         string
+	exit        string
+	mode        string
+	targets     []string
+	dnsDomain   string
+	policy      CheckPolicy
+	intervalMs  int              // 订阅的监控任务中最短的检测间隔（基准间隔）
+	subscribers []string         // 订阅的监控任务
+	monitors    []*MonitorConfig // 订阅的监控任务配置（自适应间隔按其当前出口和候选出口计算）
+	notify      []chan struct{}  // 订阅的监控任务的通知通道
+	stop        chan struct{}
+	nextMs      int    // 当前的实际检测间隔（自适应间隔调整，仅检测 goroutine 访问）
+	downStreak  int    // 连续判定为不可用的次数（退避用，仅检测 goroutine 访问）
+	lastPace    string // 上一次的自适应节奏（仅检测 goroutine 访问）
+}
+
+// monitorLoop 监控任务的评估循环
+type monitorLoop struct {
+	notify chan struct{} // 订阅的检测有新结果（缓冲1，评估期间的多次通知合并为一次）
+	stop   chan struct{}
+}
+
+// ProbeInfo 共享检测任务的运行信息（写入状态文件，status 显示）
+type ProbeInfo struct {
+	Exit        string    `json:"exit"`
+	Mode        string    `json:"mode"`
+	Targets     []string  `json:"targets"`
+	Policy      string    `json:"policy"`
+	IntervalMs  int       `json:"interval_ms"`
+	Subscribers []string  `json:"subscribers"`
+	Runs        uint64    `json:"runs"`
+	LastRun     time.Time `json:"last_run"`
+	NextMs      int       `json:"next_ms,omitempty"` // 自适应间隔: 下一次检测的间隔
+	Pace        string    `json:"pace,omitempty"`    // 自适应间隔: 加速 / 放松 / 退避（基准间隔时为空）
+}
+
+// probeKeyOf 检测键: 出口、检测模式、排序后的目标集合、DNS 查询域名和检测策略
+func probeKeyOf(exit, mode string, targets []string, dnsDomain string, policy CheckPolicy) string {
+	sorted := append([]string(nil), targets...)
+	sort.Strings(sorted)
+
+	weights := make([]string, 0, len(policy.Weights))
+	for target, weight := range policy.Weights {
+		weights = append(weights, fmt.Sprintf("%s=%g", target, weight))
+	}
+	sort.Strings(weights)
+
+	return strings.Join([]string{exit, mode, strings.Join(sorted, ","), dnsDomain, policy.String(), strings.Join(weights, ",")}, "|")
+}
+
+// monitorProbe 监控任务的检测配置（ping 模式用 check_targets，dns 模式用 dns_servers）
+func (d *FailoverDaemon) monitorProbe(monitor *MonitorConfig) (mode string, targets []string, dnsDomain string, policy CheckPolicy) {
+	mode = monitor.GetCheckMode(d.config.Daemon.CheckMode)
+	if mode == "dns" {
+		targets = monitor.DNSServers
+		dnsDomain = monitor.GetDNSQueryDomain(d.config.Daemon.DNSQueryDomain)
+	} else {
+		targets = monitor.CheckTargets
+	}
+	policy = monitor.GetCheckPolicy(d.config.Daemon.CheckPolicy)
+	return mode, targets, dnsDomain, policy
+}
+
+// startProbes 按当前配置和候选出口（含定时任务覆盖）建立共享检测任务并启动
+func (d *FailoverDaemon) startProbes() {
+	d.probeMutex.Lock()
+	defer d.probeMutex.Unlock()
+
+	tasks := make(map[string]*probeTask)
+	order := make([]string, 0)
+	subscriptions := 0
+	for i := range d.config.Monitors {
+		monitor := &d.config.Monitors[i]
+		loop, exists := d.monitors[monitor.Name]
+		if !exists {
+			continue
+		}
+
+		mode, targets, dnsDomain, policy := d.monitorProbe(monitor)
+		interval := monitor.GetCheckInterval(d.config.Daemon.CheckIntervalMs)
+		for _, exit := range d.candidateExits(monitor) {
+			key := probeKeyOf(exit, mode, targets, dnsDomain, policy)
+			task, exists := tasks[key]
+			if !exists {
+				task = &probeTask{
+					key:        key,
+					exit:       exit,
+					mode:       mode,
+					targets:    targets,
+					dnsDomain:  dnsDomain,
+					policy:     policy,
+					intervalMs: interval,
+					nextMs:     interval,
+					stop:       make(chan struct{}),
+				}
+				tasks[key] = task
+				order = append(order, key)
+			}
+			if interval < task.intervalMs {
+				task.intervalMs = interval
+				task.nextMs = interval
+			}
+			task.subscribers = append(task.subscribers, monitor.Name)
+			task.monitors = append(task.monitors, monitor)
+			task.notify = append(task.notify, loop.notify)
+			subscriptions++
+		}
+	}
+
+	// 同一出口有多组检测配置时提示（出口状态以最近一次检测为准）
+	perExit := make(map[string]int)
+	for _, task := range tasks {
+		perExit[task.exit]++
+	}
+	for exit, count := range perExit {
+		if count > 1 {
+			d.logger.Warn("出口 %s 被 %d 组不同的检测配置监控，出口状态以最近一次检测为准", exit, count)
+		}
+	}
+
+	infos := make(map[string]*ProbeInfo, len(tasks))
+	for _, key := range order {
+		task := tasks[key]
+		infos[key] = &ProbeInfo{
+			Exit:        task.exit,
+			Mode:        task.mode,
+			Targets:     task.targets,
+			Policy:      task.policy.String(),
+			IntervalMs:  task.intervalMs,
+			Subscribers: task.subscribers,
+		}
+		d.logger.Debug("共享检测: %s %s %v 间隔 %dms (订阅: %s)",
+			task.exit, task.mode, task.targets, task.intervalMs, strings.Join(task.subscribers, ", "))
+
+		go func(task *probeTask) {
+			timer := time.NewTimer(time.Duration(task.intervalMs) * time.Millisecond)
+			defer timer.Stop()
+			for {
+				select {
+				case <-timer.C:
+					started := time.Now()
+					next := d.runProbe(task)
+					timer.Reset(max(next-time.Since(started), 0))
+				case <-task.stop:
+					return
+				}
+			}
+		}(task)
+	}
+
+	d.probes = tasks
+	d.stateManager.SetProbes(infos)
+	d.logger.Info("共享检测: %d 个检测任务 (监控任务共订阅 %d 次)", len(tasks), subscriptions)
+}
+
+// stopProbes 停止所有共享检测任务（进行中的检测完成后退出）
+func (d *FailoverDaemon) stopProbes() {
+	d.probeMutex.Lock()
+	defer d.probeMutex.Unlock()
+
+	for _, task := range d.probes {
+		close(task.stop)
+	}
+	d.probes = make(map[string]*probeTask)
+}
+
+// restartProbes 重建共享检测任务（监控任务或候选出口变化后调用）
+func (d *FailoverDaemon) restartProbes() {
+	d.stopProbes()
+	d.startProbes()
+}
+
+// runProbe 执行一次共享检测: 更新出口状态和振荡惩罚，然后通知订阅的监控任务
+// 返回到下一次检测的间隔（从本次检测开始计算）
+func (d *FailoverDaemon) runProbe(task *probeTask) time.Duration {
+	// 执行健康检查（支持 ping 和 dns 模式，报文数量和等待时间按当前的实际间隔计算）
+	checkResult := d.healthChecker.CheckInterface(task.exit, task.mode, task.targets, task.dnsDomain, task.nextMs, task.policy)
+
+	// 获取成本（含流量配额上调）
+	cost, quotaExhausted := network.EffectiveExitCost(task.exit)
+
+	// 更新状态（计算评分）
+	isFirstCheck := d.stateManager.UpdateState(task.exit, checkResult, cost, quotaExhausted, scoring.Merge(d.config.Daemon.Scoring))
+
+	// 更新振荡惩罚
+	d.updateDampening(task.exit)
+
+	// 计算下一次检测的间隔
+	next, pace := d.nextProbeInterval(task)
+	task.nextMs = int(next / time.Millisecond)
+	d.stateManager.RecordProbe(task.key, task.nextMs, pace)
+	if pace != "" && pace != task.lastPace {
+		d.logger.Debug("共享检测: %s 切换为%s节奏 (下次间隔 %dms)", task.exit, pace, next/time.Millisecond)
+	} else if pace == "" && task.lastPace != "" {
+		d.logger.Debug("共享检测: %s 恢复基准间隔 %dms", task.exit, next/time.Millisecond)
+	}
+	task.lastPace = pace
+
+	if isFirstCheck {
+		state := d.stateManager.GetState(task.exit)
+		d.logger.Debug("  接口 %s: 初始检测完成 [延迟: %.1fms, 丢包: %.0f%%, Cost: %d, 全局评分: %.1f]",
+			task.exit, state.Latency, state.PacketLoss, state.Cost, state.FinalScore)
+	}
+
+	for _, notify := range task.notify {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+	return next
+}
+
+// SetProbes 替换共享检测任务的运行信息
+func (sm *StateManager) SetProbes(probes map[string]*ProbeInfo) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sm.probes = probes
+}
+
+// RecordProbe 记录共享检测任务执行一次及下一次检测的间隔
+func (sm *StateManager) RecordProbe(key string, nextMs int, pace string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if probe, exists := sm.probes[key]; exists {
+		probe.Runs++
+		probe.LastRun = time.Now()
+		probe.NextMs = nextMs
+		probe.Pace = pace
+	}
+}
+
+// CheckedSince 所有接口是否都在 since 之后完成过检测
+// 已完成初始检测且完全不可用的接口不要求新结果（自适应间隔下这类出口退避检测，不应拖慢评估）
+func (sm *StateManager) CheckedSince(ifaces []string, since time.Time) bool {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	fresh := false
+	for _, iface := range ifaces {
+		state, exists := sm.states[iface]
+		if !exists {
+			return false
+		}
+		if state.LastCheckTime.After(since) {
+			fresh = true
+			continue
+		}
+		if !state.InitialCheckDone || state.PacketLoss < 100.0 {
+			return false
+		}
+	}
+	return fresh
+}
+
+// printProbes 打印共享检测任务（policy failover status）
+func printProbes(probes map[string]*ProbeInfo) {
+	if len(probes) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(probes))
+	subscriptions := 0
+	for key, probe := range probes {
+		keys = append(keys, key)
+		subscriptions += len(probe.Subscribers)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("【共享检测】%d 个检测任务 (监控任务共订阅 %d 次)\n", len(probes), subscriptions)
+	for _, key := range keys {
+		probe := probes[key]
+		lastRun := "未执行"
+		if !probe.LastRun.IsZero() {
+			lastRun = probe.LastRun.Format("15:04:05")
+		}
+		interval := fmt.Sprintf("每 %dms", probe.IntervalMs)
+		if probe.Pace != "" {
+			interval += fmt.Sprintf(" → %s %dms", probe.Pace, probe.NextMs)
+		}
+		fmt.Printf("  %s %s [%s] %s, %s (订阅: %s, 已执行 %d 次, 最近: %s)\n",
+			probe.Exit, probe.Mode, strings.Join(probe.Targets, ", "), probe.Policy, interval,
+			strings.Join(probe.Subscribers, ", "), probe.Runs, lastRun)
+	}
+	fmt.Println()
+}
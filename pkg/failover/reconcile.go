@@ -0,0 +1,96 @@
+package failover
+
+import (
+	"fmt"
+	"time"
+
+	"trueword_node/pkg/drift"
+)
+
+// startDriftReconcile 启动漂移检测循环
+func (d *FailoverDaemon) startDriftReconcile() {
+	interval := d.config.Daemon.DriftCheckIntervalSec
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	stop := make(chan struct{})
+	d.driftTicker = ticker
+	d.driftStop = stop
+
+	d.logger.Info("启动漂移检测 (间隔: %ds, 自动修复: %v)", interval, d.config.Daemon.DriftAutoFix)
+
+	go func(t *time.Ticker) {
+		for {
+			select {
+			case <-t.C:
+				d.reconcile()
+			case <-stop:
+				return
+			}
+		}
+	}(ticker)
+}
+
+// stopDriftReconcile 停止漂移检测循环
+func (d *FailoverDaemon) stopDriftReconcile() {
+	if d.driftTicker != nil {
+		d.driftTicker.Stop()
+		close(d.driftStop)
+		d.driftTicker = nil
+		d.driftStop = nil
+	}
+}
+
+// reconcile 执行一次漂移检测（及修复）
+func (d *FailoverDaemon) reconcile() {
+	// 与故障转移互斥，避免修复过程中出口被切换
+	d.failoverMutex.Lock()
+	defer d.failoverMutex.Unlock()
+
+	report, err := drift.Check()
+	if err != nil {
+		d.logger.Error("【漂移检测】检测失败: %v", err)
+		return
+	}
+
+	if !report.HasDrift() {
+		d.logger.Debug("【漂移检测】内核状态与配置一致")
+		return
+	}
+
+	d.logger.Warn("【漂移检测】%s", report.Summary())
+
+	if !d.config.Daemon.DriftAutoFix {
+		d.stateManager.RecordEvent("drift", "drift", report.Summary())
+		return
+	}
+
+	// 修复时保留故障转移后的当前出口；已执行 on_exit_down 的监控任务不修复（当前出口不可用）
+	// 调用方已持有 failoverMutex，直接读取 exitDownActive
+	opts := &drift.FixOptions{ExitOverrides: make(map[string]string), SkipRepair: make(map[string]bool)}
+	currentExits := d.currentExitsSnapshot()
+	for _, monitor := range d.config.Monitors {
+		target := monitor.Target
+		if monitor.Type == "default_route" {
+			target = "default"
+		}
+		if d.exitDownActive[monitor.Name] {
+			opts.SkipRepair[target] = true
+			continue
+		}
+		if exit, exists := currentExits[monitor.Name]; exists {
+			opts.ExitOverrides[target] = exit
+		}
+	}
+
+	fixed, errs := report.Fix(opts)
+	for _, err := range errs {
+		d.logger.Error("【漂移修复】%v", err)
+	}
+
+	message := fmt.Sprintf("漂移修复: 修复 %d/%d 项 (%s)", fixed, len(report.Items), report.Summary())
+	d.logger.Info("【漂移修复】修复 %d/%d 项", fixed, len(report.Items))
+	d.stateManager.RecordEvent("drift", "drift", message)
+}
@@ -0,0 +1,387 @@
+package failover
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
+)
+
+// 定时任务: 按 cron 表达式在指定时间应用/撤销策略组、切换默认路由出口、覆盖出口成本或监控任务的候选出口
+// 成本覆盖保存在 network.CostOverrideFile（手动 failover 的评分同样生效），候选出口覆盖和执行记录保存在 ScheduleStateFile，
+// 守护进程重启后保留；启动和重载配置时清除没有定时任务再设置的覆盖（也可用 'policy failover schedule clear' 手动清除）
+
+const (
+	ScheduleStateFile = "/var/lib/trueword_node/schedule_state.json"
+
+	// 定时任务检查间隔（执行时间精度）
+	scheduleTickInterval = 15 * time.Second
+)
+
+// ScheduleRun 定时任务的最近一次执行
+type ScheduleRun struct {
+	LastRun time.Time `json:"last_run"`
+	Success bool      `json:"success"`
+	Result  string    `json:"result"`
+}
+
+// ScheduleState 定时任务运行时状态
+type ScheduleState struct {
+	Runs               map[string]*ScheduleRun `json:"runs"`                // 定时任务名 -> 最近一次执行
+	CandidateOverrides map[string][]string     `json:"candidate_overrides"` // 监控任务名 -> 覆盖的候选出口
+}
+
+// LoadScheduleState 加载定时任务状态（不存在时返回空状态）
+func LoadScheduleState() *ScheduleState {
+	state := &ScheduleState{}
+	if data, err := os.ReadFile(ScheduleStateFile); err == nil {
+		json.Unmarshal(data, state)
+	}
+	if state.Runs == nil {
+		state.Runs = make(map[string]*ScheduleRun)
+	}
+	if state.CandidateOverrides == nil {
+		state.CandidateOverrides = make(map[string][]string)
+	}
+	return state
+}
+
+// save 保存定时任务状态
+func (s *ScheduleState) save() error {
+	if err := os.MkdirAll(filepath.Dir(ScheduleStateFile), 0755); err != nil {
+		return fmt.Errorf("创建状态目录失败: %v", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化定时任务状态失败: %v", err)
+	}
+	return os.WriteFile(ScheduleStateFile, data, 0644)
+}
+
+// splitExits 解析逗号分隔的出口列表
+func splitExits(value string) []string {
+	exits := make([]string, 0)
+	for _, exit := range strings.Split(value, ",") {
+		if exit = strings.TrimSpace(exit); exit != "" {
+			exits = append(exits, exit)
+		}
+	}
+	return exits
+}
+
+// Describe 定时任务动作的简要描述
+func (s *ScheduleConfig) Describe() string {
+	switch s.Action {
+	case ScheduleEnableGroup:
+		return fmt.Sprintf("应用策略组 %s", s.Target)
+	case ScheduleDisableGroup:
+		return fmt.Sprintf("撤销策略组 %s", s.Target)
+	case ScheduleDefaultExit:
+		return fmt.Sprintf("默认路由出口 → %s", s.Target)
+	case ScheduleSetCost:
+		if s.Value == ScheduleValueReset {
+			return fmt.Sprintf("恢复 %s 的配置成本", s.Target)
+		}
+		return fmt.Sprintf("%s 成本 → %s", s.Target, s.Value)
+	case ScheduleSetCandidates:
+		if s.Value == ScheduleValueReset {
+			return fmt.Sprintf("恢复 %s 的配置候选出口", s.Target)
+		}
+		return fmt.Sprintf("%s 候选出口 → [%s]", s.Target, strings.Join(splitExits(s.Value), ", "))
+	}
+	return s.Action
+}
+
+// NextRun 定时任务的下一次执行时间（表达式无效时返回零值）
+func (s *ScheduleConfig) NextRun(after time.Time) time.Time {
+	spec, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	return spec.Next(after)
+}
+
+// startScheduler 启动定时任务循环
+func (d *FailoverDaemon) startScheduler() {
+	if len(d.config.Schedules) == 0 {
+		return
+	}
+
+	// 每个循环持有自己的任务列表和执行时间，重载时整体替换
+	schedules := append([]ScheduleConfig(nil), d.config.Schedules...)
+	next := make(map[string]time.Time)
+	now := time.Now()
+	for _, schedule := range schedules {
+		next[schedule.Name] = schedule.NextRun(now)
+		d.logger.Info("定时任务 %s: %s (下次执行: %s)", schedule.Name, schedule.Describe(),
+			next[schedule.Name].Format("2006-01-02 15:04"))
+	}
+
+	ticker := time.NewTicker(scheduleTickInterval)
+	stop := make(chan struct{})
+	d.scheduleTicker = ticker
+	d.scheduleStop = stop
+
+	go func(t *time.Ticker) {
+		for {
+			select {
+			case <-t.C:
+			case <-stop:
+				return
+			}
+			now := time.Now()
+			for i := range schedules {
+				schedule := &schedules[i]
+				if next[schedule.Name].IsZero() || now.Before(next[schedule.Name]) {
+					continue
+				}
+				d.runSchedule(schedule)
+				next[schedule.Name] = schedule.NextRun(now)
+			}
+		}
+	}(ticker)
+}
+
+// stopScheduler 停止定时任务循环
+func (d *FailoverDaemon) stopScheduler() {
+	if d.scheduleTicker != nil {
+		d.scheduleTicker.Stop()
+		close(d.scheduleStop)
+		d.scheduleTicker = nil
+		d.scheduleStop = nil
+	}
+}
+
+// runSchedule 执行定时任务并记录结果
+func (d *FailoverDaemon) runSchedule(schedule *ScheduleConfig) {
+	d.logger.Info("【定时任务】%s: %s", schedule.Name, schedule.Describe())
+
+	run := &ScheduleRun{LastRun: time.Now(), Success: true, Result: schedule.Describe()}
+	if err := d.executeSchedule(schedule); err != nil {
+		run.Success = false
+		run.Result = err.Error()
+		d.logger.Error("【定时任务】%s 执行失败: %v", schedule.Name, err)
+	}
+
+	// 候选出口变化后重建共享检测任务
+	if run.Success && schedule.Action == ScheduleSetCandidates {
+		d.restartProbes()
+	}
+
+	d.overrideMutex.Lock()
+	d.scheduleState.Runs[schedule.Name] = run
+	if err := d.scheduleState.save(); err != nil {
+		d.logger.Error("保存定时任务状态失败: %v", err)
+	}
+	d.overrideMutex.Unlock()
+
+	message := fmt.Sprintf("定时任务 %s: %s", schedule.Name, run.Result)
+	if !run.Success {
+		message = fmt.Sprintf("定时任务 %s 执行失败: %s", schedule.Name, run.Result)
+	}
+	d.stateManager.RecordEvent("schedule", "schedule", message)
+}
+
+// executeSchedule 执行定时任务的动作（与故障转移互斥）
+func (d *FailoverDaemon) executeSchedule(schedule *ScheduleConfig) error {
+	d.failoverMutex.Lock()
+	defer d.failoverMutex.Unlock()
+
+	switch schedule.Action {
+	case ScheduleEnableGroup, ScheduleDisableGroup:
+		pm := routing.NewPolicyManager()
+		if err := pm.LoadGroup(schedule.Target); err != nil {
+			return fmt.Errorf("策略组 %s 不存在", schedule.Target)
+		}
+		if schedule.Action == ScheduleDisableGroup {
+			return pm.RevokeGroup(schedule.Target)
+		}
+		return pm.ApplyGroup(pm.GetGroup(schedule.Target))
+
+	case ScheduleDefaultExit:
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("加载配置失败: %v", err)
+		}
+		cfg.Routing.DefaultExit = schedule.Target
+		if err := cfg.Save(); err != nil {
+			return fmt.Errorf("保存配置失败: %v", err)
+		}
+
+		pm := routing.NewPolicyManager()
+		pm.SetDefaultExit(cfg.Routing.DefaultExit)
+		pm.SetDefaultOnExitDown(cfg.Routing.DefaultOnExitDown)
+		if err := pm.ApplyDefaultRouteOnly(); err != nil {
+			return fmt.Errorf("应用默认路由失败: %v", err)
+		}
+
+		for _, monitor := range d.config.Monitors {
+			if monitor.Type == "default_route" {
+				d.logger.Warn("【定时任务】监控任务 %s 管理默认路由，评分更优时会切换回其他出口（可改用 set_candidates）", monitor.Name)
+			}
+		}
+		return nil
+
+	case ScheduleSetCost:
+		if schedule.Value == ScheduleValueReset {
+			return network.ClearCostOverride(schedule.Target)
+		}
+		cost, err := strconv.Atoi(schedule.Value)
+		if err != nil {
+			return fmt.Errorf("无效的成本值: %s", schedule.Value)
+		}
+		return network.SetCostOverride(schedule.Target, cost)
+
+	case ScheduleSetCandidates:
+		d.overrideMutex.Lock()
+		defer d.overrideMutex.Unlock()
+
+		if schedule.Value == ScheduleValueReset {
+			delete(d.scheduleState.CandidateOverrides, schedule.Target)
+		} else {
+			d.scheduleState.CandidateOverrides[schedule.Target] = splitExits(schedule.Value)
+		}
+		d.confirmationCounters[schedule.Target] = 0
+		return nil
+	}
+
+	return fmt.Errorf("未知的动作: %s", schedule.Action)
+}
+
+// overrideTargets 配置中仍设置覆盖的定时任务目标（value 为 reset 的定时任务不计入）
+func overrideTargets(failoverConfig *FailoverConfig, action string) map[string]bool {
+	targets := make(map[string]bool)
+	for _, schedule := range failoverConfig.Schedules {
+		if schedule.Action == action && schedule.Value != ScheduleValueReset {
+			targets[schedule.Target] = true
+		}
+	}
+	return targets
+}
+
+// pruneScheduleOverrides 清除失效的覆盖: 监控任务已删除或没有定时任务再设置的候选出口覆盖、
+// 没有定时任务再设置的成本覆盖（守护进程启动和重载配置时调用，避免删除定时任务后覆盖永久生效）
+func (d *FailoverDaemon) pruneScheduleOverrides() {
+	candidates := overrideTargets(d.config, ScheduleSetCandidates)
+
+	d.overrideMutex.Lock()
+	pruned := false
+	for name := range d.scheduleState.CandidateOverrides {
+		if d.config.GetMonitor(name) != nil && candidates[name] {
+			continue
+		}
+		delete(d.scheduleState.CandidateOverrides, name)
+		pruned = true
+		d.logger.Info("清除监控任务 %s 的候选出口覆盖（没有定时任务设置该覆盖）", name)
+	}
+	if pruned {
+		if err := d.scheduleState.save(); err != nil {
+			d.logger.Error("保存定时任务状态失败: %v", err)
+		}
+	}
+	d.overrideMutex.Unlock()
+
+	costs := overrideTargets(d.config, ScheduleSetCost)
+	for exit := range network.LoadCostOverrides() {
+		if costs[exit] {
+			continue
+		}
+		if err := network.ClearCostOverride(exit); err != nil {
+			d.logger.Error("清除出口 %s 的成本覆盖失败: %v", exit, err)
+			continue
+		}
+		d.logger.Info("清除出口 %s 的成本覆盖（没有定时任务设置该覆盖）", exit)
+	}
+}
+
+// ClearScheduleOverrides 清除定时任务设置的所有候选出口覆盖和成本覆盖（恢复配置值）
+// 守护进程运行时通知其重新加载
+func ClearScheduleOverrides() error {
+	state := LoadScheduleState()
+	candidates := len(state.CandidateOverrides)
+	state.CandidateOverrides = make(map[string][]string)
+	if err := state.save(); err != nil {
+		return err
+	}
+
+	costs := network.LoadCostOverrides()
+	for exit := range costs {
+		if err := network.ClearCostOverride(exit); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✓ 已清除 %d 个候选出口覆盖、%d 个成本覆盖\n", candidates, len(costs))
+
+	if _, err := GetRunningPID(); err == nil {
+		return ReloadDaemon()
+	}
+	return nil
+}
+
+// candidateExits 监控任务当前使用的候选出口（定时任务覆盖优先）
+func (d *FailoverDaemon) candidateExits(monitor *MonitorConfig) []string {
+	d.overrideMutex.RLock()
+	defer d.overrideMutex.RUnlock()
+
+	if exits, ok := d.scheduleState.CandidateOverrides[monitor.Name]; ok {
+		return exits
+	}
+	return monitor.CandidateExits
+}
+
+// printSchedules 打印定时任务及下次执行时间
+func printSchedules(failoverConfig *FailoverConfig) {
+	if len(failoverConfig.Schedules) == 0 {
+		return
+	}
+
+	state := LoadScheduleState()
+	now := time.Now()
+
+	fmt.Println("【定时任务】")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("名称", "表达式", "动作", "下次执行", "上次执行", "结果")
+	for _, schedule := range failoverConfig.Schedules {
+		next := "-"
+		if t := schedule.NextRun(now); !t.IsZero() {
+			next = t.Format("2006-01-02 15:04")
+		}
+
+		last, result := "-", "-"
+		if run, ok := state.Runs[schedule.Name]; ok {
+			last = run.LastRun.Format("2006-01-02 15:04")
+			result = "✓"
+			if !run.Success {
+				result = "✗ " + run.Result
+			}
+		}
+
+		table.Append(schedule.Name, schedule.Cron, schedule.Describe(), next, last, result)
+	}
+	table.Render()
+
+	for _, monitor := range failoverConfig.Monitors {
+		if exits, ok := state.CandidateOverrides[monitor.Name]; ok {
+			fmt.Printf("  ℹ 监控任务 %s 的候选出口已被定时任务覆盖: [%s]\n", monitor.Name, strings.Join(exits, ", "))
+		}
+	}
+	overrides := network.LoadCostOverrides()
+	exits := make([]string, 0, len(overrides))
+	for exit := range overrides {
+		exits = append(exits, exit)
+	}
+	sort.Strings(exits)
+	for _, exit := range exits {
+		fmt.Printf("  ℹ 出口 %s 的成本已被定时任务覆盖: %d\n", exit, overrides[exit])
+	}
+	fmt.Println()
+}
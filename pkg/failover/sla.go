@@ -0,0 +1,278 @@
+package failover
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SLA 出口选择策略
+// 候选出口按配置顺序表示优先级，守护进程停留在满足 SLA 的最高优先级出口上:
+//   - 当前出口违反 SLA（连续 switch_confirmation_count 次）时，切换到满足 SLA 的最高优先级出口
+//   - 更高优先级的出口持续满足 SLA 达到 preempt_hold_sec 后抢占回去
+//   - 没有出口满足 SLA 时保持当前出口（当前出口不可用时按优先级选择第一个可用出口）
+
+const (
+	SelectionScore = "score" // 评分最优（默认）
+	SelectionSLA   = "sla"   // 按优先级选择满足 SLA 的出口
+
+	// 默认抢占等待时间（秒）
+	DefaultPreemptHoldSec = 60
+)
+
+// SLAConfig SLA 阈值（0 表示不检查该项）
+type SLAConfig struct {
+	MaxLossPercent float64 `yaml:"max_loss_percent,omitempty"` // 丢包率需低于此值（%）
+	MaxLatencyMs   float64 `yaml:"max_latency_ms,omitempty"`   // 平均延迟需低于此值（ms）
+	MaxJitterMs    float64 `yaml:"max_jitter_ms,omitempty"`    // 抖动需低于此值（ms）
+	PreemptHoldSec int     `yaml:"preempt_hold_sec,omitempty"` // 更高优先级出口持续满足 SLA 多久后抢占（默认60秒）
+}
+
+// Validate 校验 SLA 配置（prefix 为配置路径，用于错误信息）
+func (s *SLAConfig) Validate(prefix string) []string {
+	if s == nil {
+		return []string{prefix + " 未配置（selection_policy 为 sla 时必须设置阈值）"}
+	}
+
+	errors := make([]string, 0)
+	if s.MaxLossPercent == 0 && s.MaxLatencyMs == 0 && s.MaxJitterMs == 0 {
+		errors = append(errors, prefix+" 至少需要设置 max_loss_percent / max_latency_ms / max_jitter_ms 之一")
+	}
+	if s.MaxLossPercent < 0 || s.MaxLossPercent > 100 {
+		errors = append(errors, prefix+".max_loss_percent 必须在 0-100 范围内")
+	}
+	if s.MaxLatencyMs < 0 {
+		errors = append(errors, prefix+".max_latency_ms 不能为负数")
+	}
+	if s.MaxJitterMs < 0 {
+		errors = append(errors, prefix+".max_jitter_ms 不能为负数")
+	}
+	if s.PreemptHoldSec < 0 || s.PreemptHoldSec > 86400 {
+		errors = append(errors, prefix+".preempt_hold_sec 必须在 0-86400 范围内")
+	}
+	return errors
+}
+
+// PreemptHold 抢占等待时间
+func (s *SLAConfig) PreemptHold() time.Duration {
+	if s.PreemptHoldSec > 0 {
+		return time.Duration(s.PreemptHoldSec) * time.Second
+	}
+	return DefaultPreemptHoldSec * time.Second
+}
+
+// Check 检查出口状态是否满足 SLA
+// 返回: 是否满足, 违反原因
+func (s *SLAConfig) Check(state *InterfaceState) (bool, string) {
+	if state.PacketLoss >= 100.0 {
+		return false, "DOWN"
+	}
+	if state.QuotaExhausted {
+		return false, "配额耗尽"
+	}
+
+	reasons := make([]string, 0)
+	if s.MaxLossPercent > 0 && state.PacketLoss >= s.MaxLossPercent {
+		reasons = append(reasons, fmt.Sprintf("丢包 %.0f%% ≥ %.0f%%", state.PacketLoss, s.MaxLossPercent))
+	}
+	if s.MaxLatencyMs > 0 && state.Latency >= s.MaxLatencyMs {
+		reasons = append(reasons, fmt.Sprintf("延迟 %.1fms ≥ %.0fms", state.Latency, s.MaxLatencyMs))
+	}
+	if s.MaxJitterMs > 0 && state.Jitter >= s.MaxJitterMs {
+		reasons = append(reasons, fmt.Sprintf("抖动 %.1fms ≥ %.0fms", state.Jitter, s.MaxJitterMs))
+	}
+	if len(reasons) > 0 {
+		return false, strings.Join(reasons, ", ")
+	}
+	return true, ""
+}
+
+// Summary SLA 的简要描述
+func (s *SLAConfig) Summary() string {
+	if s == nil {
+		return "未配置"
+	}
+	parts := make([]string, 0)
+	if s.MaxLossPercent > 0 {
+		parts = append(parts, fmt.Sprintf("丢包 < %.0f%%", s.MaxLossPercent))
+	}
+	if s.MaxLatencyMs > 0 {
+		parts = append(parts, fmt.Sprintf("延迟 < %.0fms", s.MaxLatencyMs))
+	}
+	if s.MaxJitterMs > 0 {
+		parts = append(parts, fmt.Sprintf("抖动 < %.0fms", s.MaxJitterMs))
+	}
+	return fmt.Sprintf("%s, 抢占等待 %s", strings.Join(parts, ", "), s.PreemptHold())
+}
+
+// GetSelectionPolicy 获取出口选择策略
+func (m *MonitorConfig) GetSelectionPolicy() string {
+	if m.SelectionPolicy == "" {
+		return SelectionScore
+	}
+	return m.SelectionPolicy
+}
+
+// slaSince 监控任务各出口持续满足 SLA 的起始时间（不存在时创建）
+// 返回的 map 只由该监控任务的评估循环访问
+func (d *FailoverDaemon) slaSince(monitorName string) map[string]time.Time {
+	d.slaMutex.Lock()
+	defer d.slaMutex.Unlock()
+
+	since := d.slaCompliantSince[monitorName]
+	if since == nil {
+		since = make(map[string]time.Time)
+		d.slaCompliantSince[monitorName] = since
+	}
+	return since
+}
+
+// evaluateSLA 按 SLA 策略评估是否需要切换（currentExit 由 evaluateFailover 确定）
+func (d *FailoverDaemon) evaluateSLA(monitor *MonitorConfig, currentExit string) {
+	candidates := d.candidateExits(monitor)
+	now := time.Now()
+
+	// 更新各出口持续满足 SLA 的起始时间
+	since := d.slaSince(monitor.Name)
+
+	preferred, firstUp := "", ""
+	currentIndex, preferredIndex := -1, -1
+	currentMet, currentReason := false, "不在候选出口中"
+
+	d.logger.Debug("【SLA】监控任务: %s (%s)", monitor.Name, monitor.SLA.Summary())
+	for i, exit := range candidates {
+		state := d.stateManager.GetState(exit)
+		met, reason := monitor.SLA.Check(state)
+		if met && state.Suppressed && exit != currentExit {
+			// 振荡抑制中的出口不作为切换目标
+			met, reason = false, fmt.Sprintf("振荡抑制 (惩罚 %.0f)", state.FlapPenalty)
+		}
+		if met {
+			if since[exit].IsZero() {
+				since[exit] = now
+			}
+			if preferred == "" {
+				preferred, preferredIndex = exit, i
+			}
+		} else {
+			delete(since, exit)
+		}
+		if firstUp == "" && state.PacketLoss < 100.0 {
+			firstUp = exit
+		}
+		if exit == currentExit {
+			currentIndex, currentMet, currentReason = i, met, reason
+		}
+
+		if met {
+			d.logger.Debug("  %d. %s: 满足 [延迟=%.1fms 丢包=%.0f%% 抖动=%.1fms]",
+				i+1, exit, state.Latency, state.PacketLoss, state.Jitter)
+		} else {
+			d.logger.Debug("  %d. %s: 违反 (%s)", i+1, exit, reason)
+		}
+	}
+
+	// 所有候选出口均不可用：按 on_exit_down 处理
+	if d.allCandidatesDown(monitor) {
+		d.logger.Debug("监控任务 %s: 所有候选出口均不可用", monitor.Name)
+		d.enforceExitDown(monitor)
+		return
+	}
+
+	// 之前已阻断：恢复到满足 SLA 的最高优先级出口（没有则第一个可用出口）
+	if d.isExitDownActive(monitor.Name) {
+		if preferred == "" {
+			preferred = firstUp
+		}
+		d.restoreFromExitDown(monitor, preferred)
+		return
+	}
+
+	if currentMet {
+		d.resetConfirmation(monitor, "当前出口满足 SLA")
+
+		// 更高优先级的出口持续满足 SLA 达到等待时间后抢占
+		if preferredIndex < 0 || preferredIndex >= currentIndex {
+			d.stateManager.ClearSwitchLimit(monitor.Name)
+			d.logger.Debug("【保持不变】监控任务 %s: %s 满足 SLA", monitor.Name, currentExit)
+			return
+		}
+		held := now.Sub(since[preferred])
+		hold := monitor.SLA.PreemptHold()
+		if held < hold {
+			d.stateManager.ClearSwitchLimit(monitor.Name)
+			d.logger.Debug("【抢占等待】%s 已满足 SLA %s (需要 %s)", preferred, held.Truncate(time.Second), hold)
+			return
+		}
+		if !d.switchAllowed(monitor, currentExit) {
+			return
+		}
+		d.logger.Info("【抢占】监控任务 %s: 更高优先级出口 %s 已持续满足 SLA %s", monitor.Name, preferred, held.Truncate(time.Second))
+		d.executeSLASwitch(monitor, currentExit, preferred,
+			fmt.Sprintf("SLA 抢占: %s → %s (%s 持续满足 SLA %s)", currentExit, preferred, preferred, held.Truncate(time.Second)))
+		return
+	}
+
+	// 当前出口违反 SLA：切换到满足 SLA 的最高优先级出口
+	target := preferred
+	if target == "" {
+		// 没有出口满足 SLA：当前出口可用时保持，否则按优先级选择第一个可用出口
+		if currentIndex >= 0 && d.stateManager.GetState(currentExit).PacketLoss < 100.0 {
+			d.resetConfirmation(monitor, "没有满足 SLA 的出口")
+			d.stateManager.ClearSwitchLimit(monitor.Name)
+			d.logger.Debug("【保持不变】监控任务 %s: 没有满足 SLA 的出口，保持 %s (%s)", monitor.Name, currentExit, currentReason)
+			return
+		}
+		target = firstUp
+	}
+
+	d.confirmationCounters[monitor.Name]++
+	confirmationCount := monitor.GetSwitchConfirmationCount(d.config.Daemon.SwitchConfirmationCount)
+	currentConfirmations := d.confirmationCounters[monitor.Name]
+
+	d.logger.Info("【SLA 违反】监控任务 %s: %s (%s)，目标出口: %s", monitor.Name, currentExit, currentReason, target)
+	if currentConfirmations < confirmationCount {
+		d.logger.Info("【确认中】切换确认进度: %d/%d (还需 %d 次确认)",
+			currentConfirmations, confirmationCount, confirmationCount-currentConfirmations)
+		return
+	}
+
+	if !d.switchAllowed(monitor, currentExit) {
+		return
+	}
+
+	d.logger.Info("【确认完成】连续 %d 次确认通过，执行切换", currentConfirmations)
+	d.confirmationCounters[monitor.Name] = 0
+	d.executeSLASwitch(monitor, currentExit, target,
+		fmt.Sprintf("SLA 违反: %s → %s (%s)", currentExit, target, currentReason))
+}
+
+// resetConfirmation 重置监控任务的切换确认计数器
+func (d *FailoverDaemon) resetConfirmation(monitor *MonitorConfig, reason string) {
+	if d.confirmationCounters[monitor.Name] > 0 {
+		d.logger.Info("【确认取消】%s，重置确认计数器 (之前: %d/%d)", reason,
+			d.confirmationCounters[monitor.Name],
+			monitor.GetSwitchConfirmationCount(d.config.Daemon.SwitchConfirmationCount))
+		d.confirmationCounters[monitor.Name] = 0
+	}
+}
+
+// executeSLASwitch 切换到 SLA 策略选定的出口
+func (d *FailoverDaemon) executeSLASwitch(monitor *MonitorConfig, oldExit, newExit, message string) {
+	d.failoverMutex.Lock()
+	defer d.failoverMutex.Unlock()
+
+	d.logger.Info("【执行】%s", message)
+
+	if err := d.applyExit(monitor, newExit); err != nil {
+		message := fmt.Sprintf("故障转移失败: %v", err)
+		d.logger.Error("%s", message)
+		d.stateManager.RecordEvent(monitor.Name, "failover", message)
+		return
+	}
+
+	d.logger.Info("【完成】故障转移成功")
+	d.setCachedExit(monitor.Name, newExit)
+	d.stateManager.RecordSwitch(monitor.Name)
+	d.stateManager.RecordEvent(monitor.Name, "failover", message)
+}
@@ -6,10 +6,15 @@ import (
 	"os"
 	"sync"
 	"time"
+
+	"trueword_node/pkg/scoring"
 )
 
 const (
 	StateFile = "/var/lib/trueword_node/failover_state.json"
+
+	// 连续失败计数上限（恢复后每次成功检测减1，上限决定失败惩罚的最长持续检测次数）
+	maxFailureCount = 20
 )
 
 // InterfaceState 接口状态
@@ -17,12 +22,21 @@ type InterfaceState struct {
 	Name             string    `json:"name"`
 	Latency          float64   `json:"latency"`           // 平均延迟（ms）
 	PacketLoss       float64   `json:"packet_loss"`       // 丢包率（%）
+	Jitter           float64   `json:"jitter"`            // 抖动（ms）
+	Failures         int       `json:"failures"`          // 连续失败计数（失败 +1，成功 -1，用于失败惩罚）
 	BaseScore        float64   `json:"base_score"`        // 基础评分
 	Cost             int       `json:"cost"`              // 成本
-	FinalScore       float64   `json:"final_score"`       // 最终评分
+	FinalScore       float64   `json:"final_score"`       // 最终评分（全局评分参数；设置了 scoring 的监控任务按自身参数评分）
 	LastCheckTime    time.Time `json:"last_check_time"`
 	LastTarget       string    `json:"last_target"`        // 最后使用的目标IP
 	InitialCheckDone bool      `json:"initial_check_done"` // 是否完成初始检测
+	QuotaExhausted   bool      `json:"quota_exhausted,omitempty"` // 流量配额已耗尽
+	FlapPenalty      float64   `json:"flap_penalty,omitempty"`    // 振荡惩罚（按半衰期衰减）
+	FlapUpdated      time.Time `json:"flap_updated"`              // 振荡惩罚的计算时间
+	Suppressed       bool      `json:"suppressed,omitempty"`      // 振荡抑制中（不作为切换目标）
+	Targets          []TargetResult `json:"targets,omitempty"`    // 最近一次检测各目标的结果（诊断用）
+
+	lastUp bool // 上一次振荡检查时是否可用
 }
 
 // FailoverEvent 故障转移事件
@@ -39,11 +53,15 @@ type RuntimeState struct {
 	InterfaceStates map[string]*InterfaceState `json:"interface_states"`
 	RecentEvents    []FailoverEvent            `json:"recent_events"`  // 最近20条
 	CurrentExits    map[string]string          `json:"current_exits"`  // monitor_name -> current_exit
+	SwitchStates    map[string]*SwitchState    `json:"switch_states,omitempty"` // monitor_name -> 切换记录和限制状态
+	Probes          map[string]*ProbeInfo      `json:"probes,omitempty"`        // 检测键 -> 共享检测任务
 }
 
 // StateManager 状态管理器
 type StateManager struct {
 	states    map[string]*InterfaceState
+	switches  map[string]*SwitchState
+	probes    map[string]*ProbeInfo
 	events    []FailoverEvent
 	startTime time.Time
 	mutex     sync.RWMutex
@@ -53,6 +71,8 @@ type StateManager struct {
 func NewStateManager() *StateManager {
 	return &StateManager{
 		states:    make(map[string]*InterfaceState),
+		switches:  make(map[string]*SwitchState),
+		probes:    make(map[string]*ProbeInfo),
 		events:    make([]FailoverEvent, 0),
 		startTime: time.Now(),
 	}
@@ -82,9 +102,9 @@ func (sm *StateManager) GetState(iface string) *InterfaceState {
 	return state
 }
 
-// UpdateState 更新接口状态
+// UpdateState 更新接口状态（cost 为含流量配额上调的有效成本，评分使用守护进程全局评分参数）
 // 返回: 是否完成初始检测（第一次检测）
-func (sm *StateManager) UpdateState(iface string, checkResult *CheckResult, cost int) bool {
+func (sm *StateManager) UpdateState(iface string, checkResult *CheckResult, cost int, quotaExhausted bool, cfg scoring.Config) bool {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
@@ -101,18 +121,30 @@ func (sm *StateManager) UpdateState(iface string, checkResult *CheckResult, cost
 	if checkResult.Success {
 		state.Latency = checkResult.Latency
 		state.PacketLoss = checkResult.PacketLoss
+		state.Jitter = checkResult.Jitter
 		state.LastTarget = checkResult.TargetIP
+		if state.Failures > 0 {
+			state.Failures--
+		}
 	} else {
 		// 检测失败
 		state.Latency = 0
 		state.PacketLoss = 100.0
+		state.Jitter = 0
 		state.LastTarget = ""
+		if state.Failures < maxFailureCount {
+			state.Failures++
+		}
 	}
 
+	state.Targets = checkResult.Targets
+
 	// 更新成本和评分
 	state.Cost = cost
-	state.BaseScore = calculateBaseScore(state.Latency, state.PacketLoss)
-	state.FinalScore = calculateFinalScore(state.BaseScore, cost)
+	state.QuotaExhausted = quotaExhausted
+	breakdown := cfg.Score(state.Sample())
+	state.BaseScore = breakdown.Base
+	state.FinalScore = breakdown.Final
 	state.LastCheckTime = time.Now()
 
 	// 标记初始检测完成
@@ -124,6 +156,17 @@ func (sm *StateManager) UpdateState(iface string, checkResult *CheckResult, cost
 	return initialCheckDone
 }
 
+// Sample 接口状态对应的评分输入
+func (s *InterfaceState) Sample() scoring.Sample {
+	return scoring.Sample{
+		Latency:    s.Latency,
+		PacketLoss: s.PacketLoss,
+		Jitter:     s.Jitter,
+		Cost:       s.Cost,
+		Failures:   s.Failures,
+	}
+}
+
 // AllInitialChecksDone 检查所有接口是否完成初始检测
 func (sm *StateManager) AllInitialChecksDone(ifaces []string) bool {
 	sm.mutex.RLock()
@@ -157,7 +200,22 @@ func (sm *StateManager) ResetAllStates() {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	sm.states = make(map[string]*InterfaceState)
+	// 振荡惩罚和抑制状态跨重载保留（否则重载会解除振荡抑制）；切换记录在 switches 中，不受影响
+	states := make(map[string]*InterfaceState)
+	for iface, old := range sm.states {
+		if old.FlapPenalty == 0 && !old.Suppressed {
+			continue
+		}
+		states[iface] = &InterfaceState{
+			Name:        iface,
+			PacketLoss:  100.0,
+			FlapPenalty: old.FlapPenalty,
+			FlapUpdated: old.FlapUpdated,
+			Suppressed:  old.Suppressed,
+			lastUp:      old.lastUp,
+		}
+	}
+	sm.states = states
 }
 
 // RecordEvent 记录事件
@@ -206,6 +264,8 @@ func (sm *StateManager) SaveState(currentExits map[string]string) error {
 		InterfaceStates: sm.states,
 		RecentEvents:    sm.events,
 		CurrentExits:    currentExits,
+		SwitchStates:    sm.switches,
+		Probes:          sm.probes,
 	}
 
 	data, err := json.MarshalIndent(runtimeState, "", "  ")
@@ -242,61 +302,3 @@ func LoadState() (*RuntimeState, error) {
 
 	return &state, nil
 }
-
-// calculateBaseScore 计算基础评分（不含成本）
-// 与 pkg/routing/policy.go 中的算法完全一致
-func calculateBaseScore(latency, packetLoss float64) float64 {
-	// 特殊情况：完全失败（100% 丢包）
-	// 此时接口完全不可达，评分应该为 0
-	if packetLoss >= 100.0 {
-		return 0
-	}
-
-	var score float64
-
-	// 丢包率评分（0-60分）
-	if packetLoss == 0 {
-		score += 60
-	} else if packetLoss <= 5 {
-		score += 45
-	} else if packetLoss <= 10 {
-		score += 30
-	} else if packetLoss <= 20 {
-		score += 15
-	} else {
-		score += 0
-	}
-
-	// 延迟评分（0-40分）
-	// 注意：延迟为 0 说明没有成功的 ping，但已经被上面的丢包率检查过滤了
-	if latency < 50 {
-		score += 40
-	} else if latency < 100 {
-		score += 35
-	} else if latency < 150 {
-		score += 30
-	} else if latency < 200 {
-		score += 25
-	} else if latency < 300 {
-		score += 15
-	} else {
-		score += 5
-	}
-
-	return score
-}
-
-// calculateFinalScore 计算最终评分（含成本惩罚）
-// 与 pkg/routing/policy.go 中的算法完全一致
-func calculateFinalScore(baseScore float64, cost int) float64 {
-	// 成本惩罚 = cost * 0.5
-	costPenalty := float64(cost) * 0.5
-	finalScore := baseScore - costPenalty
-
-	// 确保最终评分不为负数
-	if finalScore < 0 {
-		finalScore = 0
-	}
-
-	return finalScore
-}
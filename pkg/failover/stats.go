@@ -0,0 +1,61 @@
+package failover
+
+import (
+	"time"
+
+	"trueword_node/pkg/accounting"
+	"trueword_node/pkg/network"
+)
+
+// quotaStatsIntervalSec 配置了流量配额但未设置 stats_interval_sec 时的默认采集间隔（配额用量依赖流量统计）
+const quotaStatsIntervalSec = 300
+
+// startStatsCollector 启动流量统计采集循环
+func (d *FailoverDaemon) startStatsCollector() {
+	interval := d.config.Daemon.StatsIntervalSec
+	if interval <= 0 && len(network.ExitQuotas()) > 0 {
+		interval = quotaStatsIntervalSec
+		d.logger.Info("出口配置了流量配额，使用默认流量统计采集间隔 %ds", interval)
+	}
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	stop := make(chan struct{})
+	d.statsTicker = ticker
+	d.statsStop = stop
+
+	d.logger.Info("启动流量统计采集 (间隔: %ds)", interval)
+
+	go func(t *time.Ticker) {
+		d.collectStats()
+		for {
+			select {
+			case <-t.C:
+				d.collectStats()
+			case <-stop:
+				return
+			}
+		}
+	}(ticker)
+}
+
+// stopStatsCollector 停止流量统计采集循环
+func (d *FailoverDaemon) stopStatsCollector() {
+	if d.statsTicker != nil {
+		d.statsTicker.Stop()
+		close(d.statsStop)
+		d.statsTicker = nil
+		d.statsStop = nil
+	}
+}
+
+// collectStats 执行一次流量统计采集
+func (d *FailoverDaemon) collectStats() {
+	if err := accounting.Collect(); err != nil {
+		d.logger.Error("【流量统计】采集失败: %v", err)
+		return
+	}
+	d.logger.Debug("【流量统计】采集完成")
+}
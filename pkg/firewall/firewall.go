@@ -0,0 +1,399 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/forward"
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
+)
+
+const (
+	// twnode 独占的 nftables 表，撤销时整表删除
+	TableFamily = "inet"
+	TableName   = "twnode"
+
+	// 出口NAT模式
+	NATMasquerade = "masquerade"
+	NATSNAT       = "snat"
+	NATNone       = "none"
+
+	// 转发默认策略
+	ForwardAccept = "accept"
+	ForwardDrop   = "drop"
+)
+
+// NATRule 出口NAT规则
+type NATRule struct {
+	Exit    string
+	Mode    string // masquerade / snat / none
+	Address string // SNAT 目标地址
+	Source  string // 规则来源（隧道/物理接口/策略出口）
+}
+
+// MSSRule 隧道 TCP MSS 钳制规则
+type MSSRule struct {
+	Tunnel string
+	Size   int // 0 表示按路径MTU
+}
+
+// Ruleset twnode 防火墙规则集
+type Ruleset struct {
+	NAT           []*NATRule
+	MSS           []*MSSRule
+	ForwardPolicy string
+	ForwardAllow  []string // 允许转发的源地址段（drop 时生效）
+	SiteTunnels   []string // 站点互联隧道（不做NAT，drop 时双向放行）
+	Exits         []string // 所有已配置出口（drop 时只允许转发到这些出口）
+	Forwards      []*forward.Forward
+	ForwardMarks  map[string]int // 入口 -> 连接标记（即回程路由表）
+}
+
+// ParseNATMode 解析出口NAT模式
+// 返回: 模式, SNAT地址
+func ParseNATMode(value string) (string, string, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "", NATMasquerade:
+		return NATMasquerade, "", nil
+	case NATNone:
+		return NATNone, "", nil
+	}
+
+	if strings.HasPrefix(value, NATSNAT+":") {
+		addr := strings.TrimPrefix(value, NATSNAT+":")
+		if ip := net.ParseIP(addr); ip == nil || ip.To4() == nil {
+			return "", "", fmt.Errorf("无效的SNAT地址: %s", addr)
+		}
+		return NATSNAT, addr, nil
+	}
+
+	return "", "", fmt.Errorf("无效的NAT模式 '%s' (可选: masquerade/snat:<地址>/none)", value)
+}
+
+// ParseMSSClamp 解析 MSS 钳制设置: auto / off / 数值
+func ParseMSSClamp(value string) (int, error) {
+	switch value {
+	case "auto", "":
+		return 0, nil
+	case "off":
+		return -1, nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 536 || size > 9000 {
+		return 0, fmt.Errorf("无效的MSS值 '%s' (可选: auto/off/536-9000)", value)
+	}
+	return size, nil
+}
+
+// Build 根据隧道、物理接口和策略配置生成规则集
+func Build() (*Ruleset, error) {
+	rs := &Ruleset{
+		NAT:           make([]*NATRule, 0),
+		MSS:           make([]*MSSRule, 0),
+		ForwardPolicy: ForwardAccept,
+	}
+
+	seen := make(map[string]bool)
+	addNAT := func(exit, value, source string) error {
+		if exit == "" || seen[exit] {
+			return nil
+		}
+		mode, addr, err := ParseNATMode(value)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", source, exit, err)
+		}
+		seen[exit] = true
+		rs.NAT = append(rs.NAT, &NATRule{Exit: exit, Mode: mode, Address: addr, Source: source})
+		rs.Exits = append(rs.Exits, exit)
+		if mode == NATNone && source == "隧道" {
+			rs.SiteTunnels = append(rs.SiteTunnels, exit)
+		}
+		return nil
+	}
+
+	// 1. 物理接口
+	ifaceConfig, err := network.LoadInterfaceConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaceConfig.Interfaces {
+		if !iface.Enabled {
+			continue
+		}
+		if err := addNAT(iface.Name, iface.NATMode, "物理接口"); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. 隧道
+	tunnels, err := network.ListTunnelConfigs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Name < tunnels[j].Name
+	})
+	for _, tunnel := range tunnels {
+		if err := addNAT(tunnel.Name, tunnel.NATMode, "隧道"); err != nil {
+			return nil, err
+		}
+		if tunnel.MSSClamp >= 0 {
+			rs.MSS = append(rs.MSS, &MSSRule{Tunnel: tunnel.Name, Size: tunnel.MSSClamp})
+		}
+	}
+
+	// 3. 策略组和默认路由使用的其他出口（第三方接口，保持原 MASQUERADE 行为）
+	pm := routing.NewPolicyManager()
+	pm.LoadAllGroups()
+	sources := make([]string, 0)
+	for _, group := range pm.Groups() {
+		addNAT(group.Exit, "", "策略出口")
+		if group.From != "" && group.From != "all" {
+			sources = append(sources, group.From)
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	addNAT(cfg.Routing.DefaultExit, "", "策略出口")
+
+	// 4. 转发过滤
+	switch cfg.Firewall.ForwardPolicy {
+	case "", ForwardAccept:
+	case ForwardDrop:
+		rs.ForwardPolicy = ForwardDrop
+	default:
+		return nil, fmt.Errorf("无效的转发策略 '%s' (可选: accept/drop)", cfg.Firewall.ForwardPolicy)
+	}
+
+	for _, cidr := range append(sources, cfg.Firewall.ForwardAllow...) {
+		normalized, err := routing.NormalizeCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的转发源地址 %s: %w", cidr, err)
+		}
+		rs.ForwardAllow = append(rs.ForwardAllow, normalized)
+	}
+	rs.ForwardAllow = routing.AggregateCIDRs(rs.ForwardAllow)
+
+	// 5. 端口转发
+	forwards, err := forward.List()
+	if err != nil {
+		return nil, err
+	}
+	rs.Forwards = forwards
+	rs.ForwardMarks = forward.ViaTables(forwards)
+
+	return rs, nil
+}
+
+// Render 生成 nft 脚本（先删除旧表再创建，nft -f 原子执行）
+func (rs *Ruleset) Render() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "table %s %s\n", TableFamily, TableName)
+	fmt.Fprintf(&b, "delete table %s %s\n", TableFamily, TableName)
+	fmt.Fprintf(&b, "table %s %s {\n", TableFamily, TableName)
+
+	// 端口转发：DNAT 并给连接打上入口标记，回包按标记走回程路由表
+	// 只恢复回复方向的标记：原方向的后续包（ACK、数据）带上标记会被 fwmark 规则送回入口隧道
+	if len(rs.Forwards) > 0 {
+		b.WriteString("\tchain prerouting_nat {\n")
+		b.WriteString("\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+		for _, f := range rs.Forwards {
+			mark, ok := rs.ForwardMarks[f.Via]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&b, "\t\tiifname %q %s dport %d ct mark set 0x%x dnat ip to %s\n", f.Via, f.Proto, f.PublicPort, mark, f.Target())
+		}
+		b.WriteString("\t}\n")
+
+		b.WriteString("\tchain prerouting_mark {\n")
+		b.WriteString("\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+		b.WriteString("\t\tct direction reply ct mark != 0 meta mark set ct mark\n")
+		b.WriteString("\t}\n")
+
+		b.WriteString("\tchain output_mark {\n")
+		b.WriteString("\t\ttype route hook output priority mangle; policy accept;\n")
+		b.WriteString("\t\tct direction reply ct mark != 0 meta mark set ct mark\n")
+		b.WriteString("\t}\n")
+	}
+
+	// 出口NAT
+	b.WriteString("\tchain postrouting {\n")
+	b.WriteString("\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	for _, rule := range rs.NAT {
+		switch rule.Mode {
+		case NATMasquerade:
+			fmt.Fprintf(&b, "\t\toifname %q masquerade\n", rule.Exit)
+		case NATSNAT:
+			fmt.Fprintf(&b, "\t\toifname %q snat ip to %s\n", rule.Exit, rule.Address)
+		case NATNone:
+			fmt.Fprintf(&b, "\t\toifname %q accept\n", rule.Exit)
+		}
+	}
+	b.WriteString("\t}\n")
+
+	// 转发：MSS 钳制 + 过滤
+	b.WriteString("\tchain forward {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook forward priority filter; policy %s;\n", rs.ForwardPolicy)
+	for _, rule := range rs.MSS {
+		size := "rt mtu"
+		if rule.Size > 0 {
+			size = strconv.Itoa(rule.Size)
+		}
+		fmt.Fprintf(&b, "\t\toifname %q tcp flags syn / syn,rst tcp option maxseg size set %s\n", rule.Tunnel, size)
+		fmt.Fprintf(&b, "\t\tiifname %q tcp flags syn / syn,rst tcp option maxseg size set %s\n", rule.Tunnel, size)
+	}
+
+	if rs.ForwardPolicy == ForwardDrop {
+		b.WriteString("\t\tct state established,related accept\n")
+		if len(rs.Forwards) > 0 {
+			b.WriteString("\t\tct status dnat accept\n")
+		}
+		if len(rs.SiteTunnels) > 0 {
+			set := quoteSet(rs.SiteTunnels)
+			fmt.Fprintf(&b, "\t\tiifname %s accept\n", set)
+			fmt.Fprintf(&b, "\t\toifname %s accept\n", set)
+		}
+		if len(rs.ForwardAllow) > 0 && len(rs.Exits) > 0 {
+			fmt.Fprintf(&b, "\t\tip saddr { %s } oifname %s accept\n", strings.Join(rs.ForwardAllow, ", "), quoteSet(rs.Exits))
+		}
+	}
+	b.WriteString("\t}\n")
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// quoteSet 生成 nft 匿名集合 { "a", "b" }
+func quoteSet(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return "{ " + strings.Join(quoted, ", ") + " }"
+}
+
+// Apply 生成并应用 twnode 防火墙表
+func Apply() error {
+	rs, err := Build()
+	if err != nil {
+		return err
+	}
+	return rs.Apply()
+}
+
+// Apply 应用规则集（原子替换 twnode 表）
+func (rs *Ruleset) Apply() error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(rs.Render())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("应用 nftables 规则失败: %v, 输出: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	if err := forward.ApplyRouting(rs.Forwards); err != nil {
+		return fmt.Errorf("端口转发回程路由: %w", err)
+	}
+	return nil
+}
+
+// Sync 防火墙已启用（表存在）时按当前配置重新生成
+// 隧道/接口配置变化后调用
+func Sync() error {
+	if !TableExists() {
+		return nil
+	}
+	return Apply()
+}
+
+// Remove 删除 twnode 防火墙表
+func Remove() error {
+	forward.RevokeRouting()
+
+	if !TableExists() {
+		return nil
+	}
+	output, err := exec.Command("nft", "delete", "table", TableFamily, TableName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("删除 nftables 表失败: %v, 输出: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// TableExists 检查 twnode 防火墙表是否存在
+func TableExists() bool {
+	return exec.Command("nft", "list", "table", TableFamily, TableName).Run() == nil
+}
+
+// Print 打印规则集
+func (rs *Ruleset) Print() {
+	fmt.Println("【出口NAT】")
+	if len(rs.NAT) == 0 {
+		fmt.Println("  (无)")
+	} else {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header("出口", "来源", "NAT", "MSS钳制")
+
+		mss := make(map[string]string)
+		for _, rule := range rs.MSS {
+			mss[rule.Tunnel] = "按路径MTU"
+			if rule.Size > 0 {
+				mss[rule.Tunnel] = strconv.Itoa(rule.Size)
+			}
+		}
+
+		for _, rule := range rs.NAT {
+			nat := rule.Mode
+			switch rule.Mode {
+			case NATSNAT:
+				nat = "SNAT → " + rule.Address
+			case NATNone:
+				nat = "不做NAT（站点互联）"
+			}
+			clamp := "-"
+			if value, ok := mss[rule.Exit]; ok {
+				clamp = value
+			}
+			table.Append(rule.Exit, rule.Source, nat, clamp)
+		}
+		table.Render()
+	}
+
+	fmt.Println()
+	fmt.Println("【转发过滤】")
+	fmt.Printf("  默认策略: %s\n", rs.ForwardPolicy)
+	if rs.ForwardPolicy == ForwardDrop {
+		fmt.Println("  放行: 已建立/相关连接")
+		if len(rs.SiteTunnels) > 0 {
+			fmt.Printf("  放行: 站点互联隧道 %s（双向）\n", strings.Join(rs.SiteTunnels, ", "))
+		}
+		if len(rs.ForwardAllow) > 0 {
+			fmt.Printf("  放行: 源地址 %s → 已配置出口\n", strings.Join(rs.ForwardAllow, ", "))
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("【端口转发】")
+	forward.Print(rs.Forwards)
+
+	fmt.Println()
+	if TableExists() {
+		fmt.Printf("状态: ✓ 已应用 (nft list table %s %s)\n", TableFamily, TableName)
+	} else {
+		fmt.Println("状态: ✗ 未应用")
+	}
+}
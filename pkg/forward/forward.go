@@ -0,0 +1,304 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v3"
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
+)
+
+const (
+	ForwardDir = "/etc/trueword_node/forwards"
+)
+
+// 回程路由: 经某入口(via)进来的转发连接，回包按连接标记走回该入口
+// 优先级（forward_pref，默认9）在保护规则之前，保证回包不会被送往主路由表
+// 每个入口一张路由表（默认1100-1199），表号同时作为连接标记(ct mark / fwmark)
+// 表号在创建入口的第一条规则时分配并随规则保存，入口增删不会改变其他入口的表号（已有连接的标记保持有效）
+
+// Forward 端口转发规则
+type Forward struct {
+	Name       string `yaml:"name"`        // 规则名: <via>-<proto>-<public_port>
+	Proto      string `yaml:"proto"`       // tcp / udp
+	PublicPort int    `yaml:"public_port"` // 入口端口
+	Via        string `yaml:"via"`         // 入口（隧道名或物理接口名）
+	InnerIP    string `yaml:"inner_ip"`    // 内部主机IP
+	InnerPort  int    `yaml:"inner_port"`  // 内部主机端口
+	Table      int    `yaml:"table"`       // 回程路由表（同一入口的规则共用）
+}
+
+// New 校验参数并创建端口转发规则
+func New(proto string, publicPort int, via, target string) (*Forward, error) {
+	proto = strings.ToLower(proto)
+	if proto != "tcp" && proto != "udp" {
+		return nil, fmt.Errorf("协议必须是 tcp 或 udp")
+	}
+
+	if publicPort < 1 || publicPort > 65535 {
+		return nil, fmt.Errorf("端口必须在 1-65535 之间")
+	}
+
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("内部地址格式应为 <ip>:<port>: %w", err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("无效的内部IP: %s", host)
+	}
+	innerPort, err := strconv.Atoi(portStr)
+	if err != nil || innerPort < 1 || innerPort > 65535 {
+		return nil, fmt.Errorf("无效的内部端口: %s", portStr)
+	}
+
+	if !isKnownExit(via) {
+		return nil, fmt.Errorf("入口 %s 不是已配置的隧道或物理接口", via)
+	}
+
+	forwards, err := List()
+	if err != nil {
+		return nil, err
+	}
+	table, err := allocateTable(via, forwards)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Forward{
+		Name:       fmt.Sprintf("%s-%s-%d", via, proto, publicPort),
+		Proto:      proto,
+		PublicPort: publicPort,
+		Via:        via,
+		InnerIP:    ip.String(),
+		InnerPort:  innerPort,
+		Table:      table,
+	}, nil
+}
+
+// allocateTable 为入口分配回程路由表: 已有同入口的规则时沿用其表号，否则使用最小的空闲表号
+// 入口的最后一条规则删除后表号即被释放
+func allocateTable(via string, forwards []*Forward) (int, error) {
+	used := make(map[int]bool)
+	for _, f := range forwards {
+		if f.Table == 0 {
+			continue
+		}
+		if f.Via == via {
+			return f.Table, nil
+		}
+		used[f.Table] = true
+	}
+
+	tableBase, tableMax := config.RoutingNamespace().ForwardTables()
+	for tableID := tableBase; tableID <= tableMax; tableID++ {
+		if !used[tableID] {
+			return tableID, nil
+		}
+	}
+	return 0, fmt.Errorf("回程路由表 %d-%d 已全部分配", tableBase, tableMax)
+}
+
+// isKnownExit 判断是否为已配置的隧道或物理接口
+func isKnownExit(name string) bool {
+	if _, err := network.LoadTunnelConfig(name); err == nil {
+		return true
+	}
+	ifaceConfig, err := network.LoadInterfaceConfig()
+	return err == nil && ifaceConfig.GetInterfaceByName(name) != nil
+}
+
+// Target 返回内部地址 ip:port
+func (f *Forward) Target() string {
+	return net.JoinHostPort(f.InnerIP, strconv.Itoa(f.InnerPort))
+}
+
+// Save 保存端口转发规则
+func Save(f *Forward) error {
+	if err := os.MkdirAll(ForwardDir, 0755); err != nil {
+		return fmt.Errorf("创建配置目录失败: %w", err)
+	}
+
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("序列化配置失败: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(ForwardDir, f.Name+".yaml"), data, 0644); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	return nil
+}
+
+// Delete 删除端口转发规则配置
+func Delete(name string) error {
+	if err := os.Remove(filepath.Join(ForwardDir, name+".yaml")); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("端口转发规则 %s 不存在", name)
+		}
+		return fmt.Errorf("删除配置文件失败: %w", err)
+	}
+	return nil
+}
+
+// List 加载所有端口转发规则（按名称排序）
+func List() ([]*Forward, error) {
+	forwards := make([]*Forward, 0)
+
+	entries, err := os.ReadDir(ForwardDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return forwards, nil
+		}
+		return nil, fmt.Errorf("读取配置目录失败: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(ForwardDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %w", entry.Name(), err)
+		}
+
+		var f Forward
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %w", entry.Name(), err)
+		}
+		forwards = append(forwards, &f)
+	}
+
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].Name < forwards[j].Name
+	})
+
+	// 旧版本的规则没有表号（或表号不在当前路由命名空间范围内）：按名称顺序分配并保存
+	tableBase, tableMax := config.RoutingNamespace().ForwardTables()
+	for _, f := range forwards {
+		if f.Table < tableBase || f.Table > tableMax {
+			f.Table = 0
+		}
+	}
+	for _, f := range forwards {
+		if f.Table != 0 {
+			continue
+		}
+		table, err := allocateTable(f.Via, forwards)
+		if err != nil {
+			fmt.Printf("⚠ 端口转发规则 %s: %v\n", f.Name, err)
+			continue
+		}
+		f.Table = table
+		if err := Save(f); err != nil {
+			fmt.Printf("⚠ 保存端口转发规则 %s 的回程路由表失败: %v\n", f.Name, err)
+		}
+	}
+	return forwards, nil
+}
+
+// ViaTables 返回每个入口的回程路由表（表号同时作为连接标记）
+func ViaTables(forwards []*Forward) map[string]int {
+	tables := make(map[string]int)
+	for _, f := range forwards {
+		if _, ok := tables[f.Via]; !ok && f.Table != 0 {
+			tables[f.Via] = f.Table
+		}
+	}
+	return tables
+}
+
+// ApplyRouting 安装回程路由: 每个入口一张表（默认路由指向入口）+ fwmark 规则
+func ApplyRouting(forwards []*Forward) error {
+	RevokeRouting()
+
+	for via, tableID := range ViaTables(forwards) {
+		routeCmd := []string{"route", "replace", "default"}
+		if gateway := exitGateway(via); gateway != "" {
+			routeCmd = append(routeCmd, "via", gateway)
+		}
+		routeCmd = append(routeCmd, "dev", via, "table", strconv.Itoa(tableID))
+
+		if output, err := exec.Command("ip", routeCmd...).CombinedOutput(); err != nil {
+			return fmt.Errorf("添加入口 %s 回程路由失败: %v, 输出: %s", via, err, strings.TrimSpace(string(output)))
+		}
+
+		mark := fmt.Sprintf("0x%x", tableID)
+		if output, err := exec.Command("ip", "rule", "add", "fwmark", mark, "lookup", strconv.Itoa(tableID), "pref", strconv.Itoa(config.RoutingNamespace().ForwardPref)).CombinedOutput(); err != nil {
+			return fmt.Errorf("添加入口 %s 回程规则失败: %v, 输出: %s", via, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	exec.Command("ip", "route", "flush", "cache").Run()
+	return nil
+}
+
+// exitGateway 获取出口的网关（隧道和无网关的P2P接口返回空）
+func exitGateway(exit string) string {
+	info, err := network.GetInterfaceInfo(exit)
+	if err != nil {
+		return ""
+	}
+	switch info.Type {
+	case network.InterfaceTypePhysical:
+		return info.Gateway
+	case network.InterfaceTypeThirdParty:
+		return network.GetGatewayFromRoutes(exit)
+	}
+	return ""
+}
+
+// RevokeRouting 删除所有回程路由规则和路由表
+func RevokeRouting() {
+	rules, err := routing.ListIPRules()
+	if err != nil {
+		return
+	}
+
+	ns := config.RoutingNamespace()
+	tableBase, tableMax := ns.ForwardTables()
+	for _, rule := range rules {
+		if rule.Pref != ns.ForwardPref {
+			continue
+		}
+		exec.Command("ip", "rule", "del", "pref", strconv.Itoa(ns.ForwardPref), "lookup", rule.Table).Run()
+
+		if tableID, err := strconv.Atoi(rule.Table); err == nil && tableID >= tableBase && tableID <= tableMax {
+			exec.Command("ip", "route", "flush", "table", rule.Table).Run()
+		}
+	}
+}
+
+// Print 打印端口转发规则
+func Print(forwards []*Forward) {
+	if len(forwards) == 0 {
+		fmt.Println("  没有端口转发规则")
+		return
+	}
+
+	tables := ViaTables(forwards)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("名称", "协议", "入口", "入口端口", "内部地址", "回程表")
+	for _, f := range forwards {
+		table.Append(
+			f.Name,
+			f.Proto,
+			f.Via,
+			strconv.Itoa(f.PublicPort),
+			f.Target(),
+			strconv.Itoa(tables[f.Via]),
+		)
+	}
+	table.Render()
+}
@@ -0,0 +1,652 @@
+package gc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/vishvananda/netlink"
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/failover"
+	"trueword_node/pkg/forward"
+	"trueword_node/pkg/ipsec"
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
+	"trueword_node/pkg/wireguard"
+)
+
+// Options 垃圾回收选项
+type Options struct {
+	// IgnoreConfig 忽略现有配置，把 twnode 创建的所有内核状态都视为残留
+	// init 清空配置前使用
+	IgnoreConfig bool
+}
+
+// Item 残留项
+type Item struct {
+	Category string // 撤销文件/xfrm/策略规则/路由表/接口/文件
+	Name     string
+	Detail   string
+	Safe     bool // 确定由 twnode 创建、可安全删除；否则需要 --force
+	remove   func() error
+}
+
+// Report 垃圾回收报告
+type Report struct {
+	Items []*Item
+	Notes []string
+}
+
+func (r *Report) add(category, name, detail string, safe bool, remove func() error) {
+	r.Items = append(r.Items, &Item{
+		Category: category,
+		Name:     name,
+		Detail:   detail,
+		Safe:     safe,
+		remove:   remove,
+	})
+}
+
+// Count 返回将被删除的数量
+func (r *Report) Count(force bool) int {
+	count := 0
+	for _, item := range r.Items {
+		if item.Safe || force {
+			count++
+		}
+	}
+	return count
+}
+
+// expectedState 配置中期望存在的对象
+type expectedState struct {
+	tunnels       map[string]*network.TunnelConfig
+	remoteIPs     map[string]bool // 隧道对端真实IP（含 WireGuard 动态对端的 ProtectedIP）
+	ipsecRevs     map[string]bool // 已配置加密隧道对应的 IPsec 撤销文件名
+	groupPrios    map[int]bool
+	appliedPrios  map[int]bool // 已应用（内核中有规则）的策略组优先级
+	defaultExit   string
+	sourceRules   map[string]int  // 多出口源地址路由: 源IP -> 路由表
+	forwardTables map[int]bool    // 端口转发回程路由表
+	vrfs          map[string]bool // 隧道和策略组使用的 VRF
+}
+
+// loadExpected 加载配置中期望存在的对象
+func loadExpected(opts *Options, rules []*routing.IPRule) *expectedState {
+	exp := &expectedState{
+		tunnels:       make(map[string]*network.TunnelConfig),
+		remoteIPs:     make(map[string]bool),
+		ipsecRevs:     make(map[string]bool),
+		groupPrios:    make(map[int]bool),
+		appliedPrios:  make(map[int]bool),
+		sourceRules:   make(map[string]int),
+		forwardTables: make(map[int]bool),
+		vrfs:          make(map[string]bool),
+	}
+
+	if opts.IgnoreConfig {
+		return exp
+	}
+
+	if tunnels, err := network.ListTunnelConfigs(); err == nil {
+		for _, t := range tunnels {
+			exp.tunnels[t.Name] = t
+			for _, ip := range []string{t.RemoteIP, t.ProtectedIP} {
+				if ip != "" && ip != "0.0.0.0" {
+					exp.remoteIPs[ip] = true
+				}
+			}
+			if t.TunnelType != "wireguard" && t.UseEncryption {
+				exp.ipsecRevs[ipsec.IPsecRevFile(t.LocalIP, t.RemoteIP)] = true
+			}
+		}
+	}
+
+	pm := routing.NewPolicyManager()
+	pm.LoadAllGroups()
+	for _, group := range pm.Groups() {
+		exp.groupPrios[group.Priority] = true
+	}
+	for _, rule := range rules {
+		if exp.groupPrios[rule.Pref] {
+			exp.appliedPrios[rule.Pref] = true
+		}
+	}
+
+	if cfg, err := config.Load(); err == nil {
+		exp.defaultExit = cfg.Routing.DefaultExit
+	}
+
+	if routes, err := routing.PlanSourceRoutes(); err == nil {
+		for _, route := range routes {
+			exp.sourceRules[route.IP] = route.Table
+		}
+	}
+
+	if forwards, err := forward.List(); err == nil {
+		for _, tableID := range forward.ViaTables(forwards) {
+			exp.forwardTables[tableID] = true
+		}
+	}
+
+	for _, vrf := range routing.UsedVRFs() {
+		exp.vrfs[vrf] = true
+	}
+
+	return exp
+}
+
+// Collect 收集 twnode 可能创建、但已没有对应配置的内核状态和文件
+func Collect(opts *Options) (*Report, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	rules, err := routing.ListIPRules()
+	if err != nil {
+		return nil, err
+	}
+
+	exp := loadExpected(opts, rules)
+	report := &Report{}
+
+	// 守护进程运行时，pref 5 的临时规则可能正在使用
+	daemonRunning := false
+	if _, err := failover.GetRunningPID(); err == nil {
+		daemonRunning = true
+	}
+
+	revCovered := collectRevFiles(report, exp)
+	collectXfrm(report, exp)
+	collectRules(report, exp, rules, daemonRunning)
+	collectTables(report, exp, rules, daemonRunning)
+	collectLinks(report, exp, revCovered)
+	collectFiles(report, exp)
+
+	return report, nil
+}
+
+// collectRevFiles 收集无对应配置的撤销文件
+// 返回: 撤销文件会删除的接口名（避免重复列出）
+func collectRevFiles(report *Report, exp *expectedState) map[string]bool {
+	covered := make(map[string]bool)
+
+	entries, err := os.ReadDir(ipsec.RevDir)
+	if err != nil {
+		return covered
+	}
+
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".rev") {
+			continue
+		}
+		base := strings.TrimSuffix(fileName, ".rev")
+
+		if isIPPair(base) {
+			// IPsec 撤销文件: <ip1>-<ip2>.rev
+			if exp.ipsecRevs[fileName] {
+				continue
+			}
+			report.add("撤销文件", fileName, "无对应加密隧道，执行撤销命令（删除 xfrm 状态/策略）", true, func() error {
+				return ipsec.ExecuteRevFile(fileName)
+			})
+			continue
+		}
+
+		// 隧道撤销文件: <name>.rev
+		if _, ok := exp.tunnels[base]; ok {
+			continue
+		}
+		covered[base] = true
+		report.add("撤销文件", fileName, fmt.Sprintf("隧道 %s 已无配置，执行撤销命令（删除接口及VIP路由）", base), true, func() error {
+			return ipsec.ExecuteRevFile(fileName)
+		})
+	}
+
+	return covered
+}
+
+// isIPPair 判断是否为 <ip1>-<ip2> 格式
+func isIPPair(s string) bool {
+	parts := strings.Split(s, "-")
+	return len(parts) == 2 && net.ParseIP(parts[0]) != nil && net.ParseIP(parts[1]) != nil
+}
+
+// xfrmState 解析后的 xfrm 状态
+type xfrmState struct {
+	Src   string
+	Dst   string
+	Proto string
+	SPI   string
+}
+
+// xfrmPolicy 解析后的 xfrm 策略
+type xfrmPolicy struct {
+	Src string
+	Dst string
+	Dir string
+}
+
+// collectXfrm 收集 twnode 创建（SPI 由两端IP生成）但无对应配置的 xfrm 状态和策略
+func collectXfrm(report *Report, exp *expectedState) {
+	states := listXfrmStates()
+
+	// twnode 创建的 IP 对（按撤销文件名归一）
+	twnodePairs := make(map[string]bool)
+	for _, st := range states {
+		if st.Proto == "esp" && st.SPI == ipsec.IPsecSPI(st.Src, st.Dst) {
+			twnodePairs[ipsec.IPsecRevFile(st.Src, st.Dst)] = true
+		}
+	}
+
+	for _, st := range states {
+		pair := ipsec.IPsecRevFile(st.Src, st.Dst)
+		if !twnodePairs[pair] || exp.ipsecRevs[pair] {
+			continue
+		}
+		state := st
+		report.add("xfrm", fmt.Sprintf("state %s -> %s", st.Src, st.Dst), fmt.Sprintf("spi 0x%s，无对应加密隧道", st.SPI), true, func() error {
+			return exec.Command("ip", "xfrm", "state", "del", "src", state.Src, "dst", state.Dst, "proto", "esp", "spi", "0x"+state.SPI).Run()
+		})
+	}
+
+	for _, pol := range listXfrmPolicies() {
+		pair := ipsec.IPsecRevFile(pol.Src, pol.Dst)
+		if !twnodePairs[pair] || exp.ipsecRevs[pair] {
+			continue
+		}
+		policy := pol
+		report.add("xfrm", fmt.Sprintf("policy %s -> %s dir %s", pol.Src, pol.Dst, pol.Dir), "无对应加密隧道", true, func() error {
+			return exec.Command("ip", "xfrm", "policy", "del", "src", policy.Src, "dst", policy.Dst, "dir", policy.Dir).Run()
+		})
+	}
+}
+
+// listXfrmStates 读取 xfrm 状态
+// 格式:
+//
+//	src 1.2.3.4 dst 5.6.7.8
+//		proto esp spi 0x1234abcd reqid 0 mode tunnel
+func listXfrmStates() []*xfrmState {
+	output, err := exec.Command("ip", "xfrm", "state", "list").Output()
+	if err != nil {
+		return nil
+	}
+
+	states := make([]*xfrmState, 0)
+	var current *xfrmState
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "src" && len(fields) >= 4 && fields[2] == "dst" {
+			current = &xfrmState{Src: fields[1], Dst: fields[3]}
+			states = append(states, current)
+			continue
+		}
+
+		if fields[0] == "proto" && current != nil {
+			for i := 0; i+1 < len(fields); i++ {
+				switch fields[i] {
+				case "proto":
+					current.Proto = fields[i+1]
+				case "spi":
+					current.SPI = strings.TrimPrefix(fields[i+1], "0x")
+				}
+			}
+		}
+	}
+
+	return states
+}
+
+// listXfrmPolicies 读取 xfrm 策略
+// 格式:
+//
+//	src 1.2.3.4/32 dst 5.6.7.8/32
+//		dir out priority 0 ptype main
+func listXfrmPolicies() []*xfrmPolicy {
+	output, err := exec.Command("ip", "xfrm", "policy", "list").Output()
+	if err != nil {
+		return nil
+	}
+
+	policies := make([]*xfrmPolicy, 0)
+	var current *xfrmPolicy
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "src" && len(fields) >= 4 && fields[2] == "dst" {
+			current = &xfrmPolicy{
+				Src: strings.TrimSuffix(fields[1], "/32"),
+				Dst: strings.TrimSuffix(fields[3], "/32"),
+			}
+			continue
+		}
+
+		if fields[0] == "dir" && len(fields) >= 2 && current != nil {
+			current.Dir = fields[1]
+			policies = append(policies, current)
+			current = nil
+		}
+	}
+
+	return policies
+}
+
+// collectRules 收集残留的策略规则
+func collectRules(report *Report, exp *expectedState, rules []*routing.IPRule, daemonRunning bool) {
+	hasTunnels := len(exp.tunnels) > 0
+
+	ns := config.RoutingNamespace()
+	tunnelTable := strconv.Itoa(ns.TunnelTable())
+	vipTable := strconv.Itoa(ns.VIPTable())
+
+	for _, rule := range rules {
+		r := rule
+		delByPref := func() error {
+			return exec.Command("ip", "rule", "del", "pref", strconv.Itoa(r.Pref)).Run()
+		}
+		delByTo := func() error {
+			return exec.Command("ip", "rule", "del", "to", r.To, "pref", strconv.Itoa(r.Pref)).Run()
+		}
+
+		switch {
+		case rule.Pref == ns.TestPref:
+			// 常驻探测规则只匹配探测报文；其余为旧版本按目标添加的检测临时规则残留
+			if rule.Fwmark != fmt.Sprintf("0x%x", ns.TestTable()) || rule.Table != strconv.Itoa(ns.TestTable()) {
+				del := delByPref
+				if rule.To != "" {
+					del = delByTo
+				}
+				report.add("策略规则", fmt.Sprintf("pref %d", rule.Pref), "检测临时规则残留: "+rule.Raw, true, del)
+			} else if !daemonRunning {
+				report.add("策略规则", fmt.Sprintf("pref %d", rule.Pref), "检测探测规则（守护进程未运行，检测时自动重建）", true, delByPref)
+			}
+
+		case rule.Pref == ns.SourceLocalPref() && routing.IsNamespaceRule(ns, rule):
+			if _, ok := exp.sourceRules[rule.From]; !ok {
+				report.add("策略规则", fmt.Sprintf("pref %d from %s", rule.Pref, rule.From), "源地址本地路由规则无对应物理接口", true, func() error {
+					return exec.Command("ip", "rule", "del", "from", r.From, "lookup", r.Table, "pref", strconv.Itoa(r.Pref)).Run()
+				})
+			}
+
+		case rule.Pref == ns.SourcePref:
+			if table, ok := exp.sourceRules[rule.From]; !ok || strconv.Itoa(table) != rule.Table {
+				report.add("策略规则", fmt.Sprintf("pref %d from %s", rule.Pref, rule.From), "源地址路由无对应物理接口", true, func() error {
+					return exec.Command("ip", "rule", "del", "from", r.From, "lookup", r.Table, "pref", strconv.Itoa(r.Pref)).Run()
+				})
+			}
+
+		case rule.Pref == ns.ForwardPref:
+			if tableID, _ := strconv.Atoi(rule.Table); !exp.forwardTables[tableID] {
+				report.add("策略规则", fmt.Sprintf("pref %d fwmark %s", rule.Pref, rule.Fwmark), "端口转发回程规则无对应转发", true, func() error {
+					return exec.Command("ip", "rule", "del", "pref", strconv.Itoa(r.Pref), "lookup", r.Table).Run()
+				})
+			}
+
+		case rule.Pref == ns.ProtectPref && rule.To != "":
+			if !exp.remoteIPs[strings.TrimSuffix(rule.To, "/32")] {
+				report.add("策略规则", fmt.Sprintf("pref %d to %s", rule.Pref, rule.To), "保护规则无对应隧道", true, delByTo)
+			}
+
+		case rule.Pref == ns.TunnelPref && rule.Table == tunnelTable && rule.To != "":
+			if !exp.remoteIPs[strings.TrimSuffix(rule.To, "/32")] {
+				report.add("策略规则", fmt.Sprintf("pref %d to %s", rule.Pref, rule.To), "隧道对端策略路由无对应隧道", true, func() error {
+					return exec.Command("ip", "rule", "del", "to", r.To, "table", r.Table, "pref", strconv.Itoa(r.Pref)).Run()
+				})
+			}
+
+		case rule.Pref == ns.VIPPref && rule.Table == vipTable:
+			if !hasTunnels {
+				report.add("策略规则", fmt.Sprintf("pref %d", rule.Pref), "VIP路由规则无任何隧道", true, delByPref)
+			}
+
+		case rule.Pref == ns.VRFPref && (rule.Iif != "" || rule.Oif != ""):
+			selector, vrf := "iif", rule.Iif
+			if vrf == "" {
+				selector, vrf = "oif", rule.Oif
+			}
+			if !exp.vrfs[vrf] {
+				report.add("策略规则", fmt.Sprintf("pref %d %s %s", rule.Pref, selector, vrf), "VRF隔离规则无对应隧道或策略组", true, func() error {
+					return exec.Command("ip", "rule", "del", selector, vrf, "lookup", r.Table, "pref", strconv.Itoa(r.Pref)).Run()
+				})
+			}
+
+		case ns.IsGroupPref(rule.Pref):
+			if !exp.groupPrios[rule.Pref] {
+				report.add("策略规则", fmt.Sprintf("pref %d", rule.Pref), "无对应策略组", true, delByPref)
+			}
+
+		case rule.Pref == ns.DefaultPref && rule.Table == strconv.Itoa(ns.DefaultTable()):
+			if exp.defaultExit == "" {
+				report.add("策略规则", fmt.Sprintf("pref %d", rule.Pref), "未设置默认出口", true, delByPref)
+			}
+		}
+	}
+}
+
+// collectTables 收集残留的路由表内容
+func collectTables(report *Report, exp *expectedState, rules []*routing.IPRule, daemonRunning bool) {
+	ns := config.RoutingNamespace()
+	sourceMin, sourceMax := ns.SourceTables()
+	forwardMin, forwardMax := ns.ForwardTables()
+
+	sourceTables := make(map[int]bool)
+	for _, tableID := range exp.sourceRules {
+		sourceTables[tableID] = true
+	}
+
+	for _, tableID := range ListNonEmptyTables() {
+		table := strconv.Itoa(tableID)
+		flush := func() error {
+			return exec.Command("ip", "route", "flush", "table", table).Run()
+		}
+
+		switch {
+		case tableID == ns.TestTable():
+			if !daemonRunning {
+				report.add("路由表", table, "检测探测路由（守护进程未运行，检测时自动重建）", true, flush)
+			}
+
+		case tableID == ns.TunnelTable():
+			collectTableRoutes(report, table, "对端IP无对应隧道", func(route *routing.IPRoute) bool {
+				return exp.remoteIPs[route.Prefix.Addr().String()]
+			})
+
+		case tableID == ns.VIPTable():
+			collectTableRoutes(report, table, "接口无对应隧道", func(route *routing.IPRoute) bool {
+				_, ok := exp.tunnels[route.Dev]
+				return ok
+			})
+
+		case tableID == ns.DefaultTable():
+			if exp.defaultExit == "" {
+				report.add("路由表", table, "未设置默认出口", true, flush)
+			}
+
+		case isGroupTable(ns, tableID):
+			pref, _ := ns.GroupPrefOfTable(tableID)
+			if !exp.groupPrios[pref] {
+				report.add("路由表", table, "无对应策略组", true, flush)
+			} else if !exp.appliedPrios[pref] {
+				report.add("路由表", table, "策略组未应用（无规则）但路由表非空", true, flush)
+			}
+
+		case tableID >= sourceMin && tableID <= sourceMax:
+			if !sourceTables[tableID] {
+				report.add("路由表", table, "源地址路由表无对应物理接口", true, flush)
+			}
+
+		case tableID >= forwardMin && tableID <= forwardMax:
+			if !exp.forwardTables[tableID] {
+				report.add("路由表", table, "端口转发回程路由表无对应转发", true, flush)
+			}
+		}
+	}
+}
+
+// isGroupTable 判断路由表是否属于策略组
+func isGroupTable(ns config.Namespace, tableID int) bool {
+	_, ok := ns.GroupPrefOfTable(tableID)
+	return ok
+}
+
+// collectTableRoutes 逐条收集路由表中不再需要的路由
+func collectTableRoutes(report *Report, table, reason string, keep func(route *routing.IPRoute) bool) {
+	routes, err := routing.ListIPRoutes(table)
+	if err != nil {
+		return
+	}
+
+	for _, route := range routes {
+		if keep(route) {
+			continue
+		}
+		r := route
+		report.add("路由表", fmt.Sprintf("table %s: %s", table, route.Prefix), reason, true, func() error {
+			args := []string{"route", "del", r.Prefix.String()}
+			if r.Dev != "" {
+				args = append(args, "dev", r.Dev)
+			}
+			args = append(args, "table", table)
+			return exec.Command("ip", args...).Run()
+		})
+	}
+}
+
+// ListNonEmptyTables 列出所有非空的路由表ID
+func ListNonEmptyTables() []int {
+	output, err := exec.Command("ip", "route", "show", "table", "all").Output()
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] != "table" {
+				continue
+			}
+			if id, err := strconv.Atoi(fields[i+1]); err == nil {
+				seen[id] = true
+			}
+		}
+	}
+
+	tables := make([]int, 0, len(seen))
+	for id := range seen {
+		tables = append(tables, id)
+	}
+	sort.Ints(tables)
+	return tables
+}
+
+// collectLinks 收集无配置的 GRE/WireGuard 接口
+func collectLinks(report *Report, exp *expectedState, revCovered map[string]bool) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return
+	}
+
+	for _, link := range links {
+		name := link.Attrs().Name
+		linkType := link.Type()
+		if linkType != "gre" && linkType != "wireguard" {
+			continue
+		}
+		// 内核自动创建的 GRE 回退设备
+		if name == "gre0" {
+			continue
+		}
+		if _, ok := exp.tunnels[name]; ok || revCovered[name] {
+			continue
+		}
+
+		report.add("接口", name, fmt.Sprintf("%s 接口无配置和撤销记录（可能不是 twnode 创建）", linkType), false, func() error {
+			return exec.Command("ip", "link", "del", "dev", name).Run()
+		})
+	}
+}
+
+// collectFiles 收集无对应隧道的对端配置文件
+func collectFiles(report *Report, exp *expectedState) {
+	entries, err := os.ReadDir(wireguard.PeerConfigDir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".txt") {
+			continue
+		}
+		if _, ok := exp.tunnels[strings.TrimSuffix(entry.Name(), ".txt")]; ok {
+			continue
+		}
+		path := filepath.Join(wireguard.PeerConfigDir, entry.Name())
+		report.add("文件", path, "对端配置无对应隧道", true, func() error {
+			return os.Remove(path)
+		})
+	}
+}
+
+// Remove 删除收集到的残留项（force 为 true 时包括不确定由 twnode 创建的项）
+// 返回: 成功删除的数量, 失败的错误
+func (r *Report) Remove(force bool) (int, []error) {
+	removed := 0
+	errs := make([]error, 0)
+
+	for _, item := range r.Items {
+		if !item.Safe && !force {
+			continue
+		}
+		if err := item.remove(); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %v", item.Category, item.Name, err))
+			continue
+		}
+		removed++
+	}
+
+	exec.Command("ip", "route", "flush", "cache").Run()
+	return removed, errs
+}
+
+// Print 打印残留项
+func (r *Report) Print() {
+	if len(r.Items) == 0 {
+		fmt.Println("  ✓ 未发现残留")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("类别", "对象", "说明", "可安全删除")
+
+	for _, item := range r.Items {
+		safe := "是"
+		if !item.Safe {
+			safe = "否 (需 --force)"
+		}
+		table.Append(item.Category, item.Name, item.Detail, safe)
+	}
+
+	table.Render()
+
+	fmt.Println()
+	fmt.Printf("共 %d 项残留，其中 %d 项可安全删除\n", len(r.Items), r.Count(false))
+}
@@ -69,6 +69,9 @@ func StartAllTunnels() error {
 		}
 	}
 
+	// 物理接口流量整形
+	ApplyInterfaceShaping()
+
 	fmt.Println()
 	fmt.Println("╔═══════════════════════════════════════════════════════════╗")
 	fmt.Printf("║  启动完成: 成功 %d 个, 失败 %d 个                           ║\n", successCount, failedCount)
@@ -78,6 +81,25 @@ func StartAllTunnels() error {
 	return nil
 }
 
+// ApplyInterfaceShaping 应用物理接口的流量整形配置
+func ApplyInterfaceShaping() {
+	ifaceConfig, err := network.LoadInterfaceConfig()
+	if err != nil {
+		return
+	}
+
+	for _, iface := range ifaceConfig.Interfaces {
+		if iface.Shaping == nil || !iface.Enabled {
+			continue
+		}
+		if err := network.ApplyShaping(iface.Name, "", iface.Shaping); err != nil {
+			fmt.Printf("  ⚠ 接口 %s 流量整形失败: %v\n", iface.Name, err)
+			continue
+		}
+		fmt.Printf("  ✓ 接口 %s 流量整形: %s\n", iface.Name, iface.Shaping.Summary())
+	}
+}
+
 // StopAllTunnels 停止所有隧道（无论是否启用）
 func StopAllTunnels() error {
 	fmt.Println()
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"trueword_node/pkg/config"
 	"trueword_node/pkg/network"
 )
 
@@ -24,7 +25,12 @@ type Tunnel struct {
 	RemoteIP        string
 	LocalVirtualIP  string
 	RemoteVirtualIP string
-	GREKey          uint32 // GRE密钥
+	GREKey          uint32                  // GRE密钥
+	RemoteSubnets   []string                // 对端站点网段（路由到VIP路由表）
+	VRF             string                  // 隧道接口加入的VRF（可选）
+	Netns           string                  // 隧道接口所在的网络命名空间（可选）
+	Netmap          []network.NetmapRule    // 本端网段映射（可选）
+	Firewall        *network.TunnelFirewall // 隧道防火墙（可选）
 }
 
 // 执行命令并记录 (静默执行,只在出错时显示)
@@ -63,6 +69,23 @@ func generateSPI(ip1, ip2 string) string {
 	return hex.EncodeToString(hash[:])[:8]
 }
 
+// IPsecSPI 返回 twnode 为 src -> dst 方向 xfrm 状态生成的 SPI（十六进制，不含 0x）
+// 用于识别由 twnode 创建的 xfrm 状态
+func IPsecSPI(src, dst string) string {
+	return generateSPI(src, dst)
+}
+
+// IPsecRevFile 返回两端IP对应的 IPsec 撤销文件名
+func IPsecRevFile(ip1, ip2 string) string {
+	ipOne, ipTwo := sortIPs(ip1, ip2)
+	return fmt.Sprintf("%s-%s.rev", ipOne, ipTwo)
+}
+
+// ExecuteRevFile 执行撤销文件中的命令并删除文件
+func ExecuteRevFile(revFile string) error {
+	return executeRevCommands(revFile)
+}
+
 // 生成GRE Key (从auth密钥字符串生成)
 func generateGREKey(authKey string) uint32 {
 	// 去掉0x前缀
@@ -108,6 +131,23 @@ func recordRevCommands(revFile string, commands []string) error {
 	return os.WriteFile(revPath, []byte(content), 0644)
 }
 
+// appendRevCommand 向已有的撤销记录追加命令（已存在时忽略）
+func appendRevCommand(revFile, command string) error {
+	revPath := filepath.Join(RevDir, revFile)
+	data, err := os.ReadFile(revPath)
+	if err != nil {
+		return fmt.Errorf("读取撤销记录失败: %w", err)
+	}
+
+	commands := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, existing := range commands {
+		if strings.TrimSpace(existing) == command {
+			return nil
+		}
+	}
+	return recordRevCommands(revFile, append(commands, command))
+}
+
 // 执行撤销命令
 func executeRevCommands(revFile string) error {
 	revPath := filepath.Join(RevDir, revFile)
@@ -242,16 +282,50 @@ func (t *Tunnel) Create() error {
 		execCommandNoError(fmt.Sprintf("ip link set dev %s down", t.Name))
 		execCommandNoError(fmt.Sprintf("ip tunnel del %s", t.Name))
 	}
+	if t.Netns != "" && network.LinkExistsIn(t.Name, t.Netns) {
+		fmt.Printf("   ⚠️  接口 %s 已存在于网络命名空间 %s，正在清理...\n", t.Name, t.Netns)
+		execCommandNoError(fmt.Sprintf("ip -n %s link del %s", t.Netns, t.Name))
+	}
+
+	// 对端VIP和对端网段所在的路由表（默认VIP路由表，VRF隧道为VRF路由表）
+	ns := config.RoutingNamespace()
+	routeTable, err := network.PeerRouteTable(t.VRF, t.Netns)
+	if err != nil {
+		return err
+	}
 
 	// 记录撤销命令
-	revCommands := []string{
-		fmt.Sprintf("ip link set dev %s down", t.Name),
-		fmt.Sprintf("ip tunnel del %s mode gre remote %s local %s key %d ttl 255", t.Name, t.RemoteIP, t.LocalIP, t.GREKey),
-		fmt.Sprintf("ip addr del %s/32 dev %s", t.LocalVirtualIP, t.Name),
-		fmt.Sprintf("ip route del %s/32 dev %s table 80", t.RemoteVirtualIP, t.Name),
+	var revCommands []string
+	if t.Netns != "" {
+		// 网络命名空间内删除接口时地址和路由一并删除
+		revCommands = []string{fmt.Sprintf("ip -n %s link del %s", t.Netns, t.Name)}
+	} else {
+		revCommands = []string{
+			fmt.Sprintf("ip link set dev %s down", t.Name),
+			fmt.Sprintf("ip tunnel del %s mode gre remote %s local %s key %d ttl 255", t.Name, t.RemoteIP, t.LocalIP, t.GREKey),
+			fmt.Sprintf("ip addr del %s/32 dev %s", t.LocalVirtualIP, t.Name),
+			fmt.Sprintf("ip route del %s/32 dev %s table %s", t.RemoteVirtualIP, t.Name, routeTable),
+		}
+		for _, subnet := range t.RemoteSubnets {
+			revCommands = append(revCommands, fmt.Sprintf("ip route del %s dev %s table %s", subnet, t.Name, routeTable))
+		}
+	}
+	if len(t.Netmap) > 0 {
+		revCommands = append(revCommands, network.NetmapRevCommand(t.Name, t.Netns))
+	}
+	if t.Firewall != nil {
+		revCommands = append(revCommands, network.TunnelFirewallRevCommand(t.Name, t.Netns))
 	}
 	recordRevCommands(revFile, revCommands)
 
+	// 隧道防火墙（在接口启动前应用，避免规则生效前对端流量进入）
+	if t.Firewall != nil {
+		if err := network.ApplyTunnelFirewall(t.Name, t.LocalVirtualIP, t.Netns, t.Firewall); err != nil {
+			return err
+		}
+		fmt.Printf("   ✓ 隧道防火墙: %s\n", network.TunnelFirewallSummary(t.Firewall))
+	}
+
 	// 创建GRE隧道 (带key参数)
 	cmd := fmt.Sprintf("ip tunnel add %s mode gre remote %s local %s key %d ttl 255",
 		t.Name, t.RemoteIP, t.LocalIP, t.GREKey)
@@ -259,37 +333,63 @@ func (t *Tunnel) Create() error {
 		return err
 	}
 
+	// 加入VRF或移入网络命名空间（GRE的underlay仍在默认命名空间）
+	if err := network.EnterIsolation(t.Name, t.VRF, t.Netns); err != nil {
+		return err
+	}
+
 	// 设置IP地址
 	cmd = fmt.Sprintf("ip addr add %s/32 dev %s", t.LocalVirtualIP, t.Name)
-	if err := execCommand(cmd); err != nil {
+	if err := execCommand(network.IPCommandLine(t.Netns, cmd)); err != nil {
 		return err
 	}
 
 	// 启动接口
 	cmd = fmt.Sprintf("ip link set dev %s up mtu 1400", t.Name)
-	if err := execCommand(cmd); err != nil {
+	if err := execCommand(network.IPCommandLine(t.Netns, cmd)); err != nil {
 		return err
 	}
 
-	// 确保路由规则存在 (表80用于虚拟IP路由)
-	checkCmd := exec.Command("bash", "-c", "ip rule list | grep -q ^80:")
-	if err := checkCmd.Run(); err != nil {
-		cmd = "ip rule add from all lookup 80 pref 80"
-		if err := execCommand(cmd); err != nil {
-			return err
+	// 确保路由规则存在 (VIP路由表，默认表80；VRF和网络命名空间内的隧道不需要)
+	if t.VRF == "" && t.Netns == "" {
+		checkCmd := exec.Command("bash", "-c", fmt.Sprintf("ip rule list | grep -q ^%d:", ns.VIPPref))
+		if err := checkCmd.Run(); err != nil {
+			cmd = fmt.Sprintf("ip rule add from all lookup %d pref %d", ns.VIPTable(), ns.VIPPref)
+			if err := execCommand(cmd); err != nil {
+				return err
+			}
 		}
 	}
 
-	// 添加路由到表80
-	cmd = fmt.Sprintf("ip route add %s/32 dev %s table 80", t.RemoteVirtualIP, t.Name)
-	if err := execCommand(cmd); err != nil {
+	// 添加对端VIP路由
+	cmd = fmt.Sprintf("ip route replace %s/32 dev %s table %s", t.RemoteVirtualIP, t.Name, routeTable)
+	if err := execCommand(network.IPCommandLine(t.Netns, cmd)); err != nil {
 		return err
 	}
 
+	// 对端站点网段
+	for _, subnet := range t.RemoteSubnets {
+		cmd = fmt.Sprintf("ip route replace %s dev %s table %s", subnet, t.Name, routeTable)
+		if err := execCommand(network.IPCommandLine(t.Netns, cmd)); err != nil {
+			return err
+		}
+	}
+
+	// 本端网段映射（NETMAP）
+	if len(t.Netmap) > 0 {
+		if err := network.ApplyNetmap(t.Name, t.Netns, t.Netmap); err != nil {
+			return err
+		}
+		fmt.Printf("   ✓ 网段映射: %s\n", network.NetmapDisplay(t.Netmap))
+	}
+
 	fmt.Printf("   ✓ GRE隧道已创建\n")
+	if t.VRF != "" || t.Netns != "" {
+		fmt.Printf("   ✓ 隔离上下文: %s\n", network.IsolationLabel(t.VRF, t.Netns))
+	}
 
 	// 测试连通性
-	if pingHost(t.RemoteVirtualIP, 3) {
+	if network.ContextCommand(t.VRF, t.Netns, "ping", "-c", "3", "-W", "3", t.RemoteVirtualIP).Run() == nil {
 		fmt.Printf("   ✓ 隧道连接成功 (%s <-> %s)\n", t.LocalVirtualIP, t.RemoteVirtualIP)
 		return nil
 	} else {
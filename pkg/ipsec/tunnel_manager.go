@@ -5,6 +5,7 @@ import (
 	"net"
 	"time"
 
+	"trueword_node/pkg/config"
 	"trueword_node/pkg/network"
 	"trueword_node/pkg/wireguard"
 
@@ -81,8 +82,9 @@ func setupPolicyRoute(remoteIP, parentInterface, gateway string) error {
 		return nil
 	}
 
-	// 使用路由表50进行策略路由
-	const routeTable = 50
+	// 使用隧道对端策略路由表（默认表50）
+	ns := config.RoutingNamespace()
+	routeTable := ns.TunnelTable()
 
 	// 检查规则是否存在
 	rules, err := netlink.RuleList(netlink.FAMILY_V4)
@@ -102,7 +104,7 @@ func setupPolicyRoute(remoteIP, parentInterface, gateway string) error {
 	if !ruleExists {
 		rule := netlink.NewRule()
 		rule.Table = routeTable
-		rule.Priority = routeTable
+		rule.Priority = ns.TunnelPref
 		if err := netlink.RuleAdd(rule); err != nil {
 			return fmt.Errorf("添加路由规则失败: %w", err)
 		}
@@ -114,7 +116,7 @@ func setupPolicyRoute(remoteIP, parentInterface, gateway string) error {
 		return fmt.Errorf("获取接口 %s 失败: %w", parentInterface, err)
 	}
 
-	// 添加到路由表50
+	// 添加到隧道对端策略路由表
 	_, ipNet, err := net.ParseCIDR(remoteIP + "/32")
 	if err != nil {
 		return fmt.Errorf("解析IP失败: %w", err)
@@ -161,7 +163,7 @@ func setupPolicyRoute(remoteIP, parentInterface, gateway string) error {
 
 // removePolicyRoute 移除策略路由
 func removePolicyRoute(remoteIP, parentInterface string) error {
-	const routeTable = 50
+	routeTable := config.RoutingNamespace().TunnelTable()
 
 	// 获取父接口
 	link, err := netlink.LinkByName(parentInterface)
@@ -214,6 +216,9 @@ func (tm *TunnelManager) createIPsecTunnel() error {
 	if err := network.ValidateParentInterface(cfg.ParentInterface); err != nil {
 		return fmt.Errorf("❌ 父接口验证失败: %w", err)
 	}
+	if err := network.ValidateIsolation(cfg.VRF, cfg.Netns); err != nil {
+		return fmt.Errorf("❌ 隔离上下文验证失败: %w", err)
+	}
 
 	// 2. 如果未指定本地IP,从父接口获取
 	if cfg.LocalIP == "" {
@@ -268,6 +273,11 @@ func (tm *TunnelManager) createIPsecTunnel() error {
 		LocalVirtualIP:  cfg.LocalVIP,
 		RemoteVirtualIP: cfg.RemoteVIP,
 		GREKey:          greKey,
+		RemoteSubnets:   cfg.RoutedSubnets(),
+		VRF:             cfg.VRF,
+		Netns:           cfg.Netns,
+		Netmap:          cfg.NetmapLocal,
+		Firewall:        cfg.Firewall,
 	}
 
 	if err := tunnel.Create(); err != nil {
@@ -311,6 +321,9 @@ func (tm *TunnelManager) createWireGuardTunnel() error {
 	if err := network.ValidateParentInterface(cfg.ParentInterface); err != nil {
 		return fmt.Errorf("❌ 父接口验证失败: %w", err)
 	}
+	if err := network.ValidateIsolation(cfg.VRF, cfg.Netns); err != nil {
+		return fmt.Errorf("❌ 隔离上下文验证失败: %w", err)
+	}
 
 	// 2. 如果未指定本地IP,从父接口获取
 	if cfg.LocalIP == "" {
@@ -368,6 +381,12 @@ func (tm *TunnelManager) createWireGuardTunnel() error {
 		PeerPublicKey:  cfg.PeerPublicKey,
 		ListenPort:     cfg.ListenPort,
 		PeerListenPort: cfg.PeerListenPort,
+		RemoteSubnets:  cfg.RoutedSubnets(),
+		StrictAllowed:  cfg.WGStrictAllowed,
+		VRF:            cfg.VRF,
+		Netns:          cfg.Netns,
+		Netmap:         cfg.NetmapLocal,
+		Firewall:       cfg.Firewall,
 	}
 
 	if err := wgTunnel.Create(); err != nil {
@@ -470,16 +489,36 @@ func (tm *TunnelManager) Start() error {
 	fmt.Printf("  启动隧道: %s ... ", cfg.Name)
 
 	// 检查隧道是否已存在
-	if _, err := netlink.LinkByName(cfg.Name); err == nil {
+	if network.LinkExistsIn(cfg.Name, cfg.Netns) {
 		fmt.Printf("已运行\n")
 		return nil
 	}
 
+	if err := network.ValidateIsolation(cfg.VRF, cfg.Netns); err != nil {
+		fmt.Printf("失败 (隔离上下文错误)\n")
+		return err
+	}
+
 	// 根据隧道类型启动
+	var err error
 	if cfg.TunnelType == "wireguard" {
-		return tm.startWireGuardTunnel()
+		err = tm.startWireGuardTunnel()
+	} else {
+		err = tm.startIPsecTunnel()
 	}
-	return tm.startIPsecTunnel()
+	if err != nil {
+		return err
+	}
+
+	// 流量整形（失败不影响隧道运行）
+	if cfg.Shaping != nil {
+		if err := tm.SyncShaping(); err != nil {
+			fmt.Printf("   ⚠ 警告: 流量整形失败: %v\n", err)
+		} else {
+			fmt.Printf("   ✓ 流量整形: %s\n", cfg.Shaping.Summary())
+		}
+	}
+	return nil
 }
 
 // startIPsecTunnel 启动 IPsec 隧道
@@ -511,6 +550,11 @@ func (tm *TunnelManager) startIPsecTunnel() error {
 		LocalVirtualIP:  cfg.LocalVIP,
 		RemoteVirtualIP: cfg.RemoteVIP,
 		GREKey:          greKey,
+		RemoteSubnets:   cfg.RoutedSubnets(),
+		VRF:             cfg.VRF,
+		Netns:           cfg.Netns,
+		Netmap:          cfg.NetmapLocal,
+		Firewall:        cfg.Firewall,
 	}
 
 	if err := tunnel.Create(); err != nil {
@@ -552,6 +596,12 @@ func (tm *TunnelManager) startWireGuardTunnel() error {
 		PeerPublicKey:   cfg.PeerPublicKey,
 		ListenPort:      cfg.ListenPort,
 		PeerListenPort:  cfg.PeerListenPort,
+		RemoteSubnets:   cfg.RoutedSubnets(),
+		StrictAllowed:   cfg.WGStrictAllowed,
+		VRF:             cfg.VRF,
+		Netns:           cfg.Netns,
+		Netmap:          cfg.NetmapLocal,
+		Firewall:        cfg.Firewall,
 	}
 
 	if err := wgTunnel.Create(); err != nil {
@@ -574,7 +624,7 @@ func (tm *TunnelManager) Stop() error {
 	fmt.Printf("  停止隧道: %s ... ", cfg.Name)
 
 	// 检查隧道是否存在
-	if _, err := netlink.LinkByName(cfg.Name); err != nil {
+	if !network.LinkExistsIn(cfg.Name, cfg.Netns) {
 		fmt.Printf("未运行\n")
 		return nil
 	}
@@ -631,3 +681,119 @@ func (tm *TunnelManager) stopWireGuardTunnel() error {
 	fmt.Printf("✓\n")
 	return nil
 }
+
+// SyncNetmap 隧道运行中时同步本端网段映射（NETMAP）
+func (tm *TunnelManager) SyncNetmap() error {
+	cfg := tm.config
+
+	if !network.LinkExistsIn(cfg.Name, cfg.Netns) {
+		return nil
+	}
+
+	if len(cfg.NetmapLocal) == 0 {
+		network.RemoveNetmap(cfg.Name, cfg.Netns)
+		return nil
+	}
+
+	if err := network.ApplyNetmap(cfg.Name, cfg.Netns, cfg.NetmapLocal); err != nil {
+		return err
+	}
+
+	// 隧道启动时未配置映射的，补充撤销命令
+	return appendRevCommand(fmt.Sprintf("%s.rev", cfg.Name), network.NetmapRevCommand(cfg.Name, cfg.Netns))
+}
+
+// SyncFirewall 隧道运行中时同步隧道防火墙
+func (tm *TunnelManager) SyncFirewall() error {
+	cfg := tm.config
+
+	if !network.LinkExistsIn(cfg.Name, cfg.Netns) {
+		return nil
+	}
+
+	if cfg.Firewall == nil {
+		network.RemoveTunnelFirewall(cfg.Name, cfg.Netns)
+		return nil
+	}
+
+	if err := network.ApplyTunnelFirewall(cfg.Name, cfg.LocalVIP, cfg.Netns, cfg.Firewall); err != nil {
+		return err
+	}
+
+	// 隧道启动时未配置防火墙的，补充撤销命令
+	return appendRevCommand(fmt.Sprintf("%s.rev", cfg.Name), network.TunnelFirewallRevCommand(cfg.Name, cfg.Netns))
+}
+
+// SyncShaping 隧道运行中时同步流量整形（撤销命令追加到隧道的 rev 文件）
+func (tm *TunnelManager) SyncShaping() error {
+	cfg := tm.config
+
+	if !network.LinkExistsIn(cfg.Name, cfg.Netns) {
+		return nil
+	}
+
+	if err := network.ApplyShaping(cfg.Name, cfg.Netns, cfg.Shaping); err != nil {
+		return err
+	}
+	if cfg.Shaping == nil {
+		return nil
+	}
+
+	revFile := fmt.Sprintf("%s.rev", cfg.Name)
+	for _, command := range network.ShapingRevCommands(cfg.Name, cfg.Netns) {
+		if err := appendRevCommand(revFile, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncSubnets 隧道运行中时同步对端站点网段路由（VIP路由表）
+// oldSubnets 为修改前的对端网段，用于清理不再需要的路由
+func (tm *TunnelManager) SyncSubnets(oldSubnets []string) error {
+	cfg := tm.config
+
+	if !network.LinkExistsIn(cfg.Name, cfg.Netns) {
+		return nil
+	}
+
+	routeTable, err := network.PeerRouteTable(cfg.VRF, cfg.Netns)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for _, subnet := range cfg.RoutedSubnets() {
+		keep[subnet] = true
+	}
+
+	for _, subnet := range oldSubnets {
+		if !keep[subnet] {
+			execCommandNoError(network.IPCommandLine(cfg.Netns, fmt.Sprintf("ip route del %s dev %s table %s", subnet, cfg.Name, routeTable)))
+		}
+	}
+
+	for _, subnet := range cfg.RoutedSubnets() {
+		if err := execCommand(network.IPCommandLine(cfg.Netns, fmt.Sprintf("ip route replace %s dev %s table %s", subnet, cfg.Name, routeTable))); err != nil {
+			return err
+		}
+	}
+
+	// WireGuard 限制 allowed-ips 时需要同步对端配置
+	if cfg.TunnelType == "wireguard" && cfg.PeerPublicKey != "" {
+		wgTunnel := &wireguard.WireGuardTunnel{
+			RemoteVIP:     cfg.RemoteVIP,
+			RemoteSubnets: cfg.RoutedSubnets(),
+			StrictAllowed: cfg.WGStrictAllowed,
+		}
+		cmd := fmt.Sprintf("wg set %s peer %s allowed-ips %s", cfg.Name, cfg.PeerPublicKey, wgTunnel.AllowedIPs())
+		if cfg.Netns != "" {
+			cmd = fmt.Sprintf("ip netns exec %s %s", cfg.Netns, cmd)
+		}
+		if err := execCommand(cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -4,9 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -14,8 +11,6 @@ import (
 )
 
 const (
-	// 临时策略路由优先级（用于测试）
-	TestPolicyPriority = 5
 	// 检查结果缓存文件
 	CheckResultFile = "/var/lib/trueword_node/check_results.json"
 )
@@ -23,10 +18,12 @@ const (
 // CheckResult 检查结果
 type CheckResult struct {
 	TunnelName   string    `json:"tunnel_name"`
-	Status       string    `json:"status"`      // "UP", "DOWN", "IDLE"
-	Latency      float64   `json:"latency"`     // 延迟(毫秒)
-	PacketLoss   float64   `json:"packet_loss"` // 丢包率(百分比)
-	TargetIP     string    `json:"target_ip"`   // 成功响应的目标IP
+	Status       string    `json:"status"`             // "UP", "DOWN", "IDLE"
+	Latency      float64   `json:"latency"`            // 延迟(毫秒)
+	PacketLoss   float64   `json:"packet_loss"`        // 丢包率(百分比)
+	Jitter       float64   `json:"jitter"`             // 抖动(毫秒，ping mdev)
+	Failures     int       `json:"failures,omitempty"` // 连续失败计数（守护进程提供，用于失败惩罚）
+	TargetIP     string    `json:"target_ip"`          // 成功响应的目标IP
 	CheckTime    time.Time `json:"check_time"`
 	ErrorMessage string    `json:"error_message,omitempty"`
 }
@@ -37,146 +34,19 @@ type AllCheckResults struct {
 	LastUpdate time.Time               `json:"last_update"`
 }
 
-// ping解析正则
-var (
-	// ping统计行: "5 packets transmitted, 5 received, 0% packet loss, time 4005ms"
-	pingStatsRegex = regexp.MustCompile(`(\d+) packets transmitted, (\d+) received, (\d+)% packet loss`)
-	// RTT行: "rtt min/avg/max/mdev = 10.123/15.456/20.789/5.012 ms"
-	rttRegex = regexp.MustCompile(`rtt min/avg/max/mdev = ([\d.]+)/([\d.]+)/([\d.]+)/([\d.]+) ms`)
-)
-
-// parsePingOutput 解析ping输出
-func parsePingOutput(output string) (avgLatency float64, packetLoss float64, err error) {
-	// 解析丢包率
-	statsMatch := pingStatsRegex.FindStringSubmatch(output)
-	if statsMatch == nil {
-		return 0, 0, fmt.Errorf("无法解析ping统计信息")
-	}
-
-	transmitted, _ := strconv.Atoi(statsMatch[1])
-	received, _ := strconv.Atoi(statsMatch[2])
-	loss, _ := strconv.Atoi(statsMatch[3])
-
-	if transmitted == 0 {
-		return 0, 100, nil
-	}
-
-	packetLoss = float64(loss)
-
-	// 如果全部丢包，不解析RTT
-	if received == 0 {
-		return 0, packetLoss, nil
-	}
-
-	// 解析平均延迟
-	rttMatch := rttRegex.FindStringSubmatch(output)
-	if rttMatch == nil {
-		return 0, packetLoss, fmt.Errorf("无法解析RTT信息")
-	}
-
-	avgLatency, _ = strconv.ParseFloat(rttMatch[2], 64)
-
-	return avgLatency, packetLoss, nil
-}
-
-// addTestPolicyRoute 添加临时测试策略路由
-func addTestPolicyRoute(targetIP, exitInterface string) error {
-	// 添加策略路由规则: ip rule add to <targetIP> lookup <table> pref <prio>
-	// 使用一个临时路由表（例如表5）
-	tableID := TestPolicyPriority
-
-	// 先清理可能存在的旧规则和路由（确保干净状态）
-	removeTestPolicyRoute(targetIP)
-
-	// 添加路由规则
-	cmd := exec.Command("ip", "rule", "add", "to", targetIP, "lookup", strconv.Itoa(tableID), "pref", strconv.Itoa(TestPolicyPriority))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("添加测试路由规则失败: %w", err)
-	}
-
-	// 检查是否是物理接口（通过查找配置）
-	ifaceConfig, err := LoadInterfaceConfig()
-	var gateway string
-	isPhysical := false
-
-	if err == nil {
-		for _, iface := range ifaceConfig.Interfaces {
-			if iface.Name == exitInterface {
-				isPhysical = true
-				gateway = iface.Gateway
-				break
-			}
-		}
-	}
-
-	// 添加路由（支持 onlink 容错）
-	var routeCmd *exec.Cmd
-	if isPhysical && gateway != "" {
-		// 物理接口：通过网关路由
-		// 第一次尝试：正常路由
-		routeCmd = exec.Command("ip", "route", "add", targetIP, "via", gateway, "dev", exitInterface, "table", strconv.Itoa(tableID))
-		err = routeCmd.Run()
-		if err != nil {
-			// 第二次尝试：添加 onlink 标志（支持 VPS/云服务器跨子网网关）
-			routeCmd = exec.Command("ip", "route", "add", targetIP, "via", gateway, "dev", exitInterface, "table", strconv.Itoa(tableID), "onlink")
-			err = routeCmd.Run()
-		}
-	} else {
-		// 隧道或无网关的P2P连接：直接通过设备路由
-		routeCmd = exec.Command("ip", "route", "add", targetIP, "dev", exitInterface, "table", strconv.Itoa(tableID))
-		err = routeCmd.Run()
-	}
-
+// pingExit 通过出口设备进行ping测试（进程内 ICMP 探测，不需要临时策略路由）
+// netns 非空时在网络命名空间内探测；包间隔0.2秒，最后一个包发出后等待 timeout 秒
+func pingExit(targetIP, exitInterface, netns string, count int, timeout int) (avgLatency float64, packetLoss float64, jitter float64, err error) {
+	results, err := ProbeExit(exitInterface, []string{targetIP}, ProbeOptions{
+		Count:    count,
+		Interval: 200 * time.Millisecond,
+		Timeout:  time.Duration(timeout) * time.Second,
+		Netns:    netns,
+	})
 	if err != nil {
-		// 清理规则
-		exec.Command("ip", "rule", "del", "pref", strconv.Itoa(TestPolicyPriority)).Run()
-		return fmt.Errorf("添加测试路由失败: %w", err)
+		return 0, 0, 0, fmt.Errorf("ping失败: %w", err)
 	}
-
-	return nil
-}
-
-// removeTestPolicyRoute 删除临时测试策略路由
-func removeTestPolicyRoute(targetIP string) {
-	tableID := TestPolicyPriority
-
-	// 删除路由
-	exec.Command("ip", "route", "del", targetIP, "table", strconv.Itoa(tableID)).Run()
-
-	// 删除规则
-	exec.Command("ip", "rule", "del", "pref", strconv.Itoa(TestPolicyPriority)).Run()
-}
-
-// pingWithRoute 使用指定出口进行ping测试
-func pingWithRoute(targetIP, exitInterface string, count int, timeout int) (avgLatency float64, packetLoss float64, err error) {
-	// 添加临时策略路由
-	if err := addTestPolicyRoute(targetIP, exitInterface); err != nil {
-		return 0, 0, err
-	}
-	defer removeTestPolicyRoute(targetIP)
-
-	// 执行ping
-	// -c: 包数量
-	// -W: 超时时间(秒)
-	// -i: 包间隔(秒)，0.2秒可大幅提速
-	// -A: 自适应模式，收到回复后立即发送下一个包
-	cmd := exec.Command("ping", "-c", strconv.Itoa(count), "-W", strconv.Itoa(timeout), "-i", "0.2", targetIP)
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		// ping失败，尝试解析输出
-		if len(output) > 0 {
-			_, loss, parseErr := parsePingOutput(string(output))
-			if parseErr == nil && loss == 100 {
-				// 全部丢包
-				return 0, 100, nil
-			}
-		}
-		return 0, 0, fmt.Errorf("ping失败: %w", err)
-	}
-
-	// 解析输出
-	return parsePingOutput(string(output))
+	return results[0].Latency, results[0].PacketLoss, results[0].Jitter, nil
 }
 
 // CheckTunnel 检查单个隧道的连通性
@@ -207,8 +77,8 @@ func CheckTunnel(tunnelName string, targetIPs []string) *CheckResult {
 		}
 	}
 
-	// 检查隧道接口是否存在
-	if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s", tunnelName)); os.IsNotExist(err) {
+	// 检查隧道接口是否存在（网络命名空间内的隧道在命名空间内检查）
+	if !LinkExistsIn(tunnelName, tunnelConfig.Netns) {
 		result.Status = "IDLE"
 		result.ErrorMessage = "隧道未启动"
 		return result
@@ -224,7 +94,8 @@ func CheckTunnel(tunnelName string, targetIPs []string) *CheckResult {
 	}
 
 	for _, targetIP := range targetIPs {
-		avgLatency, packetLoss, err := pingWithRoute(targetIP, tunnelName, 20, 1)
+		// VRF 内的隧道绑定设备即可，网络命名空间内的隧道在命名空间内探测
+		avgLatency, packetLoss, jitter, err := pingExit(targetIP, tunnelName, tunnelConfig.Netns, 20, 1)
 
 		// 记录最后一次测试结果
 		lastResult.targetIP = targetIP
@@ -241,6 +112,7 @@ func CheckTunnel(tunnelName string, targetIPs []string) *CheckResult {
 		result.TargetIP = targetIP
 		result.Latency = avgLatency
 		result.PacketLoss = packetLoss
+		result.Jitter = jitter
 
 		// 如果丢包率 < 100%，说明这个IP是通的，直接返回UP
 		if packetLoss < 100 {
@@ -335,7 +207,7 @@ func CheckInterface(interfaceName string, targetIPs []string) *CheckResult {
 	}
 
 	for _, targetIP := range targetIPs {
-		avgLatency, packetLoss, err := pingWithRoute(targetIP, interfaceName, 20, 1)
+		avgLatency, packetLoss, jitter, err := pingExit(targetIP, interfaceName, "", 20, 1)
 
 		// 记录最后一次测试结果
 		lastResult.targetIP = targetIP
@@ -352,6 +224,7 @@ func CheckInterface(interfaceName string, targetIPs []string) *CheckResult {
 		result.TargetIP = targetIP
 		result.Latency = avgLatency
 		result.PacketLoss = packetLoss
+		result.Jitter = jitter
 
 		// 如果丢包率 < 100%，说明这个IP是通的，直接返回UP
 		if packetLoss < 100 {
@@ -0,0 +1,59 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 出口成本的临时覆盖（由故障转移守护进程的定时任务设置，如夜间降低廉价中转的成本）
+// 覆盖值替代配置中的 Cost 参与评分（流量配额在覆盖值的基础上继续上调），恢复后使用配置值
+
+const CostOverrideFile = "/var/lib/trueword_node/cost_overrides.json"
+
+// LoadCostOverrides 加载出口成本覆盖（不存在时返回空）
+func LoadCostOverrides() map[string]int {
+	overrides := make(map[string]int)
+	data, err := os.ReadFile(CostOverrideFile)
+	if err != nil {
+		return overrides
+	}
+	json.Unmarshal(data, &overrides)
+	return overrides
+}
+
+// SetCostOverride 设置出口成本覆盖
+func SetCostOverride(exitName string, cost int) error {
+	if cost < 0 || cost > 100 {
+		return fmt.Errorf("成本值必须在 0-100 之间")
+	}
+	overrides := LoadCostOverrides()
+	overrides[exitName] = cost
+	return saveCostOverrides(overrides)
+}
+
+// ClearCostOverride 恢复出口的配置成本
+func ClearCostOverride(exitName string) error {
+	overrides := LoadCostOverrides()
+	if _, ok := overrides[exitName]; !ok {
+		return nil
+	}
+	delete(overrides, exitName)
+	return saveCostOverrides(overrides)
+}
+
+// saveCostOverrides 保存出口成本覆盖
+func saveCostOverrides(overrides map[string]int) error {
+	if err := os.MkdirAll(filepath.Dir(CostOverrideFile), 0755); err != nil {
+		return fmt.Errorf("创建状态目录失败: %w", err)
+	}
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化成本覆盖失败: %w", err)
+	}
+	if err := os.WriteFile(CostOverrideFile, data, 0644); err != nil {
+		return fmt.Errorf("写入成本覆盖失败: %w", err)
+	}
+	return nil
+}
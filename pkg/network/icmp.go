@@ -0,0 +1,451 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"trueword_node/pkg/config"
+)
+
+// 进程内 ICMP 探测
+// 原始套接字通过 SO_BINDTODEVICE 绑定到出口设备，一个套接字同时探测多个目标，
+// 发送时间和内核接收时间戳（SO_TIMESTAMPNS）计算每个包的往返时间，不再 fork ping 和安装临时规则:
+//   - 隧道/无网关的P2P接口: 绑定设备后内核直接从该设备发出（目标视为链路上可达）
+//   - 有网关的物理接口: 套接字打上探测标记（SO_MARK），常驻规则 fwmark <检测表号> lookup <检测表> pref <test_pref>
+//     只匹配探测报文，检测表中每个物理出口一条经网关的默认路由（metric 为接口索引），由绑定的设备选中
+//   - VRF 内的隧道: 绑定设备即在 VRF 内路由
+//   - 网络命名空间内的隧道: 在命名空间内创建套接字
+// 注意: 回复报文不带探测标记，物理出口启用严格反向路径过滤（rp_filter=1）时回复会被丢弃，需使用宽松模式（2）
+
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+	icmpFilter      = 1  // ICMP_FILTER (linux/icmp.h)
+	icmpPayloadSize = 56 // 与 ping 默认一致
+
+	// 接收超时（用于周期性检查是否结束）
+	probeRecvTimeout = 50 * time.Millisecond
+)
+
+// ProbeOptions 探测参数
+type ProbeOptions struct {
+	Count    int           // 每个目标的包数量
+	Interval time.Duration // 同一目标相邻两个包的间隔
+	Timeout  time.Duration // 最后一个包发出后等待回复的时间
+	Netns    string        // 出口所在的网络命名空间（空为默认命名空间）
+}
+
+// ProbeResult 单个目标的探测结果
+type ProbeResult struct {
+	Target     string
+	Sent       int
+	Received   int
+	Latency    float64 // 平均往返时间（ms）
+	PacketLoss float64 // 丢包率（%）
+	Jitter     float64 // 往返时间的平均偏差（ms，与 ping mdev 一致）
+}
+
+// probeIdent 探测标识计数器（同一进程内并发的探测使用不同的 ICMP 标识）
+var probeIdent uint32
+
+// probeRouteMutex 串行化探测规则的检查和添加
+var probeRouteMutex sync.Mutex
+
+// ProbeExit 通过出口设备并行探测多个目标（每轮向所有目标各发一个包，共 Count 轮）
+// 套接字无法创建或绑定（接口不存在、权限不足）时返回错误；发送失败的包计为丢包
+func ProbeExit(iface string, targets []string, opts ProbeOptions) ([]*ProbeResult, error) {
+	if opts.Count <= 0 {
+		return nil, fmt.Errorf("探测包数量必须大于 0")
+	}
+	if opts.Count*len(targets) > math.MaxUint16 {
+		return nil, fmt.Errorf("探测包数量过多: %d 个目标 × %d", len(targets), opts.Count)
+	}
+
+	addrs := make([][4]byte, len(targets))
+	for i, target := range targets {
+		ip := net.ParseIP(target).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("无效的探测目标: %s", target)
+		}
+		copy(addrs[i][:], ip)
+	}
+
+	mark := 0
+	if opts.Netns == "" {
+		var err error
+		if mark, err = ProbeMark(iface); err != nil {
+			return nil, err
+		}
+	}
+
+	fd, err := openProbeSocket(iface, opts.Netns, mark)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	p := &prober{
+		fd:    fd,
+		ident: uint16(os.Getpid()) + uint16(atomic.AddUint32(&probeIdent, 1)),
+		addrs: addrs,
+		sent:  make([]time.Time, opts.Count*len(targets)),
+		rtts:  make([][]float64, len(targets)),
+		seen:  make([]bool, opts.Count*len(targets)),
+	}
+	p.run(opts)
+
+	results := make([]*ProbeResult, len(targets))
+	for i, target := range targets {
+		results[i] = summarizeProbe(target, opts.Count, p.rtts[i])
+	}
+	return results, nil
+}
+
+// ProbeMark 出口的探测标记: 有网关的物理接口确保探测规则和路由后返回标记，其他接口返回 0（仅绑定设备）
+// 同时供其他进程内检测（如 DNS 查询）的套接字使用
+func ProbeMark(iface string) (int, error) {
+	ifaceConfig, err := LoadInterfaceConfig()
+	if err != nil {
+		return 0, nil
+	}
+	for _, physIface := range ifaceConfig.Interfaces {
+		if physIface.Name == iface && physIface.Gateway != "" {
+			return ensureProbeRoute(iface, physIface.Gateway)
+		}
+	}
+	return 0, nil
+}
+
+// ensureProbeRoute 确保探测规则和物理出口在检测表中的默认路由，返回探测标记（检测表号）
+// 同时删除旧版本按目标添加的检测临时规则残留
+func ensureProbeRoute(iface, gateway string) (int, error) {
+	ns := config.RoutingNamespace()
+	tableID := ns.TestTable()
+
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return 0, fmt.Errorf("获取接口 %s 失败: %w", iface, err)
+	}
+	gw := net.ParseIP(gateway)
+	if gw == nil {
+		return 0, fmt.Errorf("接口 %s 的网关无效: %s", iface, gateway)
+	}
+
+	probeRouteMutex.Lock()
+	defer probeRouteMutex.Unlock()
+
+	rules, err := netlink.RuleList(netlink.FAMILY_V4)
+	if err != nil {
+		return 0, fmt.Errorf("获取路由规则失败: %w", err)
+	}
+	ruleExists := false
+	for i := range rules {
+		rule := rules[i]
+		if rule.Priority != ns.TestPref {
+			continue
+		}
+		if rule.Mark == tableID && rule.Table == tableID && !ruleExists {
+			ruleExists = true
+			continue
+		}
+		netlink.RuleDel(&rule)
+	}
+
+	if !ruleExists {
+		rule := netlink.NewRule()
+		rule.Priority = ns.TestPref
+		rule.Mark = tableID
+		rule.Table = tableID
+		if err := netlink.RuleAdd(rule); err != nil {
+			return 0, fmt.Errorf("添加探测规则失败: %w", err)
+		}
+	}
+
+	// 每个物理出口一条默认路由，metric 取接口索引以便共存；绑定设备的套接字只会选中本设备的路由
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Gw:        gw,
+		Table:     tableID,
+		Priority:  link.Attrs().Index,
+	}
+	if err := netlink.RouteReplace(route); err != nil {
+		// 添加 onlink 标志重试（支持 VPS/云服务器跨子网网关）
+		route.Flags = int(netlink.FLAG_ONLINK)
+		if err := netlink.RouteReplace(route); err != nil {
+			return 0, fmt.Errorf("添加探测路由失败: %w", err)
+		}
+	}
+
+	return tableID, nil
+}
+
+// openProbeSocket 创建绑定到出口设备的 ICMP 原始套接字（namespace 非空时在该网络命名空间内创建）
+func openProbeSocket(iface, namespace string, mark int) (int, error) {
+	if namespace == "" {
+		return newProbeSocket(iface, mark)
+	}
+
+	// 切换网络命名空间只影响当前线程，在独立的 goroutine 中锁定线程执行；
+	// 恢复失败时不解锁，goroutine 退出时该线程随之销毁
+	type socketResult struct {
+		fd  int
+		err error
+	}
+	done := make(chan socketResult, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		origin, err := netns.Get()
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- socketResult{-1, fmt.Errorf("获取当前网络命名空间失败: %w", err)}
+			return
+		}
+		defer origin.Close()
+
+		target, err := netns.GetFromName(namespace)
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- socketResult{-1, fmt.Errorf("打开网络命名空间 %s 失败: %w", namespace, err)}
+			return
+		}
+		defer target.Close()
+
+		if err := netns.Set(target); err != nil {
+			runtime.UnlockOSThread()
+			done <- socketResult{-1, fmt.Errorf("进入网络命名空间 %s 失败: %w", namespace, err)}
+			return
+		}
+		fd, err := newProbeSocket(iface, mark)
+		if netns.Set(origin) == nil {
+			runtime.UnlockOSThread()
+		}
+		done <- socketResult{fd, err}
+	}()
+
+	result := <-done
+	return result.fd, result.err
+}
+
+// newProbeSocket 在当前网络命名空间创建 ICMP 原始套接字并绑定设备
+func newProbeSocket(iface string, mark int) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return -1, fmt.Errorf("创建 ICMP 套接字失败: %w", err)
+	}
+
+	setup := func() error {
+		if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface); err != nil {
+			return fmt.Errorf("绑定接口 %s 失败: %w", iface, err)
+		}
+		if mark != 0 {
+			if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+				return fmt.Errorf("设置探测标记失败: %w", err)
+			}
+		}
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1); err != nil {
+			return fmt.Errorf("启用接收时间戳失败: %w", err)
+		}
+		// 只接收 echo reply，其他 ICMP 报文由内核过滤
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_RAW, icmpFilter, ^(1 << icmpEchoReply)); err != nil {
+			return fmt.Errorf("设置 ICMP 过滤失败: %w", err)
+		}
+		tv := syscall.NsecToTimeval(probeRecvTimeout.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return fmt.Errorf("设置接收超时失败: %w", err)
+		}
+		return nil
+	}
+	if err := setup(); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// prober 一次探测的状态（序号 = 轮次 × 目标数 + 目标索引）
+type prober struct {
+	fd    int
+	ident uint16
+	addrs [][4]byte
+
+	mutex sync.Mutex
+	sent  []time.Time // 每个序号的发送时间（零值表示未发送）
+	seen  []bool      // 每个序号是否已收到回复（忽略重复回复）
+	rtts  [][]float64 // 每个目标的往返时间（ms）
+}
+
+// run 发送所有探测包并接收回复，直到全部收到或最后一个包发出后超时
+func (p *prober) run(opts ProbeOptions) {
+	total := len(p.sent)
+	var lastSent time.Time
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for round := 0; round < opts.Count; round++ {
+			for i, addr := range p.addrs {
+				seq := round*len(p.addrs) + i
+				packet := echoRequest(p.ident, uint16(seq))
+
+				p.mutex.Lock()
+				p.sent[seq] = time.Now()
+				p.mutex.Unlock()
+
+				if err := syscall.Sendto(p.fd, packet, 0, &syscall.SockaddrInet4{Addr: addr}); err != nil {
+					// 发送失败（如设备 DOWN）计为丢包
+					continue
+				}
+			}
+			if round < opts.Count-1 {
+				time.Sleep(opts.Interval)
+			}
+		}
+		lastSent = time.Now()
+	}()
+
+	buf := make([]byte, 1500)
+	oob := make([]byte, syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{}))))
+	received, sending := 0, true
+	for {
+		if !sending && (received == total || time.Since(lastSent) >= opts.Timeout) {
+			return
+		}
+		if sending {
+			select {
+			case <-done:
+				sending = false
+			default:
+			}
+		}
+
+		n, oobn, _, from, err := syscall.Recvmsg(p.fd, buf, oob, 0)
+		if err != nil {
+			// 接收超时（EAGAIN）或被信号打断，继续检查是否结束
+			continue
+		}
+		if p.handleReply(buf[:n], oob[:oobn], from) {
+			received++
+		}
+	}
+}
+
+// handleReply 处理收到的报文，属于本次探测的有效回复时记录往返时间
+func (p *prober) handleReply(packet, oob []byte, from syscall.Sockaddr) bool {
+	recvTime := receiveTimestamp(oob)
+
+	// 原始套接字收到的报文包含 IP 头
+	if len(packet) < 20 {
+		return false
+	}
+	headerLen := int(packet[0]&0x0f) * 4
+	if len(packet) < headerLen+8 {
+		return false
+	}
+	icmp := packet[headerLen:]
+	if icmp[0] != icmpEchoReply || binary.BigEndian.Uint16(icmp[4:6]) != p.ident {
+		return false
+	}
+
+	seq := int(binary.BigEndian.Uint16(icmp[6:8]))
+	if seq >= len(p.sent) {
+		return false
+	}
+	index := seq % len(p.addrs)
+	src, ok := from.(*syscall.SockaddrInet4)
+	if !ok || src.Addr != p.addrs[index] {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.sent[seq].IsZero() || p.seen[seq] {
+		return false
+	}
+	p.seen[seq] = true
+
+	rtt := recvTime.Sub(p.sent[seq])
+	if rtt < 0 {
+		rtt = 0
+	}
+	p.rtts[index] = append(p.rtts[index], float64(rtt.Nanoseconds())/1e6)
+	return true
+}
+
+// receiveTimestamp 从控制消息中取出内核接收时间戳（不可用时使用当前时间）
+func receiveTimestamp(oob []byte) time.Time {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err == nil {
+		for _, m := range messages {
+			if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMPNS &&
+				len(m.Data) >= int(unsafe.Sizeof(syscall.Timespec{})) {
+				ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+				return time.Unix(ts.Unix())
+			}
+		}
+	}
+	return time.Now()
+}
+
+// echoRequest 构造 ICMP echo request（负载前8字节为发送时间，便于抓包排查）
+func echoRequest(ident, seq uint16) []byte {
+	packet := make([]byte, 8+icmpPayloadSize)
+	packet[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(packet[4:6], ident)
+	binary.BigEndian.PutUint16(packet[6:8], seq)
+	binary.BigEndian.PutUint64(packet[8:16], uint64(time.Now().UnixNano()))
+	for i := 16; i < len(packet); i++ {
+		packet[i] = byte(i)
+	}
+	binary.BigEndian.PutUint16(packet[2:4], icmpChecksum(packet))
+	return packet
+}
+
+// icmpChecksum ICMP 校验和（RFC 1071）
+func icmpChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// summarizeProbe 汇总单个目标的往返时间: 平均值和平均偏差（mdev = sqrt(E[rtt²] - E[rtt]²)）
+func summarizeProbe(target string, sent int, rtts []float64) *ProbeResult {
+	result := &ProbeResult{
+		Target:     target,
+		Sent:       sent,
+		Received:   len(rtts),
+		PacketLoss: float64(sent-len(rtts)) / float64(sent) * 100,
+	}
+	if len(rtts) == 0 {
+		return result
+	}
+
+	var sum, sumSquares float64
+	for _, rtt := range rtts {
+		sum += rtt
+		sumSquares += rtt * rtt
+	}
+	avg := sum / float64(len(rtts))
+	result.Latency = avg
+	result.Jitter = math.Sqrt(math.Max(sumSquares/float64(len(rtts))-avg*avg, 0))
+	return result
+}
@@ -17,11 +17,14 @@ const (
 
 // PhysicalInterface 物理网络接口
 type PhysicalInterface struct {
-	Name    string `yaml:"name"`    // 接口名 (如 eth0, ens33)
-	IP      string `yaml:"ip"`      // IP地址
-	Gateway string `yaml:"gateway"` // 网关地址
-	Cost    int    `yaml:"cost"`    // 成本 (0-100, 默认0)
-	Enabled bool   `yaml:"enabled"` // 是否启用
+	Name    string   `yaml:"name"`               // 接口名 (如 eth0, ens33)
+	IP      string   `yaml:"ip"`                 // IP地址
+	Gateway string   `yaml:"gateway"`            // 网关地址
+	Cost    int      `yaml:"cost"`               // 成本 (0-100, 默认0)
+	Enabled bool     `yaml:"enabled"`            // 是否启用
+	NATMode string   `yaml:"nat_mode,omitempty"` // 出口NAT: masquerade(默认) / snat:<地址> / none
+	Shaping *Shaping `yaml:"shaping,omitempty"`  // 流量整形（可选）
+	Quota   *Quota   `yaml:"quota,omitempty"`    // 流量配额（可选）
 }
 
 // InterfaceConfig 所有物理接口配置
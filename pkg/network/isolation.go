@@ -0,0 +1,188 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"trueword_node/pkg/config"
+)
+
+// 多租户隔离: 隧道和策略组可以放入 VRF 或网络命名空间（二选一，均为空表示默认上下文）
+// VRF: 隧道接口加入 VRF 设备，VIP/对端网段路由写入 VRF 的路由表
+// 网络命名空间: 隧道接口创建后移入命名空间（underlay 仍在默认命名空间），路由和策略规则在命名空间内维护
+
+// NetnsDir ip netns 命名空间挂载目录
+const NetnsDir = "/var/run/netns"
+
+// ValidateIsolation 校验隔离上下文
+func ValidateIsolation(vrf, netns string) error {
+	if vrf != "" && netns != "" {
+		return fmt.Errorf("VRF 和网络命名空间不能同时指定")
+	}
+	if vrf != "" {
+		if _, err := VRFTable(vrf); err != nil {
+			return err
+		}
+	}
+	if netns != "" && !NetnsExists(netns) {
+		return fmt.Errorf("网络命名空间 %s 不存在，请先创建: ip netns add %s", netns, netns)
+	}
+	return nil
+}
+
+// VRFTable 返回 VRF 设备绑定的路由表
+func VRFTable(vrf string) (int, error) {
+	link, err := netlink.LinkByName(vrf)
+	if err != nil {
+		return 0, fmt.Errorf("VRF %s 不存在，请先创建: ip link add %s type vrf table <表号> && ip link set %s up", vrf, vrf, vrf)
+	}
+	dev, ok := link.(*netlink.Vrf)
+	if !ok {
+		return 0, fmt.Errorf("接口 %s 不是 VRF 设备", vrf)
+	}
+	return int(dev.Table), nil
+}
+
+// LinkVRF 返回接口所属的 VRF 名称（不属于任何 VRF 时返回空）
+func LinkVRF(name string) string {
+	link, err := netlink.LinkByName(name)
+	if err != nil || link.Attrs().MasterIndex == 0 {
+		return ""
+	}
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		return ""
+	}
+	if _, ok := master.(*netlink.Vrf); !ok {
+		return ""
+	}
+	return master.Attrs().Name
+}
+
+// NetnsExists 判断网络命名空间是否存在
+func NetnsExists(netns string) bool {
+	_, err := os.Stat(filepath.Join(NetnsDir, netns))
+	return err == nil
+}
+
+// IPArgs 在指定网络命名空间中执行 ip 命令的参数（netns 为空时为默认命名空间）
+func IPArgs(netns string, args ...string) []string {
+	if netns == "" {
+		return args
+	}
+	return append([]string{"-n", netns}, args...)
+}
+
+// IPCommand 构造在指定网络命名空间中执行的 ip 命令
+func IPCommand(netns string, args ...string) *exec.Cmd {
+	return exec.Command("ip", IPArgs(netns, args...)...)
+}
+
+// IPCommandLine 将 "ip ..." 命令行改写为在指定网络命名空间中执行
+func IPCommandLine(netns, cmd string) string {
+	if netns == "" || !strings.HasPrefix(cmd, "ip ") {
+		return cmd
+	}
+	return "ip -n " + netns + " " + strings.TrimPrefix(cmd, "ip ")
+}
+
+// ContextCommand 构造在隔离上下文中执行的命令（ping、dig 等探测命令）
+func ContextCommand(vrf, netns, name string, args ...string) *exec.Cmd {
+	switch {
+	case vrf != "":
+		return exec.Command("ip", append([]string{"vrf", "exec", vrf, name}, args...)...)
+	case netns != "":
+		return exec.Command("ip", append([]string{"netns", "exec", netns, name}, args...)...)
+	}
+	return exec.Command(name, args...)
+}
+
+// LinkExistsIn 判断接口在指定网络命名空间中是否存在
+func LinkExistsIn(name, netns string) bool {
+	if netns == "" {
+		_, err := netlink.LinkByName(name)
+		return err == nil
+	}
+	return IPCommand(netns, "link", "show", "dev", name).Run() == nil
+}
+
+// LinkUpIn 判断接口在指定网络命名空间中是否存在且UP
+func LinkUpIn(name, netns string) bool {
+	if netns == "" {
+		return IsInterfaceUp(name)
+	}
+	output, err := IPCommand(netns, "-o", "link", "show", "dev", name).Output()
+	if err != nil {
+		return false
+	}
+	// 输出格式: 5: tun01@NONE: <POINTOPOINT,NOARP,UP,LOWER_UP> ...
+	start := strings.Index(string(output), "<")
+	end := strings.Index(string(output), ">")
+	if start < 0 || end < start {
+		return false
+	}
+	for _, flag := range strings.Split(string(output)[start+1:end], ",") {
+		if flag == "UP" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsolationLabel 隔离上下文的显示文本
+func IsolationLabel(vrf, netns string) string {
+	switch {
+	case vrf != "":
+		return "vrf:" + vrf
+	case netns != "":
+		return "netns:" + netns
+	}
+	return "-"
+}
+
+// TunnelNetns 返回隧道所在的网络命名空间（非隧道或默认命名空间返回空）
+func TunnelNetns(name string) string {
+	cfg, err := LoadTunnelConfig(name)
+	if err != nil {
+		return ""
+	}
+	return cfg.Netns
+}
+
+// PeerRouteTable 对端VIP和对端网段路由所在的路由表
+// 默认上下文为VIP路由表；VRF 隧道为VRF的路由表；网络命名空间内的隧道为命名空间内的主路由表
+func PeerRouteTable(vrf, netns string) (string, error) {
+	switch {
+	case vrf != "":
+		table, err := VRFTable(vrf)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(table), nil
+	case netns != "":
+		return "main", nil
+	}
+	return strconv.Itoa(config.RoutingNamespace().VIPTable()), nil
+}
+
+// EnterIsolation 将新建的隧道接口放入隔离上下文（加入VRF或移入网络命名空间）
+func EnterIsolation(name, vrf, netns string) error {
+	var args []string
+	switch {
+	case vrf != "":
+		args = []string{"link", "set", "dev", name, "master", vrf}
+	case netns != "":
+		args = []string{"link", "set", "dev", name, "netns", netns}
+	default:
+		return nil
+	}
+	if output, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("接口 %s 加入 %s 失败: %v, 输出: %s", name, IsolationLabel(vrf, netns), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// 1:1 网段映射（NETMAP）
+// 两端站点使用相同网段（如都为 192.168.1.0/24）时，每端把自己的真实网段映射为一个全局唯一的网段:
+//   - 本端映射 (netmap_local): 出隧道时 SNAT 真实网段 → 映射网段，入隧道时 DNAT 映射网段 → 真实网段（本端 nftables 执行）
+//   - 对端映射 (netmap_remote): 由对端执行，本端只把对端的映射网段路由到隧道
+// 两端的配置互为镜像，show-peer 输出对端需要执行的命令
+// 每个隧道使用独立的 nftables 表 ip twnode_netmap_<隧道名>，随隧道创建和撤销
+
+// NetmapRule 网段映射: 真实网段在隧道上以映射网段出现（前缀长度相同）
+type NetmapRule struct {
+	Real   string `yaml:"real"`
+	Mapped string `yaml:"mapped"`
+}
+
+// String 映射的文本形式: 真实网段=映射网段
+func (r NetmapRule) String() string {
+	return r.Real + "=" + r.Mapped
+}
+
+// ParseNetmap 解析逗号分隔的网段映射列表: 192.168.1.0/24=10.101.1.0/24,...
+func ParseNetmap(value string) ([]NetmapRule, error) {
+	rules := make([]NetmapRule, 0)
+	reals := make(map[string]bool)
+	mapped := make(map[string]bool)
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("无效的网段映射 '%s' (格式: 真实网段=映射网段)", item)
+		}
+
+		realNet, err := parseNetmapPrefix(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		mappedNet, err := parseNetmapPrefix(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		realOnes, _ := realNet.Mask.Size()
+		mappedOnes, _ := mappedNet.Mask.Size()
+		if realOnes != mappedOnes {
+			return nil, fmt.Errorf("网段映射 %s 两端前缀长度必须相同", item)
+		}
+		if realNet.String() == mappedNet.String() {
+			return nil, fmt.Errorf("网段映射 %s 的真实网段与映射网段相同", item)
+		}
+		if reals[realNet.String()] || mapped[mappedNet.String()] {
+			return nil, fmt.Errorf("网段映射 %s 与其他映射重复", item)
+		}
+
+		reals[realNet.String()] = true
+		mapped[mappedNet.String()] = true
+		rules = append(rules, NetmapRule{Real: realNet.String(), Mapped: mappedNet.String()})
+	}
+
+	return rules, nil
+}
+
+// parseNetmapPrefix 解析映射中的 IPv4 网段
+func parseNetmapPrefix(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		value += "/32"
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil || ipNet.IP.To4() == nil {
+		return nil, fmt.Errorf("无效的网段: %s", value)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones == 0 {
+		return nil, fmt.Errorf("网段不能为默认路由: %s", value)
+	}
+	return ipNet, nil
+}
+
+// NetmapDisplay 网段映射列表显示
+func NetmapDisplay(rules []NetmapRule) string {
+	if len(rules) == 0 {
+		return "(无)"
+	}
+	items := make([]string, len(rules))
+	for i, rule := range rules {
+		items[i] = rule.Real + " ⇄ " + rule.Mapped
+	}
+	return strings.Join(items, ", ")
+}
+
+// NetmapString 网段映射列表的命令行形式
+func NetmapString(rules []NetmapRule) string {
+	items := make([]string, len(rules))
+	for i, rule := range rules {
+		items[i] = rule.String()
+	}
+	return strings.Join(items, ",")
+}
+
+var netmapTableInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// NetmapTable 隧道的 NETMAP nftables 表名
+func NetmapTable(tunnel string) string {
+	return "twnode_netmap_" + netmapTableInvalid.ReplaceAllString(tunnel, "_")
+}
+
+// RenderNetmap 生成隧道的 NETMAP nft 脚本（先删除旧表再创建，nft -f 原子执行）
+// 优先级在 twnode 防火墙表的 NAT 链之前，映射网段内的连接不再被出口 masquerade
+func RenderNetmap(tunnel string, rules []NetmapRule) string {
+	table := NetmapTable(tunnel)
+
+	pre := make([]string, len(rules))
+	post := make([]string, len(rules))
+	for i, rule := range rules {
+		pre[i] = rule.Mapped + " : " + rule.Real
+		post[i] = rule.Real + " : " + rule.Mapped
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "table ip %s\n", table)
+	fmt.Fprintf(&b, "delete table ip %s\n", table)
+	fmt.Fprintf(&b, "table ip %s {\n", table)
+
+	b.WriteString("\tchain prerouting {\n")
+	b.WriteString("\t\ttype nat hook prerouting priority -110; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tiifname %q dnat ip prefix to ip daddr map { %s }\n", tunnel, strings.Join(pre, ", "))
+	b.WriteString("\t}\n")
+
+	b.WriteString("\tchain postrouting {\n")
+	b.WriteString("\t\ttype nat hook postrouting priority 90; policy accept;\n")
+	fmt.Fprintf(&b, "\t\toifname %q snat ip prefix to ip saddr map { %s }\n", tunnel, strings.Join(post, ", "))
+	b.WriteString("\t}\n")
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ApplyNetmap 应用隧道的 NETMAP 规则（网络命名空间内的隧道在命名空间内执行）
+func ApplyNetmap(tunnel, netns string, rules []NetmapRule) error {
+	if len(rules) == 0 {
+		RemoveNetmap(tunnel, netns)
+		return nil
+	}
+
+	cmd := ContextCommand("", netns, "nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(RenderNetmap(tunnel, rules))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("应用 NETMAP 规则失败: %v, 输出: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// RemoveNetmap 删除隧道的 NETMAP 规则
+func RemoveNetmap(tunnel, netns string) {
+	ContextCommand("", netns, "nft", "delete", "table", "ip", NetmapTable(tunnel)).Run()
+}
+
+// NetmapRevCommand 删除隧道 NETMAP 规则的撤销命令
+func NetmapRevCommand(tunnel, netns string) string {
+	cmd := fmt.Sprintf("nft delete table ip %s", NetmapTable(tunnel))
+	if netns != "" {
+		cmd = fmt.Sprintf("ip netns exec %s %s", netns, cmd)
+	}
+	return cmd
+}
+
+// NetmapActive 判断隧道的 NETMAP 表是否存在
+func NetmapActive(tunnel, netns string) bool {
+	return ContextCommand("", netns, "nft", "list", "table", "ip", NetmapTable(tunnel)).Run() == nil
+}
@@ -0,0 +1,289 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// 流量配额（按量计费的 LTE/中转线路）
+// 每个计费周期（从每月的重置日开始）允许的流量，用量由流量统计（twnode stats collect / 守护进程）
+// 从出口的链路计数器汇总后写入 QuotaUsageFile。
+// 用量超过软阈值后有效成本从配置的 Cost 线性升至 100；用量耗尽后出口标记为已耗尽，
+// 故障转移只在没有其他可用出口时才使用该出口
+
+const (
+	QuotaUsageFile = "/var/lib/trueword_node/stats/quota.json"
+
+	// 默认软阈值（百分比）
+	DefaultQuotaSoftPercent = 80
+
+	// 配额计量方向
+	QuotaCountTotal = "total"
+	QuotaCountTx    = "tx"
+	QuotaCountRx    = "rx"
+)
+
+// Quota 流量配额配置
+type Quota struct {
+	LimitBytes  uint64 `yaml:"limit_bytes"`            // 每个计费周期允许的字节数
+	ResetDay    int    `yaml:"reset_day"`              // 每月重置日 (1-28)
+	SoftPercent int    `yaml:"soft_percent,omitempty"` // 成本开始上升的用量百分比（默认80）
+	Count       string `yaml:"count,omitempty"`        // 计量方向: total(默认) / tx / rx
+}
+
+// QuotaUsage 出口在当前计费周期的用量
+type QuotaUsage struct {
+	UsedBytes   uint64    `json:"used_bytes"`
+	LimitBytes  uint64    `json:"limit_bytes"`
+	PeriodStart time.Time `json:"period_start"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ParseBytes 解析流量大小: 50GB / 500MB / 1.5TB（1024 进制）
+func ParseBytes(value string) (uint64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	for _, unit := range units {
+		if !strings.HasSuffix(value, unit.suffix) {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSuffix(value, unit.suffix), 64)
+		if err != nil || number <= 0 {
+			break
+		}
+		return uint64(number * unit.size), nil
+	}
+
+	return 0, fmt.Errorf("无效的流量大小 '%s' (示例: 50GB, 500MB, 1.5TB)", value)
+}
+
+// NewQuota 校验参数并创建配额
+func NewQuota(limit string, resetDay, softPercent int, count string) (*Quota, error) {
+	limitBytes, err := ParseBytes(limit)
+	if err != nil {
+		return nil, err
+	}
+	if resetDay < 1 || resetDay > 28 {
+		return nil, fmt.Errorf("重置日必须在 1-28 之间")
+	}
+	if softPercent < 0 || softPercent >= 100 {
+		return nil, fmt.Errorf("软阈值必须在 0-99 之间（0 表示默认 %d）", DefaultQuotaSoftPercent)
+	}
+	switch count {
+	case "", QuotaCountTotal:
+		count = ""
+	case QuotaCountTx, QuotaCountRx:
+	default:
+		return nil, fmt.Errorf("无效的计量方向 '%s' (可选: total/tx/rx)", count)
+	}
+
+	return &Quota{LimitBytes: limitBytes, ResetDay: resetDay, SoftPercent: softPercent, Count: count}, nil
+}
+
+// softPercent 实际使用的软阈值
+func (q *Quota) softPercent() int {
+	if q.SoftPercent == 0 {
+		return DefaultQuotaSoftPercent
+	}
+	return q.SoftPercent
+}
+
+// CountMode 实际使用的计量方向
+func (q *Quota) CountMode() string {
+	if q.Count == "" {
+		return QuotaCountTotal
+	}
+	return q.Count
+}
+
+// PeriodStart 当前计费周期的开始时间（本地时区的重置日零点）
+func (q *Quota) PeriodStart(now time.Time) time.Time {
+	start := time.Date(now.Year(), now.Month(), q.ResetDay, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// Summary 配额的简要描述
+func (q *Quota) Summary() string {
+	if q == nil {
+		return "未配置"
+	}
+	return fmt.Sprintf("%s/周期 (每月%d日重置, 软阈值 %d%%, 计量 %s)",
+		FormatBytes(q.LimitBytes), q.ResetDay, q.softPercent(), q.CountMode())
+}
+
+// EffectiveCost 根据用量计算有效成本
+// 返回: 有效成本, 是否已耗尽
+func (q *Quota) EffectiveCost(cost int, used uint64) (int, bool) {
+	if q.LimitBytes == 0 {
+		return cost, false
+	}
+	if used >= q.LimitBytes {
+		return 100, true
+	}
+
+	percent := float64(used) * 100 / float64(q.LimitBytes)
+	soft := float64(q.softPercent())
+	if percent <= soft {
+		return cost, false
+	}
+
+	// 软阈值到上限之间线性升至 100
+	ratio := (percent - soft) / (100 - soft)
+	effective := cost + int(math.Round(float64(100-cost)*ratio))
+	if effective > 100 {
+		effective = 100
+	}
+	return effective, false
+}
+
+// LoadQuotaUsage 加载各出口的配额用量（不存在时返回空）
+func LoadQuotaUsage() map[string]*QuotaUsage {
+	usage := make(map[string]*QuotaUsage)
+	data, err := os.ReadFile(QuotaUsageFile)
+	if err != nil {
+		return usage
+	}
+	json.Unmarshal(data, &usage)
+	return usage
+}
+
+// SaveQuotaUsage 保存各出口的配额用量
+func SaveQuotaUsage(usage map[string]*QuotaUsage) error {
+	if err := os.MkdirAll(filepath.Dir(QuotaUsageFile), 0755); err != nil {
+		return fmt.Errorf("创建统计目录失败: %w", err)
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化配额用量失败: %w", err)
+	}
+	tmp := QuotaUsageFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入配额用量失败: %w", err)
+	}
+	return os.Rename(tmp, QuotaUsageFile)
+}
+
+// ExitCostConfig 返回出口（隧道或物理接口）的成本和配额（存在成本覆盖时使用覆盖值）
+func ExitCostConfig(exitName string) (int, *Quota) {
+	cost, quota := 0, (*Quota)(nil)
+	if tunnelConfig, err := LoadTunnelConfig(exitName); err == nil && tunnelConfig != nil {
+		cost, quota = tunnelConfig.Cost, tunnelConfig.Quota
+	} else if ifaceConfig, err := LoadInterfaceConfig(); err == nil {
+		if iface := ifaceConfig.GetInterfaceByName(exitName); iface != nil {
+			cost, quota = iface.Cost, iface.Quota
+		}
+	}
+
+	if override, ok := LoadCostOverrides()[exitName]; ok {
+		cost = override
+	}
+	return cost, quota
+}
+
+// ExitQuotas 返回所有配置了配额的出口
+func ExitQuotas() map[string]*Quota {
+	quotas := make(map[string]*Quota)
+
+	if ifaceConfig, err := LoadInterfaceConfig(); err == nil {
+		for _, iface := range ifaceConfig.Interfaces {
+			if iface.Quota != nil {
+				quotas[iface.Name] = iface.Quota
+			}
+		}
+	}
+
+	if tunnels, err := ListTunnelConfigs(); err == nil {
+		for _, tunnel := range tunnels {
+			if tunnel.Quota != nil {
+				quotas[tunnel.Name] = tunnel.Quota
+			}
+		}
+	}
+
+	return quotas
+}
+
+// EffectiveExitCost 出口的有效成本（配置的成本按当前计费周期的配额用量上调）
+// 返回: 有效成本, 配额是否已耗尽
+func EffectiveExitCost(exitName string) (int, bool) {
+	cost, quota := ExitCostConfig(exitName)
+	if quota == nil {
+		return cost, false
+	}
+
+	usage, ok := LoadQuotaUsage()[exitName]
+	if !ok || !usage.PeriodStart.Equal(quota.PeriodStart(time.Now())) {
+		// 尚无本周期的用量（未采集或已进入新周期）
+		return cost, false
+	}
+	return quota.EffectiveCost(cost, usage.UsedBytes)
+}
+
+// PrintQuotas 打印各出口的流量配额和当前计费周期的用量
+func PrintQuotas() {
+	quotas := ExitQuotas()
+	if len(quotas) == 0 {
+		fmt.Println("没有配置流量配额的出口")
+		return
+	}
+
+	names := make([]string, 0, len(quotas))
+	for name := range quotas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	usage := LoadQuotaUsage()
+	now := time.Now()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("出口", "配额", "计量", "周期开始", "已用", "用量", "Cost", "有效Cost", "状态")
+	for _, name := range names {
+		quota := quotas[name]
+		cost, _ := ExitCostConfig(name)
+		start := quota.PeriodStart(now)
+
+		used, percent, effective, status := "-", "-", strconv.Itoa(cost), "ℹ 未采集"
+		if u, ok := usage[name]; ok && u.PeriodStart.Equal(start) {
+			effectiveCost, exhausted := quota.EffectiveCost(cost, u.UsedBytes)
+			used = FormatBytes(u.UsedBytes)
+			percent = fmt.Sprintf("%.1f%%", float64(u.UsedBytes)*100/float64(quota.LimitBytes))
+			effective = strconv.Itoa(effectiveCost)
+			switch {
+			case exhausted:
+				status = "✗ 已耗尽"
+			case effectiveCost > cost:
+				status = "⚠ 超过软阈值"
+			default:
+				status = "✓ 正常"
+			}
+		}
+
+		table.Append(name, FormatBytes(quota.LimitBytes), quota.CountMode(), start.Format("2006-01-02"),
+			used, percent, strconv.Itoa(cost), effective, status)
+	}
+	table.Render()
+
+	fmt.Println()
+	fmt.Println("ℹ 用量来自流量统计采集（twnode stats collect 或守护进程），配额耗尽的出口仅在没有其他可用出口时使用")
+}
@@ -0,0 +1,300 @@
+package network
+
+import (
+	"fmt"
+	"hash/crc32"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// 流量整形（tc）
+// 出向: 指定速率时根队列为 htb 限速类 + fq_codel，或带内置整形器的 cake；未指定速率时只替换根队列
+// 入向: 通过 ingress 队列把流量重定向到 ifb 设备，在 ifb 的出向上限速（整形而不是直接丢弃）
+// 隧道在启动时应用（撤销命令记录在隧道的 rev 文件中），物理接口在设置时和 line start-all 时应用
+
+const (
+	QdiscFQCodel = "fq_codel"
+	QdiscCake    = "cake"
+)
+
+// Shaping 流量整形配置（速率单位 kbit/s，0 表示不限速）
+type Shaping struct {
+	EgressKbit  int    `yaml:"egress_kbit,omitempty"`  // 出向限速
+	IngressKbit int    `yaml:"ingress_kbit,omitempty"` // 入向限速（经 ifb 设备）
+	Qdisc       string `yaml:"qdisc,omitempty"`        // 队列算法: fq_codel(默认) / cake
+}
+
+// ParseRate 解析速率: 50mbit / 500kbit / 1gbit / 50M / 0（不限速），返回 kbit/s
+func ParseRate(value string) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "0" || value == "off" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		kbit   int
+	}{
+		{"gbit", 1000000}, {"mbit", 1000}, {"kbit", 1},
+		{"g", 1000000}, {"m", 1000}, {"k", 1},
+	}
+	for _, unit := range units {
+		if !strings.HasSuffix(value, unit.suffix) {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSuffix(value, unit.suffix), 64)
+		if err != nil || number <= 0 {
+			break
+		}
+		kbit := int(number * float64(unit.kbit))
+		if kbit < 8 {
+			return 0, fmt.Errorf("速率过低: %s (最低 8kbit)", value)
+		}
+		return kbit, nil
+	}
+
+	return 0, fmt.Errorf("无效的速率 '%s' (示例: 50mbit, 500kbit, 1gbit, 0 表示不限速)", value)
+}
+
+// FormatRate 速率显示（0 显示为不限速）
+func FormatRate(kbit int) string {
+	switch {
+	case kbit == 0:
+		return "不限速"
+	case kbit%1000000 == 0:
+		return fmt.Sprintf("%dGbit", kbit/1000000)
+	case kbit%1000 == 0:
+		return fmt.Sprintf("%dMbit", kbit/1000)
+	}
+	return fmt.Sprintf("%dKbit", kbit)
+}
+
+// ParseQdisc 解析队列算法
+func ParseQdisc(value string) (string, error) {
+	switch value {
+	case "", QdiscFQCodel:
+		return QdiscFQCodel, nil
+	case QdiscCake:
+		return QdiscCake, nil
+	}
+	return "", fmt.Errorf("无效的队列算法 '%s' (可选: fq_codel/cake)", value)
+}
+
+// qdisc 实际使用的队列算法
+func (s *Shaping) qdisc() string {
+	if s.Qdisc == "" {
+		return QdiscFQCodel
+	}
+	return s.Qdisc
+}
+
+// Summary 整形配置的简要描述
+func (s *Shaping) Summary() string {
+	if s == nil {
+		return "未配置"
+	}
+	summary := fmt.Sprintf("出向 %s, 队列 %s", FormatRate(s.EgressKbit), s.qdisc())
+	if s.IngressKbit > 0 {
+		summary += fmt.Sprintf(", 入向 %s", FormatRate(s.IngressKbit))
+	}
+	return summary
+}
+
+// IFBName 接口入向整形使用的 ifb 设备名（接口名最长15字符，过长时使用哈希）
+func IFBName(dev string) string {
+	name := "ifb-" + dev
+	if len(name) <= 15 {
+		return name
+	}
+	return fmt.Sprintf("ifb-%s%05x", dev[:6], crc32.ChecksumIEEE([]byte(dev))&0xfffff)
+}
+
+// runTC 在指定网络命名空间中执行 tc 命令
+func runTC(netns string, args ...string) error {
+	output, err := ContextCommand("", netns, "tc", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tc %s 失败: %v, 输出: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// applyRootQdisc 在设备的出向配置根队列（rate 为 0 时不限速）
+func applyRootQdisc(dev, netns, qdisc string, kbit int) error {
+	rate := strconv.Itoa(kbit) + "kbit"
+
+	switch {
+	case kbit == 0:
+		return runTC(netns, "qdisc", "replace", "dev", dev, "root", qdisc)
+	case qdisc == QdiscCake:
+		return runTC(netns, "qdisc", "replace", "dev", dev, "root", "cake", "bandwidth", rate)
+	}
+
+	if err := runTC(netns, "qdisc", "replace", "dev", dev, "root", "handle", "1:", "htb", "default", "10"); err != nil {
+		return err
+	}
+	if err := runTC(netns, "class", "replace", "dev", dev, "parent", "1:", "classid", "1:10", "htb", "rate", rate, "ceil", rate); err != nil {
+		return err
+	}
+	return runTC(netns, "qdisc", "replace", "dev", dev, "parent", "1:10", "handle", "10:", qdisc)
+}
+
+// ApplyShaping 在设备上应用流量整形（替换现有配置，未配置入向限速时清理 ifb）
+func ApplyShaping(dev, netns string, s *Shaping) error {
+	if s == nil {
+		RemoveShaping(dev, netns)
+		return nil
+	}
+
+	if err := applyRootQdisc(dev, netns, s.qdisc(), s.EgressKbit); err != nil {
+		return fmt.Errorf("出向整形: %w", err)
+	}
+
+	ifb := IFBName(dev)
+	if s.IngressKbit == 0 {
+		ContextCommand("", netns, "tc", "qdisc", "del", "dev", dev, "ingress").Run()
+		IPCommand(netns, "link", "del", "dev", ifb).Run()
+		return nil
+	}
+
+	if !LinkExistsIn(ifb, netns) {
+		if output, err := IPCommand(netns, "link", "add", "name", ifb, "type", "ifb").CombinedOutput(); err != nil {
+			return fmt.Errorf("创建 ifb 设备 %s 失败 (需要 ifb 内核模块): %v, 输出: %s", ifb, err, strings.TrimSpace(string(output)))
+		}
+	}
+	if output, err := IPCommand(netns, "link", "set", "dev", ifb, "up").CombinedOutput(); err != nil {
+		return fmt.Errorf("启动 ifb 设备 %s 失败: %v, 输出: %s", ifb, err, strings.TrimSpace(string(output)))
+	}
+
+	// 重建 ingress 队列，避免重复的重定向过滤器
+	ContextCommand("", netns, "tc", "qdisc", "del", "dev", dev, "ingress").Run()
+	if err := runTC(netns, "qdisc", "add", "dev", dev, "handle", "ffff:", "ingress"); err != nil {
+		return fmt.Errorf("入向整形: %w", err)
+	}
+	if err := runTC(netns, "filter", "add", "dev", dev, "parent", "ffff:", "protocol", "all", "prio", "1",
+		"u32", "match", "u32", "0", "0", "action", "mirred", "egress", "redirect", "dev", ifb); err != nil {
+		return fmt.Errorf("入向整形: %w", err)
+	}
+	if err := applyRootQdisc(ifb, netns, s.qdisc(), s.IngressKbit); err != nil {
+		return fmt.Errorf("入向整形: %w", err)
+	}
+	return nil
+}
+
+// RemoveShaping 删除设备上的流量整形（恢复内核默认队列）
+func RemoveShaping(dev, netns string) {
+	ContextCommand("", netns, "tc", "qdisc", "del", "dev", dev, "root").Run()
+	ContextCommand("", netns, "tc", "qdisc", "del", "dev", dev, "ingress").Run()
+	IPCommand(netns, "link", "del", "dev", IFBName(dev)).Run()
+}
+
+// ShapingRevCommands 删除流量整形的撤销命令
+func ShapingRevCommands(dev, netns string) []string {
+	tc := "tc"
+	if netns != "" {
+		tc = fmt.Sprintf("ip netns exec %s tc", netns)
+	}
+	return []string{
+		fmt.Sprintf("%s qdisc del dev %s root", tc, dev),
+		fmt.Sprintf("%s qdisc del dev %s ingress", tc, dev),
+		IPCommandLine(netns, fmt.Sprintf("ip link del dev %s", IFBName(dev))),
+	}
+}
+
+// QdiscStats 队列统计（tc -s qdisc 根队列）
+type QdiscStats struct {
+	Qdisc       string
+	SentBytes   uint64
+	SentPackets uint64
+	Dropped     uint64
+	Overlimits  uint64
+	Backlog     string
+}
+
+var (
+	qdiscStatsHeader = regexp.MustCompile(`^qdisc (\S+) \S+ root`)
+	qdiscStatsSent   = regexp.MustCompile(`Sent (\d+) bytes (\d+) pkt \(dropped (\d+), overlimits (\d+)`)
+	qdiscStatsBack   = regexp.MustCompile(`backlog (\S+) (\d+)p`)
+)
+
+// GetQdiscStats 读取设备根队列的实时计数器
+func GetQdiscStats(dev, netns string) (*QdiscStats, error) {
+	output, err := ContextCommand("", netns, "tc", "-s", "qdisc", "show", "dev", dev).Output()
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 队列统计失败: %w", dev, err)
+	}
+
+	var stats *QdiscStats
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if match := qdiscStatsHeader.FindStringSubmatch(line); match != nil {
+			if stats != nil {
+				break
+			}
+			stats = &QdiscStats{Qdisc: match[1]}
+			continue
+		}
+		if stats == nil {
+			continue
+		}
+		if match := qdiscStatsSent.FindStringSubmatch(line); match != nil {
+			stats.SentBytes, _ = strconv.ParseUint(match[1], 10, 64)
+			stats.SentPackets, _ = strconv.ParseUint(match[2], 10, 64)
+			stats.Dropped, _ = strconv.ParseUint(match[3], 10, 64)
+			stats.Overlimits, _ = strconv.ParseUint(match[4], 10, 64)
+		}
+		if match := qdiscStatsBack.FindStringSubmatch(line); match != nil {
+			stats.Backlog = fmt.Sprintf("%s/%sp", match[1], match[2])
+		}
+	}
+
+	if stats == nil {
+		return nil, fmt.Errorf("%s 没有根队列", dev)
+	}
+	return stats, nil
+}
+
+// FormatBytes 字节数显示（1024 进制）
+func FormatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// PrintShaping 打印设备的整形配置和实时计数器
+func PrintShaping(dev, netns string, s *Shaping) {
+	fmt.Printf("【%s】 %s\n", dev, s.Summary())
+	if s == nil {
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("方向", "设备", "限速", "队列", "已发送", "包数", "丢弃", "超限", "积压")
+
+	appendRow := func(direction, device string, kbit int) {
+		stats, err := GetQdiscStats(device, netns)
+		if err != nil {
+			table.Append(direction, device, FormatRate(kbit), "✗ 未应用", "-", "-", "-", "-", "-")
+			return
+		}
+		table.Append(direction, device, FormatRate(kbit), stats.Qdisc,
+			FormatBytes(stats.SentBytes), strconv.FormatUint(stats.SentPackets, 10),
+			strconv.FormatUint(stats.Dropped, 10), strconv.FormatUint(stats.Overlimits, 10), stats.Backlog)
+	}
+
+	appendRow("出向", dev, s.EgressKbit)
+	if s.IngressKbit > 0 {
+		appendRow("入向", IFBName(dev), s.IngressKbit)
+	}
+	table.Render()
+}
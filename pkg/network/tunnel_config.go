@@ -2,8 +2,10 @@ package network
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -41,6 +43,92 @@ type TunnelConfig struct {
 
 	// 策略路由保护字段（所有类型隧道共用）
 	ProtectedIP     string `yaml:"protected_ip,omitempty"`     // 当前保护路由使用的对端IP
+
+	// 防火墙字段（nftables，所有类型隧道共用）
+	NATMode         string `yaml:"nat_mode,omitempty"`         // 出口NAT: masquerade(默认) / snat:<地址> / none(站点互联不做NAT)
+	MSSClamp        int    `yaml:"mss_clamp,omitempty"`        // TCP MSS钳制: 0=按路径MTU自动, -1=禁用, >0=固定值
+
+	// 站点互联路由字段（所有类型隧道共用）
+	RemoteSubnets   []string `yaml:"remote_subnets,omitempty"`    // 对端站点的内网网段（与对端VIP一起路由到表80）
+	LocalSubnets    []string `yaml:"local_subnets,omitempty"`     // 本端站点的内网网段（通过 show-peer 告知对端）
+	WGStrictAllowed bool     `yaml:"wg_strict_allowed,omitempty"` // WireGuard allowed-ips 仅包含对端VIP和对端网段（多对端场景）
+
+	// 1:1 网段映射字段（两端站点网段重叠时使用）
+	NetmapLocal     []NetmapRule `yaml:"netmap_local,omitempty"`  // 本端网段映射（本端 nftables 在隧道上 SNAT/DNAT）
+	NetmapRemote    []NetmapRule `yaml:"netmap_remote,omitempty"` // 对端网段映射（对端执行，本端路由映射后的网段）
+
+	// 隧道防火墙字段（对端经隧道进入的流量，未配置时不限制）
+	Firewall        *TunnelFirewall `yaml:"firewall,omitempty"`
+
+	// 流量整形字段（tc，未配置时使用内核默认队列）
+	Shaping         *Shaping `yaml:"shaping,omitempty"`
+
+	// 流量配额字段（按量计费线路，用量接近上限时有效成本上升）
+	Quota           *Quota `yaml:"quota,omitempty"`
+
+	// 多租户隔离字段（VRF 和网络命名空间二选一）
+	VRF             string `yaml:"vrf,omitempty"`   // 隧道接口加入的 VRF（VIP和对端网段路由写入VRF路由表）
+	Netns           string `yaml:"netns,omitempty"` // 隧道接口所在的网络命名空间（underlay 仍在默认命名空间）
+}
+
+// ParseSubnets 解析逗号分隔的网段列表（规范化为网络地址，去重）
+func ParseSubnets(value string) ([]string, error) {
+	subnets := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			item += "/32"
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil || ipNet.IP.To4() == nil {
+			return nil, fmt.Errorf("无效的网段: %s", item)
+		}
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			return nil, fmt.Errorf("网段不能为默认路由: %s", item)
+		}
+
+		cidr := ipNet.String()
+		if !seen[cidr] {
+			seen[cidr] = true
+			subnets = append(subnets, cidr)
+		}
+	}
+
+	return subnets, nil
+}
+
+// PeerRoutes 返回需要路由到隧道的对端地址（对端VIP/32 + 对端网段）
+func (c *TunnelConfig) PeerRoutes() []string {
+	routes := make([]string, 0, len(c.RemoteSubnets)+len(c.NetmapRemote)+1)
+	if c.RemoteVIP != "" {
+		routes = append(routes, c.RemoteVIP+"/32")
+	}
+	return append(routes, c.RoutedSubnets()...)
+}
+
+// RoutedSubnets 返回路由到隧道的对端网段（对端站点网段 + 对端映射后的网段）
+func (c *TunnelConfig) RoutedSubnets() []string {
+	subnets := make([]string, 0, len(c.RemoteSubnets)+len(c.NetmapRemote))
+	seen := make(map[string]bool)
+	for _, subnet := range c.RemoteSubnets {
+		if !seen[subnet] {
+			seen[subnet] = true
+			subnets = append(subnets, subnet)
+		}
+	}
+	for _, rule := range c.NetmapRemote {
+		if !seen[rule.Mapped] {
+			seen[rule.Mapped] = true
+			subnets = append(subnets, rule.Mapped)
+		}
+	}
+	return subnets
 }
 
 // SaveTunnelConfig 保存隧道配置
@@ -149,5 +237,10 @@ func ValidateParentInterface(parentName string) error {
 		return fmt.Errorf("父隧道 %s 未启用", parentName)
 	}
 
+	// 网络命名空间内隧道的VIP在默认命名空间不可用
+	if tunnelConfig.Netns != "" {
+		return fmt.Errorf("父隧道 %s 位于网络命名空间 %s，不能作为父接口", parentName, tunnelConfig.Netns)
+	}
+
 	return nil
 }
@@ -0,0 +1,258 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// 隧道防火墙: 限制对端经隧道进入的流量（访问本机和经本机转发）
+// 每个隧道使用独立的 nftables 表 inet twnode_tunnel_<隧道名>，随隧道启动创建、停止撤销（记录在撤销命令中）
+// 规则挂在 prerouting（filter 优先级），位于 NETMAP 和端口转发的 DNAT 之后，目的地址按转换后的真实地址匹配；
+// VRF 隧道的流量在进入 VRF 之前即可按隧道接口匹配
+
+const (
+	// 隧道防火墙默认策略
+	TunnelFirewallAccept = "accept"
+	TunnelFirewallDrop   = "drop"
+)
+
+// TunnelFirewall 隧道防火墙配置
+type TunnelFirewall struct {
+	Policy string               `yaml:"policy"`          // 默认策略: accept / drop
+	Rules  []TunnelFirewallRule `yaml:"rules,omitempty"` // 按顺序匹配的规则
+}
+
+// TunnelFirewallRule 隧道防火墙规则
+type TunnelFirewallRule struct {
+	Action string `yaml:"action"`          // accept / drop
+	Dest   string `yaml:"dest,omitempty"`  // 目的网段（空表示任意）
+	Proto  string `yaml:"proto,omitempty"` // tcp / udp / icmp（空表示任意协议）
+	Ports  string `yaml:"ports,omitempty"` // 目的端口: 22,443,8000-8100（仅 tcp/udp）
+}
+
+// ParseTunnelFirewallPolicy 解析隧道防火墙默认策略
+func ParseTunnelFirewallPolicy(value string) (string, error) {
+	switch value {
+	case TunnelFirewallAccept, TunnelFirewallDrop:
+		return value, nil
+	}
+	return "", fmt.Errorf("无效的默认策略 '%s' (可选: accept/drop)", value)
+}
+
+// NewTunnelFirewallRule 校验参数并创建隧道防火墙规则
+func NewTunnelFirewallRule(action, dest, proto, ports string) (TunnelFirewallRule, error) {
+	rule := TunnelFirewallRule{Action: action}
+
+	if action != TunnelFirewallAccept && action != TunnelFirewallDrop {
+		return rule, fmt.Errorf("无效的动作 '%s' (可选: accept/drop)", action)
+	}
+
+	if dest != "" {
+		subnets, err := ParseSubnets(dest)
+		if err != nil {
+			return rule, err
+		}
+		rule.Dest = strings.Join(subnets, ",")
+	}
+
+	proto = strings.ToLower(proto)
+	switch proto {
+	case "", "any":
+	case "tcp", "udp", "icmp":
+		rule.Proto = proto
+	default:
+		return rule, fmt.Errorf("无效的协议 '%s' (可选: tcp/udp/icmp/any)", proto)
+	}
+
+	if ports != "" {
+		if rule.Proto != "tcp" && rule.Proto != "udp" {
+			return rule, fmt.Errorf("指定端口时协议必须是 tcp 或 udp")
+		}
+		normalized, err := parsePorts(ports)
+		if err != nil {
+			return rule, err
+		}
+		rule.Ports = normalized
+	}
+
+	return rule, nil
+}
+
+// parsePorts 解析端口列表: 22,443,8000-8100
+func parsePorts(value string) (string, error) {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(item, "-", 2)
+		low, err := strconv.Atoi(bounds[0])
+		if err != nil || low < 1 || low > 65535 {
+			return "", fmt.Errorf("无效的端口: %s", item)
+		}
+		if len(bounds) == 2 {
+			high, err := strconv.Atoi(bounds[1])
+			if err != nil || high < low || high > 65535 {
+				return "", fmt.Errorf("无效的端口范围: %s", item)
+			}
+			items = append(items, fmt.Sprintf("%d-%d", low, high))
+			continue
+		}
+		items = append(items, strconv.Itoa(low))
+	}
+
+	if len(items) == 0 {
+		return "", fmt.Errorf("端口列表不能为空")
+	}
+	return strings.Join(items, ","), nil
+}
+
+// match 规则的 nft 匹配条件
+func (r TunnelFirewallRule) match() string {
+	parts := make([]string, 0, 3)
+	if r.Dest != "" {
+		parts = append(parts, fmt.Sprintf("ip daddr { %s }", strings.ReplaceAll(r.Dest, ",", ", ")))
+	}
+	switch {
+	case r.Ports != "":
+		parts = append(parts, fmt.Sprintf("%s dport { %s }", r.Proto, strings.ReplaceAll(r.Ports, ",", ", ")))
+	case r.Proto == "icmp":
+		parts = append(parts, "ip protocol icmp")
+	case r.Proto != "":
+		parts = append(parts, "meta l4proto "+r.Proto)
+	}
+	return strings.Join(parts, " ")
+}
+
+// TunnelFirewallTable 隧道防火墙的 nftables 表名
+func TunnelFirewallTable(tunnel string) string {
+	return "twnode_tunnel_" + tunnelFirewallInvalid.ReplaceAllString(tunnel, "_")
+}
+
+var tunnelFirewallInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Render 生成隧道防火墙的 nft 脚本（先删除旧表再创建，nft -f 原子执行）
+// localVIP 为本端VIP，默认拒绝时仍放行对端到本端VIP的 ICMP 探测（对端健康检查）
+func (fw *TunnelFirewall) Render(tunnel, localVIP string) string {
+	table := TunnelFirewallTable(tunnel)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s\n", table)
+	fmt.Fprintf(&b, "delete table inet %s\n", table)
+	fmt.Fprintf(&b, "table inet %s {\n", table)
+
+	b.WriteString("\tchain prerouting {\n")
+	b.WriteString("\t\ttype filter hook prerouting priority filter; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tiifname %q jump peer\n", tunnel)
+	b.WriteString("\t}\n")
+
+	b.WriteString("\tchain peer {\n")
+	b.WriteString("\t\tct state established,related accept\n")
+	if fw.Policy == TunnelFirewallDrop && localVIP != "" {
+		fmt.Fprintf(&b, "\t\tip daddr %s icmp type echo-request accept\n", localVIP)
+	}
+	for _, rule := range fw.Rules {
+		fmt.Fprintf(&b, "\t\t%s\n", strings.TrimSpace(rule.match()+" "+rule.Action))
+	}
+	fmt.Fprintf(&b, "\t\t%s\n", fw.Policy)
+	b.WriteString("\t}\n")
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ApplyTunnelFirewall 应用隧道防火墙（网络命名空间内的隧道在命名空间内执行）
+// 未配置防火墙时删除表
+func ApplyTunnelFirewall(tunnel, localVIP, netns string, fw *TunnelFirewall) error {
+	if fw == nil {
+		RemoveTunnelFirewall(tunnel, netns)
+		return nil
+	}
+
+	cmd := ContextCommand("", netns, "nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(fw.Render(tunnel, localVIP))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("应用隧道防火墙规则失败: %v, 输出: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// RemoveTunnelFirewall 删除隧道防火墙
+func RemoveTunnelFirewall(tunnel, netns string) {
+	ContextCommand("", netns, "nft", "delete", "table", "inet", TunnelFirewallTable(tunnel)).Run()
+}
+
+// TunnelFirewallRevCommand 删除隧道防火墙的撤销命令
+func TunnelFirewallRevCommand(tunnel, netns string) string {
+	cmd := fmt.Sprintf("nft delete table inet %s", TunnelFirewallTable(tunnel))
+	if netns != "" {
+		cmd = fmt.Sprintf("ip netns exec %s %s", netns, cmd)
+	}
+	return cmd
+}
+
+// TunnelFirewallActive 判断隧道防火墙表是否存在
+func TunnelFirewallActive(tunnel, netns string) bool {
+	return ContextCommand("", netns, "nft", "list", "table", "inet", TunnelFirewallTable(tunnel)).Run() == nil
+}
+
+// TunnelFirewallSummary 隧道防火墙的简要描述
+func TunnelFirewallSummary(fw *TunnelFirewall) string {
+	if fw == nil {
+		return "未启用"
+	}
+	policy := "默认放行"
+	if fw.Policy == TunnelFirewallDrop {
+		policy = "默认拒绝"
+	}
+	return fmt.Sprintf("%s, %d 条规则", policy, len(fw.Rules))
+}
+
+// PrintTunnelFirewalls 打印隧道防火墙配置
+func PrintTunnelFirewalls(tunnels []*TunnelConfig) {
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Name < tunnels[j].Name
+	})
+
+	for i, tunnel := range tunnels {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		status := "✗ 未应用"
+		if TunnelFirewallActive(tunnel.Name, tunnel.Netns) {
+			status = "✓ 已应用"
+		}
+		if tunnel.Firewall == nil {
+			status = "-"
+		}
+		fmt.Printf("【%s】 %s  状态: %s\n", tunnel.Name, TunnelFirewallSummary(tunnel.Firewall), status)
+
+		if tunnel.Firewall == nil || len(tunnel.Firewall.Rules) == 0 {
+			continue
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.Header("序号", "动作", "目的网段", "协议", "端口")
+		for j, rule := range tunnel.Firewall.Rules {
+			table.Append(strconv.Itoa(j+1), rule.Action, orAny(rule.Dest), orAny(rule.Proto), orAny(rule.Ports))
+		}
+		table.Render()
+	}
+}
+
+// orAny 空值显示为 any
+func orAny(value string) string {
+	if value == "" {
+		return "any"
+	}
+	return value
+}
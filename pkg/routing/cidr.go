@@ -0,0 +1,558 @@
+package routing
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// NormalizeCIDR 规范化CIDR
+// 主机位清零（如 10.1.2.3/8 -> 10.0.0.0/8），单个IP视为 /32（IPv6 为 /128）
+func NormalizeCIDR(cidr string) (string, error) {
+	prefix, err := parseCIDRPrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
+
+// parseCIDRPrefix 解析CIDR或单个IP为规范化的前缀
+func parseCIDRPrefix(cidr string) (netip.Prefix, error) {
+	cidr = strings.TrimSpace(cidr)
+
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("无效的CIDR: %s", cidr)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("无效的CIDR: %s", cidr)
+	}
+	return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked(), nil
+}
+
+// AggregateCIDRs 规范化、去重并聚合CIDR列表
+// 1. 去掉被其他条目包含的前缀
+// 2. 合并相邻且可合并的兄弟前缀（如 10.0.0.0/25 + 10.0.0.128/25 -> 10.0.0.0/24）
+// 无效条目会被原样丢弃，调用方应事先校验
+func AggregateCIDRs(cidrs []string) []string {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := parseCIDRPrefix(cidr)
+		if err != nil {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	prefixes = aggregatePrefixes(prefixes)
+
+	result := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, prefix.String())
+	}
+	return result
+}
+
+// aggregatePrefixes 聚合前缀列表，返回按地址排序的最简集合
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	if len(prefixes) == 0 {
+		return prefixes
+	}
+
+	sortPrefixes(prefixes)
+
+	// 反复合并，直到无法继续（每轮合并后前缀变短，最多迭代地址位数次）
+	for {
+		merged := make([]netip.Prefix, 0, len(prefixes))
+		changed := false
+
+		for _, prefix := range prefixes {
+			// 被上一条包含则丢弃（排序保证包含者在前）
+			if len(merged) > 0 && prefixContains(merged[len(merged)-1], prefix) {
+				changed = true
+				continue
+			}
+
+			// 与上一条是兄弟前缀则合并为父前缀
+			if len(merged) > 0 {
+				last := merged[len(merged)-1]
+				if parent, ok := siblingParent(last, prefix); ok {
+					merged[len(merged)-1] = parent
+					changed = true
+					continue
+				}
+			}
+
+			merged = append(merged, prefix)
+		}
+
+		prefixes = merged
+		if !changed {
+			return prefixes
+		}
+		sortPrefixes(prefixes)
+	}
+}
+
+// sortPrefixes 按地址族、地址、掩码长度（短的在前）排序
+func sortPrefixes(prefixes []netip.Prefix) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := prefixes[i], prefixes[j]
+		if a.Addr().Is4() != b.Addr().Is4() {
+			return a.Addr().Is4()
+		}
+		if cmp := a.Addr().Compare(b.Addr()); cmp != 0 {
+			return cmp < 0
+		}
+		return a.Bits() < b.Bits()
+	})
+}
+
+// prefixContains 判断 outer 是否完全包含 inner
+func prefixContains(outer, inner netip.Prefix) bool {
+	if outer.Addr().Is4() != inner.Addr().Is4() {
+		return false
+	}
+	return outer.Bits() <= inner.Bits() && outer.Contains(inner.Addr())
+}
+
+// siblingParent 判断两个前缀是否为同一父前缀下的两半，是则返回父前缀
+func siblingParent(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() {
+		return netip.Prefix{}, false
+	}
+	parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+	if parent.Addr() != a.Addr() || !parent.Contains(b.Addr()) || a == b {
+		return netip.Prefix{}, false
+	}
+	return parent, true
+}
+
+// CIDROverlap 跨策略组的CIDR重叠
+type CIDROverlap struct {
+	Winner     string // 生效的策略组（优先级数字小）
+	WinnerCIDR string
+	WinnerPrio int
+	Loser      string // 被覆盖的策略组
+	LoserCIDR  string
+	LoserPrio  int
+	Shadowed   bool   // Loser 条目是否被完全遮蔽（Winner 前缀包含 Loser 前缀）
+	Exit       string // 重叠部分的实际出口
+	SameExit   bool   // 两组出口相同（重叠无实际影响）
+	FromDiffer bool   // 两组源限制不同（仅在源同时匹配时重叠生效）
+}
+
+// GroupRedundancy 组内冗余统计
+type GroupRedundancy struct {
+	Group        string
+	Total        int      // 原始条目数
+	Invalid      []string // 无效条目
+	NonCanonical []string // 未规范化的条目（主机位不为0）
+	Duplicates   []string // 重复条目
+	Contained    []string // 被组内其他条目包含的条目
+	Aggregated   int      // 聚合后的条目数
+}
+
+// groupEntry 分析用的条目
+type groupEntry struct {
+	prefix netip.Prefix
+	group  *PolicyGroup
+}
+
+// AnalyzeRedundancy 分析每个策略组内的重复与冗余
+func (pm *PolicyManager) AnalyzeRedundancy() []*GroupRedundancy {
+	results := make([]*GroupRedundancy, 0, len(pm.groups))
+
+	for _, group := range pm.Groups() {
+		r := &GroupRedundancy{
+			Group: group.Name,
+			Total: len(group.CIDRs),
+		}
+
+		seen := make(map[netip.Prefix]bool)
+		prefixes := make([]netip.Prefix, 0, len(group.CIDRs))
+		for _, cidr := range group.CIDRs {
+			prefix, err := parseCIDRPrefix(cidr)
+			if err != nil {
+				r.Invalid = append(r.Invalid, cidr)
+				continue
+			}
+			if prefix.String() != cidr {
+				r.NonCanonical = append(r.NonCanonical, cidr)
+			}
+			if seen[prefix] {
+				r.Duplicates = append(r.Duplicates, prefix.String())
+				continue
+			}
+			seen[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+
+		// 查找被组内其他条目包含的条目
+		sortPrefixes(prefixes)
+		var stack []netip.Prefix
+		for _, prefix := range prefixes {
+			for len(stack) > 0 && !prefixContains(stack[len(stack)-1], prefix) {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) > 0 {
+				r.Contained = append(r.Contained, fmt.Sprintf("%s ⊂ %s", prefix, stack[len(stack)-1]))
+			}
+			stack = append(stack, prefix)
+		}
+
+		r.Aggregated = len(aggregatePrefixes(prefixes))
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// AnalyzeOverlaps 分析跨策略组的CIDR重叠
+// 内核按 ip rule 优先级（数字小的优先）逐条匹配，命中即查对应路由表，
+// 因此低优先级组中更精确的前缀也会被高优先级组中的大前缀“吃掉”
+func (pm *PolicyManager) AnalyzeOverlaps() []*CIDROverlap {
+	entries := make([]groupEntry, 0)
+	for _, group := range pm.groups {
+		seen := make(map[netip.Prefix]bool)
+		for _, cidr := range group.CIDRs {
+			prefix, err := parseCIDRPrefix(cidr)
+			if err != nil || seen[prefix] {
+				continue
+			}
+			seen[prefix] = true
+			entries = append(entries, groupEntry{prefix: prefix, group: group})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].prefix, entries[j].prefix
+		if a.Addr().Is4() != b.Addr().Is4() {
+			return a.Addr().Is4()
+		}
+		if cmp := a.Addr().Compare(b.Addr()); cmp != 0 {
+			return cmp < 0
+		}
+		if a.Bits() != b.Bits() {
+			return a.Bits() < b.Bits()
+		}
+		return entries[i].group.Priority < entries[j].group.Priority
+	})
+
+	// CIDR 之间要么嵌套要么不相交，用栈扫描即可找出所有重叠对
+	overlaps := make([]*CIDROverlap, 0)
+	var stack []groupEntry
+	for _, entry := range entries {
+		for len(stack) > 0 && !prefixContains(stack[len(stack)-1].prefix, entry.prefix) {
+			stack = stack[:len(stack)-1]
+		}
+
+		for _, outer := range stack {
+			if outer.group == entry.group {
+				continue
+			}
+			// 重叠部分已被高优先级组排除，不会被其“吃掉”
+			if groupExcludes(higherPriority(outer, entry).group, entry.prefix) {
+				continue
+			}
+			overlaps = append(overlaps, newCIDROverlap(outer, entry))
+		}
+
+		stack = append(stack, entry)
+	}
+
+	sort.SliceStable(overlaps, func(i, j int) bool {
+		if overlaps[i].WinnerPrio != overlaps[j].WinnerPrio {
+			return overlaps[i].WinnerPrio < overlaps[j].WinnerPrio
+		}
+		return overlaps[i].LoserPrio < overlaps[j].LoserPrio
+	})
+
+	return overlaps
+}
+
+// higherPriority 返回两个条目中优先级高（数字小）的一个
+func higherPriority(a, b groupEntry) groupEntry {
+	if b.group.Priority < a.group.Priority {
+		return b
+	}
+	return a
+}
+
+// groupExcludes 判断前缀是否被策略组的排除列表完全覆盖
+func groupExcludes(group *PolicyGroup, prefix netip.Prefix) bool {
+	for _, cidr := range group.Excludes {
+		exclude, err := parseCIDRPrefix(cidr)
+		if err != nil {
+			continue
+		}
+		if prefixContains(exclude, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteSets 策略组实际下发的路由
+// throw 路由只对比组内CIDR更具体的排除项生效（与组内CIDR相同会冲突，更大的不会被查到），因此:
+// 被排除项完全覆盖（相同或更大）的组内CIDR不添加路由，落在剩余CIDR内的排除项添加 throw 路由，其余排除项无需下发
+func (g *PolicyGroup) RouteSets() (cidrs, throws []string) {
+	remaining := make([]netip.Prefix, 0, len(g.CIDRs))
+	for _, cidr := range g.CIDRs {
+		prefix, err := parseCIDRPrefix(cidr)
+		if err != nil || groupExcludes(g, prefix) {
+			continue
+		}
+		remaining = append(remaining, prefix)
+		cidrs = append(cidrs, prefix.String())
+	}
+
+	for _, cidr := range g.Excludes {
+		exclude, err := parseCIDRPrefix(cidr)
+		if err != nil {
+			continue
+		}
+		for _, prefix := range remaining {
+			if prefixContains(prefix, exclude) {
+				throws = append(throws, exclude.String())
+				break
+			}
+		}
+	}
+	return cidrs, throws
+}
+
+// coveredCIDRs 被排除项完全覆盖（相同或更大）的组内CIDR
+func (g *PolicyGroup) coveredCIDRs() []string {
+	covered := make([]string, 0)
+	for _, cidr := range g.CIDRs {
+		prefix, err := parseCIDRPrefix(cidr)
+		if err == nil && groupExcludes(g, prefix) {
+			covered = append(covered, prefix.String())
+		}
+	}
+	return covered
+}
+
+// newCIDROverlap 根据包含关系构造重叠记录（outer 包含 inner）
+func newCIDROverlap(outer, inner groupEntry) *CIDROverlap {
+	winner, loser := outer, inner
+	if inner.group.Priority < outer.group.Priority {
+		winner, loser = inner, outer
+	}
+
+	return &CIDROverlap{
+		Winner:     winner.group.Name,
+		WinnerCIDR: winner.prefix.String(),
+		WinnerPrio: winner.group.Priority,
+		Loser:      loser.group.Name,
+		LoserCIDR:  loser.prefix.String(),
+		LoserPrio:  loser.group.Priority,
+		Shadowed:   winner.prefix == outer.prefix,
+		Exit:       winner.group.Exit,
+		SameExit:   winner.group.Exit == loser.group.Exit,
+		FromDiffer: normalizeFrom(winner.group.From) != normalizeFrom(loser.group.From),
+	}
+}
+
+// normalizeFrom 统一源限制的表示
+func normalizeFrom(from string) string {
+	if from == "" {
+		return "all"
+	}
+	return from
+}
+
+// CheckCIDROverlaps 检查某个策略组与其他已加载策略组的重叠（用于添加/导入后提示）
+func (pm *PolicyManager) CheckCIDROverlaps(groupName string) []*CIDROverlap {
+	result := make([]*CIDROverlap, 0)
+	for _, o := range pm.AnalyzeOverlaps() {
+		if o.Winner == groupName || o.Loser == groupName {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// NormalizeGroup 规范化、去重并聚合策略组的CIDR列表
+// 返回: 处理前条目数, 处理后条目数
+func (pm *PolicyManager) NormalizeGroup(groupName string) (int, int, error) {
+	group, exists := pm.groups[groupName]
+	if !exists {
+		return 0, 0, fmt.Errorf("策略组 %s 不存在", groupName)
+	}
+
+	before := len(group.CIDRs)
+	group.CIDRs = AggregateCIDRs(group.CIDRs)
+	group.Excludes = AggregateCIDRs(group.Excludes)
+	return before, len(group.CIDRs), nil
+}
+
+// WarnCIDROverlaps 加载所有策略组，提示指定策略组与其他组的重叠（出口不同的才提示）
+func WarnCIDROverlaps(groupName string) {
+	pm := NewPolicyManager()
+	if err := pm.LoadAllGroups(); err != nil {
+		return
+	}
+
+	warned := 0
+	for _, o := range pm.CheckCIDROverlaps(groupName) {
+		if o.SameExit {
+			continue
+		}
+		if warned == 0 {
+			fmt.Println()
+			fmt.Println("⚠ 与其他策略组存在重叠（按优先级，数字小的生效）:")
+		}
+		warned++
+		if warned > 10 {
+			continue
+		}
+		if o.Shadowed {
+			fmt.Printf("  - %s (%s, 优先级 %d) 被 %s (%s, 优先级 %d) 完全遮蔽，实际出口: %s\n",
+				o.LoserCIDR, o.Loser, o.LoserPrio, o.WinnerCIDR, o.Winner, o.WinnerPrio, o.Exit)
+		} else {
+			fmt.Printf("  - %s (%s, 优先级 %d) 覆盖 %s (%s, 优先级 %d) 的一部分，实际出口: %s\n",
+				o.WinnerCIDR, o.Winner, o.WinnerPrio, o.LoserCIDR, o.Loser, o.LoserPrio, o.Exit)
+		}
+	}
+
+	if warned > 10 {
+		fmt.Printf("  ... 以及其他 %d 处\n", warned-10)
+	}
+	if warned > 0 {
+		fmt.Println("  详情请运行: twnode policy analyze")
+	}
+}
+
+// Groups 按优先级返回所有已加载的策略组
+func (pm *PolicyManager) Groups() []*PolicyGroup {
+	groups := make([]*PolicyGroup, 0, len(pm.groups))
+	for _, group := range pm.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Priority < groups[j].Priority
+	})
+	return groups
+}
+
+// Analyze 打印策略组分析报告（组内冗余 + 跨组重叠）
+func (pm *PolicyManager) Analyze() {
+	if len(pm.groups) == 0 {
+		fmt.Println("没有策略组")
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("【组内冗余】")
+	fmt.Println()
+
+	redundantGroups := 0
+	for _, r := range pm.AnalyzeRedundancy() {
+		if len(r.Invalid) == 0 && len(r.NonCanonical) == 0 && len(r.Duplicates) == 0 &&
+			len(r.Contained) == 0 && r.Aggregated == r.Total {
+			continue
+		}
+		redundantGroups++
+
+		fmt.Printf("策略组 %s: %d 条 -> 聚合后 %d 条\n", r.Group, r.Total, r.Aggregated)
+		printAnalyzeItems("无效条目", r.Invalid)
+		printAnalyzeItems("未规范化", r.NonCanonical)
+		printAnalyzeItems("重复条目", r.Duplicates)
+		printAnalyzeItems("被包含", r.Contained)
+		fmt.Println()
+	}
+
+	if redundantGroups == 0 {
+		fmt.Println("  ✓ 所有策略组均无冗余条目")
+		fmt.Println()
+	} else {
+		fmt.Println("提示: 使用 'twnode policy analyze --fix' 规范化并聚合所有策略组")
+		fmt.Println()
+	}
+
+	fmt.Println("【跨组重叠】")
+	fmt.Println()
+
+	overlaps := pm.AnalyzeOverlaps()
+	if len(overlaps) == 0 {
+		fmt.Println("  ✓ 策略组之间无重叠")
+		fmt.Println()
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("生效组", "优先级", "生效CIDR", "被覆盖组", "优先级", "被覆盖CIDR", "重叠部分出口", "说明")
+
+	shadowed := make(map[string]bool)
+	for _, o := range overlaps {
+		var notes []string
+		if o.Shadowed {
+			notes = append(notes, "完全遮蔽")
+			if !o.SameExit {
+				shadowed[o.Loser+" "+o.LoserCIDR] = true
+			}
+		} else {
+			notes = append(notes, "部分覆盖")
+		}
+		if o.SameExit {
+			notes = append(notes, "出口相同")
+		}
+		if o.FromDiffer {
+			notes = append(notes, "源限制不同")
+		}
+
+		table.Append(
+			o.Winner,
+			strconv.Itoa(o.WinnerPrio),
+			o.WinnerCIDR,
+			o.Loser,
+			strconv.Itoa(o.LoserPrio),
+			o.LoserCIDR,
+			o.Exit,
+			strings.Join(notes, ", "),
+		)
+	}
+
+	table.Render()
+
+	fmt.Println()
+	fmt.Printf("共 %d 处重叠", len(overlaps))
+	if len(shadowed) > 0 {
+		fmt.Printf("，其中 %d 条低优先级条目被完全遮蔽且出口不同（永远不会生效）", len(shadowed))
+	}
+	fmt.Println()
+	fmt.Println()
+	fmt.Println("说明:")
+	fmt.Println("  完全遮蔽: 低优先级组中更精确的前缀被高优先级组的大前缀覆盖，该条目不会生效")
+	fmt.Println("  部分覆盖: 高优先级组中的精确前缀覆盖了低优先级组大前缀的一部分")
+	fmt.Println("  如需让精确前缀生效，请使用 'twnode policy set-priority' 调高其所在组的优先级")
+}
+
+// printAnalyzeItems 打印分析条目（最多显示10条）
+func printAnalyzeItems(title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+
+	fmt.Printf("  %s (%d):\n", title, len(items))
+	for i, item := range items {
+		if i >= 10 {
+			fmt.Printf("    ... 以及其他 %d 条\n", len(items)-10)
+			break
+		}
+		fmt.Printf("    - %s\n", item)
+	}
+}
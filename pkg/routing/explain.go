@@ -0,0 +1,610 @@
+package routing
+
+import (
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"trueword_node/pkg/config"
+)
+
+// ip route 输出中可能出现的路由类型前缀
+var routeTypes = map[string]bool{
+	"unicast":     true,
+	"local":       true,
+	"broadcast":   true,
+	"multicast":   true,
+	"anycast":     true,
+	"throw":       true,
+	"blackhole":   true,
+	"unreachable": true,
+	"prohibit":    true,
+	"nat":         true,
+}
+
+// IPRule 解析后的 ip rule 条目
+type IPRule struct {
+	Pref   int
+	From   string // "all" 或前缀
+	To     string // "" 表示不限制
+	Iif    string
+	Oif    string
+	Fwmark string
+	Not    bool
+	Action string // lookup/blackhole/unreachable/prohibit/goto/nop
+	Table  string
+	// 忽略前缀长度不超过此值的路由（suppress_prefixlength，-1 表示不限制）
+	SuppressPrefix int
+	Raw            string
+}
+
+// IPRoute 解析后的路由条目
+type IPRoute struct {
+	Type    string
+	Prefix  netip.Prefix
+	Gateway string
+	Dev     string
+	Raw     string
+}
+
+// ExplainStep 路由决策的一个步骤
+type ExplainStep struct {
+	Rule    string // 规则原文
+	Pref    int
+	Owner   string // 规则归属（twnode 的哪类规则）
+	Matched bool   // 规则选择器是否命中
+	Final   bool   // 是否为最终决策的规则
+	Reason  string // 命中/未命中/继续的原因
+}
+
+// ExplainResult 路由决策结果
+type ExplainResult struct {
+	Dst     string
+	From    string
+	Iif     string
+	Steps   []*ExplainStep
+	Pref    int    // 最终命中的规则优先级（0 表示未命中）
+	Owner   string // 最终命中的规则归属
+	Table   string
+	Route   string // 命中的路由原文
+	Type    string // 路由类型
+	Exit    string // 出口接口
+	Gateway string // 网关
+	Detail  string // 配置层面的解释（命中哪个策略组CIDR、哪个隧道VIP等）
+}
+
+// ExplainRoute 按内核规则顺序推演目标地址的路由决策
+// 逐条遍历 ip rule，判断选择器是否匹配，再在对应路由表中做最长前缀匹配；
+// throw 或表内无匹配时继续下一条规则，与内核 fib_rules 的行为一致
+func ExplainRoute(dst, from, iif string) (*ExplainResult, error) {
+	dstAddr, err := netip.ParseAddr(dst)
+	if err != nil {
+		return nil, fmt.Errorf("无效的目标地址: %s", dst)
+	}
+
+	var srcAddr netip.Addr
+	if from != "" {
+		srcAddr, err = netip.ParseAddr(from)
+		if err != nil {
+			return nil, fmt.Errorf("无效的源地址: %s", from)
+		}
+	}
+
+	rules, err := ListIPRules()
+	if err != nil {
+		return nil, err
+	}
+
+	// 加载策略组，用于解释用户策略组规则
+	pm := NewPolicyManager()
+	pm.LoadAllGroups()
+
+	result := &ExplainResult{
+		Dst:  dst,
+		From: from,
+		Iif:  iif,
+	}
+
+	tableCache := make(map[string][]*IPRoute)
+
+	for _, rule := range rules {
+		step := &ExplainStep{
+			Rule:  rule.Raw,
+			Pref:  rule.Pref,
+			Owner: pm.describeRuleOwner(rule),
+		}
+		result.Steps = append(result.Steps, step)
+
+		matched, reason := rule.matches(dstAddr, srcAddr, iif)
+		if !matched {
+			step.Reason = reason
+			continue
+		}
+		step.Matched = true
+
+		switch rule.Action {
+		case "blackhole", "unreachable", "prohibit":
+			step.Reason = fmt.Sprintf("规则动作为 %s，数据包被丢弃", rule.Action)
+			step.Final = true
+			result.Pref = rule.Pref
+			result.Owner = step.Owner
+			result.Type = rule.Action
+			return result, nil
+		case "lookup":
+		default:
+			step.Reason = fmt.Sprintf("规则动作 %s 暂不支持推演，跳过", rule.Action)
+			continue
+		}
+
+		routes, cached := tableCache[rule.Table]
+		if !cached {
+			routes, err = ListIPRoutes(rule.Table)
+			if err != nil {
+				step.Reason = fmt.Sprintf("读取路由表 %s 失败: %v", rule.Table, err)
+				continue
+			}
+			tableCache[rule.Table] = routes
+		}
+
+		route := longestPrefixMatch(routes, dstAddr)
+		if route == nil {
+			step.Reason = fmt.Sprintf("路由表 %s 中无匹配路由，继续下一条规则", rule.Table)
+			continue
+		}
+
+		if route.Type == "throw" {
+			step.Reason = fmt.Sprintf("路由表 %s 命中 throw %s（排除项），继续下一条规则", rule.Table, route.Prefix)
+			continue
+		}
+
+		if route.Prefix.Bits() <= rule.SuppressPrefix {
+			step.Reason = fmt.Sprintf("路由表 %s 命中 %s，但前缀长度不超过 %d 被忽略（suppress_prefixlength），继续下一条规则", rule.Table, route.Prefix, rule.SuppressPrefix)
+			continue
+		}
+
+		step.Reason = fmt.Sprintf("路由表 %s 命中: %s", rule.Table, route.Raw)
+		step.Final = true
+		result.Pref = rule.Pref
+		result.Owner = step.Owner
+		result.Table = rule.Table
+		result.Route = route.Raw
+		result.Type = route.Type
+		result.Exit = route.Dev
+		result.Gateway = route.Gateway
+		result.Detail = pm.explainMatch(rule, route, dstAddr)
+		return result, nil
+	}
+
+	return result, nil
+}
+
+// describeRuleOwner 描述规则属于 twnode 的哪类规则
+func (pm *PolicyManager) describeRuleOwner(rule *IPRule) string {
+	ns := config.RoutingNamespace()
+	switch {
+	case rule.Pref == 0:
+		return "本地路由"
+	case rule.Pref == ns.TestPref:
+		return "检测探测规则（仅探测报文）"
+	case rule.Pref == ns.SourceLocalPref():
+		return fmt.Sprintf("源地址本地路由（表%s，忽略默认路由）", rule.Table)
+	case rule.Pref == ns.SourcePref:
+		return fmt.Sprintf("源地址路由（表%s）", rule.Table)
+	case rule.Pref == ns.ForwardPref:
+		return fmt.Sprintf("端口转发回程路由（表%s）", rule.Table)
+	case rule.Pref == ns.ProtectPref:
+		return "系统保护规则（隧道对端IP）"
+	case rule.Pref == ns.TunnelPref:
+		return fmt.Sprintf("隧道对端策略路由（表%d）", ns.TunnelTable())
+	case rule.Pref == ns.VIPPref:
+		return fmt.Sprintf("隧道VIP路由（表%d）", ns.VIPTable())
+	case ns.IsGroupPref(rule.Pref):
+		for _, group := range pm.groups {
+			if group.Priority == rule.Pref {
+				return fmt.Sprintf("策略组 %s", group.Name)
+			}
+		}
+		return "策略组（配置中不存在）"
+	case rule.Pref == ns.VRFPref:
+		return fmt.Sprintf("VRF隔离规则（表%s）", rule.Table)
+	case rule.Pref == ns.DefaultPref:
+		return "默认路由（0.0.0.0/0）"
+	case rule.Pref == 32766:
+		return "主路由表"
+	case rule.Pref == 32767:
+		return "默认路由表"
+	default:
+		return "非 twnode 管理"
+	}
+}
+
+// explainMatch 从配置层面解释命中原因
+func (pm *PolicyManager) explainMatch(rule *IPRule, route *IPRoute, dst netip.Addr) string {
+	ns := config.RoutingNamespace()
+	switch {
+	case rule.Pref == ns.SourceLocalPref():
+		return fmt.Sprintf("源地址 %s 属于物理接口，目标在表 %s 中有非默认路由（直连网段/隧道VIP），不走源地址路由", rule.From, rule.Table)
+	case rule.Pref == ns.SourcePref:
+		return fmt.Sprintf("源地址 %s 属于物理接口 %s，回程流量从该接口发出", rule.From, route.Dev)
+	case rule.Pref == ns.ProtectPref:
+		return fmt.Sprintf("%s 是隧道对端IP，受保护走主路由表，避免隧道流量进入隧道自身", rule.To)
+	case rule.Pref == ns.VIPPref:
+		tunnels, err := getAllTunnelConfigs()
+		if err == nil {
+			for _, t := range tunnels {
+				if t.RemoteVIP == dst.String() {
+					return fmt.Sprintf("%s 是隧道 %s 的对端VIP", dst, t.Name)
+				}
+			}
+		}
+		return fmt.Sprintf("表%d中的路由 %s", ns.VIPTable(), route.Prefix)
+	case ns.IsGroupPref(rule.Pref):
+		for _, group := range pm.groups {
+			if group.Priority != rule.Pref {
+				continue
+			}
+			for _, cidr := range group.CIDRs {
+				prefix, err := parseCIDRPrefix(cidr)
+				if err == nil && prefix.Contains(dst) {
+					detail := fmt.Sprintf("命中策略组 %s 的 CIDR %s，配置出口 %s", group.Name, cidr, group.Exit)
+					if route.Dev != "" && route.Dev != group.Exit {
+						detail += fmt.Sprintf("（⚠ 内核路由出口为 %s，与配置不一致，可能已被故障转移切换或需重新 apply）", route.Dev)
+					}
+					return detail
+				}
+			}
+			return fmt.Sprintf("策略组 %s 的路由表中存在 %s，但配置中无对应CIDR（需重新 apply）", group.Name, route.Prefix)
+		}
+	case rule.Pref == ns.DefaultPref:
+		return "未命中任何策略组，走 twnode 默认路由"
+	case rule.Pref == 32766:
+		return "未命中任何 twnode 规则，走系统主路由表"
+	}
+	return ""
+}
+
+// matches 判断规则选择器是否匹配
+func (r *IPRule) matches(dst, src netip.Addr, iif string) (bool, string) {
+	matched, reason := r.matchSelectors(dst, src, iif)
+	if r.Not {
+		if matched {
+			return false, "not 规则: 选择器匹配，取反后不命中"
+		}
+		return true, ""
+	}
+	return matched, reason
+}
+
+// matchSelectors 判断选择器（不含 not）是否匹配
+func (r *IPRule) matchSelectors(dst, src netip.Addr, iif string) (bool, string) {
+	if r.From != "" && r.From != "all" {
+		prefix, err := parseCIDRPrefix(r.From)
+		if err != nil {
+			return false, fmt.Sprintf("无法解析源选择器 %s", r.From)
+		}
+		if !src.IsValid() {
+			return false, fmt.Sprintf("源限制 %s，未指定 --from", r.From)
+		}
+		if !prefix.Contains(src) {
+			return false, fmt.Sprintf("源 %s 不在 %s 内", src, r.From)
+		}
+	}
+
+	if r.To != "" && r.To != "all" {
+		prefix, err := parseCIDRPrefix(r.To)
+		if err != nil {
+			return false, fmt.Sprintf("无法解析目标选择器 %s", r.To)
+		}
+		if !prefix.Contains(dst) {
+			return false, fmt.Sprintf("目标不在 %s 内", r.To)
+		}
+	}
+
+	if r.Iif != "" {
+		// 未指定 --iif 视为本机发出的流量（iif lo）
+		in := iif
+		if in == "" {
+			in = "lo"
+		}
+		if r.Iif != in {
+			return false, fmt.Sprintf("入接口限制 %s", r.Iif)
+		}
+	}
+
+	if r.Oif != "" {
+		return false, fmt.Sprintf("出接口限制 %s（无法推演）", r.Oif)
+	}
+
+	if r.Fwmark != "" {
+		return false, fmt.Sprintf("fwmark 限制 %s（无法推演）", r.Fwmark)
+	}
+
+	return true, ""
+}
+
+// ListIPRules 读取并解析 ip rule show
+func ListIPRules() ([]*IPRule, error) {
+	output, err := exec.Command("ip", "rule", "show").Output()
+	if err != nil {
+		return nil, fmt.Errorf("读取策略规则失败: %w", err)
+	}
+
+	rules := make([]*IPRule, 0)
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if rule := ParseIPRule(line); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// ParseIPRule 解析单条 ip rule 输出
+// 例: "100:	from 192.168.1.0/24 to 10.0.0.0/8 iif eth0 lookup 100"
+func ParseIPRule(line string) *IPRule {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return nil
+	}
+	pref, err := strconv.Atoi(line[:colon])
+	if err != nil {
+		return nil
+	}
+
+	rule := &IPRule{Pref: pref, Raw: line, Action: "lookup", SuppressPrefix: -1}
+	fields := strings.Fields(line[colon+1:])
+	for i := 0; i < len(fields); i++ {
+		next := ""
+		if i+1 < len(fields) {
+			next = fields[i+1]
+		}
+
+		switch fields[i] {
+		case "not":
+			rule.Not = true
+		case "from":
+			rule.From = next
+			i++
+		case "to":
+			rule.To = next
+			i++
+		case "iif":
+			rule.Iif = next
+			i++
+		case "oif":
+			rule.Oif = next
+			i++
+		case "fwmark":
+			rule.Fwmark = next
+			i++
+		case "lookup", "table":
+			rule.Action = "lookup"
+			rule.Table = next
+			i++
+		case "goto":
+			rule.Action = "goto"
+			i++
+		case "suppress_prefixlength":
+			if n, err := strconv.Atoi(next); err == nil {
+				rule.SuppressPrefix = n
+			}
+			i++
+		case "blackhole", "unreachable", "prohibit", "nop":
+			rule.Action = fields[i]
+		}
+	}
+
+	return rule
+}
+
+// ListIPRoutes 读取并解析指定路由表
+func ListIPRoutes(table string) ([]*IPRoute, error) {
+	output, err := exec.Command("ip", "route", "show", "table", table).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]*IPRoute, 0)
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if route := ParseIPRoute(line); route != nil {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+// ParseIPRoute 解析单条路由
+// 例: "10.0.0.0/8 via 192.168.1.1 dev eth0", "throw 10.1.0.0/16", "default dev tun01 scope link"
+func ParseIPRoute(line string) *IPRoute {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	route := &IPRoute{Type: "unicast", Raw: line}
+	if routeTypes[fields[0]] {
+		route.Type = fields[0]
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return nil
+		}
+	}
+
+	dst := fields[0]
+	if dst == "default" {
+		dst = "0.0.0.0/0"
+	}
+	prefix, err := parseCIDRPrefix(dst)
+	if err != nil {
+		return nil
+	}
+	route.Prefix = prefix
+
+	for i := 1; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "via":
+			route.Gateway = fields[i+1]
+		case "dev":
+			route.Dev = fields[i+1]
+		}
+	}
+
+	return route
+}
+
+// longestPrefixMatch 最长前缀匹配
+func longestPrefixMatch(routes []*IPRoute, dst netip.Addr) *IPRoute {
+	var best *IPRoute
+	for _, route := range routes {
+		if route.Prefix.Addr().Is4() != dst.Is4() || !route.Prefix.Contains(dst) {
+			continue
+		}
+		if best == nil || route.Prefix.Bits() > best.Prefix.Bits() {
+			best = route
+		}
+	}
+	return best
+}
+
+// kernelRouteGet 查询内核实际路由决策（ip route get）
+// 返回: 出口接口, 网关, 原始输出
+func kernelRouteGet(dst, from, iif string) (string, string, string, error) {
+	args := []string{"route", "get", dst}
+	if from != "" {
+		args = append(args, "from", from)
+	}
+	if iif != "" {
+		args = append(args, "iif", iif)
+	}
+
+	output, err := exec.Command("ip", args...).CombinedOutput()
+	raw := strings.TrimSpace(string(output))
+	if err != nil {
+		return "", "", raw, fmt.Errorf("%s", raw)
+	}
+
+	var dev, gateway string
+	fields := strings.Fields(raw)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "dev":
+			if dev == "" {
+				dev = fields[i+1]
+			}
+		case "via":
+			if gateway == "" {
+				gateway = fields[i+1]
+			}
+		}
+	}
+
+	return dev, gateway, raw, nil
+}
+
+// Explain 解释目标地址的路由决策，并与内核 ip route get 的结果比对
+func Explain(dst, from, iif string, verbose bool) error {
+	result, err := ExplainRoute(dst, from, iif)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("【查询条件】")
+	fmt.Printf("  目标地址: %s\n", dst)
+	if from != "" {
+		fmt.Printf("  源地址: %s\n", from)
+	}
+	if iif != "" {
+		fmt.Printf("  入接口: %s\n", iif)
+	} else {
+		fmt.Printf("  入接口: (本机发出)\n")
+	}
+
+	fmt.Println()
+	fmt.Println("【规则匹配过程】")
+	for _, step := range result.Steps {
+		// 非详细模式下只显示 twnode 管理的规则和命中的规则
+		if !verbose && !step.Matched && step.Owner == "非 twnode 管理" {
+			continue
+		}
+
+		mark := "·"
+		if step.Final {
+			mark = "✓"
+		} else if step.Matched {
+			mark = "→"
+		}
+		fmt.Printf("  %s [%5d] %-24s %s\n", mark, step.Pref, step.Owner, step.Rule)
+		if step.Reason != "" {
+			fmt.Printf("            %s\n", step.Reason)
+		}
+
+		if step.Final {
+			break
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("【决策结果】")
+	if result.Type == "" {
+		fmt.Println("  ✗ 没有任何规则命中，目标不可达")
+	} else {
+		fmt.Printf("  命中规则: 优先级 %d (%s)\n", result.Pref, result.Owner)
+		if result.Table != "" {
+			fmt.Printf("  路由表: %s\n", result.Table)
+		}
+		if result.Route != "" {
+			fmt.Printf("  路由: %s\n", result.Route)
+		}
+		if result.Type != "unicast" {
+			fmt.Printf("  路由类型: %s\n", result.Type)
+		}
+		if result.Exit != "" {
+			fmt.Printf("  出口接口: %s\n", result.Exit)
+		}
+		if result.Gateway != "" {
+			fmt.Printf("  网关: %s\n", result.Gateway)
+		} else if result.Exit != "" {
+			fmt.Printf("  网关: (直连/点对点)\n")
+		}
+		if result.Detail != "" {
+			fmt.Printf("  说明: %s\n", result.Detail)
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("【内核校验】(ip route get)")
+	dev, gateway, raw, err := kernelRouteGet(dst, from, iif)
+	if err != nil {
+		fmt.Printf("  ✗ 查询失败: %v\n", err)
+		return nil
+	}
+	fmt.Printf("  %s\n", strings.ReplaceAll(raw, "\n", "\n  "))
+
+	if dev == result.Exit && gateway == result.Gateway {
+		fmt.Println("  ✓ 推演结果与内核一致")
+	} else {
+		fmt.Printf("  ⚠ 推演结果与内核不一致: 推演出口 %s 网关 %s，内核出口 %s 网关 %s\n",
+			displayOrNone(result.Exit), displayOrNone(result.Gateway), displayOrNone(dev), displayOrNone(gateway))
+		fmt.Println("    可能原因: 存在 fwmark/oif 规则、路由缓存或非 twnode 管理的规则，使用 --verbose 查看全部规则")
+	}
+
+	return nil
+}
+
+// displayOrNone 空字符串显示为"无"
+func displayOrNone(s string) string {
+	if s == "" {
+		return "无"
+	}
+	return s
+}
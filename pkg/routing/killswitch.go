@@ -0,0 +1,283 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/network"
+)
+
+// 出口不可用时的处理方式（kill switch）
+const (
+	OnExitDownLeak        = "leak"        // 跳过策略组，流量回落到后续规则/主路由表（默认，兼容旧行为）
+	OnExitDownBlackhole   = "blackhole"   // 在路由表中安装 blackhole 路由，静默丢弃
+	OnExitDownUnreachable = "unreachable" // 在路由表中安装 unreachable 路由，立即返回 ICMP 不可达
+	OnExitDownFallback    = "fallback"    // 切换到指定的备用出口: fallback:<exit>
+)
+
+// ParseOnExitDown 解析出口不可用时的处理方式
+// 返回: 处理方式, 备用出口（仅 fallback）
+func ParseOnExitDown(value string) (string, string, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "", OnExitDownLeak:
+		return OnExitDownLeak, "", nil
+	case OnExitDownBlackhole, OnExitDownUnreachable:
+		return value, "", nil
+	}
+
+	if strings.HasPrefix(value, OnExitDownFallback+":") {
+		exit := strings.TrimSpace(strings.TrimPrefix(value, OnExitDownFallback+":"))
+		if exit == "" {
+			return "", "", fmt.Errorf("fallback 必须指定备用出口: fallback:<exit>")
+		}
+		return OnExitDownFallback, exit, nil
+	}
+
+	return "", "", fmt.Errorf("无效的出口故障处理方式 '%s' (可选: leak/blackhole/unreachable/fallback:<exit>)", value)
+}
+
+// OnExitDownDisplay 返回出口故障处理方式的显示文本
+func OnExitDownDisplay(value string) string {
+	if value == "" {
+		return OnExitDownLeak
+	}
+	return value
+}
+
+// ExitDownRouteType 返回 on_exit_down 阻断时安装的路由类型（leak 或无效值时为空）
+// fallback 的备用出口也不可用时安装 blackhole 路由
+func ExitDownRouteType(value string) string {
+	action, _, err := ParseOnExitDown(value)
+	if err != nil {
+		return ""
+	}
+	switch action {
+	case OnExitDownBlackhole, OnExitDownUnreachable:
+		return action
+	case OnExitDownFallback:
+		return OnExitDownBlackhole
+	}
+	return ""
+}
+
+// exitUnavailableReason 检查出口是否可用
+// netns 不为空时在网络命名空间内检查
+// 返回: 不可用原因，可用时返回空字符串
+func exitUnavailableReason(exit, netns string) string {
+	if netns != "" {
+		if !network.LinkUpIn(exit, netns) {
+			return fmt.Sprintf("接口 %s 在网络命名空间 %s 中不存在或未启动", exit, netns)
+		}
+		return ""
+	}
+
+	if !network.IsInterfaceUp(exit) {
+		return fmt.Sprintf("接口 %s 不存在或未启动", exit)
+	}
+
+	info, err := network.GetInterfaceInfo(exit)
+	if err != nil {
+		return fmt.Sprintf("无法获取接口 %s 信息", exit)
+	}
+
+	if info.Type == network.InterfaceTypeLoopback {
+		return fmt.Sprintf("接口 %s 是回环接口", exit)
+	}
+
+	return ""
+}
+
+// SetGroupOnExitDown 设置策略组出口不可用时的处理方式
+func (pm *PolicyManager) SetGroupOnExitDown(groupName, value string) error {
+	group, exists := pm.groups[groupName]
+	if !exists {
+		return fmt.Errorf("策略组 %s 不存在", groupName)
+	}
+
+	action, fallback, err := ParseOnExitDown(value)
+	if err != nil {
+		return err
+	}
+	if action == OnExitDownFallback && fallback == group.Exit {
+		return fmt.Errorf("备用出口不能与策略组出口相同")
+	}
+
+	if action == OnExitDownLeak {
+		group.OnExitDown = ""
+	} else {
+		group.OnExitDown = strings.TrimSpace(value)
+	}
+	return nil
+}
+
+// SetDefaultOnExitDown 设置默认路由出口不可用时的处理方式
+func (pm *PolicyManager) SetDefaultOnExitDown(value string) {
+	pm.defaultOnExitDown = value
+}
+
+// GetDefaultOnExitDown 获取默认路由出口不可用时的处理方式
+func (pm *PolicyManager) GetDefaultOnExitDown() string {
+	return pm.defaultOnExitDown
+}
+
+// applyGroupExitDown 策略组出口不可用时按 on_exit_down 处理
+// leak 返回 handled=false，由调用方按原有逻辑处理（跳过或报错）
+func (pm *PolicyManager) applyGroupExitDown(group *PolicyGroup, reason string) (bool, error) {
+	action, fallback, err := ParseOnExitDown(group.OnExitDown)
+	if err != nil {
+		return false, fmt.Errorf("策略组 %s: %w", group.Name, err)
+	}
+
+	switch action {
+	case OnExitDownLeak:
+		return false, nil
+
+	case OnExitDownFallback:
+		if fallbackReason := exitUnavailableReason(fallback, group.Netns); fallbackReason == "" {
+			fmt.Printf("\n⚠ 策略组 %s: %s，切换到备用出口 %s\n", group.Name, reason, fallback)
+			fallbackGroup := *group
+			fallbackGroup.Exit = fallback
+			fallbackGroup.OnExitDown = OnExitDownBlackhole
+			return true, pm.ApplyGroup(&fallbackGroup)
+		} else {
+			// 备用出口也不可用：阻断，避免泄漏
+			fmt.Printf("\n⚠ 策略组 %s: %s，备用出口也不可用（%s），阻断流量\n", group.Name, reason, fallbackReason)
+			action = OnExitDownBlackhole
+		}
+
+	default:
+		fmt.Printf("\n⚠ 策略组 %s: %s，启用阻断 (%s)\n", group.Name, reason, action)
+	}
+
+	tableID := config.RoutingNamespace().GroupTable(group.Priority)
+	execIPCommandNoError(group.ipCmd("ip route flush table %d", tableID))
+
+	cidrs, throws := group.RouteSets()
+	successCount := installKillSwitchRoutes(action, cidrs, tableID, group.Netns)
+
+	// 排除的CIDR仍然跳出本组
+	for _, cidr := range throws {
+		execIPCommandNoError(group.ipCmd("ip route add throw %s table %d", cidr, tableID))
+	}
+
+	if err := ensurePolicyRule(group.ruleSelector(), group.Netns, tableID, group.Priority); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("  ✓ 策略组已阻断: %d/%d 个CIDR (%s)\n", successCount, len(cidrs), action)
+
+	markGroupApplied(group.Name, true)
+	return true, nil
+}
+
+// applyDefaultExitDown 默认路由出口不可用时按 on_exit_down 处理
+// leak 返回 handled=false，由调用方按原有逻辑处理（跳过或报错）
+func (pm *PolicyManager) applyDefaultExitDown(reason string) (bool, error) {
+	action, fallback, err := ParseOnExitDown(pm.defaultOnExitDown)
+	if err != nil {
+		return false, fmt.Errorf("默认路由: %w", err)
+	}
+
+	switch action {
+	case OnExitDownLeak:
+		return false, nil
+
+	case OnExitDownFallback:
+		if fallbackReason := exitUnavailableReason(fallback, ""); fallbackReason == "" {
+			fmt.Printf("\n⚠ 默认路由: %s，切换到备用出口 %s\n", reason, fallback)
+			originalExit := pm.defaultExit
+			pm.defaultExit = fallback
+			err := pm.applyDefaultRoute()
+			pm.defaultExit = originalExit
+			return true, err
+		} else {
+			fmt.Printf("\n⚠ 默认路由: %s，备用出口也不可用（%s），阻断流量\n", reason, fallbackReason)
+			action = OnExitDownBlackhole
+		}
+
+	default:
+		fmt.Printf("\n⚠ 默认路由: %s，启用阻断 (%s)\n", reason, action)
+	}
+
+	ns := config.RoutingNamespace()
+	execIPCommandNoError(fmt.Sprintf("ip route flush table %d", ns.DefaultTable()))
+
+	if installKillSwitchRoutes(action, []string{"0.0.0.0/0"}, ns.DefaultTable(), "") == 0 {
+		return true, fmt.Errorf("添加 %s 默认路由失败", action)
+	}
+
+	if err := ensurePolicyRule("from all", "", ns.DefaultTable(), ns.DefaultPref); err != nil {
+		return true, err
+	}
+
+	fmt.Printf("  ✓ 默认路由已阻断 (%s)\n", action)
+	return true, nil
+}
+
+// ApplyExitDown 出口不可用时强制执行 on_exit_down（供守护进程在所有候选出口均不可用时调用）
+// groupName 为空表示默认路由
+// 返回: 是否已处理（leak 时不做任何修改）
+func (pm *PolicyManager) ApplyExitDown(groupName, reason string) (bool, error) {
+	if groupName == "" {
+		return pm.applyDefaultExitDown(reason)
+	}
+
+	group := pm.groups[groupName]
+	if group == nil {
+		return false, fmt.Errorf("策略组 %s 不存在", groupName)
+	}
+	return pm.applyGroupExitDown(group, reason)
+}
+
+// installKillSwitchRoutes 在路由表中安装 blackhole/unreachable 路由
+// 返回: 成功数量
+func installKillSwitchRoutes(action string, cidrs []string, tableID int, netns string) int {
+	successCount := 0
+	for _, cidr := range cidrs {
+		cmd := network.IPCommandLine(netns, fmt.Sprintf("ip route replace %s %s table %d", action, cidr, tableID))
+		if err := execIPCommand(cmd); err != nil {
+			fmt.Printf("  ✗ %s %s - 失败: %v\n", action, cidr, err)
+			continue
+		}
+		successCount++
+	}
+	return successCount
+}
+
+// ensurePolicyRule 确保 pref 对应的策略规则存在且唯一
+// selector 为规则的匹配条件（如 "from all"、"from 10.0.0.0/8 iif vrf-blue"），netns 不为空时在网络命名空间内操作
+func ensurePolicyRule(selector, netns string, tableID, pref int) error {
+	ruleCmd := network.IPCommandLine(netns, fmt.Sprintf("ip rule add %s lookup %d pref %d", selector, tableID, pref))
+
+	// 先删除同优先级的旧规则（from 可能已变化），再添加
+	for i := 0; i < 10; i++ {
+		output, err := network.IPCommand(netns, "rule", "show", "pref", fmt.Sprintf("%d", pref)).Output()
+		if err != nil || len(strings.TrimSpace(string(output))) == 0 {
+			break
+		}
+		execIPCommandNoError(network.IPCommandLine(netns, fmt.Sprintf("ip rule del pref %d", pref)))
+	}
+
+	if err := execIPCommand(ruleCmd); err != nil {
+		fmt.Printf("  ✗ 添加策略规则失败\n")
+		fmt.Printf("     错误: %v\n", err)
+		fmt.Printf("     命令: %s\n", ruleCmd)
+		return err
+	}
+	return nil
+}
+
+// exitDownHint 返回出口不可用时的处理提示
+func exitDownHint(onExitDown string) string {
+	action, fallback, err := ParseOnExitDown(onExitDown)
+	switch {
+	case err != nil || action == OnExitDownLeak:
+		return "跳过（流量回落到后续规则）"
+	case action == OnExitDownFallback:
+		return fmt.Sprintf("切换到备用出口 %s", fallback)
+	default:
+		return fmt.Sprintf("阻断流量 (%s)", action)
+	}
+}
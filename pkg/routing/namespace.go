@@ -0,0 +1,87 @@
+package routing
+
+import (
+	"fmt"
+	"strconv"
+
+	"trueword_node/pkg/config"
+)
+
+// NamespaceConflicts 检测现有 ip rule 与路由命名空间的冲突
+// 属于命名空间的优先级上出现了非预期的规则，或其他优先级查询了命名空间内的路由表，都视为冲突
+// applied 为内核状态当前使用的命名空间，其中 twnode 自己的规则不算冲突（迁移时会被清理）
+func NamespaceConflicts(ns, applied config.Namespace) ([]string, error) {
+	rules, err := ListIPRules()
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := make([]string, 0)
+	for _, rule := range rules {
+		// 内核默认规则（local/main/default）
+		if rule.Pref == 0 || rule.Pref == 32766 || rule.Pref == 32767 {
+			continue
+		}
+		if IsNamespaceRule(applied, rule) {
+			continue
+		}
+
+		if ns.OwnsPref(rule.Pref) {
+			if !IsNamespaceRule(ns, rule) {
+				conflicts = append(conflicts, fmt.Sprintf("优先级 %d 被非 twnode 规则占用: %s", rule.Pref, rule.Raw))
+			}
+			continue
+		}
+
+		if tableID, err := strconv.Atoi(rule.Table); err == nil && ns.OwnsTable(tableID) {
+			conflicts = append(conflicts, fmt.Sprintf("路由表 %d 被优先级 %d 的规则使用: %s", tableID, rule.Pref, rule.Raw))
+		}
+	}
+
+	return conflicts, nil
+}
+
+// IsNamespaceRule 判断规则是否为命名空间内 twnode 安装的规则（优先级和查询的路由表均匹配）
+func IsNamespaceRule(ns config.Namespace, rule *IPRule) bool {
+	if !ns.OwnsPref(rule.Pref) || rule.Action != "lookup" {
+		return false
+	}
+
+	// 保护规则: to <对端IP> lookup main（父接口属于VRF时查询VRF的路由表）
+	if rule.Pref == ns.ProtectPref {
+		return rule.To != ""
+	}
+
+	// 源地址本地路由规则: from <上行IP> lookup main/VIP路由表
+	if rule.Pref == ns.SourceLocalPref() {
+		return rule.From != "" && rule.From != "all" && (rule.Table == "main" || rule.Table == strconv.Itoa(ns.VIPTable()))
+	}
+
+	// VRF 隔离规则: iif/oif <VRF> lookup <VRF路由表>
+	if rule.Pref == ns.VRFPref {
+		return rule.Iif != "" || rule.Oif != ""
+	}
+
+	tableID, err := strconv.Atoi(rule.Table)
+	if err != nil {
+		return false
+	}
+
+	switch rule.Pref {
+	case ns.TestPref:
+		return tableID == ns.TestTable()
+	case ns.SourcePref:
+		tableMin, tableMax := ns.SourceTables()
+		return tableID >= tableMin && tableID <= tableMax
+	case ns.ForwardPref:
+		tableMin, tableMax := ns.ForwardTables()
+		return tableID >= tableMin && tableID <= tableMax
+	case ns.TunnelPref:
+		return tableID == ns.TunnelTable()
+	case ns.VIPPref:
+		return tableID == ns.VIPTable()
+	case ns.DefaultPref:
+		return tableID == ns.DefaultTable()
+	}
+	return tableID == ns.GroupTable(rule.Pref)
+}
@@ -12,20 +12,21 @@ import (
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/vishvananda/netlink"
+	"trueword_node/pkg/config"
 	"trueword_node/pkg/network"
+	"trueword_node/pkg/scoring"
 	"trueword_node/pkg/wireguard"
 )
 
 const (
 	PolicyDir = "/etc/trueword_node/policies"
 
-	// 策略路由优先级范围
-	// 保留0-99给系统关键路由（对端IP、对端VIP等）
-	// 100-999给用户策略组
+	// 已应用的策略组记录（每行一个组名），用于漂移检测判断哪些策略组应存在于内核
+	AppliedGroupsFile = "/var/lib/trueword_node/applied_groups"
+
+	// 策略路由优先级和路由表号由路由命名空间配置（config.Namespace）决定
+	// 默认: 系统关键路由 5-80，用户策略组 100-899，默认路由 900
 	// 32766是主路由表，32767是默认路由表
-	PrioSystem         = 10  // 系统关键路由（对端真实IP、VIP）
-	PrioUserPolicyBase = 100 // 用户策略组基础优先级
-	PrioDefault        = 900 // 默认路由优先级
 )
 
 // PolicyGroup 策略组
@@ -34,14 +35,23 @@ type PolicyGroup struct {
 	Priority int      // 优先级
 	Exit     string   // 出口（隧道名或物理接口名）
 	CIDRs    []string // 目标CIDR列表
+	Excludes []string // 排除CIDR列表（命中后跳出本组，交给后续规则处理）
 	From     string   // 源地址/源地址段（默认 "all"）
+
+	// 隔离上下文（二选一）: VRF 策略组只匹配从该VRF进入的流量；网络命名空间策略组的路由表和规则在命名空间内维护
+	VRF   string
+	Netns string
+
+	// 出口不可用时的处理方式: leak/blackhole/unreachable/fallback:<exit>（空表示 leak）
+	OnExitDown string
 }
 
 // PolicyManager 策略管理器
 type PolicyManager struct {
-	groups        map[string]*PolicyGroup
-	defaultExit   string
-	appliedGroups []string // 已应用的策略组名称
+	groups            map[string]*PolicyGroup
+	defaultExit       string
+	defaultOnExitDown string   // 默认路由出口不可用时的处理方式
+	appliedGroups     []string // 已应用的策略组名称
 }
 
 func NewPolicyManager() *PolicyManager {
@@ -52,22 +62,38 @@ func NewPolicyManager() *PolicyManager {
 }
 
 // 创建策略组
-func (pm *PolicyManager) CreateGroup(name, exit string, priority int, from string) error {
+func (pm *PolicyManager) CreateGroup(name, exit string, priority int, from, vrf, netns string) error {
 	if _, exists := pm.groups[name]; exists {
 		return fmt.Errorf("策略组 %s 已存在", name)
 	}
 
-	if priority < PrioUserPolicyBase || priority >= PrioDefault {
-		return fmt.Errorf("优先级必须在 %d-%d 之间", PrioUserPolicyBase, PrioDefault-1)
+	ns := config.RoutingNamespace()
+	if !ns.IsGroupPref(priority) {
+		return fmt.Errorf("优先级必须在 %d-%d 之间", ns.GroupPrefMin, ns.GroupPrefMax())
 	}
 
-	// 验证出口接口（允许物理接口、隧道、第三方接口，但不允许loopback）
-	info, err := network.ValidateExitInterface(exit)
-	if err != nil {
-		return fmt.Errorf("出口接口验证失败: %w", err)
+	if err := network.ValidateIsolation(vrf, netns); err != nil {
+		return err
 	}
 
-	fmt.Printf("✓ 出口接口 %s 类型: %s, 状态: UP\n", exit, info.Type.String())
+	// 验证出口接口（允许物理接口、隧道、第三方接口，但不允许loopback）
+	if netns != "" {
+		// 网络命名空间内的出口在命名空间内验证
+		if !network.LinkUpIn(exit, netns) {
+			return fmt.Errorf("出口接口验证失败: 接口 %s 在网络命名空间 %s 中不存在或未启动", exit, netns)
+		}
+		fmt.Printf("✓ 出口接口 %s (网络命名空间: %s), 状态: UP\n", exit, netns)
+	} else {
+		info, err := network.ValidateExitInterface(exit)
+		if err != nil {
+			return fmt.Errorf("出口接口验证失败: %w", err)
+		}
+
+		fmt.Printf("✓ 出口接口 %s 类型: %s, 状态: UP\n", exit, info.Type.String())
+		if vrf != "" && network.LinkVRF(exit) != vrf {
+			fmt.Printf("⚠ 出口接口 %s 不属于 VRF %s，VRF 内的流量可能无法经该出口转发\n", exit, vrf)
+		}
+	}
 
 	// 解析from参数
 	parsedFrom, err := ParseFromInput(from)
@@ -84,7 +110,10 @@ func (pm *PolicyManager) CreateGroup(name, exit string, priority int, from strin
 		Priority: priority,
 		Exit:     exit,
 		CIDRs:    make([]string, 0),
+		Excludes: make([]string, 0),
 		From:     parsedFrom,
+		VRF:      vrf,
+		Netns:    netns,
 	}
 
 	return nil
@@ -105,7 +134,7 @@ func (pm *PolicyManager) DeleteGroup(groupName string) error {
 	fmt.Printf("删除策略组: %s\n", groupName)
 
 	// 检查策略是否已应用（通过检查内核中的规则）
-	checkCmd := fmt.Sprintf("ip rule show pref %d", group.Priority)
+	checkCmd := group.ipCmd("ip rule show pref %d", group.Priority)
 	output, err := exec.Command("sh", "-c", checkCmd).CombinedOutput()
 
 	isApplied := err == nil && len(output) > 0 && strings.Contains(string(output), fmt.Sprintf("%d:", group.Priority))
@@ -133,66 +162,140 @@ func (pm *PolicyManager) DeleteGroup(groupName string) error {
 
 	// 从内存中移除
 	delete(pm.groups, groupName)
+	markGroupApplied(groupName, false)
 
 	fmt.Printf("✓ 策略组 %s 删除完成\n", groupName)
 	return nil
 }
 
 // 添加CIDR到策略组
+// CIDR 会被规范化，并与组内已有条目去重、聚合
 func (pm *PolicyManager) AddCIDR(groupName, cidr string) error {
 	group, exists := pm.groups[groupName]
 	if !exists {
 		return fmt.Errorf("策略组 %s 不存在", groupName)
 	}
 
-	// 验证CIDR
-	_, _, err := net.ParseCIDR(cidr)
+	// 验证并规范化CIDR
+	normalized, err := NormalizeCIDR(cidr)
 	if err != nil {
-		return fmt.Errorf("无效的CIDR: %s", cidr)
+		return err
+	}
+	if normalized != cidr {
+		fmt.Printf("  CIDR 已规范化: %s -> %s\n", cidr, normalized)
 	}
 
-	group.CIDRs = append(group.CIDRs, cidr)
+	before := len(group.CIDRs)
+	group.CIDRs = AggregateCIDRs(append(group.CIDRs, normalized))
+	if len(group.CIDRs) <= before {
+		fmt.Printf("  %s 已被组内现有条目覆盖或与之合并（当前 %d 条）\n", normalized, len(group.CIDRs))
+	}
 	return nil
 }
 
 // 从文件导入CIDR
+// 导入的CIDR会被规范化，并与组内已有条目去重、聚合
 func (pm *PolicyManager) ImportCIDRsFromFile(groupName, filePath string) error {
 	group, exists := pm.groups[groupName]
 	if !exists {
 		return fmt.Errorf("策略组 %s 不存在", groupName)
 	}
 
+	imported, err := readCIDRFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	before := len(group.CIDRs)
+	group.CIDRs = AggregateCIDRs(append(group.CIDRs, imported...))
+
+	fmt.Printf("成功导入 %d 个CIDR到策略组 %s\n", len(imported), groupName)
+	fmt.Printf("  规范化/去重/聚合后: %d 条 (导入前 %d 条)\n", len(group.CIDRs), before)
+	return nil
+}
+
+// AddExclude 添加排除CIDR到策略组
+// 排除的CIDR即使落在组内CIDR范围内，也不会走本组出口
+func (pm *PolicyManager) AddExclude(groupName, cidr string) error {
+	group, exists := pm.groups[groupName]
+	if !exists {
+		return fmt.Errorf("策略组 %s 不存在", groupName)
+	}
+
+	normalized, err := NormalizeCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	if normalized != cidr {
+		fmt.Printf("  CIDR 已规范化: %s -> %s\n", cidr, normalized)
+	}
+
+	group.Excludes = AggregateCIDRs(append(group.Excludes, normalized))
+	warnCoveredCIDRs(group)
+	return nil
+}
+
+// ImportExcludesFromFile 从文件导入排除CIDR
+func (pm *PolicyManager) ImportExcludesFromFile(groupName, filePath string) error {
+	group, exists := pm.groups[groupName]
+	if !exists {
+		return fmt.Errorf("策略组 %s 不存在", groupName)
+	}
+
+	imported, err := readCIDRFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	before := len(group.Excludes)
+	group.Excludes = AggregateCIDRs(append(group.Excludes, imported...))
+
+	fmt.Printf("成功导入 %d 个排除CIDR到策略组 %s\n", len(imported), groupName)
+	fmt.Printf("  规范化/去重/聚合后: %d 条 (导入前 %d 条)\n", len(group.Excludes), before)
+	warnCoveredCIDRs(group)
+	return nil
+}
+
+// warnCoveredCIDRs 提示被排除项完全覆盖的组内CIDR（应用时不添加路由）
+func warnCoveredCIDRs(group *PolicyGroup) {
+	covered := group.coveredCIDRs()
+	if len(covered) == 0 {
+		return
+	}
+	fmt.Printf("  ⚠ %d 个组内CIDR被排除项完全覆盖，应用时不添加路由: %s\n", len(covered), strings.Join(covered, ", "))
+}
+
+// readCIDRFile 读取CIDR列表文件（忽略空行和#注释，跳过无效条目），返回规范化后的CIDR
+func readCIDRFile(filePath string) ([]string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("打开文件失败: %w", err)
+		return nil, fmt.Errorf("打开文件失败: %w", err)
 	}
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
-	count := 0
+	cidrs := make([]string, 0)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		// 验证CIDR
-		_, _, err := net.ParseCIDR(line)
+		// 验证并规范化CIDR
+		normalized, err := NormalizeCIDR(line)
 		if err != nil {
 			fmt.Printf("跳过无效CIDR: %s\n", line)
 			continue
 		}
 
-		group.CIDRs = append(group.CIDRs, line)
-		count++
+		cidrs = append(cidrs, normalized)
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("读取文件失败: %w", err)
+		return nil, fmt.Errorf("读取文件失败: %w", err)
 	}
 
-	fmt.Printf("成功导入 %d 个CIDR到策略组 %s\n", count, groupName)
-	return nil
+	return cidrs, nil
 }
 
 // 设置默认路由出口
@@ -260,23 +363,39 @@ func (pm *PolicyManager) Apply() error {
 	// 1. 检查所有出口是否有效
 	fmt.Println("\n检查出口状态...")
 	validGroups := make(map[string]*PolicyGroup)
+	downGroups := make(map[string]*PolicyGroup) // 出口不可用但配置了 on_exit_down 的策略组
 	for _, group := range pm.groups {
+		// 网络命名空间内的策略组：在命名空间内检查出口
+		if group.Netns != "" {
+			if reason := exitUnavailableReason(group.Exit, group.Netns); reason != "" {
+				fmt.Printf("  ✗ %s: %s，%s\n", group.Name, reason, exitDownHint(group.OnExitDown))
+				downGroups[group.Name] = group
+			} else {
+				fmt.Printf("  ✓ %s: 接口 %s 正常 (网络命名空间: %s)\n", group.Name, group.Exit, group.Netns)
+				validGroups[group.Name] = group
+			}
+			continue
+		}
+
 		// 使用新的接口验证函数
 		if !network.IsInterfaceUp(group.Exit) {
-			fmt.Printf("  ✗ %s: 接口 %s 不存在或未启动，跳过此策略组\n", group.Name, group.Exit)
+			fmt.Printf("  ✗ %s: 接口 %s 不存在或未启动，%s\n", group.Name, group.Exit, exitDownHint(group.OnExitDown))
+			downGroups[group.Name] = group
 			continue
 		}
 
 		// 检测接口类型
 		info, err := network.GetInterfaceInfo(group.Exit)
 		if err != nil {
-			fmt.Printf("  ✗ %s: 无法获取接口 %s 信息，跳过此策略组\n", group.Name, group.Exit)
+			fmt.Printf("  ✗ %s: 无法获取接口 %s 信息，%s\n", group.Name, group.Exit, exitDownHint(group.OnExitDown))
+			downGroups[group.Name] = group
 			continue
 		}
 
 		// 拒绝loopback
 		if info.Type == network.InterfaceTypeLoopback {
-			fmt.Printf("  ✗ %s: 接口 %s 是回环接口，跳过此策略组\n", group.Name, group.Exit)
+			fmt.Printf("  ✗ %s: 接口 %s 是回环接口，%s\n", group.Name, group.Exit, exitDownHint(group.OnExitDown))
+			downGroups[group.Name] = group
 			continue
 		}
 
@@ -284,20 +403,28 @@ func (pm *PolicyManager) Apply() error {
 		validGroups[group.Name] = group
 	}
 
+	defaultDownReason := ""
 	if pm.defaultExit != "" {
 		if !network.IsInterfaceUp(pm.defaultExit) {
-			fmt.Printf("  ⚠ 默认出口: 接口 %s 不存在或未启动，将跳过默认路由设置\n", pm.defaultExit)
-			pm.defaultExit = "" // 清空，跳过后续默认路由应用
+			defaultDownReason = fmt.Sprintf("接口 %s 不存在或未启动", pm.defaultExit)
 		} else {
 			info, err := network.GetInterfaceInfo(pm.defaultExit)
 			if err != nil || info.Type == network.InterfaceTypeLoopback {
-				fmt.Printf("  ⚠ 默认出口: 接口 %s 不可用或是回环接口，将跳过默认路由设置\n", pm.defaultExit)
-				pm.defaultExit = ""
+				defaultDownReason = fmt.Sprintf("接口 %s 不可用或是回环接口", pm.defaultExit)
 			} else {
 				fmt.Printf("  ✓ 默认出口: 接口 %s 正常 (类型: %s)\n", pm.defaultExit, info.Type.String())
 			}
 		}
 	}
+	if defaultDownReason != "" {
+		action, _, _ := ParseOnExitDown(pm.defaultOnExitDown)
+		if action == OnExitDownLeak {
+			fmt.Printf("  ⚠ 默认出口: %s，将跳过默认路由设置\n", defaultDownReason)
+			pm.defaultExit = "" // 清空，跳过后续默认路由应用
+		} else {
+			fmt.Printf("  ⚠ 默认出口: %s，%s\n", defaultDownReason, exitDownHint(pm.defaultOnExitDown))
+		}
+	}
 
 	// 2. 创建路由表并添加策略（仅应用有效的策略组）
 	for _, group := range validGroups {
@@ -308,8 +435,24 @@ func (pm *PolicyManager) Apply() error {
 		pm.appliedGroups = append(pm.appliedGroups, group.Name)
 	}
 
+	// 出口不可用的策略组：按 on_exit_down 处理（leak 则跳过）
+	for _, group := range downGroups {
+		handled, err := pm.applyGroupExitDown(group, fmt.Sprintf("出口 %s 不可用", group.Exit))
+		if err != nil {
+			fmt.Printf("\n  ⚠ 策略组 %s 阻断失败: %v\n", group.Name, err)
+			continue
+		}
+		if handled {
+			pm.appliedGroups = append(pm.appliedGroups, group.Name)
+		}
+	}
+
 	// 3. 应用默认路由(0.0.0.0/0)
-	if pm.defaultExit != "" {
+	if pm.defaultExit != "" && defaultDownReason != "" {
+		if _, err := pm.applyDefaultExitDown(defaultDownReason); err != nil {
+			return fmt.Errorf("应用默认路由失败: %w", err)
+		}
+	} else if pm.defaultExit != "" {
 		if err := pm.applyDefaultRoute(); err != nil {
 			return fmt.Errorf("应用默认路由失败: %w", err)
 		}
@@ -327,43 +470,65 @@ func (pm *PolicyManager) Apply() error {
 
 // ApplyGroup 应用单个策略组
 func (pm *PolicyManager) ApplyGroup(group *PolicyGroup) error {
-	tableID := group.Priority
+	tableID := config.RoutingNamespace().GroupTable(group.Priority)
+
+	// 出口不可用时按 on_exit_down 处理（leak 则按原有逻辑继续）
+	if reason := exitUnavailableReason(group.Exit, group.Netns); reason != "" {
+		if handled, err := pm.applyGroupExitDown(group, reason); handled || err != nil {
+			return err
+		}
+	}
 
 	fmt.Printf("\n应用策略组: %s\n", group.Name)
 	fmt.Printf("  出口接口: %s\n", group.Exit)
 	fmt.Printf("  优先级: %d\n", group.Priority)
+	if ns := config.RoutingNamespace(); group.Priority == ns.VRFPref {
+		fmt.Printf("  ⚠ 优先级 %d 已保留给VRF隔离，请迁移: twnode policy set-priority %s <%d-%d>\n",
+			group.Priority, group.Name, ns.GroupPrefMin, ns.GroupPrefMax())
+	}
+	if group.VRF != "" || group.Netns != "" {
+		fmt.Printf("  隔离上下文: %s\n", network.IsolationLabel(group.VRF, group.Netns))
+	}
 
 	// 清空路由表
-	cmd := fmt.Sprintf("ip route flush table %d", tableID)
+	cmd := group.ipCmd("ip route flush table %d", tableID)
 	execIPCommand(cmd)
 
-	// 获取接口信息以决定路由命令
-	info, err := network.GetInterfaceInfo(group.Exit)
-	if err != nil {
-		return fmt.Errorf("无法获取接口信息: %w", err)
+	// 获取接口信息以决定路由命令（网络命名空间内的出口直接通过设备路由）
+	info := &network.InterfaceInfo{Name: group.Exit, Type: network.InterfaceTypeTunnel}
+	if group.Netns == "" {
+		var err error
+		info, err = network.GetInterfaceInfo(group.Exit)
+		if err != nil {
+			return fmt.Errorf("无法获取接口信息: %w", err)
+		}
 	}
 
-	// 添加路由到表
+	// 添加路由到表（被排除项完全覆盖的CIDR不添加）
+	cidrs, throws := group.RouteSets()
+	for _, cidr := range group.coveredCIDRs() {
+		fmt.Printf("  - IP: %s 被排除项完全覆盖，不添加路由\n", cidr)
+	}
 	successCount := 0
-	for _, cidr := range group.CIDRs {
+	for _, cidr := range cidrs {
 		var cmd string
 
 		// 根据接口类型决定路由命令
 		if info.Type == network.InterfaceTypePhysical && info.Gateway != "" {
 			// 物理接口有网关：通过网关路由
-			cmd = fmt.Sprintf("ip route add %s via %s dev %s table %d", cidr, info.Gateway, group.Exit, tableID)
+			cmd = group.ipCmd("ip route add %s via %s dev %s table %d", cidr, info.Gateway, group.Exit, tableID)
 		} else if info.Type == network.InterfaceTypeThirdParty {
 			// 第三方接口：尝试获取网关
 			gateway := network.GetGatewayFromRoutes(group.Exit)
 			if gateway != "" {
-				cmd = fmt.Sprintf("ip route add %s via %s dev %s table %d", cidr, gateway, group.Exit, tableID)
+				cmd = group.ipCmd("ip route add %s via %s dev %s table %d", cidr, gateway, group.Exit, tableID)
 			} else {
 				// 无网关，直接通过设备
-				cmd = fmt.Sprintf("ip route add %s dev %s table %d", cidr, group.Exit, tableID)
+				cmd = group.ipCmd("ip route add %s dev %s table %d", cidr, group.Exit, tableID)
 			}
 		} else {
 			// 隧道或无网关的P2P连接：直接通过设备
-			cmd = fmt.Sprintf("ip route add %s dev %s table %d", cidr, group.Exit, tableID)
+			cmd = group.ipCmd("ip route add %s dev %s table %d", cidr, group.Exit, tableID)
 		}
 
 		if err := execIPRouteAddWithFallback(cmd); err != nil {
@@ -376,14 +541,21 @@ func (pm *PolicyManager) ApplyGroup(group *PolicyGroup) error {
 		}
 	}
 
-	// 策略规则管理：先添加新规则，再清理重复规则（避免中断）
-	var ruleCmd string
-	if group.From == "" || group.From == "all" {
-		ruleCmd = fmt.Sprintf("ip rule add from all lookup %d pref %d", tableID, group.Priority)
-	} else {
-		ruleCmd = fmt.Sprintf("ip rule add from %s lookup %d pref %d", group.From, tableID, group.Priority)
+	// 排除的CIDR：添加 throw 路由（只需要落在组内CIDR内的排除项）
+	// 命中 throw 路由时内核跳出本表，继续匹配后续优先级的规则（其他策略组/主路由表）
+	for _, cidr := range throws {
+		cmd := group.ipCmd("ip route add throw %s table %d", cidr, tableID)
+		if err := execIPCommand(cmd); err != nil {
+			fmt.Printf("  ✗ 排除: %s - 失败\n", cidr)
+			fmt.Printf("     错误: %v\n", err)
+		} else {
+			fmt.Printf("  ✓ 排除: %s (跳出本组)\n", cidr)
+		}
 	}
 
+	// 策略规则管理：先添加新规则，再清理重复规则（避免中断）
+	ruleCmd := group.ipCmd("ip rule add %s lookup %d pref %d", group.ruleSelector(), tableID, group.Priority)
+
 	// 添加新规则
 	if err := execIPCommand(ruleCmd); err != nil {
 		// 添加失败，可能是规则已存在，这是正常的，不报错
@@ -392,10 +564,10 @@ func (pm *PolicyManager) ApplyGroup(group *PolicyGroup) error {
 
 	// 清理重复规则：删除除了最后一个之外的所有相同优先级规则
 	// 使用循环删除，直到只剩一个
-	delCmd := fmt.Sprintf("ip rule del pref %d", group.Priority)
+	delCmd := group.ipCmd("ip rule del pref %d", group.Priority)
 	for i := 0; i < 10; i++ { // 最多尝试10次，避免无限循环
 		// 检查是否有多个相同优先级的规则
-		checkCmd := fmt.Sprintf("ip rule show pref %d | wc -l", group.Priority)
+		checkCmd := group.ipCmd("ip rule show pref %d", group.Priority) + " | wc -l"
 		output, err := exec.Command("sh", "-c", checkCmd).Output()
 		if err != nil {
 			break
@@ -412,7 +584,7 @@ func (pm *PolicyManager) ApplyGroup(group *PolicyGroup) error {
 	}
 
 	// 最后验证规则是否存在
-	checkCmd := fmt.Sprintf("ip rule show pref %d", group.Priority)
+	checkCmd := group.ipCmd("ip rule show pref %d", group.Priority)
 	output, err := exec.Command("sh", "-c", checkCmd).Output()
 	if err != nil || len(output) == 0 {
 		// 规则不存在，重新添加
@@ -424,8 +596,9 @@ func (pm *PolicyManager) ApplyGroup(group *PolicyGroup) error {
 		}
 	}
 
-	fmt.Printf("  ✓ 策略组应用完成: 成功 %d/%d 个CIDR\n", successCount, len(group.CIDRs))
+	fmt.Printf("  ✓ 策略组应用完成: 成功 %d/%d 个CIDR\n", successCount, len(cidrs))
 
+	markGroupApplied(group.Name, true)
 	return nil
 }
 
@@ -437,6 +610,13 @@ func (pm *PolicyManager) ApplyDefaultRouteOnly() error {
 
 	fmt.Println("应用默认路由...")
 
+	// 出口不可用时按 on_exit_down 处理
+	if reason := exitUnavailableReason(pm.defaultExit, ""); reason != "" {
+		if handled, err := pm.applyDefaultExitDown(reason); handled || err != nil {
+			return err
+		}
+	}
+
 	// 验证接口
 	if !network.IsInterfaceUp(pm.defaultExit) {
 		return fmt.Errorf("接口 %s 不存在或未启动", pm.defaultExit)
@@ -460,12 +640,14 @@ func (pm *PolicyManager) ApplyDefaultRouteOnly() error {
 func (pm *PolicyManager) RevokeDefaultRouteOnly() error {
 	fmt.Println("撤销默认路由...")
 
+	ns := config.RoutingNamespace()
+
 	// 删除规则 - 使用 pref 精确删除
-	cmd := fmt.Sprintf("ip rule del pref %d", PrioDefault)
+	cmd := fmt.Sprintf("ip rule del pref %d", ns.DefaultPref)
 	execIPCommandNoError(cmd)
 
 	// 清空路由表
-	cmd = fmt.Sprintf("ip route flush table %d", PrioDefault)
+	cmd = fmt.Sprintf("ip route flush table %d", ns.DefaultTable())
 	execIPCommandNoError(cmd)
 
 	// 刷新缓存
@@ -477,12 +659,13 @@ func (pm *PolicyManager) RevokeDefaultRouteOnly() error {
 
 // 应用默认路由(0.0.0.0/0)
 func (pm *PolicyManager) applyDefaultRoute() error {
-	tableID := PrioDefault
+	ns := config.RoutingNamespace()
+	tableID := ns.DefaultTable()
 
 	fmt.Printf("\n应用默认路由\n")
 	fmt.Printf("  IP: 0.0.0.0/0\n")
 	fmt.Printf("  出口接口: %s\n", pm.defaultExit)
-	fmt.Printf("  优先级: %d\n", PrioDefault)
+	fmt.Printf("  优先级: %d\n", ns.DefaultPref)
 
 	// 清空路由表
 	cmd := fmt.Sprintf("ip route flush table %d", tableID)
@@ -541,7 +724,7 @@ func (pm *PolicyManager) applyDefaultRoute() error {
 	}
 
 	// 策略规则管理：先添加新规则，再清理重复规则（避免中断）
-	cmd = fmt.Sprintf("ip rule add from all lookup %d pref %d", tableID, PrioDefault)
+	cmd = fmt.Sprintf("ip rule add from all lookup %d pref %d", tableID, ns.DefaultPref)
 
 	// 添加新规则
 	if err := execIPCommand(cmd); err != nil {
@@ -549,9 +732,9 @@ func (pm *PolicyManager) applyDefaultRoute() error {
 	}
 
 	// 清理重复规则：删除除了最后一个之外的所有相同优先级规则
-	delCmd := fmt.Sprintf("ip rule del pref %d", PrioDefault)
+	delCmd := fmt.Sprintf("ip rule del pref %d", ns.DefaultPref)
 	for i := 0; i < 10; i++ {
-		checkCmd := fmt.Sprintf("ip rule show pref %d | wc -l", PrioDefault)
+		checkCmd := fmt.Sprintf("ip rule show pref %d | wc -l", ns.DefaultPref)
 		output, err := exec.Command("sh", "-c", checkCmd).Output()
 		if err != nil {
 			break
@@ -566,7 +749,7 @@ func (pm *PolicyManager) applyDefaultRoute() error {
 	}
 
 	// 最后验证规则是否存在
-	checkCmd := fmt.Sprintf("ip rule show pref %d", PrioDefault)
+	checkCmd := fmt.Sprintf("ip rule show pref %d", ns.DefaultPref)
 	output, err := exec.Command("sh", "-c", checkCmd).Output()
 	if err != nil || len(output) == 0 {
 		// 规则不存在，重新添加
@@ -591,39 +774,43 @@ func (pm *PolicyManager) Revoke() error {
 	for _, tunnel := range tunnels {
 		remoteIP, _ := getTunnelRemoteIP(tunnel)
 		if remoteIP != "" {
-			cmd := fmt.Sprintf("ip rule del to %s lookup main pref %d", remoteIP, PrioSystem)
+			cmd := fmt.Sprintf("ip rule del to %s pref %d", remoteIP, config.RoutingNamespace().ProtectPref)
 			execIPCommandNoError(cmd)
 		}
 	}
 
 	// 2. 删除策略组
 	for _, group := range pm.groups {
-		tableID := group.Priority
+		tableID := config.RoutingNamespace().GroupTable(group.Priority)
 
 		// 删除规则 - 使用 pref 精确删除
-		cmd := fmt.Sprintf("ip rule del pref %d", group.Priority)
+		cmd := group.ipCmd("ip rule del pref %d", group.Priority)
 		execIPCommandNoError(cmd)
 
 		// 清空路由表
-		cmd = fmt.Sprintf("ip route flush table %d", tableID)
+		cmd = group.ipCmd("ip route flush table %d", tableID)
 		execIPCommandNoError(cmd)
 
+		markGroupApplied(group.Name, false)
 		fmt.Printf("  ✓ 已撤销策略组: %s\n", group.Name)
 	}
 
 	// 3. 删除默认路由
 	if pm.defaultExit != "" {
 		// 删除规则 - 使用 pref 精确删除
-		cmd := fmt.Sprintf("ip rule del pref %d", PrioDefault)
+		cmd := fmt.Sprintf("ip rule del pref %d", config.RoutingNamespace().DefaultPref)
 		execIPCommandNoError(cmd)
 
-		cmd = fmt.Sprintf("ip route flush table %d", PrioDefault)
+		cmd = fmt.Sprintf("ip route flush table %d", config.RoutingNamespace().DefaultTable())
 		execIPCommandNoError(cmd)
 
 		fmt.Printf("  ✓ 已撤销默认路由\n")
 	}
 
-	// 4. 刷新缓存
+	// 4. 删除多出口源地址路由
+	RevokeSourceRouting()
+
+	// 5. 刷新缓存
 	exec.Command("ip", "route", "flush", "cache").Run()
 
 	pm.appliedGroups = make([]string, 0)
@@ -640,19 +827,20 @@ func (pm *PolicyManager) RevokeGroup(groupName string) error {
 
 	fmt.Printf("撤销策略组: %s\n", groupName)
 
-	tableID := group.Priority
+	tableID := config.RoutingNamespace().GroupTable(group.Priority)
 
 	// 删除规则 - 使用 pref 精确删除
-	cmd := fmt.Sprintf("ip rule del pref %d", group.Priority)
+	cmd := group.ipCmd("ip rule del pref %d", group.Priority)
 	execIPCommandNoError(cmd)
 
 	// 清空路由表
-	cmd = fmt.Sprintf("ip route flush table %d", tableID)
+	cmd = group.ipCmd("ip route flush table %d", tableID)
 	execIPCommandNoError(cmd)
 
 	// 刷新缓存
 	exec.Command("ip", "route", "flush", "cache").Run()
 
+	markGroupApplied(groupName, false)
 	fmt.Printf("  ✓ 策略组 %s 已撤销\n", groupName)
 	return nil
 }
@@ -674,6 +862,22 @@ func (pm *PolicyManager) Save() error {
 			content += fmt.Sprintf("# From: %s\n", group.From)
 		}
 
+		if group.OnExitDown != "" {
+			content += fmt.Sprintf("# OnExitDown: %s\n", group.OnExitDown)
+		}
+
+		if group.VRF != "" {
+			content += fmt.Sprintf("# VRF: %s\n", group.VRF)
+		}
+		if group.Netns != "" {
+			content += fmt.Sprintf("# Netns: %s\n", group.Netns)
+		}
+
+		// 排除CIDR（每行一条）
+		for _, cidr := range group.Excludes {
+			content += fmt.Sprintf("# Exclude: %s\n", cidr)
+		}
+
 		content += "\n"
 		content += strings.Join(group.CIDRs, "\n")
 
@@ -698,7 +902,10 @@ func (pm *PolicyManager) LoadGroup(name string) error {
 	var exit string
 	var priority int
 	var from string
+	var onExitDown string
+	var vrf, netns string
 	cidrs := make([]string, 0)
+	excludes := make([]string, 0)
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -710,6 +917,14 @@ func (pm *PolicyManager) LoadGroup(name string) error {
 			fmt.Sscanf(line, "# Priority: %d", &priority)
 		} else if strings.HasPrefix(line, "# From:") {
 			from = strings.TrimSpace(strings.TrimPrefix(line, "# From:"))
+		} else if strings.HasPrefix(line, "# OnExitDown:") {
+			onExitDown = strings.TrimSpace(strings.TrimPrefix(line, "# OnExitDown:"))
+		} else if strings.HasPrefix(line, "# VRF:") {
+			vrf = strings.TrimSpace(strings.TrimPrefix(line, "# VRF:"))
+		} else if strings.HasPrefix(line, "# Netns:") {
+			netns = strings.TrimSpace(strings.TrimPrefix(line, "# Netns:"))
+		} else if strings.HasPrefix(line, "# Exclude:") {
+			excludes = append(excludes, strings.TrimSpace(strings.TrimPrefix(line, "# Exclude:")))
 		} else if line != "" && !strings.HasPrefix(line, "#") {
 			cidrs = append(cidrs, line)
 		}
@@ -729,13 +944,37 @@ func (pm *PolicyManager) LoadGroup(name string) error {
 		Exit:     exit,
 		Priority: priority,
 		CIDRs:    cidrs,
+		Excludes: excludes,
 		From:     from,
+		VRF:      vrf,
+		Netns:    netns,
+
+		OnExitDown: onExitDown,
 	}
 
 	pm.groups[name] = group
 	return nil
 }
 
+// LoadAllGroups 加载策略目录下的所有策略组
+func (pm *PolicyManager) LoadAllGroups() error {
+	entries, err := os.ReadDir(PolicyDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".policy") {
+			groupName := strings.TrimSuffix(entry.Name(), ".policy")
+			if err := pm.LoadGroup(groupName); err != nil {
+				return fmt.Errorf("加载策略组 %s 失败: %w", groupName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // 获取策略组
 func (pm *PolicyManager) GetGroup(name string) *PolicyGroup {
 	return pm.groups[name]
@@ -754,7 +993,10 @@ func (pm *PolicyManager) ListGroups() {
 		priority int
 		exit     string
 		cidrNum  int
+		exclNum  int
 		from     string
+		onDown   string
+		context  string
 	}
 
 	groupList := make([]groupInfo, 0, len(pm.groups))
@@ -768,7 +1010,10 @@ func (pm *PolicyManager) ListGroups() {
 			priority: group.Priority,
 			exit:     group.Exit,
 			cidrNum:  len(group.CIDRs),
+			exclNum:  len(group.Excludes),
 			from:     fromStr,
+			onDown:   OnExitDownDisplay(group.OnExitDown),
+			context:  network.IsolationLabel(group.VRF, group.Netns),
 		})
 	}
 
@@ -787,7 +1032,7 @@ func (pm *PolicyManager) ListGroups() {
 	fmt.Println()
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.Header("组名", "优先级", "出口", "CIDR数量", "源限制")
+	table.Header("组名", "优先级", "出口", "CIDR数量", "排除数量", "源限制", "出口故障", "隔离")
 
 	for _, g := range groupList {
 		table.Append(
@@ -795,7 +1040,10 @@ func (pm *PolicyManager) ListGroups() {
 			strconv.Itoa(g.priority),
 			g.exit,
 			strconv.Itoa(g.cidrNum),
+			strconv.Itoa(g.exclNum),
 			g.from,
+			g.onDown,
+			g.context,
 		)
 	}
 
@@ -984,75 +1232,22 @@ func ParseFromInput(input string) (string, error) {
 
 // ExitScore 出口评分结果
 type ExitScore struct {
-	Name        string
-	Latency     float64
-	PacketLoss  float64
-	BaseScore   float64 // 基础评分 (丢包+延迟)
-	Cost        int     // 成本 (0-100)
-	CostPenalty float64 // 成本惩罚
-	Score       float64 // 最终评分 = 基础评分 - 成本惩罚
-	Available   bool
-	Reason      string
+	Name           string
+	Latency        float64
+	PacketLoss     float64
+	Jitter         float64
+	BaseScore      float64 // 基础评分 (丢包+延迟+抖动)
+	Cost           int     // 成本 (0-100，含成本覆盖和流量配额上调)
+	CostPenalty    float64 // 成本惩罚
+	Score          float64 // 最终评分 = 基础评分 - 成本惩罚 - 失败惩罚
+	Breakdown      scoring.Breakdown
+	Available      bool
+	Reason         string
+	QuotaExhausted bool // 流量配额已耗尽（仅在没有其他可用出口时选择）
 }
 
-// calculateBaseScore 计算基础评分（不含成本）
-func calculateBaseScore(latency, packetLoss float64) float64 {
-	// 特殊情况：完全失败（100% 丢包）
-	// 此时接口完全不可达，评分应该为 0
-	if packetLoss >= 100.0 {
-		return 0
-	}
-
-	var score float64
-
-	// 丢包率评分（0-60分）
-	if packetLoss == 0 {
-		score += 60
-	} else if packetLoss <= 5 {
-		score += 45
-	} else if packetLoss <= 10 {
-		score += 30
-	} else if packetLoss <= 20 {
-		score += 15
-	} else {
-		score += 0
-	}
-
-	// 延迟评分（0-40分）
-	// 注意：延迟为 0 说明没有成功的 ping，但已经被上面的丢包率检查过滤了
-	if latency < 50 {
-		score += 40
-	} else if latency < 100 {
-		score += 35
-	} else if latency < 150 {
-		score += 30
-	} else if latency < 200 {
-		score += 25
-	} else if latency < 300 {
-		score += 15
-	} else {
-		score += 5
-	}
-
-	return score
-}
-
-// calculateFinalScore 计算最终评分（基础评分 - 成本惩罚）
-func calculateFinalScore(baseScore float64, cost int) (float64, float64) {
-	// 成本惩罚 = cost * 0.5
-	costPenalty := float64(cost) * 0.5
-	finalScore := baseScore - costPenalty
-
-	// 确保最终评分不为负数
-	if finalScore < 0 {
-		finalScore = 0
-	}
-
-	return finalScore, costPenalty
-}
-
-// SelectBestExit 从候选出口中选择最佳出口
-func SelectBestExit(candidates []string, checkResults *network.AllCheckResults) (*ExitScore, []*ExitScore, error) {
+// SelectBestExit 从候选出口中选择最佳出口（按评分参数 cfg 计算评分）
+func SelectBestExit(candidates []string, checkResults *network.AllCheckResults, cfg scoring.Config) (*ExitScore, []*ExitScore, error) {
 	if len(candidates) == 0 {
 		return nil, nil, fmt.Errorf("候选出口列表为空")
 	}
@@ -1081,22 +1276,36 @@ func SelectBestExit(candidates []string, checkResults *network.AllCheckResults)
 			continue
 		}
 
-		// 获取出口成本
-		cost := getExitCost(candidate)
+		// 获取出口成本（含流量配额上调）
+		cost, exhausted := network.EffectiveExitCost(candidate)
 
 		// 计算评分
 		exitScore.Latency = result.Latency
 		exitScore.PacketLoss = result.PacketLoss
+		exitScore.Jitter = result.Jitter
 		exitScore.Cost = cost
-		exitScore.BaseScore = calculateBaseScore(result.Latency, result.PacketLoss)
-		exitScore.Score, exitScore.CostPenalty = calculateFinalScore(exitScore.BaseScore, cost)
+		exitScore.Breakdown = cfg.Score(scoring.Sample{
+			Latency:    result.Latency,
+			PacketLoss: result.PacketLoss,
+			Jitter:     result.Jitter,
+			Cost:       cost,
+			Failures:   result.Failures,
+		})
+		exitScore.BaseScore = exitScore.Breakdown.Base
+		exitScore.CostPenalty = exitScore.Breakdown.CostPenalty
+		exitScore.Score = exitScore.Breakdown.Final
 		exitScore.Available = true
+		exitScore.QuotaExhausted = exhausted
 
 		scores = append(scores, exitScore)
 
-		// 选择最佳
+		// 选择最佳（配额未耗尽的出口优先）
 		if bestExit == nil {
 			bestExit = exitScore
+		} else if exitScore.QuotaExhausted != bestExit.QuotaExhausted {
+			if bestExit.QuotaExhausted {
+				bestExit = exitScore
+			}
 		} else if exitScore.Score > bestExit.Score {
 			bestExit = exitScore
 		} else if exitScore.Score == bestExit.Score && exitScore.Latency < bestExit.Latency {
@@ -1112,27 +1321,6 @@ func SelectBestExit(candidates []string, checkResults *network.AllCheckResults)
 	return bestExit, scores, nil
 }
 
-// getExitCost 获取出口的成本值
-func getExitCost(exitName string) int {
-	// 先尝试作为隧道加载
-	tunnelConfig, err := network.LoadTunnelConfig(exitName)
-	if err == nil && tunnelConfig != nil {
-		return tunnelConfig.Cost
-	}
-
-	// 再尝试作为物理接口加载
-	ifaceConfig, err := network.LoadInterfaceConfig()
-	if err == nil {
-		iface := ifaceConfig.GetInterfaceByName(exitName)
-		if iface != nil {
-			return iface.Cost
-		}
-	}
-
-	// 默认成本为0
-	return 0
-}
-
 // checkCandidates 检查候选出口连通性并保存结果
 func checkCandidates(candidates []string, checkIP string) error {
 	fmt.Println("检查出口连通性...")
@@ -1184,6 +1372,9 @@ func printScores(scores []*ExitScore, bestExit *ExitScore) {
 			if bestExit != nil && score.Name == bestExit.Name {
 				best = " \033[93m★ 最佳\033[0m"
 			}
+			if score.QuotaExhausted {
+				best = " \033[91m[配额耗尽]\033[0m" + best
+			}
 
 			// 显示详细评分信息：基础评分、成本、成本惩罚、最终评分
 			if score.Cost > 0 {
@@ -1202,8 +1393,8 @@ func printScores(scores []*ExitScore, bestExit *ExitScore) {
 	fmt.Println()
 }
 
-// FailoverGroup 对策略组执行 failover
-func (pm *PolicyManager) FailoverGroup(groupName string, candidates []string, checkIP string) error {
+// FailoverGroup 对策略组执行 failover（按评分参数 cfg 选择最佳出口）
+func (pm *PolicyManager) FailoverGroup(groupName string, candidates []string, checkIP string, cfg scoring.Config) error {
 	fmt.Printf("准备 failover: %s -> 候选出口 [%s]\n\n", groupName, strings.Join(candidates, ", "))
 
 	// 只加载指定的策略组
@@ -1245,7 +1436,7 @@ func (pm *PolicyManager) FailoverGroup(groupName string, candidates []string, ch
 	}
 
 	// 选择最佳出口
-	bestExit, scores, err := SelectBestExit(candidates, checkResults)
+	bestExit, scores, err := SelectBestExit(candidates, checkResults, cfg)
 	if err != nil {
 		return err
 	}
@@ -1287,8 +1478,8 @@ func (pm *PolicyManager) FailoverGroup(groupName string, candidates []string, ch
 	return nil
 }
 
-// FailoverDefault 对默认路由执行 failover
-func (pm *PolicyManager) FailoverDefault(candidates []string, checkIP string) error {
+// FailoverDefault 对默认路由执行 failover（按评分参数 cfg 选择最佳出口）
+func (pm *PolicyManager) FailoverDefault(candidates []string, checkIP string, cfg scoring.Config) error {
 	fmt.Printf("准备 failover: 默认路由 -> 候选出口 [%s]\n\n", strings.Join(candidates, ", "))
 
 	// 验证候选出口是否存在
@@ -1320,7 +1511,7 @@ func (pm *PolicyManager) FailoverDefault(candidates []string, checkIP string) er
 	}
 
 	// 选择最佳出口
-	bestExit, scores, err := SelectBestExit(candidates, checkResults)
+	bestExit, scores, err := SelectBestExit(candidates, checkResults, cfg)
 	if err != nil {
 		return err
 	}
@@ -1416,6 +1607,7 @@ func getTunnelTypeDisplay(tunnelType string) string {
 func SyncProtection() error {
 	fmt.Println("同步保护路由...")
 
+	protectPref := config.RoutingNamespace().ProtectPref
 	protectedCount := 0
 	updatedCount := 0
 
@@ -1434,7 +1626,7 @@ func SyncProtection() error {
 
 		// 如果是 WireGuard 且 RemoteIP 是 0.0.0.0，尝试从运行状态获取
 		if config.TunnelType == "wireguard" && (remoteIP == "" || remoteIP == "0.0.0.0") {
-			if network.IsInterfaceUp(config.Name) {
+			if network.LinkUpIn(config.Name, config.Netns) {
 				endpoint := getWireGuardPeerEndpoint(config.Name)
 				if endpoint != "" {
 					remoteIP = endpoint
@@ -1447,7 +1639,7 @@ func SyncProtection() error {
 		if remoteIP == "" || remoteIP == "0.0.0.0" {
 			// 如果之前有保护IP，清理旧的保护路由
 			if config.ProtectedIP != "" {
-				delCmd := fmt.Sprintf("ip rule del to %s lookup main pref %d", config.ProtectedIP, PrioSystem)
+				delCmd := fmt.Sprintf("ip rule del to %s pref %d", config.ProtectedIP, protectPref)
 				execIPCommandNoError(delCmd)
 				config.ProtectedIP = ""
 				network.SaveTunnelConfig(config)
@@ -1462,7 +1654,7 @@ func SyncProtection() error {
 		ipChanged := false
 		if config.ProtectedIP != "" && config.ProtectedIP != remoteIP {
 			// IP已变化，先删除旧的保护路由
-			delCmd := fmt.Sprintf("ip rule del to %s lookup main pref %d", config.ProtectedIP, PrioSystem)
+			delCmd := fmt.Sprintf("ip rule del to %s pref %d", config.ProtectedIP, protectPref)
 			execIPCommandNoError(delCmd)
 			fmt.Printf("  ⚠ %s 隧道 %s 对端IP已变化: %s → %s\n",
 				getTunnelTypeDisplay(config.TunnelType), config.Name, config.ProtectedIP, remoteIP)
@@ -1471,11 +1663,12 @@ func SyncProtection() error {
 		}
 
 		// 删除当前remoteIP的旧规则（防止重复）
-		delCmd := fmt.Sprintf("ip rule del to %s lookup main pref %d", remoteIP, PrioSystem)
+		delCmd := fmt.Sprintf("ip rule del to %s pref %d", remoteIP, protectPref)
 		execIPCommandNoError(delCmd)
 
 		// 添加规则：到远程IP的流量不走策略路由
-		cmd := fmt.Sprintf("ip rule add to %s lookup main pref %d", remoteIP, PrioSystem)
+		// 父接口属于VRF时，underlay 查询该VRF的路由表（每个VRF独立保护）
+		cmd := fmt.Sprintf("ip rule add to %s lookup %s pref %d", remoteIP, protectTable(config.ParentInterface), protectPref)
 		if err := execIPCommand(cmd); err != nil {
 			fmt.Printf("  ⚠ 警告: 添加保护路由失败: %s\n", err)
 		} else {
@@ -1494,7 +1687,7 @@ func SyncProtection() error {
 	}
 
 	// 3. 清理僵尸规则（无对应隧道的保护路由）
-	cmd := exec.Command("sh", "-c", fmt.Sprintf("ip rule show pref %d", PrioSystem))
+	cmd := exec.Command("sh", "-c", fmt.Sprintf("ip rule show pref %d", protectPref))
 	output, err := cmd.Output()
 	if err == nil && len(output) > 0 {
 		// 解析规则，提取保护的IP
@@ -1529,7 +1722,7 @@ func SyncProtection() error {
 		if len(orphanedIPs) > 0 {
 			fmt.Printf("  清理 %d 个僵尸规则...\n", len(orphanedIPs))
 			for _, ip := range orphanedIPs {
-				delCmd := fmt.Sprintf("ip rule del to %s lookup main pref %d", ip, PrioSystem)
+				delCmd := fmt.Sprintf("ip rule del to %s pref %d", ip, protectPref)
 				if err := execIPCommand(delCmd); err == nil {
 					fmt.Printf("  ✓ 已清理僵尸规则: %s\n", ip)
 				}
@@ -1537,6 +1730,16 @@ func SyncProtection() error {
 		}
 	}
 
+	// 4. 同步VRF隔离规则（VRF内的流量不进入默认上下文的默认路由）
+	if err := SyncVRFIsolation(); err != nil {
+		fmt.Printf("  ⚠ 警告: 同步VRF隔离规则失败: %v\n", err)
+	}
+
+	// 5. 同步多出口源地址路由（物理接口IP可能已变化）
+	if err := SyncSourceRouting(); err != nil {
+		fmt.Printf("  ⚠ 警告: 同步源地址路由失败: %v\n", err)
+	}
+
 	// 刷新路由缓存
 	exec.Command("ip", "route", "flush", "cache").Run()
 
@@ -1554,16 +1757,16 @@ func SyncProtection() error {
 
 // Failover 对策略组执行 failover（守护进程使用）
 // 静默模式，不打印详细输出，只返回错误
-func Failover(groupName string, candidates []string, checkIP string, force bool) error {
+func Failover(groupName string, candidates []string, checkIP string, force bool, cfg scoring.Config) error {
 	pm := NewPolicyManager()
-	return pm.FailoverGroup(groupName, candidates, checkIP)
+	return pm.FailoverGroup(groupName, candidates, checkIP, cfg)
 }
 
 // FailoverDefaultRoute 对默认路由执行 failover（守护进程使用）
 // 静默模式，不打印详细输出，只返回错误
-func FailoverDefaultRoute(candidates []string, checkIP string, force bool) error {
+func FailoverDefaultRoute(candidates []string, checkIP string, force bool, cfg scoring.Config) error {
 	pm := NewPolicyManager()
-	return pm.FailoverDefault(candidates, checkIP)
+	return pm.FailoverDefault(candidates, checkIP, cfg)
 }
 
 // PolicyGroupExists 检查策略组是否存在
@@ -1574,3 +1777,41 @@ func PolicyGroupExists(groupName string) bool {
 	}
 	return pm.groups[groupName] != nil
 }
+
+// LoadAppliedGroups 读取已应用的策略组记录
+// 返回: 组名列表, 记录文件是否存在（旧版本升级后可能不存在）
+func LoadAppliedGroups() ([]string, bool) {
+	data, err := os.ReadFile(AppliedGroupsFile)
+	if err != nil {
+		return nil, false
+	}
+
+	groups := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			groups = append(groups, line)
+		}
+	}
+	return groups, true
+}
+
+// markGroupApplied 更新已应用的策略组记录
+func markGroupApplied(groupName string, applied bool) {
+	groups, _ := LoadAppliedGroups()
+
+	result := make([]string, 0, len(groups)+1)
+	for _, name := range groups {
+		if name != groupName {
+			result = append(result, name)
+		}
+	}
+	if applied {
+		result = append(result, groupName)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(AppliedGroupsFile), 0755); err != nil {
+		return
+	}
+	os.WriteFile(AppliedGroupsFile, []byte(strings.Join(result, "\n")+"\n"), 0644)
+}
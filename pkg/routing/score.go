@@ -0,0 +1,65 @@
+package routing
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/scoring"
+)
+
+// ScoreExits 计算候选出口的评分（不切换任何路由）
+// checkIP 非空时先重新检查候选出口，否则使用上次检查结果；explain 时打印各项得分明细
+func ScoreExits(candidates []string, checkIP string, cfg scoring.Config, explain bool) error {
+	if checkIP != "" {
+		if err := checkCandidates(candidates, checkIP); err != nil {
+			return err
+		}
+	}
+
+	checkResults, err := network.LoadCheckResults()
+	if err != nil {
+		return fmt.Errorf("加载检查结果失败: %w (请先运行 'twnode line check' 或指定检查IP)", err)
+	}
+
+	bestExit, scores, err := SelectBestExit(candidates, checkResults, cfg)
+	printScores(scores, bestExit)
+	if explain {
+		printScoreBreakdown(scores, cfg)
+	}
+	return err
+}
+
+// printScoreBreakdown 打印各出口的评分明细
+func printScoreBreakdown(scores []*ExitScore, cfg scoring.Config) {
+	fmt.Println("【评分明细】")
+	fmt.Printf("评分参数: %s\n", cfg.Summary())
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("出口", "延迟", "丢包", "抖动", "丢包得分", "延迟得分", "抖动得分", "基础", "成本惩罚", "失败惩罚", "最终")
+	for _, score := range scores {
+		if !score.Available {
+			table.Append(score.Name, "-", "-", "-", "-", "-", "-", "-", "-", "-", score.Reason)
+			continue
+		}
+		b := score.Breakdown
+		cost := fmt.Sprintf("-%.1f (Cost %d)", b.CostPenalty, score.Cost)
+		if score.QuotaExhausted {
+			cost += " 配额耗尽"
+		}
+		table.Append(score.Name,
+			fmt.Sprintf("%.1fms", score.Latency),
+			fmt.Sprintf("%.0f%%", score.PacketLoss),
+			fmt.Sprintf("%.1fms", score.Jitter),
+			fmt.Sprintf("%.1f/%.0f", b.Loss, cfg.LossWeight),
+			fmt.Sprintf("%.1f/%.0f", b.Latency, cfg.LatencyWeight),
+			fmt.Sprintf("%.1f/%.0f", b.Jitter, cfg.JitterWeight),
+			fmt.Sprintf("%.1f", b.Base),
+			cost,
+			fmt.Sprintf("-%.1f", b.FailurePenalty),
+			fmt.Sprintf("%.1f", b.Final))
+	}
+	table.Render()
+	fmt.Println()
+}
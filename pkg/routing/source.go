@@ -0,0 +1,240 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/network"
+)
+
+// 多出口源地址路由（回程路由）
+// 优先级（source_pref，默认8）必须在保护规则（protect_pref，默认10）之前：以某上行接口IP为源的流量
+// （回包、隧道封装包）必须从该接口发出，否则会被保护规则/主路由表送往主上行
+// 每个物理接口一张路由表（默认1000-1099），按接口名排序依次分配
+// 源地址路由表只有接口网段和默认路由，因此在 source_pref-1 先查主路由表和VIP路由表（suppress_prefixlength 0 忽略默认路由）:
+// 以上行IP为源、目标为直连网段（LAN）、隧道对端或VIP的流量按原有路由发出，只有非本地目标才使用源地址路由表的默认路由
+
+// SourceRoute 单个物理接口的源地址路由
+type SourceRoute struct {
+	Interface string
+	IP        string
+	Subnet    string // 接口所在网段（如 192.168.1.0/24）
+	Gateway   string
+	Table     int
+}
+
+// PlanSourceRoutes 根据物理接口配置计算需要的源地址路由
+// 只有 2 个及以上启用、已启动且有网关的物理接口（多出口）时才需要
+func PlanSourceRoutes() ([]*SourceRoute, error) {
+	ifaceConfig, err := network.LoadInterfaceConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := make([]network.PhysicalInterface, 0)
+	for _, iface := range ifaceConfig.Interfaces {
+		if !iface.Enabled || iface.IP == "" || iface.Gateway == "" {
+			continue
+		}
+		if !network.IsInterfaceUp(iface.Name) {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+
+	if len(ifaces) < 2 {
+		return []*SourceRoute{}, nil
+	}
+
+	sort.Slice(ifaces, func(i, j int) bool {
+		return ifaces[i].Name < ifaces[j].Name
+	})
+
+	tableBase, tableMax := config.RoutingNamespace().SourceTables()
+	routes := make([]*SourceRoute, 0, len(ifaces))
+	for i, iface := range ifaces {
+		tableID := tableBase + i
+		if tableID > tableMax {
+			break
+		}
+		routes = append(routes, &SourceRoute{
+			Interface: iface.Name,
+			IP:        iface.IP,
+			Subnet:    interfaceSubnet(iface.Name, iface.IP),
+			Gateway:   iface.Gateway,
+			Table:     tableID,
+		})
+	}
+
+	return routes, nil
+}
+
+// interfaceSubnet 获取接口上指定IP所在的网段
+func interfaceSubnet(ifaceName, ip string) string {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return ""
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ""
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.Equal(net.ParseIP(ip)) {
+			continue
+		}
+		return (&net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}).String()
+	}
+
+	return ""
+}
+
+// SyncSourceRouting 同步多出口源地址路由
+// 为每个物理接口维护独立路由表和 from <接口IP> lookup <表> 规则，
+// 保证从某上行进入的连接从同一上行回包，父接口为次上行的隧道也能正常工作
+func SyncSourceRouting() error {
+	fmt.Println("同步多出口源地址路由...")
+
+	routes, err := PlanSourceRoutes()
+	if err != nil {
+		return fmt.Errorf("加载物理接口配置失败: %w", err)
+	}
+
+	ns := config.RoutingNamespace()
+	tableBase, tableMax := ns.SourceTables()
+
+	expected := make(map[string]int) // 源IP -> 路由表
+	for _, route := range routes {
+		expected[route.IP] = route.Table
+	}
+
+	// 1. 清理过期规则（接口IP变化、接口禁用或不再是多出口）
+	rules, err := ListIPRules()
+	if err != nil {
+		return err
+	}
+
+	staleTables := make(map[int]bool)
+	for _, rule := range rules {
+		if rule.Pref == ns.SourceLocalPref() && IsNamespaceRule(ns, rule) {
+			if _, ok := expected[rule.From]; !ok {
+				execIPCommandNoError(fmt.Sprintf("ip rule del from %s lookup %s pref %d", rule.From, rule.Table, rule.Pref))
+			}
+			continue
+		}
+		if rule.Pref != ns.SourcePref {
+			continue
+		}
+		tableID, _ := strconv.Atoi(rule.Table)
+		if table, ok := expected[rule.From]; ok && table == tableID {
+			continue
+		}
+
+		execIPCommandNoError(fmt.Sprintf("ip rule del from %s lookup %s pref %d", rule.From, rule.Table, ns.SourcePref))
+		fmt.Printf("  ✓ 已清理过期规则: from %s lookup %s\n", rule.From, rule.Table)
+		if tableID >= tableBase && tableID <= tableMax {
+			staleTables[tableID] = true
+		}
+	}
+
+	// 2. 写入路由表并添加规则
+	for _, route := range routes {
+		delete(staleTables, route.Table)
+		tableID := route.Table
+
+		execIPCommandNoError(fmt.Sprintf("ip route flush table %d", tableID))
+
+		if route.Subnet != "" {
+			cmd := fmt.Sprintf("ip route replace %s dev %s src %s table %d", route.Subnet, route.Interface, route.IP, tableID)
+			if err := execIPCommand(cmd); err != nil {
+				fmt.Printf("  ⚠ %s: 添加网段路由失败: %v\n", route.Interface, err)
+			}
+		}
+
+		cmd := fmt.Sprintf("ip route replace default via %s dev %s table %d", route.Gateway, route.Interface, tableID)
+		if err := execIPCommand(cmd); err != nil {
+			fmt.Printf("  ✗ %s: 添加默认路由失败: %v\n", route.Interface, err)
+			continue
+		}
+
+		// 本地目标（直连网段、隧道VIP）不进入源地址路由表
+		for _, table := range localTables() {
+			if hasSourceRule(rules, ns.SourceLocalPref(), route.IP, table) {
+				continue
+			}
+			cmd = fmt.Sprintf("ip rule add from %s lookup %s suppress_prefixlength 0 pref %d", route.IP, table, ns.SourceLocalPref())
+			if err := execIPCommand(cmd); err != nil {
+				fmt.Printf("  ⚠ %s: 添加本地路由规则失败: %v\n", route.Interface, err)
+			}
+		}
+
+		if !hasSourceRule(rules, ns.SourcePref, route.IP, strconv.Itoa(tableID)) {
+			cmd = fmt.Sprintf("ip rule add from %s lookup %d pref %d", route.IP, tableID, ns.SourcePref)
+			if err := execIPCommand(cmd); err != nil {
+				fmt.Printf("  ✗ %s: 添加源地址规则失败: %v\n", route.Interface, err)
+				continue
+			}
+		}
+
+		fmt.Printf("  ✓ %s: from %s → 表%d (via %s)\n", route.Interface, route.IP, tableID, route.Gateway)
+	}
+
+	// 3. 清空不再使用的路由表
+	for tableID := range staleTables {
+		execIPCommandNoError(fmt.Sprintf("ip route flush table %d", tableID))
+	}
+
+	exec.Command("ip", "route", "flush", "cache").Run()
+
+	if len(routes) == 0 {
+		fmt.Println("  单出口，无需源地址路由")
+	}
+	return nil
+}
+
+// RevokeSourceRouting 删除所有多出口源地址路由
+func RevokeSourceRouting() {
+	rules, err := ListIPRules()
+	if err != nil {
+		return
+	}
+
+	ns := config.RoutingNamespace()
+	tableBase, tableMax := ns.SourceTables()
+	for _, rule := range rules {
+		if rule.Pref == ns.SourceLocalPref() && IsNamespaceRule(ns, rule) {
+			execIPCommandNoError(fmt.Sprintf("ip rule del from %s lookup %s pref %d", rule.From, rule.Table, rule.Pref))
+			continue
+		}
+		if rule.Pref != ns.SourcePref {
+			continue
+		}
+		execIPCommandNoError(fmt.Sprintf("ip rule del from %s lookup %s pref %d", rule.From, rule.Table, ns.SourcePref))
+
+		if tableID, err := strconv.Atoi(rule.Table); err == nil && tableID >= tableBase && tableID <= tableMax {
+			execIPCommandNoError(fmt.Sprintf("ip route flush table %d", tableID))
+		}
+	}
+}
+
+// localTables 源地址本地路由规则查询的路由表（主路由表和隧道VIP路由表）
+func localTables() []string {
+	return []string{"main", strconv.Itoa(config.RoutingNamespace().VIPTable())}
+}
+
+// hasSourceRule 判断源地址规则是否已存在
+func hasSourceRule(rules []*IPRule, pref int, from string, table string) bool {
+	for _, rule := range rules {
+		if rule.Pref == pref && rule.From == from && rule.Table == table {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/network"
+)
+
+// VRF 隔离
+// 内核为 VRF 设备自动添加的 l3mdev 规则优先级为 1000，位于 twnode 的策略组和默认路由之后，
+// 因此在 vrf_pref（默认899）为每个被使用的 VRF 添加 iif/oif 规则，使 VRF 内的流量查询 VRF 自己的路由表，
+// 不会落入全局默认路由（default_pref）。VRF 策略组的规则带 iif <vrf>，只匹配从该 VRF 进入的流量。
+// 注意: 未限定 VRF 的全局策略组（from 匹配时）仍可能匹配 VRF 内的流量
+// 早期版本的策略组优先级上限为899，与默认 vrf_pref 重叠: 存在占用 vrf_pref 的策略组时不添加 VRF 隔离规则，
+// 提示用 policy set-priority 迁移；清理时只删除带 iif/oif 的规则，不按优先级删除（避免删除策略组的规则）
+
+// ruleSelector 策略组规则的匹配条件
+func (g *PolicyGroup) ruleSelector() string {
+	from := g.From
+	if from == "" {
+		from = "all"
+	}
+	if g.VRF != "" {
+		return fmt.Sprintf("from %s iif %s", from, g.VRF)
+	}
+	return "from " + from
+}
+
+// ipCmd 构造在策略组所在网络命名空间中执行的 ip 命令行
+func (g *PolicyGroup) ipCmd(format string, args ...interface{}) string {
+	return network.IPCommandLine(g.Netns, fmt.Sprintf(format, args...))
+}
+
+// protectTable 保护规则查询的路由表
+// 父接口属于 VRF 时为 VRF 的路由表，否则为主路由表
+func protectTable(parent string) string {
+	vrf := network.LinkVRF(parent)
+	if vrf == "" {
+		return "main"
+	}
+	table, err := network.VRFTable(vrf)
+	if err != nil {
+		return "main"
+	}
+	return strconv.Itoa(table)
+}
+
+// SetGroupIsolation 设置策略组的隔离上下文（vrf 和 netns 均为空表示默认上下文）
+// 已应用的策略组需要先撤销，在新的上下文中重新应用
+func (pm *PolicyManager) SetGroupIsolation(groupName, vrf, netns string) error {
+	group := pm.groups[groupName]
+	if group == nil {
+		return fmt.Errorf("策略组 %s 不存在", groupName)
+	}
+	if err := network.ValidateIsolation(vrf, netns); err != nil {
+		return err
+	}
+
+	group.VRF = vrf
+	group.Netns = netns
+	return nil
+}
+
+// UsedVRFs 收集隧道和策略组使用的 VRF
+func UsedVRFs() []string {
+	seen := make(map[string]bool)
+
+	if tunnels, err := getAllTunnelConfigs(); err == nil {
+		for _, tunnel := range tunnels {
+			if tunnel.VRF != "" {
+				seen[tunnel.VRF] = true
+			}
+		}
+	}
+
+	pm := NewPolicyManager()
+	if err := pm.LoadAllGroups(); err == nil {
+		for _, group := range pm.groups {
+			if group.VRF != "" {
+				seen[group.VRF] = true
+			}
+		}
+	}
+
+	vrfs := make([]string, 0, len(seen))
+	for vrf := range seen {
+		vrfs = append(vrfs, vrf)
+	}
+	sort.Strings(vrfs)
+	return vrfs
+}
+
+// VRFPrefGroups 占用 vrf_pref 的策略组（早期版本创建，需要迁移到策略组优先级范围内）
+func VRFPrefGroups() []string {
+	vrfPref := config.RoutingNamespace().VRFPref
+
+	pm := NewPolicyManager()
+	if err := pm.LoadAllGroups(); err != nil {
+		return nil
+	}
+
+	names := make([]string, 0)
+	for _, group := range pm.groups {
+		if group.Priority == vrfPref {
+			names = append(names, group.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SyncVRFIsolation 同步 VRF 隔离规则（为使用中的 VRF 添加 iif/oif 规则，清理不再使用的规则）
+func SyncVRFIsolation() error {
+	ns := config.RoutingNamespace()
+	vrfPref := ns.VRFPref
+
+	// 期望的规则: 选择器 -> 路由表
+	expected := make(map[string]string)
+	for _, vrf := range UsedVRFs() {
+		table, err := network.VRFTable(vrf)
+		if err != nil {
+			fmt.Printf("  ⚠ VRF %s: %v\n", vrf, err)
+			continue
+		}
+		expected["iif "+vrf] = strconv.Itoa(table)
+		expected["oif "+vrf] = strconv.Itoa(table)
+	}
+
+	// 优先级被早期版本的策略组占用时不添加隔离规则（规则会与策略组规则混在同一优先级）
+	if conflicts := VRFPrefGroups(); len(conflicts) > 0 {
+		if len(expected) > 0 {
+			fmt.Printf("  ⚠ 策略组 %s 使用优先级 %d（已保留给VRF隔离），未添加VRF隔离规则\n", strings.Join(conflicts, ", "), vrfPref)
+			fmt.Printf("    请迁移到 %d-%d: twnode policy set-priority <策略组> <优先级>\n",
+				ns.GroupPrefMin, ns.GroupPrefMax())
+		}
+		expected = make(map[string]string)
+	}
+
+	rules, err := ListIPRules()
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Pref != vrfPref {
+			continue
+		}
+		// 查询 twnode 路由表的规则属于策略组，不是 VRF 隔离规则
+		if table, err := strconv.Atoi(rule.Table); err == nil && (ns.OwnsTable(table) || table == ns.GroupTable(vrfPref)) {
+			continue
+		}
+		selector := ""
+		switch {
+		case rule.Iif != "":
+			selector = "iif " + rule.Iif
+		case rule.Oif != "":
+			selector = "oif " + rule.Oif
+		default:
+			// 不带 iif/oif 的规则不是 VRF 隔离规则（如早期版本的策略组），保留
+			continue
+		}
+		if expected[selector] == rule.Table && !existing[selector] {
+			existing[selector] = true
+			continue
+		}
+		// 不再使用的 VRF、表号变化或重复的规则
+		execIPCommandNoError(fmt.Sprintf("ip rule del %s pref %d lookup %s", selector, vrfPref, rule.Table))
+		fmt.Printf("  ✓ 清理VRF隔离规则: %s\n", rule.Raw)
+	}
+
+	selectors := make([]string, 0, len(expected))
+	for selector := range expected {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+
+	for _, selector := range selectors {
+		if existing[selector] {
+			continue
+		}
+		cmd := fmt.Sprintf("ip rule add %s lookup %s pref %d", selector, expected[selector], vrfPref)
+		if err := execIPCommand(cmd); err != nil {
+			fmt.Printf("  ⚠ 警告: 添加VRF隔离规则失败: %v\n", err)
+			continue
+		}
+		fmt.Printf("  ✓ VRF隔离: %s → 表 %s\n", selector, expected[selector])
+	}
+
+	return nil
+}
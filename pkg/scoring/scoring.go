@@ -0,0 +1,248 @@
+package scoring
+
+import (
+	"fmt"
+	"math"
+)
+
+// 出口评分
+// 基础评分 = 丢包得分 + 延迟得分 + 抖动得分（各项满分由权重决定，默认 60/40/0，即原有的评分表）
+// 最终评分 = 基础评分 - 成本惩罚（成本 × cost_factor） - 失败惩罚（连续失败计数 × failure_penalty，有上限）
+// 曲线: step 为原有的阶梯评分表；linear 在 good（满分）和 bad（0分）阈值之间线性插值
+// 守护进程（evaluateFailover）、手动 failover（SelectBestExit）和 policy score 使用同一套评分
+
+const (
+	CurveStep   = "step"
+	CurveLinear = "linear"
+)
+
+// Config 评分参数（Merge 后的实际值）
+type Config struct {
+	LossWeight        float64 // 丢包满分（默认60）
+	LatencyWeight     float64 // 延迟满分（默认40）
+	JitterWeight      float64 // 抖动满分（默认0，不参与评分）
+	Curve             string  // 丢包/延迟评分曲线: step(默认) / linear
+	LatencyGoodMs     float64 // linear: 延迟满分阈值（默认50）
+	LatencyBadMs      float64 // linear: 延迟0分阈值（默认300）
+	LossBadPercent    float64 // linear: 丢包0分阈值（默认20）
+	JitterGoodMs      float64 // 抖动满分阈值（默认5）
+	JitterBadMs       float64 // 抖动0分阈值（默认50）
+	CostFactor        float64 // 每点成本的扣分（默认0.5）
+	FailurePenalty    float64 // 每次连续失败的扣分（默认0，不启用）
+	MaxFailurePenalty float64 // 失败惩罚上限（默认30）
+}
+
+// Overrides 配置文件中的评分参数（未设置的字段使用上一层的值，显式的 0 生效）
+// 守护进程全局配置和监控任务均可设置，监控任务设置的字段覆盖全局
+type Overrides struct {
+	LossWeight        *float64 `yaml:"loss_weight,omitempty"`
+	LatencyWeight     *float64 `yaml:"latency_weight,omitempty"`
+	JitterWeight      *float64 `yaml:"jitter_weight,omitempty"`
+	Curve             string   `yaml:"curve,omitempty"`
+	LatencyGoodMs     *float64 `yaml:"latency_good_ms,omitempty"`
+	LatencyBadMs      *float64 `yaml:"latency_bad_ms,omitempty"`
+	LossBadPercent    *float64 `yaml:"loss_bad_percent,omitempty"`
+	JitterGoodMs      *float64 `yaml:"jitter_good_ms,omitempty"`
+	JitterBadMs       *float64 `yaml:"jitter_bad_ms,omitempty"`
+	CostFactor        *float64 `yaml:"cost_factor,omitempty"`
+	FailurePenalty    *float64 `yaml:"failure_penalty,omitempty"`
+	MaxFailurePenalty *float64 `yaml:"max_failure_penalty,omitempty"`
+}
+
+// Default 默认评分参数（与原有评分一致）
+func Default() Config {
+	return Config{
+		LossWeight:        60,
+		LatencyWeight:     40,
+		JitterWeight:      0,
+		Curve:             CurveStep,
+		LatencyGoodMs:     50,
+		LatencyBadMs:      300,
+		LossBadPercent:    20,
+		JitterGoodMs:      5,
+		JitterBadMs:       50,
+		CostFactor:        0.5,
+		FailurePenalty:    0,
+		MaxFailurePenalty: 30,
+	}
+}
+
+// Merge 返回用 overrides 中已设置的字段依次覆盖默认值后的参数（nil 跳过）
+func Merge(overrides ...*Overrides) Config {
+	result := Default()
+	for _, o := range overrides {
+		if o == nil {
+			continue
+		}
+		setFloat(&result.LossWeight, o.LossWeight)
+		setFloat(&result.LatencyWeight, o.LatencyWeight)
+		setFloat(&result.JitterWeight, o.JitterWeight)
+		if o.Curve != "" {
+			result.Curve = o.Curve
+		}
+		setFloat(&result.LatencyGoodMs, o.LatencyGoodMs)
+		setFloat(&result.LatencyBadMs, o.LatencyBadMs)
+		setFloat(&result.LossBadPercent, o.LossBadPercent)
+		setFloat(&result.JitterGoodMs, o.JitterGoodMs)
+		setFloat(&result.JitterBadMs, o.JitterBadMs)
+		setFloat(&result.CostFactor, o.CostFactor)
+		setFloat(&result.FailurePenalty, o.FailurePenalty)
+		setFloat(&result.MaxFailurePenalty, o.MaxFailurePenalty)
+	}
+	return result
+}
+
+// setFloat 已设置时覆盖
+func setFloat(target *float64, value *float64) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+// Validate 校验评分参数（prefix 为配置路径，用于错误信息）
+// parents 为上层配置（如监控任务的上层为全局配置），按合并后的实际参数校验
+func (o *Overrides) Validate(prefix string, parents ...*Overrides) []string {
+	if o == nil {
+		return nil
+	}
+
+	errors := make([]string, 0)
+	merged := Merge(append(parents, o)...)
+
+	fields := []struct {
+		name  string
+		value *float64
+	}{
+		{"loss_weight", o.LossWeight}, {"latency_weight", o.LatencyWeight}, {"jitter_weight", o.JitterWeight},
+		{"latency_good_ms", o.LatencyGoodMs}, {"latency_bad_ms", o.LatencyBadMs}, {"loss_bad_percent", o.LossBadPercent},
+		{"jitter_good_ms", o.JitterGoodMs}, {"jitter_bad_ms", o.JitterBadMs}, {"cost_factor", o.CostFactor},
+		{"failure_penalty", o.FailurePenalty}, {"max_failure_penalty", o.MaxFailurePenalty},
+	}
+	for _, field := range fields {
+		if field.value != nil && *field.value < 0 {
+			errors = append(errors, fmt.Sprintf("%s.%s 不能为负数", prefix, field.name))
+		}
+	}
+
+	if o.Curve != "" && o.Curve != CurveStep && o.Curve != CurveLinear {
+		errors = append(errors, fmt.Sprintf("%s.curve 必须是 %s 或 %s", prefix, CurveStep, CurveLinear))
+	}
+	weights := merged.LossWeight + merged.LatencyWeight + merged.JitterWeight
+	if weights > 100 {
+		errors = append(errors, fmt.Sprintf("%s: loss_weight + latency_weight + jitter_weight 不能超过 100", prefix))
+	}
+	if weights <= 0 {
+		errors = append(errors, fmt.Sprintf("%s: loss_weight、latency_weight、jitter_weight 不能都为 0", prefix))
+	}
+	if merged.LatencyGoodMs >= merged.LatencyBadMs {
+		errors = append(errors, fmt.Sprintf("%s.latency_good_ms 必须小于 latency_bad_ms", prefix))
+	}
+	if merged.JitterGoodMs >= merged.JitterBadMs {
+		errors = append(errors, fmt.Sprintf("%s.jitter_good_ms 必须小于 jitter_bad_ms", prefix))
+	}
+	if merged.LossBadPercent <= 0 || merged.LossBadPercent > 100 {
+		errors = append(errors, fmt.Sprintf("%s.loss_bad_percent 必须在 0-100 范围内（不含0）", prefix))
+	}
+
+	return errors
+}
+
+// Sample 评分输入
+type Sample struct {
+	Latency    float64 // 平均延迟（ms）
+	PacketLoss float64 // 丢包率（%）
+	Jitter     float64 // 抖动（ms）
+	Cost       int     // 有效成本（0-100）
+	Failures   int     // 连续失败计数
+}
+
+// Breakdown 评分明细
+type Breakdown struct {
+	Loss           float64 // 丢包得分
+	Latency        float64 // 延迟得分
+	Jitter         float64 // 抖动得分
+	Base           float64 // 基础评分 = 丢包 + 延迟 + 抖动
+	CostPenalty    float64 // 成本惩罚
+	FailurePenalty float64 // 连续失败惩罚
+	Final          float64 // 最终评分（不小于0）
+}
+
+// Score 计算评分
+func (c Config) Score(s Sample) Breakdown {
+	var b Breakdown
+
+	// 完全失败（100% 丢包）: 接口不可达，基础评分为 0
+	if s.PacketLoss < 100.0 {
+		if c.Curve == CurveLinear {
+			b.Loss = c.LossWeight * linear(s.PacketLoss, 0, c.LossBadPercent)
+			b.Latency = c.LatencyWeight * linear(s.Latency, c.LatencyGoodMs, c.LatencyBadMs)
+		} else {
+			b.Loss = c.LossWeight * stepLoss(s.PacketLoss)
+			b.Latency = c.LatencyWeight * stepLatency(s.Latency)
+		}
+		b.Jitter = c.JitterWeight * linear(s.Jitter, c.JitterGoodMs, c.JitterBadMs)
+	}
+	b.Base = b.Loss + b.Latency + b.Jitter
+
+	b.CostPenalty = float64(s.Cost) * c.CostFactor
+	b.FailurePenalty = math.Min(float64(s.Failures)*c.FailurePenalty, c.MaxFailurePenalty)
+
+	b.Final = b.Base - b.CostPenalty - b.FailurePenalty
+	if b.Final < 0 {
+		b.Final = 0
+	}
+	return b
+}
+
+// linear 在 good（1.0）和 bad（0）之间线性插值
+func linear(value, good, bad float64) float64 {
+	switch {
+	case value <= good:
+		return 1
+	case value >= bad:
+		return 0
+	}
+	return (bad - value) / (bad - good)
+}
+
+// stepLoss 丢包阶梯评分（占满分的比例: 0% 满分，≤5% 45/60，≤10% 30/60，≤20% 15/60）
+func stepLoss(loss float64) float64 {
+	switch {
+	case loss == 0:
+		return 1
+	case loss <= 5:
+		return 0.75
+	case loss <= 10:
+		return 0.5
+	case loss <= 20:
+		return 0.25
+	}
+	return 0
+}
+
+// stepLatency 延迟阶梯评分（占满分的比例: <50ms 满分，<100 35/40，<150 30/40，<200 25/40，<300 15/40，其余 5/40）
+func stepLatency(latency float64) float64 {
+	switch {
+	case latency < 50:
+		return 1
+	case latency < 100:
+		return 0.875
+	case latency < 150:
+		return 0.75
+	case latency < 200:
+		return 0.625
+	case latency < 300:
+		return 0.375
+	}
+	return 0.125
+}
+
+// Summary 评分参数的简要描述
+func (c Config) Summary() string {
+	summary := fmt.Sprintf("丢包 %.0f / 延迟 %.0f / 抖动 %.0f (%s), 成本系数 %.2f",
+		c.LossWeight, c.LatencyWeight, c.JitterWeight, c.Curve, c.CostFactor)
+	if c.FailurePenalty > 0 {
+		summary += fmt.Sprintf(", 失败惩罚 %.1f/次 (上限 %.0f)", c.FailurePenalty, c.MaxFailurePenalty)
+	}
+	return summary
+}
@@ -10,7 +10,11 @@ import (
 
 	"trueword_node/pkg/config"
 	"trueword_node/pkg/failover"
+	"trueword_node/pkg/firewall"
+	"trueword_node/pkg/forward"
+	"trueword_node/pkg/gc"
 	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
 )
 
 // 检查命令是否存在
@@ -44,84 +48,67 @@ func setSysctl(param, value string) error {
 	return cmd.Run()
 }
 
-// 检查iptables规则是否存在
-func iptablesRuleExists(table, chain, rule string) bool {
-	cmd := exec.Command("iptables", "-t", table, "-C", chain)
-	if rule != "" {
-		ruleArgs := strings.Fields(rule)
-		cmd.Args = append(cmd.Args, ruleArgs...)
-	}
-	return cmd.Run() == nil
-}
+// removeLegacyMasquerade 删除旧版本添加的全局 iptables MASQUERADE 规则及其持久化服务
+// NAT 改由 twnode 独占的 nftables 表按出口管理
+func removeLegacyMasquerade() bool {
+	removed := false
 
-// 添加iptables规则
-func addIptablesRule(table, chain, rule string) error {
-	cmd := exec.Command("iptables", "-t", table, "-A", chain)
-	if rule != "" {
-		ruleArgs := strings.Fields(rule)
-		cmd.Args = append(cmd.Args, ruleArgs...)
+	if commandExists("iptables") {
+		for exec.Command("iptables", "-t", "nat", "-C", "POSTROUTING", "-j", "MASQUERADE").Run() == nil {
+			if exec.Command("iptables", "-t", "nat", "-D", "POSTROUTING", "-j", "MASQUERADE").Run() != nil {
+				break
+			}
+			removed = true
+		}
 	}
-	return cmd.Run()
-}
-
-// setupIptablesPersistence 设置iptables规则持久化
-func setupIptablesPersistence() error {
-	// 1. 创建iptables脚本
-	scriptPath := "/usr/local/bin/twnode-iptables.sh"
-	scriptContent := `#!/bin/bash
-# TrueWord Node iptables 规则
-# 系统启动时自动应用
 
-# 清空可能的重复规则（防止多次运行导致重复）
-iptables -t nat -D POSTROUTING -j MASQUERADE 2>/dev/null || true
+	legacyService := "/etc/systemd/system/twnode-iptables.service"
+	if _, err := os.Stat(legacyService); err == nil {
+		exec.Command("systemctl", "disable", "--now", "twnode-iptables.service").Run()
+		os.Remove(legacyService)
+		os.Remove("/usr/local/bin/twnode-iptables.sh")
+		exec.Command("systemctl", "daemon-reload").Run()
+		removed = true
+	}
 
-# 添加 MASQUERADE 规则
-iptables -t nat -A POSTROUTING -j MASQUERADE
+	return removed
+}
 
-exit 0
-`
-	if err := os.WriteFile(scriptPath, []byte(scriptContent), 0755); err != nil {
-		return fmt.Errorf("创建脚本失败: %w", err)
+// setupFirewallPersistence 通过 systemd 在开机时重新应用 twnode 防火墙表
+func setupFirewallPersistence() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("获取程序路径失败: %w", err)
 	}
 
-	// 2. 创建systemd service
-	servicePath := "/etc/systemd/system/twnode-iptables.service"
-	serviceContent := `[Unit]
-Description=TrueWord Node iptables Rules
+	servicePath := "/etc/systemd/system/twnode-firewall.service"
+	serviceContent := fmt.Sprintf(`[Unit]
+Description=TrueWord Node nftables Rules
 After=network-pre.target
 Before=network.target
 DefaultDependencies=no
 
 [Service]
 Type=oneshot
-ExecStart=/usr/local/bin/twnode-iptables.sh
+ExecStart=%s firewall apply
+ExecStop=%s firewall revoke
 RemainAfterExit=yes
 
 [Install]
 WantedBy=multi-user.target
-`
+`, exe, exe)
 	if err := os.WriteFile(servicePath, []byte(serviceContent), 0644); err != nil {
 		return fmt.Errorf("创建systemd service失败: %w", err)
 	}
 
-	// 3. 重载systemd
-	cmd := exec.Command("systemctl", "daemon-reload")
-	if err := cmd.Run(); err != nil {
+	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
 		return fmt.Errorf("重载systemd失败: %w", err)
 	}
 
-	// 4. 启用service（开机自启）
-	cmd = exec.Command("systemctl", "enable", "twnode-iptables.service")
-	if err := cmd.Run(); err != nil {
+	if err := exec.Command("systemctl", "enable", "twnode-firewall.service").Run(); err != nil {
 		return fmt.Errorf("启用service失败: %w", err)
 	}
 
-	// 5. 立即启动service
-	cmd = exec.Command("systemctl", "start", "twnode-iptables.service")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("启动service失败: %w", err)
-	}
-
 	return nil
 }
 
@@ -136,7 +123,7 @@ func CheckEnvironment() error {
 	fmt.Println("  ✓ Root权限")
 
 	// 2. 检查必需的命令
-	requiredCommands := []string{"ip", "iptables", "ping"}
+	requiredCommands := []string{"ip", "nft", "ping"}
 	for _, cmd := range requiredCommands {
 		if !commandExists(cmd) {
 			return fmt.Errorf("缺少必需的命令: %s", cmd)
@@ -168,12 +155,12 @@ func CheckEnvironment() error {
 	}
 	fmt.Println("  ✓ IP转发已启用")
 
-	// 5. 检查iptables MASQUERADE规则
-	if !iptablesRuleExists("nat", "POSTROUTING", "-j MASQUERADE") {
-		fmt.Println("  ⚠ iptables MASQUERADE规则未配置")
-		return fmt.Errorf("iptables MASQUERADE规则未配置，请先运行初始化命令")
+	// 5. 检查 twnode 防火墙表（出口NAT）
+	if !firewall.TableExists() {
+		fmt.Println("  ⚠ nftables 表 inet twnode 未配置")
+		return fmt.Errorf("防火墙(出口NAT)未配置，请先运行 'twnode firewall apply' 或初始化命令")
 	}
-	fmt.Println("  ✓ iptables MASQUERADE已配置")
+	fmt.Println("  ✓ nftables 出口NAT已配置")
 
 	fmt.Println("\n✓ 环境检查通过")
 	return nil
@@ -194,7 +181,7 @@ func Initialize() error {
 
 	// 2. 检查必需的命令
 	fmt.Println("【检查系统环境】")
-	requiredCommands := []string{"ip", "iptables", "ping", "sysctl"}
+	requiredCommands := []string{"ip", "nft", "ping", "sysctl"}
 	for _, cmd := range requiredCommands {
 		if !commandExists(cmd) {
 			return fmt.Errorf("❌ 缺少必需的命令: %s，请先安装", cmd)
@@ -239,48 +226,7 @@ func Initialize() error {
 
 	fmt.Println()
 
-	// 4. 配置iptables MASQUERADE
-	if !iptablesRuleExists("nat", "POSTROUTING", "-j MASQUERADE") {
-		if err := addIptablesRule("nat", "POSTROUTING", "-j MASQUERADE"); err != nil {
-			return fmt.Errorf("❌ 添加iptables规则失败: %w", err)
-		}
-	}
-	fmt.Println("  ✓ iptables MASQUERADE已配置（当前会话）")
-
-	// 检查iptables规则是否已持久化
-	servicePath := "/etc/systemd/system/twnode-iptables.service"
-	if _, err := os.Stat(servicePath); err == nil {
-		// Service文件已存在，检查是否已启用
-		cmd := exec.Command("systemctl", "is-enabled", "twnode-iptables.service")
-		if err := cmd.Run(); err == nil {
-			fmt.Println("  ✓ 已持久化（systemd service已启用）")
-		} else {
-			fmt.Println("  ⚠️  systemd service存在但未启用，建议重新配置")
-		}
-	} else {
-		// Service文件不存在，询问是否持久化
-		fmt.Println()
-		fmt.Println("  ℹ️  iptables规则是临时的，重启后会失效")
-		fmt.Print("  是否通过systemd持久化? (Y/n): ")
-		reader := bufio.NewReader(os.Stdin)
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
-
-		if response == "" || response == "y" || response == "yes" {
-			if err := setupIptablesPersistence(); err != nil {
-				fmt.Printf("  ⚠️  持久化失败: %v\n", err)
-				fmt.Println("  提示: 您可以手动配置 iptables-persistent 或其他持久化方案")
-			} else {
-				fmt.Println("  ✓ 已通过systemd持久化iptables规则")
-			}
-		} else {
-			fmt.Println("  - 已跳过持久化")
-		}
-	}
-
-	fmt.Println()
-
-	// 5. 检查是否存在旧配置，如果存在则警告
+	// 4. 检查是否存在旧配置，如果存在则警告
 	fmt.Println("【初始化配置目录】")
 	dirs := []string{
 		config.ConfigDir,
@@ -288,6 +234,12 @@ func Initialize() error {
 		"/etc/trueword_node/policies",
 	}
 
+	// 保留旧配置中的路由命名空间（与其他软件共存的设置不随初始化丢失）
+	namespace := config.DefaultNamespace()
+	if ns, err := config.LoadNamespace(); err == nil {
+		namespace = ns
+	}
+
 	// 检查是否存在旧配置
 	hasOldConfig := false
 	for _, dir := range dirs {
@@ -327,6 +279,21 @@ func Initialize() error {
 			return fmt.Errorf("用户取消初始化")
 		}
 
+		fmt.Println()
+		fmt.Println("  清理内核中残留的 twnode 状态...")
+		if report, err := gc.Collect(&gc.Options{IgnoreConfig: true}); err != nil {
+			fmt.Printf("  ⚠️  收集残留状态失败: %v\n", err)
+		} else {
+			report.Print()
+			removed, errs := report.Remove(false)
+			for _, err := range errs {
+				fmt.Printf("  ⚠️  %v\n", err)
+			}
+			if removed > 0 {
+				fmt.Printf("  ✓ 已清理 %d 项残留\n", removed)
+			}
+		}
+
 		fmt.Println()
 		fmt.Println("  开始清除旧配置...")
 	}
@@ -354,8 +321,11 @@ func Initialize() error {
 
 	fmt.Println("  ✓ 配置目录已清除并重建")
 
-	// 6. 创建默认配置文件
+	// 5. 创建默认配置文件
 	cfg := config.CreateDefault()
+	if namespace != config.DefaultNamespace() {
+		cfg.Namespace = namespace
+	}
 	if err := cfg.Save(); err != nil {
 		return fmt.Errorf("❌ 保存配置文件失败: %w", err)
 	}
@@ -363,7 +333,22 @@ func Initialize() error {
 
 	fmt.Println()
 
-	// 7. 扫描物理网络接口
+	// 检测路由命名空间与现有规则（Docker、Tailscale、FRR 等）的冲突
+	fmt.Println("【检查路由命名空间】")
+	tableMin, tableMax := namespace.TableRange()
+	fmt.Printf("  规则优先级 %d-%d，路由表 %d-%d\n", namespace.TestPref, namespace.DefaultPref, tableMin, tableMax)
+	if conflicts, err := routing.NamespaceConflicts(namespace, namespace); err != nil {
+		fmt.Printf("  ⚠️  读取策略路由规则失败: %v\n", err)
+	} else {
+		printConflicts(conflicts)
+	}
+	if err := config.SaveAppliedNamespace(namespace); err != nil {
+		return fmt.Errorf("❌ %w", err)
+	}
+
+	fmt.Println()
+
+	// 6. 扫描物理网络接口
 	fmt.Println("【扫描物理网络接口】")
 	interfaces, err := network.ScanPhysicalInterfaces()
 	if err != nil {
@@ -426,6 +411,42 @@ func Initialize() error {
 		}
 	}
 
+	fmt.Println()
+
+	// 7. 配置防火墙（按出口的 nftables NAT，替代旧版全局 MASQUERADE）
+	fmt.Println("【配置防火墙】")
+	if removeLegacyMasquerade() {
+		fmt.Println("  ✓ 已移除旧版全局 iptables MASQUERADE 规则")
+	}
+
+	if err := firewall.Apply(); err != nil {
+		return fmt.Errorf("❌ 应用防火墙规则失败: %w", err)
+	}
+	fmt.Printf("  ✓ nftables 表 %s %s 已应用（当前会话）\n", firewall.TableFamily, firewall.TableName)
+
+	// 检查是否已持久化
+	firewallService := "/etc/systemd/system/twnode-firewall.service"
+	if _, err := os.Stat(firewallService); err == nil {
+		fmt.Println("  ✓ 已持久化（systemd service已存在）")
+	} else {
+		fmt.Println()
+		fmt.Println("  ℹ️  防火墙规则是临时的，重启后会失效")
+		fmt.Print("  是否通过systemd持久化? (Y/n): ")
+		reader := bufio.NewReader(os.Stdin)
+		response, _ := reader.ReadString('\n')
+		response = strings.TrimSpace(strings.ToLower(response))
+
+		if response == "" || response == "y" || response == "yes" {
+			if err := setupFirewallPersistence(); err != nil {
+				fmt.Printf("  ⚠️  持久化失败: %v\n", err)
+			} else {
+				fmt.Println("  ✓ 已通过systemd持久化防火墙规则")
+			}
+		} else {
+			fmt.Println("  - 已跳过持久化")
+		}
+	}
+
 	// 完成提示
 	fmt.Println()
 	fmt.Println("╔═══════════════════════════════════════════════════════════╗")
@@ -440,6 +461,7 @@ func Initialize() error {
 	fmt.Println("配置文件:")
 	fmt.Println("  • 物理接口: /etc/trueword_node/interfaces/physical.yaml")
 	fmt.Println("  • 全局配置: /etc/trueword_node/config.yaml")
+	fmt.Println("  • 防火墙:   twnode firewall show")
 	fmt.Println()
 
 	return nil
@@ -489,15 +511,16 @@ func mergeDaemonStateToCheckResults(checkResults *network.AllCheckResults, daemo
 // ShowStatus 显示系统状态
 // getActualDefaultRoute 从系统实际读取默认路由出口
 func getActualDefaultRoute() string {
-	// 读取优先级 900 的路由规则
-	cmd := exec.Command("sh", "-c", "ip rule show pref 900 | head -1")
+	// 读取默认路由规则（default_pref，默认 900）
+	ns := config.RoutingNamespace()
+	cmd := exec.Command("sh", "-c", fmt.Sprintf("ip rule show pref %d | head -1", ns.DefaultPref))
 	output, err := cmd.Output()
 	if err != nil || len(output) == 0 {
 		return "" // 未配置默认路由
 	}
 
-	// 读取路由表 900 中的默认路由
-	tableID := 900
+	// 读取默认路由表中的默认路由
+	tableID := ns.DefaultTable()
 	cmd = exec.Command("sh", "-c", fmt.Sprintf("ip route show table %d | grep '^default'", tableID))
 	output, err = cmd.Output()
 	if err != nil || len(output) == 0 {
@@ -576,9 +599,9 @@ func ShowStatus() error {
 	ipForward, _ := checkSysctl("net.ipv4.ip_forward")
 	fmt.Printf("  IP转发:             %s\n", map[string]string{"1": "✓ 已启用", "0": "✗ 未启用"}[ipForward])
 
-	// iptables MASQUERADE
-	masqueradeExists := iptablesRuleExists("nat", "POSTROUTING", "-j MASQUERADE")
-	fmt.Printf("  iptables MASQ:      %s\n", map[bool]string{true: "✓ 已配置", false: "✗ 未配置"}[masqueradeExists])
+	// nftables 出口NAT
+	firewallApplied := firewall.TableExists()
+	fmt.Printf("  nftables NAT:       %s\n", map[bool]string{true: "✓ 已配置", false: "✗ 未配置"}[firewallApplied])
 
 	// 策略路由数量
 	cmd := exec.Command("ip", "rule", "list")
@@ -600,6 +623,14 @@ func ShowStatus() error {
 	}
 	fmt.Printf("  策略路由规则:       %d 条\n", policyCount)
 
+	// 端口转发
+	if forwards, err := forward.List(); err == nil && len(forwards) > 0 {
+		fmt.Println()
+		fmt.Println("【端口转发】")
+		fmt.Println()
+		forward.Print(forwards)
+	}
+
 	fmt.Println()
 	fmt.Println(strings.Repeat("=", 80))
 
@@ -0,0 +1,267 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"trueword_node/pkg/config"
+	"trueword_node/pkg/firewall"
+	"trueword_node/pkg/gc"
+	"trueword_node/pkg/ipsec"
+	"trueword_node/pkg/network"
+	"trueword_node/pkg/routing"
+)
+
+// ShowNamespace 显示路由命名空间布局、已应用的命名空间和冲突
+func ShowNamespace() error {
+	ns, err := config.LoadNamespace()
+	if err != nil {
+		return err
+	}
+	applied := config.LoadAppliedNamespace()
+
+	fmt.Println("【路由命名空间】")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("用途", "规则优先级", "路由表", "已应用")
+	sourceMin, sourceMax := ns.SourceTables()
+	forwardMin, forwardMax := ns.ForwardTables()
+	appliedSourceMin, appliedSourceMax := applied.SourceTables()
+	appliedForwardMin, appliedForwardMax := applied.ForwardTables()
+	rows := [][]string{
+		{"检测探测规则", strconv.Itoa(ns.TestPref), strconv.Itoa(ns.TestTable()),
+			layout(strconv.Itoa(applied.TestPref), strconv.Itoa(applied.TestTable()))},
+		{"源地址路由", strconv.Itoa(ns.SourcePref), tableRange(sourceMin, sourceMax),
+			layout(strconv.Itoa(applied.SourcePref), tableRange(appliedSourceMin, appliedSourceMax))},
+		{"端口转发回程", strconv.Itoa(ns.ForwardPref), tableRange(forwardMin, forwardMax),
+			layout(strconv.Itoa(applied.ForwardPref), tableRange(appliedForwardMin, appliedForwardMax))},
+		{"对端IP保护", strconv.Itoa(ns.ProtectPref), "main",
+			layout(strconv.Itoa(applied.ProtectPref), "main")},
+		{"隧道策略路由", strconv.Itoa(ns.TunnelPref), strconv.Itoa(ns.TunnelTable()),
+			layout(strconv.Itoa(applied.TunnelPref), strconv.Itoa(applied.TunnelTable()))},
+		{"隧道VIP", strconv.Itoa(ns.VIPPref), strconv.Itoa(ns.VIPTable()),
+			layout(strconv.Itoa(applied.VIPPref), strconv.Itoa(applied.VIPTable()))},
+		{"策略组", tableRange(ns.GroupPrefMin, ns.GroupPrefMax()),
+			tableRange(ns.GroupTable(ns.GroupPrefMin), ns.GroupTable(ns.GroupPrefMax())),
+			layout(tableRange(applied.GroupPrefMin, applied.GroupPrefMax()), tableRange(applied.GroupTable(applied.GroupPrefMin), applied.GroupTable(applied.GroupPrefMax())))},
+		{"VRF隔离", strconv.Itoa(ns.VRFPref), "VRF路由表",
+			layout(strconv.Itoa(applied.VRFPref), "VRF路由表")},
+		{"默认路由", strconv.Itoa(ns.DefaultPref), strconv.Itoa(ns.DefaultTable()),
+			layout(strconv.Itoa(applied.DefaultPref), strconv.Itoa(applied.DefaultTable()))},
+	}
+	for _, row := range rows {
+		table.Append(row)
+	}
+	table.Render()
+
+	if ns != applied {
+		fmt.Println()
+		fmt.Println("⚠ 配置的路由命名空间与当前内核状态不一致，请执行 'twnode namespace migrate'")
+	}
+
+	fmt.Println()
+	fmt.Println("【冲突检测】")
+	conflicts, err := routing.NamespaceConflicts(ns, applied)
+	if err != nil {
+		return fmt.Errorf("读取策略路由规则失败: %w", err)
+	}
+	printConflicts(conflicts)
+	return nil
+}
+
+// layout 已应用列的显示: 优先级 → 路由表
+func layout(prefs, tables string) string {
+	return fmt.Sprintf("%s → 表 %s", prefs, tables)
+}
+
+// tableRange 范围显示
+func tableRange(min, max int) string {
+	return fmt.Sprintf("%d-%d", min, max)
+}
+
+// printConflicts 打印冲突列表
+func printConflicts(conflicts []string) {
+	if len(conflicts) == 0 {
+		fmt.Println("  ✓ 未发现与其他软件的规则冲突")
+		return
+	}
+	for _, conflict := range conflicts {
+		fmt.Printf("  ✗ %s\n", conflict)
+	}
+	fmt.Println("  ℹ 可在 /etc/trueword_node/config.yaml 的 namespace 中调整 table_offset 和各优先级")
+}
+
+// MigrateNamespace 将内核状态迁移到配置的路由命名空间
+// 停止运行中的隧道，清理旧命名空间的规则和路由表，再按新命名空间恢复隧道、策略路由和防火墙
+func MigrateNamespace(force bool) error {
+	ns, err := config.LoadNamespace()
+	if err != nil {
+		return err
+	}
+	applied := config.LoadAppliedNamespace()
+
+	if ns == applied {
+		fmt.Println("✓ 路由命名空间未变化，无需迁移")
+		return config.SaveAppliedNamespace(ns)
+	}
+
+	// 1. 冲突检测
+	fmt.Println("【冲突检测】")
+	conflicts, err := routing.NamespaceConflicts(ns, applied)
+	if err != nil {
+		return fmt.Errorf("读取策略路由规则失败: %w", err)
+	}
+	printConflicts(conflicts)
+	if len(conflicts) > 0 && !force {
+		return fmt.Errorf("新的路由命名空间存在 %d 处冲突，使用 --force 强制迁移", len(conflicts))
+	}
+	fmt.Println()
+
+	// 记录迁移前的状态，迁移后按原样恢复
+	appliedGroups, _ := routing.LoadAppliedGroups()
+	defaultApplied := false
+	sourceApplied := false
+	rules, err := routing.ListIPRules()
+	if err != nil {
+		return fmt.Errorf("读取策略路由规则失败: %w", err)
+	}
+	for _, rule := range rules {
+		if !routing.IsNamespaceRule(applied, rule) {
+			continue
+		}
+		switch rule.Pref {
+		case applied.DefaultPref:
+			defaultApplied = true
+		case applied.SourcePref:
+			sourceApplied = true
+		}
+	}
+
+	// 2. 停止运行中的隧道（撤销记录使用旧的表号）
+	fmt.Println("【停止隧道】")
+	tunnels, err := network.ListTunnelConfigs()
+	if err != nil {
+		return fmt.Errorf("加载隧道配置失败: %w", err)
+	}
+	running := make([]*network.TunnelConfig, 0)
+	for _, tunnel := range tunnels {
+		if !network.IsInterfaceUp(tunnel.Name) {
+			continue
+		}
+		if err := ipsec.NewTunnelManager(tunnel).Stop(); err != nil {
+			fmt.Printf("  ⚠ 停止隧道 %s 失败: %v\n", tunnel.Name, err)
+		}
+		running = append(running, tunnel)
+	}
+	if len(running) == 0 {
+		fmt.Println("  没有运行中的隧道")
+	}
+	fmt.Println()
+
+	// 3. 清理旧命名空间的规则和路由表
+	fmt.Println("【清理旧的规则和路由表】")
+	removedRules, flushedTables := removeNamespaceState(applied)
+	fmt.Printf("  ✓ 已删除 %d 条规则，清空 %d 张路由表\n", removedRules, flushedTables)
+
+	if err := config.SaveAppliedNamespace(ns); err != nil {
+		return err
+	}
+	fmt.Println("  ✓ 已记录新的路由命名空间")
+	fmt.Println()
+
+	// 4. 按新命名空间恢复
+	fmt.Println("【恢复隧道】")
+	for _, tunnel := range running {
+		if err := ipsec.NewTunnelManager(tunnel).Start(); err != nil {
+			fmt.Printf("  ⚠ 启动隧道 %s 失败: %v\n", tunnel.Name, err)
+		}
+	}
+	if len(running) == 0 {
+		fmt.Println("  没有需要恢复的隧道")
+	}
+	fmt.Println()
+	if err := routing.SyncProtection(); err != nil {
+		fmt.Printf("⚠ 警告: 同步保护路由失败: %v\n", err)
+	}
+
+	if sourceApplied {
+		if err := routing.SyncSourceRouting(); err != nil {
+			fmt.Printf("⚠ 警告: 同步源地址路由失败: %v\n", err)
+		}
+	}
+
+	if len(appliedGroups) > 0 || defaultApplied {
+		fmt.Println()
+		fmt.Println("【恢复策略路由】")
+		pm := routing.NewPolicyManager()
+		for _, name := range appliedGroups {
+			if err := pm.LoadGroup(name); err != nil {
+				fmt.Printf("  ⚠ 加载策略组 %s 失败: %v\n", name, err)
+			}
+		}
+		if cfg, err := config.Load(); err == nil && defaultApplied && cfg.Routing.DefaultExit != "" {
+			pm.SetDefaultExit(cfg.Routing.DefaultExit)
+			pm.SetDefaultOnExitDown(cfg.Routing.DefaultOnExitDown)
+		}
+		if err := pm.Apply(); err != nil {
+			fmt.Printf("⚠ 警告: 应用策略路由失败: %v\n", err)
+		}
+	}
+
+	// 端口转发回程路由随防火墙一起重建
+	if err := firewall.Sync(); err != nil {
+		fmt.Printf("⚠ 警告: 同步防火墙失败: %v\n", err)
+	}
+
+	fmt.Println()
+	fmt.Println("✓ 路由命名空间迁移完成")
+	fmt.Println("  ℹ 如果故障转移守护进程正在运行，请重启守护进程")
+	return nil
+}
+
+// removeNamespaceState 删除命名空间内 twnode 安装的规则并清空其路由表
+func removeNamespaceState(ns config.Namespace) (int, int) {
+	removedRules := 0
+	if rules, err := routing.ListIPRules(); err == nil {
+		for _, rule := range rules {
+			if !routing.IsNamespaceRule(ns, rule) {
+				continue
+			}
+			args := []string{"rule", "del"}
+			if rule.To != "" {
+				args = append(args, "to", rule.To)
+			}
+			if rule.Iif != "" {
+				args = append(args, "iif", rule.Iif)
+			}
+			if rule.Oif != "" {
+				args = append(args, "oif", rule.Oif)
+			}
+			if rule.From != "" && rule.From != "all" {
+				args = append(args, "from", rule.From)
+			}
+			if rule.Fwmark != "" {
+				args = append(args, "fwmark", rule.Fwmark)
+			}
+			args = append(args, "pref", strconv.Itoa(rule.Pref), "lookup", rule.Table)
+			if exec.Command("ip", args...).Run() == nil {
+				removedRules++
+			}
+		}
+	}
+
+	flushedTables := 0
+	for _, tableID := range gc.ListNonEmptyTables() {
+		if !ns.OwnsTable(tableID) {
+			continue
+		}
+		if exec.Command("ip", "route", "flush", "table", strconv.Itoa(tableID)).Run() == nil {
+			flushedTables++
+		}
+	}
+
+	exec.Command("ip", "route", "flush", "cache").Run()
+	return removedRules, flushedTables
+}
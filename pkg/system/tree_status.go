@@ -271,6 +271,11 @@ func printNode(node *InterfaceNode, prefix string, isLast bool) {
 		if tunnel.Cost > 0 {
 			infoStr = fmt.Sprintf("%s | Cost: %s%d%s", infoStr, colorYellow, tunnel.Cost, colorReset)
 		}
+
+		// 显示隔离上下文
+		if tunnel.VRF != "" || tunnel.Netns != "" {
+			infoStr = fmt.Sprintf("%s | %s%s%s", infoStr, colorBlue, network.IsolationLabel(tunnel.VRF, tunnel.Netns), colorReset)
+		}
 	}
 
 	// 构建节点名称（如果是默认路由出口，加上星号）
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"trueword_node/pkg/config"
 	"trueword_node/pkg/network"
 )
 
@@ -28,6 +29,23 @@ type WireGuardTunnel struct {
 	PeerPublicKey   string
 	ListenPort      int
 	PeerListenPort  int
+	RemoteSubnets   []string // 对端站点网段（路由到VIP路由表）
+	StrictAllowed   bool     // allowed-ips 仅包含对端VIP和对端网段
+	VRF             string   // 隧道接口加入的VRF（可选）
+	Netns           string   // 隧道接口所在的网络命名空间（可选，UDP套接字仍在默认命名空间）
+	Netmap          []network.NetmapRule // 本端网段映射（可选）
+	Firewall        *network.TunnelFirewall // 隧道防火墙（可选）
+}
+
+// AllowedIPs 返回对端 allowed-ips
+// 默认 0.0.0.0/0，路由完全由策略路由系统控制；
+// 多对端场景（StrictAllowed）按对端VIP和对端网段限制，供 WireGuard 按目的地址选择对端
+func (wg *WireGuardTunnel) AllowedIPs() string {
+	if !wg.StrictAllowed {
+		return "0.0.0.0/0"
+	}
+	allowed := []string{wg.RemoteVIP + "/32"}
+	return strings.Join(append(allowed, wg.RemoteSubnets...), ",")
 }
 
 // 执行命令并记录 (静默执行,只在出错时显示)
@@ -171,15 +189,49 @@ func (wg *WireGuardTunnel) Create() error {
 		execCommandNoError(fmt.Sprintf("ip link set dev %s down", wg.Name))
 		execCommandNoError(fmt.Sprintf("ip link del dev %s", wg.Name))
 	}
+	if wg.Netns != "" && network.LinkExistsIn(wg.Name, wg.Netns) {
+		fmt.Printf("   ⚠️  接口 %s 已存在于网络命名空间 %s，正在清理...\n", wg.Name, wg.Netns)
+		execCommandNoError(fmt.Sprintf("ip -n %s link del dev %s", wg.Netns, wg.Name))
+	}
+
+	// 对端VIP和对端网段所在的路由表（默认VIP路由表，VRF隧道为VRF路由表）
+	ns := config.RoutingNamespace()
+	routeTable, err := network.PeerRouteTable(wg.VRF, wg.Netns)
+	if err != nil {
+		return err
+	}
 
 	// 记录撤销命令
-	revCommands := []string{
-		fmt.Sprintf("ip link set dev %s down", wg.Name),
-		fmt.Sprintf("ip link del dev %s", wg.Name),
-		fmt.Sprintf("ip route del %s/32 dev %s table 80", wg.RemoteVIP, wg.Name),
+	var revCommands []string
+	if wg.Netns != "" {
+		// 网络命名空间内删除接口时地址和路由一并删除
+		revCommands = []string{fmt.Sprintf("ip -n %s link del dev %s", wg.Netns, wg.Name)}
+	} else {
+		revCommands = []string{
+			fmt.Sprintf("ip link set dev %s down", wg.Name),
+			fmt.Sprintf("ip link del dev %s", wg.Name),
+			fmt.Sprintf("ip route del %s/32 dev %s table %s", wg.RemoteVIP, wg.Name, routeTable),
+		}
+		for _, subnet := range wg.RemoteSubnets {
+			revCommands = append(revCommands, fmt.Sprintf("ip route del %s dev %s table %s", subnet, wg.Name, routeTable))
+		}
+	}
+	if len(wg.Netmap) > 0 {
+		revCommands = append(revCommands, network.NetmapRevCommand(wg.Name, wg.Netns))
+	}
+	if wg.Firewall != nil {
+		revCommands = append(revCommands, network.TunnelFirewallRevCommand(wg.Name, wg.Netns))
 	}
 	recordRevCommands(revFile, revCommands)
 
+	// 隧道防火墙（在接口启动前应用，避免规则生效前对端流量进入）
+	if wg.Firewall != nil {
+		if err := network.ApplyTunnelFirewall(wg.Name, wg.LocalVIP, wg.Netns, wg.Firewall); err != nil {
+			return err
+		}
+		fmt.Printf("   ✓ 隧道防火墙: %s\n", network.TunnelFirewallSummary(wg.Firewall))
+	}
+
 	// 1. 创建 WireGuard 接口
 	cmd := fmt.Sprintf("ip link add name %s type wireguard", wg.Name)
 	if err := execCommand(cmd); err != nil {
@@ -201,51 +253,77 @@ func (wg *WireGuardTunnel) Create() error {
 	// 如果端口为 0，WireGuard 会自动分配一个随机端口
 
 	// 4. 添加对端配置
-	// allowed-ips 设置为 0.0.0.0/0，不使用 WireGuard 内置路由
-	// 路由完全由本软件的策略路由系统控制
+	// allowed-ips 默认 0.0.0.0/0，不使用 WireGuard 内置路由
+	// 路由完全由本软件的策略路由系统控制（见 AllowedIPs）
 	var peerCmd string
 	if wg.Mode == "client" {
 		// 客户端模式：配置 endpoint 和 persistent-keepalive
-		peerCmd = fmt.Sprintf("wg set %s peer %s endpoint %s:%d allowed-ips 0.0.0.0/0 persistent-keepalive 25",
-			wg.Name, wg.PeerPublicKey, wg.RemoteIP, wg.PeerListenPort)
+		peerCmd = fmt.Sprintf("wg set %s peer %s endpoint %s:%d allowed-ips %s persistent-keepalive 25",
+			wg.Name, wg.PeerPublicKey, wg.RemoteIP, wg.PeerListenPort, wg.AllowedIPs())
 	} else {
 		// 服务端模式：不配置 endpoint（等待客户端连接），不需要 persistent-keepalive
 		// 仅配置 peer 公钥和 allowed-ips
-		peerCmd = fmt.Sprintf("wg set %s peer %s allowed-ips 0.0.0.0/0",
-			wg.Name, wg.PeerPublicKey)
+		peerCmd = fmt.Sprintf("wg set %s peer %s allowed-ips %s",
+			wg.Name, wg.PeerPublicKey, wg.AllowedIPs())
 	}
 	if err := execCommand(peerCmd); err != nil {
 		return err
 	}
 
+	// 加入VRF或移入网络命名空间（在默认命名空间创建，UDP套接字留在默认命名空间）
+	if err := network.EnterIsolation(wg.Name, wg.VRF, wg.Netns); err != nil {
+		return err
+	}
+
 	// 5. 配置本地虚拟 IP
 	cmd = fmt.Sprintf("ip addr add %s/32 dev %s", wg.LocalVIP, wg.Name)
-	if err := execCommand(cmd); err != nil {
+	if err := execCommand(network.IPCommandLine(wg.Netns, cmd)); err != nil {
 		return err
 	}
 
 	// 6. 启动接口
 	cmd = fmt.Sprintf("ip link set dev %s up", wg.Name)
-	if err := execCommand(cmd); err != nil {
+	if err := execCommand(network.IPCommandLine(wg.Netns, cmd)); err != nil {
 		return err
 	}
 
-	// 7. 确保路由规则存在 (表80用于虚拟IP路由)
-	checkCmd := exec.Command("bash", "-c", "ip rule list | grep -q ^80:")
-	if err := checkCmd.Run(); err != nil {
-		cmd = "ip rule add from all lookup 80 pref 80"
-		if err := execCommand(cmd); err != nil {
-			return err
+	// 7. 确保路由规则存在 (VIP路由表，默认表80；VRF和网络命名空间内的隧道不需要)
+	if wg.VRF == "" && wg.Netns == "" {
+		checkCmd := exec.Command("bash", "-c", fmt.Sprintf("ip rule list | grep -q ^%d:", ns.VIPPref))
+		if err := checkCmd.Run(); err != nil {
+			cmd = fmt.Sprintf("ip rule add from all lookup %d pref %d", ns.VIPTable(), ns.VIPPref)
+			if err := execCommand(cmd); err != nil {
+				return err
+			}
 		}
 	}
 
-	// 8. 添加对端 VIP 路由到表80
-	cmd = fmt.Sprintf("ip route add %s/32 dev %s table 80", wg.RemoteVIP, wg.Name)
-	if err := execCommand(cmd); err != nil {
+	// 8. 添加对端 VIP 路由
+	cmd = fmt.Sprintf("ip route replace %s/32 dev %s table %s", wg.RemoteVIP, wg.Name, routeTable)
+	if err := execCommand(network.IPCommandLine(wg.Netns, cmd)); err != nil {
 		return err
 	}
 
+	// 9. 添加对端站点网段路由
+	for _, subnet := range wg.RemoteSubnets {
+		cmd = fmt.Sprintf("ip route replace %s dev %s table %s", subnet, wg.Name, routeTable)
+		if err := execCommand(network.IPCommandLine(wg.Netns, cmd)); err != nil {
+			return err
+		}
+	}
+
+	// 10. 本端网段映射（NETMAP）
+	if len(wg.Netmap) > 0 {
+		if err := network.ApplyNetmap(wg.Name, wg.Netns, wg.Netmap); err != nil {
+			return err
+		}
+		fmt.Printf("   ✓ 网段映射: %s\n", network.NetmapDisplay(wg.Netmap))
+	}
+
 	fmt.Printf("   ✓ WireGuard隧道已创建\n")
+	if wg.VRF != "" || wg.Netns != "" {
+		fmt.Printf("   ✓ 隔离上下文: %s\n", network.IsolationLabel(wg.VRF, wg.Netns))
+	}
 
 	// WireGuard 握手说明
 	if wg.Mode == "client" {
@@ -257,12 +335,12 @@ func (wg *WireGuardTunnel) Create() error {
 		fmt.Printf("   ⏳ 正在建立加密连接（首次握手可能需要5-10秒）...\n")
 
 		// 先主动触发握手（发送多个 ping 包）
-		triggerWireGuardHandshake(wg.RemoteVIP, wg.Name)
+		wg.triggerHandshake()
 
 		// 等待握手完成
-		if waitForWireGuardHandshake(wg.Name, 15) { // 15秒超时
+		if wg.waitForHandshake(15) { // 15秒超时
 			// 握手成功，测试连通性
-			if pingHost(wg.RemoteVIP, 3) {
+			if wg.pingPeer(3) {
 				fmt.Printf("   ✓ 隧道连接成功 (%s <-> %s)\n", wg.LocalVIP, wg.RemoteVIP)
 				return nil
 			}
@@ -281,7 +359,7 @@ func (wg *WireGuardTunnel) Create() error {
 		time.Sleep(2 * time.Second)
 
 		// 尝试 ping（可能对端还未连接）
-		if pingHost(wg.RemoteVIP, 5) {
+		if wg.pingPeer(5) {
 			fmt.Printf("   ✓ 隧道连接成功 (%s <-> %s)\n", wg.LocalVIP, wg.RemoteVIP)
 			return nil
 		} else {
@@ -321,9 +399,9 @@ func interfaceExists(name string) bool {
 	return err == nil
 }
 
-// Ping检查
-func pingHost(host string, timeout int) bool {
-	cmd := exec.Command("ping", "-c", "3", "-W", fmt.Sprintf("%d", timeout), host)
+// pingPeer Ping检查对端VIP（在隧道所在的隔离上下文中执行）
+func (wg *WireGuardTunnel) pingPeer(timeout int) bool {
+	cmd := network.ContextCommand(wg.VRF, wg.Netns, "ping", "-c", "3", "-W", fmt.Sprintf("%d", timeout), wg.RemoteVIP)
 	err := cmd.Run()
 	return err == nil
 }
@@ -348,24 +426,24 @@ func pingHostWithRetry(host string, count int, totalTimeout int) bool {
 	return false
 }
 
-// triggerWireGuardHandshake 主动触发 WireGuard 握手
-func triggerWireGuardHandshake(remoteVIP, interfaceName string) {
+// triggerHandshake 主动触发 WireGuard 握手
+func (wg *WireGuardTunnel) triggerHandshake() {
 	// 发送多个 ping 包主动触发握手
 	// WireGuard 握手由数据包触发，多发几个增加成功率
 	for i := 0; i < 5; i++ {
-		cmd := exec.Command("ping", "-c", "1", "-W", "1", "-I", interfaceName, remoteVIP)
+		cmd := network.ContextCommand(wg.VRF, wg.Netns, "ping", "-c", "1", "-W", "1", "-I", wg.Name, wg.RemoteVIP)
 		cmd.Run() // 忽略错误，只是触发握手
 		time.Sleep(500 * time.Millisecond)
 	}
 }
 
-// waitForWireGuardHandshake 等待 WireGuard 握手完成
-func waitForWireGuardHandshake(interfaceName string, timeout int) bool {
+// waitForHandshake 等待 WireGuard 握手完成
+func (wg *WireGuardTunnel) waitForHandshake(timeout int) bool {
 	startTime := time.Now()
 
 	for time.Since(startTime).Seconds() < float64(timeout) {
-		// 使用 wg show 检查握手状态
-		cmd := exec.Command("wg", "show", interfaceName, "latest-handshakes")
+		// 使用 wg show 检查握手状态（网络命名空间内的接口需在命名空间中查询）
+		cmd := network.ContextCommand("", wg.Netns, "wg", "show", wg.Name, "latest-handshakes")
 		output, err := cmd.CombinedOutput()
 		if err == nil {
 			// 输出格式: <公钥> <时间戳>
@@ -496,7 +574,7 @@ func SavePeerConfig(tunnelName, content string) error {
 // 从 "wg show <interface> endpoints" 输出解析
 // 返回格式: "IP:Port" 或空字符串（如果没有连接）
 func GetWireGuardPeerEndpoint(interfaceName string) string {
-	cmd := exec.Command("wg", "show", interfaceName, "endpoints")
+	cmd := network.ContextCommand("", network.TunnelNetns(interfaceName), "wg", "show", interfaceName, "endpoints")
 	output, err := cmd.Output()
 	if err != nil {
 		return ""